@@ -2,43 +2,201 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 
+	"quillet/internal/avatar"
 	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/local"
 	"quillet/internal/messenger"
+	"quillet/internal/p2p"
+	"quillet/internal/paths"
+	"quillet/internal/profile"
 	"quillet/internal/stub"
 )
 
 // App is the main application struct that manages the Wails lifecycle.
 // ctx is stored as a field because Wails passes it via lifecycle callbacks
 // and requires it for runtime.EventsEmit calls.
+//
+// The messenger and avatar store belong to the active local profile and are
+// replaced when the user switches profiles; bindings reach them through
+// active and activeAvatars.
 type App struct {
-	ctx       context.Context
+	ctx      context.Context
+	lock     *paths.Lock
+	profiles *profile.Registry
+
+	mu        sync.RWMutex
 	cancel    context.CancelFunc
 	messenger messenger.Messenger
+	avatars   *avatar.Store
+}
+
+// identityFileName is the identity key inside each profile directory.
+const identityFileName = "identity.key"
+
+// NewApp creates a new App instance with a messenger backend for the
+// active profile, whose identity and data are kept in the profile's data directory.
+// It locks the data directory until Shutdown; while another process holds
+// it, NewApp fails with domain.ErrDataDirLocked.
+func NewApp() (*App, error) {
+	dir, err := paths.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	lock, err := paths.Acquire(dir)
+	if err != nil {
+		return nil, err
+	}
+	app, err := newApp(dir)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	app.lock = lock
+	return app, nil
 }
 
-// NewApp creates a new App instance with a StubMessenger backend.
-func NewApp() *App {
+// newApp opens the profiles in dir and the backend of the active one.
+func newApp(dir string) (*App, error) {
+	profiles, err := profile.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	profileDir := profiles.Dir(profiles.Active().ID)
+	m, err := newMessenger(profileDir)
+	if err != nil {
+		return nil, err
+	}
 	return &App{
-		messenger: stub.NewStubMessenger(),
+		profiles:  profiles,
+		messenger: m,
+		avatars:   newAvatarStore(profileDir),
+	}, nil
+}
+
+// Messenger backends, selected with the QUILLET_BACKEND environment variable.
+const (
+	backendEnv    = "QUILLET_BACKEND"
+	backendP2P    = "p2p"    // default: profile database reaching contacts over TCP
+	backendSQLite = "sqlite" // profile database without networking
+	backendStub   = "stub"   // in-memory demo data for UI development
+)
+
+// Environment variables configuring the p2p backend.
+const (
+	listenEnv    = "QUILLET_LISTEN"    // address to listen on
+	discoveryEnv = "QUILLET_DISCOVERY" // multicast group:port for LAN discovery, "off" for none
+	relayEnv     = "QUILLET_RELAY"     // <public ID>@host:port of a relay, see cmd/quillet-relay
+)
+
+// newMessenger creates the messenger backend for a profile data directory.
+func newMessenger(dir string) (messenger.Messenger, error) {
+	self, err := identity.OpenManager(filepath.Join(dir, identityFileName))
+	if err != nil {
+		return nil, err
 	}
+	switch backend := os.Getenv(backendEnv); backend {
+	case "", backendP2P:
+		cfg := p2p.Config{
+			ListenAddress:  os.Getenv(listenEnv),
+			DiscoveryGroup: os.Getenv(discoveryEnv),
+			Relay:          os.Getenv(relayEnv),
+		}
+		if cfg.ListenAddress == "" {
+			cfg.ListenAddress = p2p.DefaultListenAddress
+		}
+		switch cfg.DiscoveryGroup {
+		case "":
+			cfg.DiscoveryGroup = p2p.DefaultDiscoveryGroup
+		case "off":
+			cfg.DiscoveryGroup = ""
+		}
+		return p2p.Open(dir, self, cfg)
+	case backendSQLite:
+		return local.Open(dir, self)
+	case backendStub:
+		return stub.NewStubMessengerFor(self), nil
+	default:
+		return nil, fmt.Errorf("unknown %s %q", backendEnv, backend)
+	}
+}
+
+// newAvatarStore returns the avatar store of a profile data directory.
+func newAvatarStore(dir string) *avatar.Store {
+	return avatar.NewStore(filepath.Join(dir, avatar.DirName))
+}
+
+// active returns the messenger of the active profile.
+func (a *App) active() messenger.Messenger {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.messenger
+}
+
+// activeAvatars returns the avatar store of the active profile.
+func (a *App) activeAvatars() *avatar.Store {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.avatars
+}
+
+// serveAvatar serves the active profile's avatars to the WebView under
+// avatar.URLPrefix; it is installed as the asset server's fallback handler.
+func (a *App) serveAvatar(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, avatar.URLPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	a.activeAvatars().ServeHTTP(w, r)
 }
 
 // Startup is called when the Wails app starts.
 // The context is saved for calling Wails runtime methods.
 func (a *App) Startup(ctx context.Context) {
-	simCtx, cancel := context.WithCancel(ctx)
 	a.ctx = ctx
+
+	a.mu.Lock()
+	a.start(a.messenger, a.avatars)
+	a.mu.Unlock()
+
+	slog.Info("application started", "profile", a.profiles.Active().Name)
+}
+
+// start makes m and avatars current, wires m to the frontend events and
+// launches its background goroutines under a fresh context.
+// Must be called with a.mu held.
+func (a *App) start(m messenger.Messenger, avatars *avatar.Store) {
+	simCtx, cancel := context.WithCancel(a.ctx)
 	a.cancel = cancel
+	a.messenger = m
+	a.avatars = avatars
 
-	a.messenger.OnNewMessage(func(msg domain.Message) {
+	// Without an identity the frontend shows onboarding, and a passphrase-protected
+	// identity starts locked until UnlockIdentity; see NotifyReady.
+	if has, err := m.HasIdentity(a.ctx); err != nil {
+		slog.Error("check identity", "error", err)
+	} else if !has {
+		slog.Info("no identity yet, onboarding required")
+	} else if locked, _ := m.IsLocked(a.ctx); locked {
+		slog.Info("identity is locked, passphrase required")
+	}
+
+	m.OnNewMessage(func(msg domain.Message) {
 		runtime.EventsEmit(a.ctx, EventMessageReceived, msg)
 	})
 
-	a.messenger.OnContactStatusChanged(func(contactID string, isOnline bool, lastSeen int64) {
+	m.OnContactStatusChanged(func(contactID string, isOnline bool, lastSeen int64) {
 		runtime.EventsEmit(a.ctx, EventContactStatus, messenger.ContactStatusEvent{
 			ContactID: contactID,
 			IsOnline:  isOnline,
@@ -46,7 +204,7 @@ func (a *App) Startup(ctx context.Context) {
 		})
 	})
 
-	a.messenger.OnMessageStatusChanged(func(messageID, chatID string, status domain.MessageStatus) {
+	m.OnMessageStatusChanged(func(messageID, chatID string, status domain.MessageStatus) {
 		runtime.EventsEmit(a.ctx, EventMessageStatus, messenger.MessageStatusEvent{
 			MessageID: messageID,
 			ChatID:    chatID,
@@ -54,25 +212,59 @@ func (a *App) Startup(ctx context.Context) {
 		})
 	})
 
-	a.messenger.OnTypingChanged(func(contactID string, isTyping bool) {
+	m.OnTypingChanged(func(contactID string, isTyping bool) {
 		runtime.EventsEmit(a.ctx, EventContactTyping, messenger.TypingEvent{
 			ContactID: contactID,
 			IsTyping:  isTyping,
 		})
 	})
 
-	a.messenger.OnConnectionStateChanged(func(state string) {
+	m.OnConnectionStateChanged(func(state string) {
 		runtime.EventsEmit(a.ctx, EventConnectionState, state)
 	})
 
-	a.messenger.StartStatusSimulation(simCtx)
+	m.OnContactKeyChanged(func(contactID, previousID, publicKey string, wasVerified bool) {
+		runtime.EventsEmit(a.ctx, EventContactKeyChanged, messenger.ContactKeyEvent{
+			ContactID:   contactID,
+			PreviousID:  previousID,
+			PublicKey:   publicKey,
+			WasVerified: wasVerified,
+		})
+	})
+
+	m.OnMessagesExpired(func(expired domain.ExpiredMessages) {
+		runtime.EventsEmit(a.ctx, EventMessagesExpired, expired)
+	})
+
+	m.StartStatusSimulation(simCtx)
+	m.StartJanitor(simCtx)
+	if n, ok := m.(messenger.Network); ok {
+		n.StartNetwork(simCtx)
+	}
 
 	// Start connection simulation with a fixed delay to allow frontend to mount.
-	if sm, ok := a.messenger.(*stub.StubMessenger); ok {
+	if sm, ok := m.(*stub.StubMessenger); ok {
 		sm.StartConnectionSimulation(simCtx)
 	}
+}
 
-	slog.Info("application started")
+// stop cancels the background goroutines of the running messenger, waits
+// for them to exit and releases its resources. Must be called with a.mu held.
+func (a *App) stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.messenger.Wait()
+	closeMessenger(a.messenger)
+}
+
+// closeMessenger releases a backend that holds resources such as a database.
+func closeMessenger(m messenger.Messenger) {
+	if c, ok := m.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("close messenger", "error", err)
+		}
+	}
 }
 
 // DomReady is called when the frontend DOM is ready.
@@ -90,7 +282,11 @@ func (a *App) BeforeClose(_ context.Context) bool {
 // Shutdown is called when the application is shutting down.
 func (a *App) Shutdown(_ context.Context) {
 	slog.Info("application shutting down")
-	a.cancel()
-	a.messenger.Wait()
+	a.mu.Lock()
+	a.stop()
+	a.mu.Unlock()
 	slog.Info("all background goroutines stopped")
+	if err := a.lock.Release(); err != nil {
+		slog.Error("release data dir", "error", err)
+	}
 }
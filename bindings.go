@@ -1,13 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/skip2/go-qrcode"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 
+	"quillet/internal/backup"
+	"quillet/internal/chatimport"
 	"quillet/internal/domain"
-	"quillet/internal/stub"
+	"quillet/internal/export"
+	"quillet/internal/identity"
+	"quillet/internal/messenger"
 )
 
 // validThemes contains the set of allowed theme values.
@@ -17,11 +27,33 @@ var validThemes = map[string]struct{}{
 	"system": {},
 }
 
+// Identity validation limits (UC-001).
+const (
+	maxDisplayNameLen = 64
+	minPassphraseLen  = 4
+	maxKeyFileSize    = 64 << 10
+)
+
+// maxRetentionDays caps retention periods at about a century.
+const maxRetentionDays = 36500
+
+// maxOutboxTTLHours caps how long a message is retried at a year.
+const maxOutboxTTLHours = 24 * 365
+
+// qrCodeSize is the width and height of invite QR codes in pixels.
+const qrCodeSize = 512
+
 // NotifyReady is called by the frontend when React event listeners are registered.
-// It emits the current connection state so the frontend is in sync.
+// It emits the current connection state so the frontend is in sync, and
+// requests onboarding or the passphrase if the identity is missing or locked.
 func (a *App) NotifyReady() {
-	if sm, ok := a.messenger.(*stub.StubMessenger); ok {
-		if state := sm.ConnectionState(); state != "" {
+	if has, err := a.active().HasIdentity(a.ctx); err == nil && !has {
+		runtime.EventsEmit(a.ctx, EventIdentityRequired, nil)
+	} else if locked, err := a.active().IsLocked(a.ctx); err == nil && locked {
+		runtime.EventsEmit(a.ctx, EventIdentityLocked, nil)
+	}
+	if cs, ok := a.active().(interface{ ConnectionState() string }); ok {
+		if state := cs.ConnectionState(); state != "" {
 			runtime.EventsEmit(a.ctx, EventConnectionState, state)
 		}
 	}
@@ -29,60 +61,487 @@ func (a *App) NotifyReady() {
 
 // --- Identity ---
 
+// HasIdentity reports whether an identity exists; false means onboarding is required.
+func (a *App) HasIdentity() (bool, error) {
+	return a.active().HasIdentity(a.ctx)
+}
+
+// CreateIdentity generates a new Ed25519 identity for the local user.
+// An empty passphrase leaves the private key unencrypted.
+func (a *App) CreateIdentity(displayName, passphrase, avatarPath string) (*domain.User, error) {
+	displayName, err := normalizeDisplayName(displayName)
+	if err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
+	}
+	if passphrase != "" && utf8.RuneCountInString(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("create identity: %w", domain.ErrPassphraseTooShort)
+	}
+	avatarPath, err = a.importAvatar(avatarPath)
+	if err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
+	}
+	return a.active().CreateIdentity(a.ctx, displayName, passphrase, avatarPath)
+}
+
+// IsLocked reports whether the identity is waiting for its passphrase.
+func (a *App) IsLocked() (bool, error) {
+	return a.active().IsLocked(a.ctx)
+}
+
+// UnlockIdentity decrypts the private key with the given passphrase.
+func (a *App) UnlockIdentity(passphrase string) error {
+	return a.active().UnlockIdentity(a.ctx, passphrase)
+}
+
+// LockIdentity wipes the private key from memory until the next UnlockIdentity.
+func (a *App) LockIdentity() error {
+	if err := a.active().LockIdentity(a.ctx); err != nil {
+		return err
+	}
+	runtime.EventsEmit(a.ctx, EventIdentityLocked, nil)
+	return nil
+}
+
+// HasPassphrase reports whether the private key is encrypted with a passphrase.
+func (a *App) HasPassphrase() (bool, error) {
+	return a.active().HasPassphrase(a.ctx)
+}
+
+// ChangePassphrase re-encrypts the private key. An empty newPassphrase
+// removes the passphrase.
+func (a *App) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	if newPassphrase != "" && utf8.RuneCountInString(newPassphrase) < minPassphraseLen {
+		return fmt.Errorf("change passphrase: %w", domain.ErrPassphraseTooShort)
+	}
+	return a.active().ChangePassphrase(a.ctx, oldPassphrase, newPassphrase)
+}
+
+// ImportIdentity restores an identity from a hex private key (seed or full key)
+// and protects it with passphrase. An existing identity is replaced only
+// when overwrite is true.
+func (a *App) ImportIdentity(privateKeyHex, passphrase string, overwrite bool) (*domain.User, error) {
+	if strings.TrimSpace(privateKeyHex) == "" {
+		return nil, fmt.Errorf("import identity: %w", domain.ErrInvalidPrivateKey)
+	}
+	if passphrase != "" && utf8.RuneCountInString(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("import identity: %w", domain.ErrPassphraseTooShort)
+	}
+	return a.active().ImportIdentity(a.ctx, privateKeyHex, passphrase, overwrite)
+}
+
+// ImportIdentityFile restores an identity from a key file: either an
+// identity.key exported from another machine, decrypted with passphrase,
+// or a text file holding a hex private key.
+func (a *App) ImportIdentityFile(path, passphrase string, overwrite bool) (*domain.User, error) {
+	if passphrase != "" && utf8.RuneCountInString(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("import identity: %w", domain.ErrPassphraseTooShort)
+	}
+	data, err := readKeyFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("import identity: %w", err)
+	}
+	return a.active().ImportIdentity(a.ctx, string(data), passphrase, overwrite)
+}
+
+// ExportPrivateKey returns the private key as hex after re-checking the passphrase.
+func (a *App) ExportPrivateKey(passphrase string) (string, error) {
+	return a.active().ExportPrivateKey(a.ctx, passphrase)
+}
+
+// ImportRecoveryPhrase restores an identity from its 24-word recovery phrase
+// and protects it with passphrase. An existing identity is replaced only
+// when overwrite is true.
+func (a *App) ImportRecoveryPhrase(phrase, passphrase string, overwrite bool) (*domain.User, error) {
+	if passphrase != "" && utf8.RuneCountInString(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("import recovery phrase: %w", domain.ErrPassphraseTooShort)
+	}
+	return a.active().ImportRecoveryPhrase(a.ctx, phrase, passphrase, overwrite)
+}
+
+// ExportRecoveryPhrase returns the 24-word recovery phrase after re-checking the passphrase.
+func (a *App) ExportRecoveryPhrase(passphrase string) (string, error) {
+	return a.active().ExportRecoveryPhrase(a.ctx, passphrase)
+}
+
+// RotateIdentityKey replaces the identity key pair, e.g. after a device was
+// compromised, and notifies contacts with a certificate signed by the old key.
+func (a *App) RotateIdentityKey() (*domain.User, error) {
+	return a.active().RotateIdentityKey(a.ctx)
+}
+
+// readKeyFile reads a key file, refusing anything larger than maxKeyFileSize.
+func readKeyFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxKeyFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeyFileSize {
+		return nil, domain.ErrInvalidPrivateKey
+	}
+	return data, nil
+}
+
 // GetIdentity returns the current user's profile.
 func (a *App) GetIdentity() (*domain.User, error) {
-	return a.messenger.GetProfile(a.ctx)
+	return a.active().GetProfile(a.ctx)
+}
+
+// UpdateProfile changes the current user's display name and avatar.
+// avatarPath is either an image file to import, the current avatar URL to
+// keep it, or "" to remove the avatar.
+func (a *App) UpdateProfile(displayName, avatarPath string) error {
+	displayName, err := normalizeDisplayName(displayName)
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+	avatarPath, err = a.importAvatar(avatarPath)
+	if err != nil {
+		return fmt.Errorf("update profile: %w", err)
+	}
+	return a.active().UpdateProfile(a.ctx, displayName, avatarPath)
 }
 
-// UpdateProfile changes the current user's display name.
-func (a *App) UpdateProfile(displayName string) error {
+// importAvatar resolves an avatarPath binding argument to an avatar URL,
+// importing image files into the active profile's avatar store.
+func (a *App) importAvatar(avatarPath string) (string, error) {
+	avatarPath = strings.TrimSpace(avatarPath)
+	avatars := a.activeAvatars()
+	if avatarPath == "" || avatars.Has(avatarPath) {
+		return avatarPath, nil
+	}
+	return avatars.Import(avatarPath)
+}
+
+// normalizeDisplayName trims a display name and checks its length.
+func normalizeDisplayName(displayName string) (string, error) {
 	displayName = strings.TrimSpace(displayName)
 	if displayName == "" {
-		return fmt.Errorf("update profile: %w", domain.ErrEmptyDisplayName)
+		return "", domain.ErrEmptyDisplayName
+	}
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLen {
+		return "", domain.ErrDisplayNameTooLong
+	}
+	return displayName, nil
+}
+
+// SetContactAvatar gives a contact a locally chosen avatar. avatarPath
+// follows the same rules as in UpdateProfile.
+func (a *App) SetContactAvatar(contactID, avatarPath string) error {
+	avatarPath, err := a.importAvatar(avatarPath)
+	if err != nil {
+		return fmt.Errorf("set contact avatar: %w", err)
+	}
+	return a.active().SetContactAvatar(a.ctx, contactID, avatarPath)
+}
+
+// --- Local profiles ---
+
+// ListProfiles returns the local profiles; exactly one is active.
+func (a *App) ListProfiles() []domain.LocalProfile {
+	return a.profiles.List()
+}
+
+// CreateProfile adds a new local profile with its own empty data directory.
+// The new profile is not activated; use SwitchProfile.
+func (a *App) CreateProfile(name string) (*domain.LocalProfile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("create profile: %w", domain.ErrEmptyProfileName)
+	}
+	if utf8.RuneCountInString(name) > maxDisplayNameLen {
+		return nil, fmt.Errorf("create profile: %w", domain.ErrProfileNameTooLong)
+	}
+	p, err := a.profiles.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SwitchProfile stops the messenger of the active profile, waits for its
+// background goroutines and starts the messenger of profile id.
+// Switching to the active profile is a no-op.
+func (a *App) SwitchProfile(id string) (*domain.LocalProfile, error) {
+	a.mu.Lock()
+	p, err := a.switchProfile(id)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("switch profile: %w", err)
+	}
+
+	slog.Info("profile switched", "profile", p.Name)
+	runtime.EventsEmit(a.ctx, EventProfileSwitched, p)
+	a.NotifyReady()
+	return p, nil
+}
+
+// switchProfile does the work of SwitchProfile. Must be called with a.mu held.
+func (a *App) switchProfile(id string) (*domain.LocalProfile, error) {
+	p, err := a.profiles.Get(id)
+	if err != nil {
+		return nil, err
 	}
-	return a.messenger.UpdateProfile(a.ctx, displayName)
+	if p.Active {
+		return &p, nil
+	}
+	// Open the next profile first so a broken one leaves the current running.
+	dir := a.profiles.Dir(id)
+	next, err := newMessenger(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.profiles.SetActive(id); err != nil {
+		closeMessenger(next)
+		return nil, err
+	}
+	a.stop()
+	a.start(next, newAvatarStore(dir))
+
+	p.Active = true
+	return &p, nil
+}
+
+// DeleteProfile removes an inactive profile together with its keys and data.
+func (a *App) DeleteProfile(id string) error {
+	return a.profiles.Delete(id)
+}
+
+// --- Backups ---
+
+// CreateBackup writes an encrypted backup of the active profile to path:
+// the identity key, database, settings and avatars, sealed with
+// passphrase. The identity passphrase is not needed; the identity key
+// stays encrypted inside the backup as it is on disk.
+func (a *App) CreateBackup(path, passphrase string) error {
+	if utf8.RuneCountInString(passphrase) < minPassphraseLen {
+		return fmt.Errorf("create backup: %w", domain.ErrPassphraseTooShort)
+	}
+	// The read lock keeps the profile from being switched or restored
+	// while it is copied.
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	db, ok := a.messenger.(backup.Snapshotter)
+	if !ok {
+		return fmt.Errorf("create backup: %w", errors.ErrUnsupported)
+	}
+	m, err := backup.Create(a.ctx, path, a.profiles.Dir(a.profiles.Active().ID), passphrase, db)
+	if err != nil {
+		return err
+	}
+	slog.Info("backup created", "files", len(m.Files))
+	return nil
+}
+
+// RestoreBackup replaces the active profile with the backup at path.
+// The backup is decrypted and checked first, so a wrong passphrase or a
+// damaged file leaves the profile untouched; then the messenger is
+// stopped, the files are swapped and the restored profile is started.
+func (a *App) RestoreBackup(path, passphrase string) error {
+	a.mu.Lock()
+	err := a.restoreBackup(path, passphrase)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("backup restored", "profile", a.profiles.Active().Name)
+	runtime.EventsEmit(a.ctx, EventBackupRestored, nil)
+	a.NotifyReady()
+	return nil
+}
+
+// restoreBackup does the work of RestoreBackup. Must be called with a.mu held.
+func (a *App) restoreBackup(path, passphrase string) error {
+	dir := a.profiles.Dir(a.profiles.Active().ID)
+	pending, err := backup.Prepare(a.ctx, path, passphrase, dir)
+	if err != nil {
+		return err
+	}
+	defer pending.Discard()
+
+	a.stop()
+	restoreErr := pending.Apply()
+	m, err := newMessenger(dir)
+	if restoreErr == nil && err != nil {
+		// Prepare opened the same files, so this is unexpected; go back to
+		// the previous profile rather than leave it unusable.
+		restoreErr = fmt.Errorf("restore backup: %w", err)
+		if err := pending.Revert(); err != nil {
+			return errors.Join(restoreErr, err)
+		}
+		m, err = newMessenger(dir)
+	}
+	if err != nil {
+		return errors.Join(restoreErr, fmt.Errorf("reopen profile: %w", err))
+	}
+	a.start(m, newAvatarStore(dir))
+	return restoreErr
+}
+
+// --- Storage ---
+
+// GetStorageStats reports the disk space of the active profile: the
+// database, each chat's messages in it and the avatar images beside it.
+func (a *App) GetStorageStats() (*domain.StorageStats, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	sm, ok := a.messenger.(messenger.StorageManager)
+	if !ok {
+		return nil, fmt.Errorf("storage stats: %w", errors.ErrUnsupported)
+	}
+	stats, err := sm.GetStorageStats(a.ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.AttachmentCount, stats.AttachmentBytes, err = a.avatars.Usage()
+	if err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	return stats, nil
+}
+
+// CompactStorage returns the database's unused space, e.g. after
+// history was cleared or expired, to the file system and reports how much
+// was reclaimed. Messages can be sent and read while it runs.
+func (a *App) CompactStorage() (*domain.CompactResult, error) {
+	// As for backups, the read lock keeps the profile in place meanwhile.
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	sm, ok := a.messenger.(messenger.StorageManager)
+	if !ok {
+		return nil, fmt.Errorf("compact storage: %w", errors.ErrUnsupported)
+	}
+	res, err := sm.CompactStorage(a.ctx)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("storage compacted", "reclaimed", res.BytesReclaimed)
+	return res, nil
+}
+
+// --- Network ---
+
+// network returns the active backend as a messenger.Network, failing with
+// errors.ErrUnsupported for a backend without networking.
+func (a *App) network(op string) (messenger.Network, error) {
+	n, ok := a.active().(messenger.Network)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, errors.ErrUnsupported)
+	}
+	return n, nil
+}
+
+// SetContactAddress sets the host:port a contact is dialed at, "" to stop
+// dialing it.
+func (a *App) SetContactAddress(contactID, address string) error {
+	n, err := a.network("set contact address")
+	if err != nil {
+		return err
+	}
+	return n.SetContactAddress(a.ctx, contactID, strings.TrimSpace(address))
+}
+
+// SetTyping tells a contact whether the user is typing in its chat.
+// Backends without networking have no one to tell and ignore it.
+func (a *App) SetTyping(contactID string, isTyping bool) error {
+	n, ok := a.active().(messenger.Network)
+	if !ok {
+		return nil
+	}
+	return n.SendTyping(a.ctx, contactID, isTyping)
+}
+
+// GetNetworkStatus describes the listening address and the connected
+// contacts for connection diagnostics.
+func (a *App) GetNetworkStatus() (*domain.NetworkStatus, error) {
+	n, err := a.network("network status")
+	if err != nil {
+		return nil, err
+	}
+	return n.GetNetworkStatus(a.ctx)
 }
 
 // --- Contacts ---
 
+// CreateInvite returns a signed quillet://add link for the current identity
+// together with a QR code PNG of it.
+func (a *App) CreateInvite() (*domain.Invite, error) {
+	inv, err := a.active().CreateInvite(a.ctx)
+	if err != nil {
+		return nil, err
+	}
+	inv.QRCode, err = qrcode.Encode(inv.Link, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("create invite: %w", err)
+	}
+	return inv, nil
+}
+
+// ParseInvite checks the signature of an invite link and returns the
+// contact details it carries, ready to prefill AddContact.
+func (a *App) ParseInvite(link string) (*domain.Invite, error) {
+	return identity.ParseInvite(strings.TrimSpace(link))
+}
+
 // GetContacts returns the full contact list.
 func (a *App) GetContacts() ([]domain.Contact, error) {
-	return a.messenger.GetContacts(a.ctx)
+	return a.active().GetContacts(a.ctx)
 }
 
-// AddContact adds a new contact by public ID.
-func (a *App) AddContact(publicID, displayName string) (*domain.Contact, error) {
-	publicID = strings.TrimSpace(publicID)
-	if publicID == "" {
-		return nil, fmt.Errorf("add contact: %w", domain.ErrEmptyPublicID)
+// AddContact adds a new contact by its 16-hex Public ID or 64-hex public key.
+func (a *App) AddContact(publicIDOrKey, displayName string) (*domain.Contact, error) {
+	peer, err := domain.ParsePeerID(publicIDOrKey)
+	if err != nil {
+		return nil, fmt.Errorf("add contact: %w", err)
 	}
 	displayName = strings.TrimSpace(displayName)
 	if displayName == "" {
 		return nil, fmt.Errorf("add contact: %w", domain.ErrEmptyDisplayName)
 	}
-	return a.messenger.AddContact(a.ctx, publicID, displayName)
+	return a.active().AddContact(a.ctx, peer, displayName)
 }
 
 // DeleteContact removes a contact by ID.
 func (a *App) DeleteContact(contactID string) error {
-	return a.messenger.RemoveContact(a.ctx, contactID)
+	return a.active().RemoveContact(a.ctx, contactID)
 }
 
 // BlockContact blocks a contact by ID.
 func (a *App) BlockContact(contactID string) error {
-	return a.messenger.BlockContact(a.ctx, contactID)
+	return a.active().BlockContact(a.ctx, contactID)
 }
 
 // UnblockContact unblocks a contact by ID.
 func (a *App) UnblockContact(contactID string) error {
-	return a.messenger.UnblockContact(a.ctx, contactID)
+	return a.active().UnblockContact(a.ctx, contactID)
+}
+
+// GetSafetyNumber returns the safety number to compare with a contact out of band.
+func (a *App) GetSafetyNumber(contactID string) (string, error) {
+	return a.active().GetSafetyNumber(a.ctx, contactID)
+}
+
+// MarkContactVerified records that the contact's safety number was compared.
+func (a *App) MarkContactVerified(contactID string) error {
+	return a.active().MarkContactVerified(a.ctx, contactID)
+}
+
+// UnverifyContact clears a contact's verified state.
+func (a *App) UnverifyContact(contactID string) error {
+	return a.active().UnverifyContact(a.ctx, contactID)
 }
 
 // --- Conversations ---
 
 // GetChatSummaries returns all conversation previews for the sidebar.
 func (a *App) GetChatSummaries() ([]domain.ChatSummary, error) {
-	return a.messenger.GetChatSummaries(a.ctx)
+	return a.active().GetChatSummaries(a.ctx)
 }
 
 // SendMessage sends a text message to a contact.
@@ -91,7 +550,16 @@ func (a *App) SendMessage(contactID, content string) (*domain.Message, error) {
 	if content == "" {
 		return nil, fmt.Errorf("send message: %w", domain.ErrEmptyContent)
 	}
-	return a.messenger.SendMessage(a.ctx, contactID, content)
+	return a.active().SendMessage(a.ctx, contactID, content)
+}
+
+// RetryMessage sends a failed message again, with a fresh outbox TTL.
+func (a *App) RetryMessage(messageID string) (*domain.Message, error) {
+	ob, ok := a.active().(messenger.Outbox)
+	if !ok {
+		return nil, fmt.Errorf("retry message: %w", errors.ErrUnsupported)
+	}
+	return ob.RetryMessage(a.ctx, messageID)
 }
 
 // GetMessages returns paginated messages for a contact.
@@ -99,24 +567,67 @@ func (a *App) GetMessages(contactID string, limit int, beforeID string) ([]domai
 	if limit < 0 {
 		return nil, fmt.Errorf("get messages: %w", domain.ErrInvalidLimit)
 	}
-	return a.messenger.GetMessages(a.ctx, contactID, limit, beforeID)
+	return a.active().GetMessages(a.ctx, contactID, limit, beforeID)
 }
 
 // MarkAsRead marks all messages in a chat as read.
 func (a *App) MarkAsRead(contactID string) error {
-	return a.messenger.MarkAsRead(a.ctx, contactID)
+	return a.active().MarkAsRead(a.ctx, contactID)
 }
 
 // ClearHistory removes all messages in a chat.
 func (a *App) ClearHistory(contactID string) error {
-	return a.messenger.ClearHistory(a.ctx, contactID)
+	return a.active().ClearHistory(a.ctx, contactID)
+}
+
+// SetChatRetention sets how many days a chat's messages are kept before
+// they are deleted: 0 follows the global setting, -1 keeps them forever.
+func (a *App) SetChatRetention(contactID string, days int) error {
+	if days < domain.RetentionForever || days > maxRetentionDays {
+		return fmt.Errorf("set chat retention: %w", domain.ErrInvalidRetention)
+	}
+	return a.active().SetChatRetention(a.ctx, contactID, days)
+}
+
+// SearchMessages searches message history, in one chat if contactID is set.
+// The query accepts from:, before: and after: filters; pass the previous
+// result's NextCursor as cursor to fetch the next page.
+func (a *App) SearchMessages(query, contactID string, limit int, cursor string) (*domain.SearchResult, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("search messages: %w", domain.ErrInvalidLimit)
+	}
+	return a.active().SearchMessages(a.ctx, query, contactID, limit, cursor)
+}
+
+// ExportChat writes the whole history of a chat to path as "html",
+// "markdown" or "json", with sender names and local times.
+func (a *App) ExportChat(contactID, format, path string) error {
+	return export.WriteChat(a.ctx, a.active(), contactID, export.Format(format), path)
+}
+
+// ImportChatHistory imports a Telegram Desktop JSON ("telegram") or
+// WhatsApp text ("whatsapp") chat export at path into the chat with
+// contactID. selfName is the local user's sender name in the export, ""
+// if none; every other sender becomes the contact. A dry run stores
+// nothing and previews the import, listing the senders to choose selfName
+// from. Progress is emitted as EventImportProgress.
+func (a *App) ImportChatHistory(contactID, format, path, selfName string, dryRun bool) (*domain.ImportResult, error) {
+	return chatimport.Import(a.ctx, a.active(), path, chatimport.Options{
+		Format:    domain.ImportFormat(format),
+		ContactID: contactID,
+		SelfName:  selfName,
+		DryRun:    dryRun,
+		Progress: func(p domain.ImportProgress) {
+			runtime.EventsEmit(a.ctx, EventImportProgress, p)
+		},
+	})
 }
 
 // --- Settings ---
 
 // GetSettings returns the current application settings.
 func (a *App) GetSettings() (*domain.Settings, error) {
-	return a.messenger.GetSettings(a.ctx)
+	return a.active().GetSettings(a.ctx)
 }
 
 // UpdateSettings saves new application settings.
@@ -127,5 +638,11 @@ func (a *App) UpdateSettings(settings domain.Settings) error {
 	if settings.SidebarWidth <= 0 {
 		return fmt.Errorf("update settings: %w", domain.ErrInvalidSidebar)
 	}
-	return a.messenger.UpdateSettings(a.ctx, settings)
+	if settings.RetentionDays < 0 || settings.RetentionDays > maxRetentionDays {
+		return fmt.Errorf("update settings: %w", domain.ErrInvalidRetention)
+	}
+	if settings.OutboxTTLHours < 0 || settings.OutboxTTLHours > maxOutboxTTLHours {
+		return fmt.Errorf("update settings: %w", domain.ErrInvalidOutboxTTL)
+	}
+	return a.active().UpdateSettings(a.ctx, settings)
 }
@@ -0,0 +1,112 @@
+// Command quillet-relay holds end-to-end encrypted messages for Quillet
+// users who are offline and hands them over when they connect.
+//
+// The relay has an identity key of its own, created on first start in the
+// data directory. Clients name the relay by its Public ID and address,
+// which the relay logs on start, in the QUILLET_RELAY variable:
+//
+//	QUILLET_RELAY=<public ID>@relay.example.org:47330
+//
+// For hole punching, the relay tells clients their public UDP address on
+// the same port number and the one above, 47331 by default; both should
+// be open to UDP.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"quillet/internal/identity"
+	"quillet/internal/nat"
+	"quillet/internal/paths"
+	"quillet/internal/relay"
+)
+
+// Files in the data directory.
+const (
+	identityFileName = "relay.key"
+	databaseFileName = "relay.db"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		listen = flag.String("listen", relay.DefaultListenAddress, "address to listen on")
+		dir    = flag.String("dir", "quillet-relay", "data directory")
+		cfg    relay.Config
+	)
+	flag.DurationVar(&cfg.TTL, "ttl", relay.DefaultTTL, "how long a message waits for its recipient")
+	flag.IntVar(&cfg.MaxEnvelopes, "max-messages", relay.DefaultMaxEnvelopes, "messages waiting per recipient")
+	flag.Int64Var(&cfg.MaxBytes, "max-bytes", relay.DefaultMaxBytes, "bytes waiting per recipient")
+	flag.IntVar(&cfg.MaxSenderEnvelopes, "max-sender-messages", relay.DefaultMaxSenderEnvelopes, "messages waiting per recipient from one sender")
+	flag.Int64Var(&cfg.MaxSenderBytes, "max-sender-bytes", relay.DefaultMaxSenderBytes, "bytes waiting per recipient from one sender")
+	flag.Parse()
+
+	lock, err := paths.Acquire(*dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	self, err := identity.OpenManager(filepath.Join(*dir, identityFileName))
+	if err != nil {
+		return err
+	}
+	if !self.HasIdentity() {
+		if _, err := self.Create("Quillet relay", "", ""); err != nil {
+			return fmt.Errorf("create relay identity: %w", err)
+		}
+	}
+
+	s, err := relay.Open(filepath.Join(*dir, databaseFileName), self, cfg)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("relay listening", "address", ln.Addr(), "relay", self.PublicID()+"@"+ln.Addr().String())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	port := ln.Addr().(*net.TCPAddr).Port
+	for _, p := range []int{port, port + 1} {
+		serveBinding(ctx, *listen, p)
+	}
+	return s.Serve(ctx, ln)
+}
+
+// serveBinding answers binding requests on UDP port of listen's host
+// until ctx is cancelled. Without them, clients cannot punch holes, so
+// failing to listen is not fatal.
+func serveBinding(ctx context.Context, listen string, port int) {
+	host, _, _ := net.SplitHostPort(listen)
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		slog.Warn("serve binding requests", "error", err)
+		return
+	}
+	slog.Info("serving binding requests", "address", pc.LocalAddr())
+	context.AfterFunc(ctx, func() { pc.Close() })
+	go func() {
+		if err := nat.ServeBinding(pc); err != nil {
+			slog.Warn("serve binding requests", "error", err)
+		}
+	}()
+}
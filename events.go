@@ -3,13 +3,20 @@ package main
 // Wails event name constants.
 // Namespace convention: "domain:action"
 const (
-	EventAppReady        = "app:ready"
-	EventMessageReceived = "message:received"
-	EventMessageStatus   = "message:status"
-	EventContactStatus   = "contact:status"
+	EventAppReady         = "app:ready"
+	EventIdentityRequired = "identity:required"
+	EventIdentityLocked   = "identity:locked"
+	EventMessageReceived  = "message:received"
+	EventMessageStatus    = "message:status"
+	EventMessagesExpired  = "message:expired"
+	EventContactStatus    = "contact:status"
 
-	EventContactTyping   = "contact:typing"
-	EventConnectionState = "connection:state"
+	EventContactTyping     = "contact:typing"
+	EventContactKeyChanged = "contact:key-changed"
+	EventConnectionState   = "connection:state"
+	EventProfileSwitched   = "profile:switched"
+	EventBackupRestored    = "backup:restored"
+	EventImportProgress    = "import:progress"
 
 	// The following events are reserved for future use and
 	// are not currently emitted from the Go backend.
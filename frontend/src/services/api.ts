@@ -28,8 +28,13 @@ export function getIdentity(): Promise<Identity> {
   return GetIdentity();
 }
 
-export function updateProfile(displayName: string): Promise<void> {
-  return UpdateProfile(displayName);
+// avatarPath is an image file to import, the current avatar URL to keep
+// it, or "" to remove the avatar.
+export function updateProfile(
+  displayName: string,
+  avatarPath: string,
+): Promise<void> {
+  return UpdateProfile(displayName, avatarPath);
 }
 
 // Contacts
@@ -39,10 +44,10 @@ export function getContacts(): Promise<Contact[]> {
 }
 
 export function addContact(
-  publicID: string,
+  publicIDOrKey: string,
   displayName: string,
 ): Promise<Contact> {
-  return AddContact(publicID, displayName);
+  return AddContact(publicIDOrKey, displayName);
 }
 
 export function deleteContact(publicID: string): Promise<void> {
@@ -6,10 +6,30 @@ export function AddContact(arg1:string,arg2:string):Promise<domain.Contact>;
 
 export function BlockContact(arg1:string):Promise<void>;
 
+export function ChangePassphrase(arg1:string,arg2:string):Promise<void>;
+
 export function ClearHistory(arg1:string):Promise<void>;
 
+export function CompactStorage():Promise<domain.CompactResult>;
+
+export function CreateBackup(arg1:string,arg2:string):Promise<void>;
+
+export function CreateIdentity(arg1:string,arg2:string,arg3:string):Promise<domain.User>;
+
+export function CreateInvite():Promise<domain.Invite>;
+
+export function CreateProfile(arg1:string):Promise<domain.LocalProfile>;
+
 export function DeleteContact(arg1:string):Promise<void>;
 
+export function DeleteProfile(arg1:string):Promise<void>;
+
+export function ExportChat(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function ExportPrivateKey(arg1:string):Promise<string>;
+
+export function ExportRecoveryPhrase(arg1:string):Promise<string>;
+
 export function GetChatSummaries():Promise<Array<domain.ChatSummary>>;
 
 export function GetContacts():Promise<Array<domain.Contact>>;
@@ -18,16 +38,66 @@ export function GetIdentity():Promise<domain.User>;
 
 export function GetMessages(arg1:string,arg2:number,arg3:string):Promise<Array<domain.Message>>;
 
+export function GetNetworkStatus():Promise<domain.NetworkStatus>;
+
+export function GetSafetyNumber(arg1:string):Promise<string>;
+
 export function GetSettings():Promise<domain.Settings>;
 
+export function GetStorageStats():Promise<domain.StorageStats>;
+
+export function HasIdentity():Promise<boolean>;
+
+export function HasPassphrase():Promise<boolean>;
+
+export function ImportChatHistory(arg1:string,arg2:string,arg3:string,arg4:string,arg5:boolean):Promise<domain.ImportResult>;
+
+export function ImportIdentity(arg1:string,arg2:string,arg3:boolean):Promise<domain.User>;
+
+export function ImportIdentityFile(arg1:string,arg2:string,arg3:boolean):Promise<domain.User>;
+
+export function ImportRecoveryPhrase(arg1:string,arg2:string,arg3:boolean):Promise<domain.User>;
+
+export function IsLocked():Promise<boolean>;
+
+export function ListProfiles():Promise<Array<domain.LocalProfile>>;
+
+export function LockIdentity():Promise<void>;
+
 export function MarkAsRead(arg1:string):Promise<void>;
 
+export function MarkContactVerified(arg1:string):Promise<void>;
+
 export function NotifyReady():Promise<void>;
 
+export function ParseInvite(arg1:string):Promise<domain.Invite>;
+
+export function RestoreBackup(arg1:string,arg2:string):Promise<void>;
+
+export function RetryMessage(arg1:string):Promise<domain.Message>;
+
+export function RotateIdentityKey():Promise<domain.User>;
+
+export function SearchMessages(arg1:string,arg2:string,arg3:number,arg4:string):Promise<domain.SearchResult>;
+
 export function SendMessage(arg1:string,arg2:string):Promise<domain.Message>;
 
+export function SetChatRetention(arg1:string,arg2:number):Promise<void>;
+
+export function SetContactAddress(arg1:string,arg2:string):Promise<void>;
+
+export function SetContactAvatar(arg1:string,arg2:string):Promise<void>;
+
+export function SetTyping(arg1:string,arg2:boolean):Promise<void>;
+
+export function SwitchProfile(arg1:string):Promise<domain.LocalProfile>;
+
 export function UnblockContact(arg1:string):Promise<void>;
 
-export function UpdateProfile(arg1:string):Promise<void>;
+export function UnlockIdentity(arg1:string):Promise<void>;
+
+export function UnverifyContact(arg1:string):Promise<void>;
+
+export function UpdateProfile(arg1:string,arg2:string):Promise<void>;
 
 export function UpdateSettings(arg1:domain.Settings):Promise<void>;
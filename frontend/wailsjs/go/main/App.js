@@ -10,14 +10,54 @@ export function BlockContact(arg1) {
   return window['go']['main']['App']['BlockContact'](arg1);
 }
 
+export function ChangePassphrase(arg1, arg2) {
+  return window['go']['main']['App']['ChangePassphrase'](arg1, arg2);
+}
+
 export function ClearHistory(arg1) {
   return window['go']['main']['App']['ClearHistory'](arg1);
 }
 
+export function CompactStorage() {
+  return window['go']['main']['App']['CompactStorage']();
+}
+
+export function CreateBackup(arg1, arg2) {
+  return window['go']['main']['App']['CreateBackup'](arg1, arg2);
+}
+
+export function CreateIdentity(arg1, arg2, arg3) {
+  return window['go']['main']['App']['CreateIdentity'](arg1, arg2, arg3);
+}
+
+export function CreateInvite() {
+  return window['go']['main']['App']['CreateInvite']();
+}
+
+export function CreateProfile(arg1) {
+  return window['go']['main']['App']['CreateProfile'](arg1);
+}
+
 export function DeleteContact(arg1) {
   return window['go']['main']['App']['DeleteContact'](arg1);
 }
 
+export function DeleteProfile(arg1) {
+  return window['go']['main']['App']['DeleteProfile'](arg1);
+}
+
+export function ExportChat(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ExportChat'](arg1, arg2, arg3);
+}
+
+export function ExportPrivateKey(arg1) {
+  return window['go']['main']['App']['ExportPrivateKey'](arg1);
+}
+
+export function ExportRecoveryPhrase(arg1) {
+  return window['go']['main']['App']['ExportRecoveryPhrase'](arg1);
+}
+
 export function GetChatSummaries() {
   return window['go']['main']['App']['GetChatSummaries']();
 }
@@ -34,28 +74,128 @@ export function GetMessages(arg1, arg2, arg3) {
   return window['go']['main']['App']['GetMessages'](arg1, arg2, arg3);
 }
 
+export function GetNetworkStatus() {
+  return window['go']['main']['App']['GetNetworkStatus']();
+}
+
+export function GetSafetyNumber(arg1) {
+  return window['go']['main']['App']['GetSafetyNumber'](arg1);
+}
+
 export function GetSettings() {
   return window['go']['main']['App']['GetSettings']();
 }
 
+export function GetStorageStats() {
+  return window['go']['main']['App']['GetStorageStats']();
+}
+
+export function HasIdentity() {
+  return window['go']['main']['App']['HasIdentity']();
+}
+
+export function HasPassphrase() {
+  return window['go']['main']['App']['HasPassphrase']();
+}
+
+export function ImportChatHistory(arg1, arg2, arg3, arg4, arg5) {
+  return window['go']['main']['App']['ImportChatHistory'](arg1, arg2, arg3, arg4, arg5);
+}
+
+export function ImportIdentity(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ImportIdentity'](arg1, arg2, arg3);
+}
+
+export function ImportIdentityFile(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ImportIdentityFile'](arg1, arg2, arg3);
+}
+
+export function ImportRecoveryPhrase(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ImportRecoveryPhrase'](arg1, arg2, arg3);
+}
+
+export function IsLocked() {
+  return window['go']['main']['App']['IsLocked']();
+}
+
+export function ListProfiles() {
+  return window['go']['main']['App']['ListProfiles']();
+}
+
+export function LockIdentity() {
+  return window['go']['main']['App']['LockIdentity']();
+}
+
 export function MarkAsRead(arg1) {
   return window['go']['main']['App']['MarkAsRead'](arg1);
 }
 
+export function MarkContactVerified(arg1) {
+  return window['go']['main']['App']['MarkContactVerified'](arg1);
+}
+
 export function NotifyReady() {
   return window['go']['main']['App']['NotifyReady']();
 }
 
+export function ParseInvite(arg1) {
+  return window['go']['main']['App']['ParseInvite'](arg1);
+}
+
+export function RestoreBackup(arg1, arg2) {
+  return window['go']['main']['App']['RestoreBackup'](arg1, arg2);
+}
+
+export function RetryMessage(arg1) {
+  return window['go']['main']['App']['RetryMessage'](arg1);
+}
+
+export function RotateIdentityKey() {
+  return window['go']['main']['App']['RotateIdentityKey']();
+}
+
+export function SearchMessages(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['SearchMessages'](arg1, arg2, arg3, arg4);
+}
+
 export function SendMessage(arg1, arg2) {
   return window['go']['main']['App']['SendMessage'](arg1, arg2);
 }
 
+export function SetChatRetention(arg1, arg2) {
+  return window['go']['main']['App']['SetChatRetention'](arg1, arg2);
+}
+
+export function SetContactAddress(arg1, arg2) {
+  return window['go']['main']['App']['SetContactAddress'](arg1, arg2);
+}
+
+export function SetContactAvatar(arg1, arg2) {
+  return window['go']['main']['App']['SetContactAvatar'](arg1, arg2);
+}
+
+export function SetTyping(arg1, arg2) {
+  return window['go']['main']['App']['SetTyping'](arg1, arg2);
+}
+
+export function SwitchProfile(arg1) {
+  return window['go']['main']['App']['SwitchProfile'](arg1);
+}
+
 export function UnblockContact(arg1) {
   return window['go']['main']['App']['UnblockContact'](arg1);
 }
 
-export function UpdateProfile(arg1) {
-  return window['go']['main']['App']['UpdateProfile'](arg1);
+export function UnlockIdentity(arg1) {
+  return window['go']['main']['App']['UnlockIdentity'](arg1);
+}
+
+export function UnverifyContact(arg1) {
+  return window['go']['main']['App']['UnverifyContact'](arg1);
+}
+
+export function UpdateProfile(arg1, arg2) {
+  return window['go']['main']['App']['UpdateProfile'](arg1, arg2);
 }
 
 export function UpdateSettings(arg1) {
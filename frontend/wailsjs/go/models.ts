@@ -1,5 +1,23 @@
 export namespace domain {
 	
+	export class ChatStorage {
+	    contactID: string;
+	    displayName: string;
+	    messageCount: number;
+	    messageBytes: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ChatStorage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.contactID = source["contactID"];
+	        this.displayName = source["displayName"];
+	        this.messageCount = source["messageCount"];
+	        this.messageBytes = source["messageBytes"];
+	    }
+	}
 	export class Message {
 	    id: string;
 	    chatID: string;
@@ -29,8 +47,11 @@ export namespace domain {
 	    avatarPath: string;
 	    isOnline: boolean;
 	    isBlocked: boolean;
+	    verified: boolean;
 	    lastSeen: number;
 	    addedAt: number;
+	    retentionDays: number;
+	    address: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new Contact(source);
@@ -44,8 +65,11 @@ export namespace domain {
 	        this.avatarPath = source["avatarPath"];
 	        this.isOnline = source["isOnline"];
 	        this.isBlocked = source["isBlocked"];
+	        this.verified = source["verified"];
 	        this.lastSeen = source["lastSeen"];
 	        this.addedAt = source["addedAt"];
+	        this.retentionDays = source["retentionDays"];
+	        this.address = source["address"];
 	    }
 	}
 	export class ChatSummary {
@@ -84,14 +108,274 @@ export namespace domain {
 		    return a;
 		}
 	}
+	export class CompactResult {
+	    bytesBefore: number;
+	    bytesAfter: number;
+	    bytesReclaimed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CompactResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.bytesBefore = source["bytesBefore"];
+	        this.bytesAfter = source["bytesAfter"];
+	        this.bytesReclaimed = source["bytesReclaimed"];
+	    }
+	}
+	
+	export class ContactPath {
+	    contactID: string;
+	    path: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ContactPath(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.contactID = source["contactID"];
+	        this.path = source["path"];
+	    }
+	}
+	export class ImportSender {
+	    name: string;
+	    messages: number;
+	    isSelf: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportSender(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.messages = source["messages"];
+	        this.isSelf = source["isSelf"];
+	    }
+	}
+	export class ImportResult {
+	    dryRun: boolean;
+	    senders: ImportSender[];
+	    total: number;
+	    imported: number;
+	    duplicates: number;
+	    skipped: number;
+	    first: number;
+	    last: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.dryRun = source["dryRun"];
+	        this.senders = this.convertValues(source["senders"], ImportSender);
+	        this.total = source["total"];
+	        this.imported = source["imported"];
+	        this.duplicates = source["duplicates"];
+	        this.skipped = source["skipped"];
+	        this.first = source["first"];
+	        this.last = source["last"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	export class Invite {
+	    link: string;
+	    publicID: string;
+	    publicKey: string;
+	    displayName: string;
+	    issuedAt: number;
+	    qrCode?: number[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Invite(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.link = source["link"];
+	        this.publicID = source["publicID"];
+	        this.publicKey = source["publicKey"];
+	        this.displayName = source["displayName"];
+	        this.issuedAt = source["issuedAt"];
+	        this.qrCode = source["qrCode"];
+	    }
+	}
+	export class LocalProfile {
+	    id: string;
+	    name: string;
+	    createdAt: number;
+	    active: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new LocalProfile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.createdAt = source["createdAt"];
+	        this.active = source["active"];
+	    }
+	}
+	
+	export class PeerStatus {
+	    contactID: string;
+	    remoteAddress: string;
+	    inbound: boolean;
+	    connectedAt: number;
+	    path: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PeerStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.contactID = source["contactID"];
+	        this.remoteAddress = source["remoteAddress"];
+	        this.inbound = source["inbound"];
+	        this.connectedAt = source["connectedAt"];
+	        this.path = source["path"];
+	    }
+	}
+	export class NetworkStatus {
+	    listenAddress: string;
+	    peers: PeerStatus[];
+	    relay: string;
+	    relayConnected: boolean;
+	    nat: string;
+	    mappedAddress: string;
+	    contacts: ContactPath[];
+	
+	    static createFrom(source: any = {}) {
+	        return new NetworkStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.listenAddress = source["listenAddress"];
+	        this.peers = this.convertValues(source["peers"], PeerStatus);
+	        this.relay = source["relay"];
+	        this.relayConnected = source["relayConnected"];
+	        this.nat = source["nat"];
+	        this.mappedAddress = source["mappedAddress"];
+	        this.contacts = this.convertValues(source["contacts"], ContactPath);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	
+	export class SearchHit {
+	    message: Message;
+	    snippet: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SearchHit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message = this.convertValues(source["message"], Message);
+	        this.snippet = source["snippet"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SearchResult {
+	    hits: SearchHit[];
+	    nextCursor: string;
 	
+	    static createFrom(source: any = {}) {
+	        return new SearchResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.hits = this.convertValues(source["hits"], SearchHit);
+	        this.nextCursor = source["nextCursor"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class Settings {
 	    theme: string;
 	    notificationsOn: boolean;
 	    soundOn: boolean;
 	    showMessagePreview: boolean;
 	    sidebarWidth: number;
+	    retentionDays: number;
+	    outboxTTLHours: number;
 	
 	    static createFrom(source: any = {}) {
 	        return new Settings(source);
@@ -104,8 +388,52 @@ export namespace domain {
 	        this.soundOn = source["soundOn"];
 	        this.showMessagePreview = source["showMessagePreview"];
 	        this.sidebarWidth = source["sidebarWidth"];
+	        this.retentionDays = source["retentionDays"];
+	        this.outboxTTLHours = source["outboxTTLHours"];
 	    }
 	}
+	export class StorageStats {
+	    databaseBytes: number;
+	    freeBytes: number;
+	    messageCount: number;
+	    messageBytes: number;
+	    attachmentCount: number;
+	    attachmentBytes: number;
+	    chats: ChatStorage[];
+	
+	    static createFrom(source: any = {}) {
+	        return new StorageStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.databaseBytes = source["databaseBytes"];
+	        this.freeBytes = source["freeBytes"];
+	        this.messageCount = source["messageCount"];
+	        this.messageBytes = source["messageBytes"];
+	        this.attachmentCount = source["attachmentCount"];
+	        this.attachmentBytes = source["attachmentBytes"];
+	        this.chats = this.convertValues(source["chats"], ChatStorage);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class User {
 	    publicID: string;
 	    publicKey: string;
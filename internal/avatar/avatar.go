@@ -0,0 +1,232 @@
+// Package avatar imports user and contact pictures into a profile's
+// avatars/ directory and serves them to the WebView.
+//
+// Imported images are center-cropped to a square thumbnail and re-encoded
+// as PNG, which drops EXIF, XMP and any other embedded metadata. Files are
+// named by the hash of the thumbnail, so importing the same picture twice
+// yields the same avatar.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	// Registered decoders for the accepted source formats.
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"quillet/internal/domain"
+)
+
+const (
+	// DirName is the avatars directory inside a profile data directory.
+	DirName = "avatars"
+
+	// URLPrefix is the asset server path avatars are served under.
+	// Stored avatar paths are URLs of this form, e.g. "/avatars/<hash>.png".
+	URLPrefix = "/avatars/"
+
+	// Size is the width and height of a thumbnail in pixels.
+	Size = 256
+
+	maxSourceSize   = 20 << 20 // bytes
+	maxSourcePixels = 50e6     // width × height, guards against decompression bombs
+	hashLen         = 32       // hex chars of SHA-256 in file names
+)
+
+// Store keeps avatar thumbnails in one directory. It is safe for concurrent use.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a Store over dir, typically <profile>/avatars.
+// The directory is created on the first Import.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Import reads the image at srcPath, converts it to a square thumbnail and
+// stores it. It returns the avatar's URL path.
+// Unsupported or corrupt files fail with domain.ErrInvalidImage.
+func (s *Store) Import(srcPath string) (string, error) {
+	src, err := readSource(srcPath)
+	if err != nil {
+		return "", fmt.Errorf("import avatar: %w", err)
+	}
+	thumb, err := Thumbnail(src)
+	if err != nil {
+		return "", fmt.Errorf("import avatar: %w", err)
+	}
+
+	sum := sha256.Sum256(thumb)
+	name := hex.EncodeToString(sum[:])[:hashLen] + ".png"
+	if err := s.write(name, thumb); err != nil {
+		return "", fmt.Errorf("import avatar: %w", err)
+	}
+	return URLPrefix + name, nil
+}
+
+// Has reports whether url names an avatar present in the store.
+func (s *Store) Has(url string) bool {
+	name, ok := parseURL(url)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+// Usage returns the number and total size of the stored thumbnails.
+func (s *Store) Usage() (files int, size int64, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("avatar usage: %w", err)
+	}
+	for _, e := range entries {
+		if _, ok := parseURL(URLPrefix + e.Name()); !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, 0, fmt.Errorf("avatar usage: %w", err)
+		}
+		files++
+		size += info.Size()
+	}
+	return files, size, nil
+}
+
+// ServeHTTP serves stored thumbnails under URLPrefix.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := parseURL(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	// Names are content hashes, so a file never changes.
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// Thumbnail decodes a PNG, JPEG, GIF or WebP image and returns it as a
+// Size×Size PNG, center-cropped to a square. Only pixels are carried over.
+func Thumbnail(src []byte) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", domain.ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImage, err)
+	}
+
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		b.Min.X+(b.Dx()-side)/2,
+		b.Min.Y+(b.Dy()-side)/2,
+	))
+
+	dst := image.NewNRGBA(image.Rect(0, 0, Size, Size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// readSource reads an image file, refusing anything larger than maxSourceSize.
+func readSource(srcPath string) ([]byte, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxSourceSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSourceSize {
+		return nil, fmt.Errorf("%w: file exceeds %d bytes", domain.ErrImageTooLarge, maxSourceSize)
+	}
+	return data, nil
+}
+
+// write atomically stores a thumbnail under name unless it already exists.
+func (s *Store) write(name string, data []byte) error {
+	dst := filepath.Join(s.dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".avatar-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// parseURL returns the file name of an avatar URL, rejecting anything that
+// is not a bare thumbnail name.
+func parseURL(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, URLPrefix)
+	if !ok || name != path.Base(name) {
+		return "", false
+	}
+	hash, ok := strings.CutSuffix(name, ".png")
+	if !ok || len(hash) != hashLen {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return name, true
+}
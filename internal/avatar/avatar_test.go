@@ -0,0 +1,134 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+// writeJPEG writes a w×h JPEG whose left half is red and right half blue.
+func writeJPEG(t *testing.T, w, h int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestThumbnail(t *testing.T) {
+	src, err := os.ReadFile(writeJPEG(t, 640, 480))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, err := Thumbnail(src)
+	if err != nil {
+		t.Fatalf("Thumbnail() error = %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("thumbnail is not a PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != Size || b.Dy() != Size {
+		t.Errorf("thumbnail size = %dx%d; want %dx%d", b.Dx(), b.Dy(), Size, Size)
+	}
+	// The center crop keeps both halves of the picture.
+	if r, _, _, _ := img.At(Size/8, Size/2).RGBA(); r < 0xc000 {
+		t.Errorf("left side not red after crop")
+	}
+	if _, _, b, _ := img.At(Size*7/8, Size/2).RGBA(); b < 0xc000 {
+		t.Errorf("right side not blue after crop")
+	}
+}
+
+func TestThumbnail_Invalid(t *testing.T) {
+	_, err := Thumbnail([]byte("not an image"))
+	if !errors.Is(err, domain.ErrInvalidImage) {
+		t.Errorf("Thumbnail() error = %v; want %v", err, domain.ErrInvalidImage)
+	}
+}
+
+func TestStore_ImportAndServe(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), DirName))
+	src := writeJPEG(t, 300, 500)
+	if files, size, err := s.Usage(); err != nil || files != 0 || size != 0 {
+		t.Errorf("Usage() before import = %d, %d, %v; want nothing", files, size, err)
+	}
+
+	url, err := s.Import(src)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	again, err := s.Import(src)
+	if err != nil {
+		t.Fatalf("second Import() error = %v", err)
+	}
+	if again != url {
+		t.Errorf("re-import URL = %q; want %q", again, url)
+	}
+	if !s.Has(url) {
+		t.Errorf("Has(%q) = false; want true", url)
+	}
+	if files, size, err := s.Usage(); err != nil || files != 1 || size == 0 {
+		t.Errorf("Usage() = %d, %d, %v; want one file", files, size, err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d; want %d", url, rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q; want image/png", ct)
+	}
+}
+
+func TestStore_RejectsForeignPaths(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	for _, url := range []string{
+		"/avatars/../identity.key",
+		"/avatars/photo.png",
+		"/avatars/0123456789abcdef0123456789abcdef.jpg",
+		"/other/0123456789abcdef0123456789abcdef.png",
+		"/avatars/0123456789abcdef0123456789abcdef.png",
+	} {
+		t.Run(url, func(t *testing.T) {
+			if s.Has(url) {
+				t.Errorf("Has(%q) = true; want false", url)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = url
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("GET %s status = %d; want %d", url, rec.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Package backup writes and restores encrypted backups of a profile.
+//
+// A backup is a single file holding the identity key, a consistent copy of
+// the database (which includes the settings) and the avatars, together
+// with a manifest listing every file with its size and SHA-256. The whole
+// archive is encrypted with a key derived from a backup passphrase that is
+// independent of the identity passphrase.
+//
+// Restoring is split in two so nothing is replaced before the backup is
+// known to be good: Prepare decrypts and checks the archive next to the
+// profile, and Pending.Apply swaps the files in once the profile is closed.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"quillet/internal/avatar"
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/store"
+)
+
+// formatVersion is the current archive and manifest format version.
+const formatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	identityFile = "identity.key"
+	maxManifest  = 1 << 20
+)
+
+// profileFiles are the entries of a profile directory a restore replaces.
+// The database's WAL and shared-memory files go with the database.
+var profileFiles = []string{
+	identityFile,
+	store.FileName,
+	store.FileName + "-wal",
+	store.FileName + "-shm",
+	avatar.DirName,
+}
+
+// Manifest describes the contents of a backup.
+type Manifest struct {
+	Version       int    `json:"version"`
+	CreatedAt     int64  `json:"createdAt"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Files         []File `json:"files"`
+}
+
+// File is one file of a backup. Name is slash-separated and relative to
+// the profile directory.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Snapshotter writes a consistent copy of a live database to a new file.
+type Snapshotter interface {
+	Snapshot(ctx context.Context, path string) error
+}
+
+// Create writes an encrypted backup of the profile in dir to dst, taking
+// the database from db rather than from the file, which may be in the
+// middle of a transaction. dst is replaced atomically. A profile without
+// an identity fails with domain.ErrNoIdentity.
+func Create(ctx context.Context, dst, dir, passphrase string, db Snapshotter) (*Manifest, error) {
+	m, err := create(ctx, dst, dir, passphrase, db)
+	if err != nil {
+		return nil, fmt.Errorf("create backup: %w", err)
+	}
+	return m, nil
+}
+
+func create(ctx context.Context, dst, dir, passphrase string, db Snapshotter) (*Manifest, error) {
+	// Copy everything first, so the archive is one point in time and the
+	// checksums match what is written.
+	staging, err := os.MkdirTemp(dir, ".backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	if err := copyFile(filepath.Join(dir, identityFile), filepath.Join(staging, identityFile)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, domain.ErrNoIdentity
+		}
+		return nil, err
+	}
+	names := []string{identityFile, store.FileName}
+	if err := db.Snapshot(ctx, filepath.Join(staging, store.FileName)); err != nil {
+		return nil, fmt.Errorf("snapshot database: %w", err)
+	}
+	avatars, err := os.ReadDir(filepath.Join(dir, avatar.DirName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(avatars) > 0 {
+		if err := os.Mkdir(filepath.Join(staging, avatar.DirName), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range avatars {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		name := path.Join(avatar.DirName, e.Name())
+		if err := copyFile(filepath.Join(dir, filepath.FromSlash(name)), filepath.Join(staging, filepath.FromSlash(name))); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	m := &Manifest{
+		Version:       formatVersion,
+		CreatedAt:     time.Now().UnixMilli(),
+		SchemaVersion: store.SchemaVersion(),
+	}
+	for _, name := range names {
+		f, err := describe(filepath.Join(staging, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		f.Name = name
+		m.Files = append(m.Files, f)
+	}
+
+	err = writeAtomic(dst, func(w io.Writer) error {
+		return writeArchive(w, staging, passphrase, m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// writeArchive writes the header and the sealed tar of the manifest and
+// the files it lists, read from staging.
+func writeArchive(w io.Writer, staging, passphrase string, m *Manifest) error {
+	sw, err := newSealWriter(w, passphrase)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(sw)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := writeEntry(tw, manifestName, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	for _, f := range m.Files {
+		src, err := os.Open(filepath.Join(staging, filepath.FromSlash(f.Name)))
+		if err != nil {
+			return err
+		}
+		err = writeEntry(tw, f.Name, f.Size, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: size, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// Pending is a backup that was decrypted and checked next to a profile
+// and is ready to replace it.
+type Pending struct {
+	Manifest Manifest
+
+	dir     string // the profile directory
+	staging string // holds the restored files, then the replaced ones
+}
+
+// Prepare decrypts the backup at src into a staging directory inside the
+// profile directory dir and checks it: every file must match the manifest,
+// the identity key must load and the database must open with this
+// version. The profile itself is not touched. A wrong passphrase fails with
+// domain.ErrWrongPassphrase and a damaged or foreign file with
+// domain.ErrCorruptBackup. The caller must Apply or Discard the result.
+func Prepare(ctx context.Context, src, passphrase, dir string) (*Pending, error) {
+	staging, err := os.MkdirTemp(dir, ".restore-*")
+	if err != nil {
+		return nil, fmt.Errorf("restore backup: %w", err)
+	}
+	p := &Pending{dir: dir, staging: staging}
+	if err := p.prepare(ctx, src, passphrase); err != nil {
+		p.Discard()
+		return nil, fmt.Errorf("restore backup: %w", err)
+	}
+	return p, nil
+}
+
+func (p *Pending) prepare(ctx context.Context, src, passphrase string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := newOpenReader(f, passphrase)
+	if err != nil {
+		return err
+	}
+	m, err := extract(ctx, r, filepath.Join(p.staging, "restored"))
+	if err != nil {
+		return err
+	}
+	p.Manifest = *m
+	return p.check()
+}
+
+// check makes sure the restored files are usable by this version.
+func (p *Pending) check() error {
+	restored := filepath.Join(p.staging, "restored")
+	self, err := identity.OpenManager(filepath.Join(restored, identityFile))
+	if err != nil {
+		return err
+	}
+	db, err := store.Open(filepath.Join(restored, store.FileName), self)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// extract unpacks the payload into dir, verifying each file against the
+// manifest, which must come first.
+func extract(ctx context.Context, r io.Reader, dir string) (*Manifest, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, corrupt(err)
+	}
+	if hdr.Name != manifestName || hdr.Size > maxManifest {
+		return nil, fmt.Errorf("%w: manifest missing", domain.ErrCorruptBackup)
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, corrupt(err)
+	}
+	if m.Version != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", domain.ErrCorruptBackup, m.Version)
+	}
+	if m.SchemaVersion > store.SchemaVersion() {
+		return nil, domain.ErrDatabaseTooNew
+	}
+
+	want := make(map[string]File, len(m.Files))
+	for _, f := range m.Files {
+		if !validName(f.Name) {
+			return nil, fmt.Errorf("%w: bad file name %q", domain.ErrCorruptBackup, f.Name)
+		}
+		want[f.Name] = f
+	}
+	for _, name := range []string{identityFile, store.FileName} {
+		if _, ok := want[name]; !ok {
+			return nil, fmt.Errorf("%w: %s missing", domain.ErrCorruptBackup, name)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, avatar.DirName), 0o700); err != nil {
+		return nil, err
+	}
+	for len(want) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, corrupt(err)
+		}
+		f, ok := want[hdr.Name]
+		if !ok || hdr.Typeflag != tar.TypeReg || hdr.Size != f.Size {
+			return nil, fmt.Errorf("%w: unexpected entry %q", domain.ErrCorruptBackup, hdr.Name)
+		}
+		if err := extractFile(tr, filepath.Join(dir, filepath.FromSlash(f.Name)), f); err != nil {
+			return nil, err
+		}
+		delete(want, hdr.Name)
+	}
+	// Reading to the end authenticates the last chunk.
+	if _, err := tr.Next(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: trailing data", domain.ErrCorruptBackup)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, corrupt(err)
+	}
+	return &m, nil
+}
+
+// extractFile writes one entry to dst and checks its checksum.
+func extractFile(r io.Reader, dst string, f File) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return corrupt(err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("%w: checksum mismatch for %s", domain.ErrCorruptBackup, f.Name)
+	}
+	return nil
+}
+
+// validName accepts the names Create writes: the identity key, the
+// database and flat files in the avatars directory.
+func validName(name string) bool {
+	if name == identityFile || name == store.FileName {
+		return true
+	}
+	dir, file := path.Split(name)
+	return dir == avatar.DirName+"/" && file != "" && file != "." && file != ".." &&
+		!strings.ContainsAny(file, `/\`)
+}
+
+// corrupt maps a read error inside the payload to domain.ErrCorruptBackup,
+// keeping domain.ErrWrongPassphrase and domain.ErrCorruptBackup as they are.
+func corrupt(err error) error {
+	if errors.Is(err, domain.ErrWrongPassphrase) || errors.Is(err, domain.ErrCorruptBackup) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", domain.ErrCorruptBackup, err)
+}
+
+// Apply moves the profile's files aside and the restored ones in. The
+// profile must be closed. If a step fails, the moves made so far are
+// undone. After a successful Apply, Revert puts the old files back and
+// Discard drops them.
+func (p *Pending) Apply() error {
+	if err := p.swap(filepath.Join(p.staging, "restored"), filepath.Join(p.staging, "replaced")); err != nil {
+		return fmt.Errorf("restore backup: %w", err)
+	}
+	return nil
+}
+
+// Revert undoes a successful Apply.
+func (p *Pending) Revert() error {
+	if err := p.swap(filepath.Join(p.staging, "replaced"), filepath.Join(p.staging, "restored")); err != nil {
+		return fmt.Errorf("revert restore: %w", err)
+	}
+	return nil
+}
+
+// Discard removes the staging directory with whatever it holds.
+func (p *Pending) Discard() error {
+	return os.RemoveAll(p.staging)
+}
+
+// swap moves the profile files to aside and those in from to the profile.
+func (p *Pending) swap(from, aside string) error {
+	if err := os.MkdirAll(aside, 0o700); err != nil {
+		return err
+	}
+	var undo [][2]string // renames to reverse on failure, in order
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			os.Rename(undo[i][1], undo[i][0])
+		}
+	}
+	move := func(src, dst string) error {
+		if err := os.Rename(src, dst); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		undo = append(undo, [2]string{src, dst})
+		return nil
+	}
+	for _, name := range profileFiles {
+		if err := move(filepath.Join(p.dir, name), filepath.Join(aside, name)); err != nil {
+			rollback()
+			return err
+		}
+	}
+	for _, name := range profileFiles {
+		if err := move(filepath.Join(from, name), filepath.Join(p.dir, name)); err != nil {
+			rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+// describe returns the size and checksum of the file at name.
+func describe(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeAtomic writes a file at path through fn, replacing it only once
+// everything was written and synced.
+func writeAtomic(path string, fn func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".quillet-backup-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := fn(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
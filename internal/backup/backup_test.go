@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"quillet/internal/avatar"
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/local"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+func mustOpen(t *testing.T, dir string) *local.Messenger {
+	t.Helper()
+	self, err := identity.OpenManager(filepath.Join(dir, identityFile))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	m, err := local.Open(dir, self)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// newProfile creates a profile with an identity, one contact named
+// contact and an avatar file.
+func newProfile(t *testing.T, passphrase, contact string) (string, *local.Messenger) {
+	t.Helper()
+	dir := t.TempDir()
+	m := mustOpen(t, dir)
+	if _, err := m.CreateIdentity(newCtx(), "Me", passphrase, ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	c, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, contact)
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	if _, err := m.SendMessage(newCtx(), c.PublicID, "hello "+contact); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, avatar.DirName), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, avatar.DirName, contact+".png"), []byte(contact), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir, m
+}
+
+func mustCreate(t *testing.T, dir string, m *local.Messenger, passphrase string) string {
+	t.Helper()
+	dst := filepath.Join(t.TempDir(), "quillet.backup")
+	if _, err := Create(newCtx(), dst, dir, passphrase, m); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return dst
+}
+
+// contactNames reopens the profile in dir and lists its contacts.
+func contactNames(t *testing.T, dir, passphrase string) []string {
+	t.Helper()
+	m := mustOpen(t, dir)
+	if err := m.UnlockIdentity(newCtx(), passphrase); err != nil {
+		t.Fatalf("UnlockIdentity() error = %v", err)
+	}
+	contacts, err := m.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	var names []string
+	for _, c := range contacts {
+		names = append(names, c.DisplayName)
+	}
+	m.Close()
+	return names
+}
+
+// entries lists a directory, to check that it was left alone.
+func entries(t *testing.T, dir string) []string {
+	t.Helper()
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range list {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRestore(t *testing.T) {
+	srcDir, src := newProfile(t, "old secret", "Alice")
+	archive := mustCreate(t, srcDir, src, "backup pass")
+
+	dir, m := newProfile(t, "other", "Bob")
+	m.Close()
+
+	p, err := Prepare(newCtx(), archive, "backup pass", dir)
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	defer p.Discard()
+	if got := len(p.Manifest.Files); got != 3 {
+		t.Errorf("manifest lists %d files; want 3", got)
+	}
+	// Nothing changes before Apply.
+	if got := contactNames(t, dir, "other"); !slices.Equal(got, []string{"Bob"}) {
+		t.Fatalf("contacts after Prepare = %v; want [Bob]", got)
+	}
+
+	if err := p.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if got := contactNames(t, dir, "old secret"); !slices.Equal(got, []string{"Alice"}) {
+		t.Errorf("contacts after Apply = %v; want [Alice]", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, avatar.DirName, "Alice.png")); err != nil {
+		t.Errorf("avatar not restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, avatar.DirName, "Bob.png")); !os.IsNotExist(err) {
+		t.Errorf("old avatar kept: %v", err)
+	}
+
+	if err := p.Revert(); err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	if got := contactNames(t, dir, "other"); !slices.Equal(got, []string{"Bob"}) {
+		t.Errorf("contacts after Revert = %v; want [Bob]", got)
+	}
+}
+
+func TestPrepare_Rejects(t *testing.T) {
+	srcDir, src := newProfile(t, "", "Alice")
+	archive := mustCreate(t, srcDir, src, "backup pass")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := filepath.Join(t.TempDir(), "damaged.backup")
+	data[len(data)-100] ^= 1
+	if err := os.WriteFile(damaged, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	foreign := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(foreign, []byte("not a backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, m := newProfile(t, "", "Bob")
+	m.Close()
+	before := entries(t, dir)
+
+	tests := []struct {
+		name       string
+		path       string
+		passphrase string
+		wantErr    error
+	}{
+		{name: "wrong passphrase", path: archive, passphrase: "guess", wantErr: domain.ErrWrongPassphrase},
+		{name: "damaged", path: damaged, passphrase: "backup pass", wantErr: domain.ErrCorruptBackup},
+		{name: "foreign file", path: foreign, passphrase: "backup pass", wantErr: domain.ErrCorruptBackup},
+		{name: "missing", path: filepath.Join(dir, "nope"), passphrase: "backup pass", wantErr: os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Prepare(newCtx(), tt.path, tt.passphrase, dir); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Prepare() error = %v; want %v", err, tt.wantErr)
+			}
+			if got := entries(t, dir); !slices.Equal(got, before) {
+				t.Errorf("profile dir = %v; want %v", got, before)
+			}
+		})
+	}
+}
+
+func TestCreate_NoIdentity(t *testing.T) {
+	dir := t.TempDir()
+	m := mustOpen(t, dir)
+	_, err := Create(newCtx(), filepath.Join(t.TempDir(), "x.backup"), dir, "backup pass", m)
+	if !errors.Is(err, domain.ErrNoIdentity) {
+		t.Errorf("Create() error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+}
@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"quillet/internal/domain"
+)
+
+// An archive file is the magic, a length-prefixed JSON header and the tar
+// payload sealed in chunks with XChaCha20-Poly1305. Each chunk nonce is the
+// header's random prefix followed by the chunk counter, with the top bit
+// set on the last chunk, so chunks cannot be reordered, dropped or
+// truncated unnoticed. The header bytes are the associated data of every
+// chunk.
+const (
+	magic         = "QUILLET-BACKUP\n"
+	chunkSize     = 64 << 10
+	maxHeaderSize = 4 << 10
+	prefixSize    = chacha20poly1305.NonceSizeX - 8
+	lastChunk     = 1 << 63
+	checkSize     = 16
+)
+
+// Argon2id parameters, the same as for passphrase-protected identity keys.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonSaltLen = 16
+)
+
+// Bounds on the Argon2id parameters an archive may ask for, so that a
+// damaged header cannot make deriving the key panic or exhaust memory.
+const (
+	argonMaxTime   = 10
+	argonMinMemory = 8 * 1024 // KiB
+	argonMaxMemory = 1 << 20  // KiB
+)
+
+// header is the clear part of an archive: everything needed to derive the
+// key from the passphrase.
+type header struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Nonce   []byte `json:"nonce"` // chunk nonce prefix
+	Check   []byte `json:"check"` // tells a wrong passphrase from a damaged payload
+}
+
+// validKDF reports whether the key derivation parameters are within bounds.
+func (h *header) validKDF() bool {
+	return h.Time >= 1 && h.Time <= argonMaxTime &&
+		h.Memory >= argonMinMemory && h.Memory <= argonMaxMemory &&
+		h.Threads >= 1 // at most 255, as a uint8
+}
+
+// derive returns the archive cipher for passphrase and the check value
+// that goes with it.
+func (h *header) derive(passphrase string) (cipher.AEAD, []byte, error) {
+	out := argon2.IDKey([]byte(passphrase), h.Salt, h.Time, h.Memory, h.Threads, chacha20poly1305.KeySize+checkSize)
+	aead, err := chacha20poly1305.NewX(out[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, out[chacha20poly1305.KeySize:], nil
+}
+
+// sealWriter encrypts everything written to it into chunks.
+// Close must be called to write the last chunk.
+type sealWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	ad    []byte
+	nonce []byte
+	buf   []byte
+	n     uint64
+}
+
+// newSealWriter writes a fresh header for passphrase to w and returns a
+// writer for the payload.
+func newSealWriter(w io.Writer, passphrase string) (*sealWriter, error) {
+	h := &header{
+		Version: formatVersion,
+		Salt:    make([]byte, argonSaltLen),
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+		Nonce:   make([]byte, prefixSize),
+	}
+	if _, err := rand.Read(h.Salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	if _, err := rand.Read(h.Nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	aead, check, err := h.derive(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("init cipher: %w", err)
+	}
+	h.Check = check
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("encode header: %w", err)
+	}
+	ad := binary.BigEndian.AppendUint32([]byte(magic), uint32(len(data)))
+	ad = append(ad, data...)
+	if _, err := w.Write(ad); err != nil {
+		return nil, err
+	}
+	return &sealWriter{
+		w:     w,
+		aead:  aead,
+		ad:    ad,
+		nonce: append(h.Nonce, make([]byte, 8)...),
+		buf:   make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is held back until more data arrives, since only
+		// Close knows which chunk is the last.
+		if len(s.buf) == chunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (s *sealWriter) Close() error {
+	return s.flush(true)
+}
+
+func (s *sealWriter) flush(last bool) error {
+	sealed := s.aead.Seal(nil, chunkNonce(s.nonce, s.n, last), s.buf, s.ad)
+	s.buf = s.buf[:0]
+	s.n++
+	_, err := s.w.Write(sealed)
+	return err
+}
+
+// openReader decrypts an archive payload. Read fails with
+// domain.ErrCorruptBackup if the payload was altered or cut short.
+type openReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	ad    []byte
+	nonce []byte
+	buf   []byte
+	plain []byte
+	n     uint64
+	done  bool
+}
+
+// newOpenReader reads the header from r and derives the key, failing with
+// domain.ErrWrongPassphrase if passphrase is not the one the archive was
+// written with.
+func newOpenReader(r io.Reader, passphrase string) (*openReader, error) {
+	br := bufio.NewReader(r)
+	ad := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(br, ad); err != nil || string(ad[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: not a backup file", domain.ErrCorruptBackup)
+	}
+	size := binary.BigEndian.Uint32(ad[len(magic):])
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("%w: bad header", domain.ErrCorruptBackup)
+	}
+	ad = append(ad, make([]byte, size)...)
+	if _, err := io.ReadFull(br, ad[len(magic)+4:]); err != nil {
+		return nil, fmt.Errorf("%w: bad header", domain.ErrCorruptBackup)
+	}
+	var h header
+	if err := json.Unmarshal(ad[len(magic)+4:], &h); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrCorruptBackup, err)
+	}
+	if h.Version != formatVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", domain.ErrCorruptBackup, h.Version)
+	}
+	if len(h.Nonce) != prefixSize || len(h.Salt) == 0 || !h.validKDF() {
+		return nil, fmt.Errorf("%w: bad header", domain.ErrCorruptBackup)
+	}
+	aead, check, err := h.derive(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("init cipher: %w", err)
+	}
+	if subtle.ConstantTimeCompare(check, h.Check) != 1 {
+		return nil, domain.ErrWrongPassphrase
+	}
+	return &openReader{
+		r:     br,
+		aead:  aead,
+		ad:    ad,
+		nonce: append(h.Nonce, make([]byte, 8)...),
+		buf:   make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk into o.plain.
+func (o *openReader) next() error {
+	n, err := io.ReadFull(o.r, o.buf)
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		o.done = true
+	case err != nil:
+		return fmt.Errorf("%w: truncated", domain.ErrCorruptBackup)
+	default:
+		if _, err := o.r.Peek(1); errors.Is(err, io.EOF) {
+			o.done = true
+		}
+	}
+	plain, err := o.aead.Open(o.buf[:0], chunkNonce(o.nonce, o.n, o.done), o.buf[:n], o.ad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", domain.ErrCorruptBackup, o.n)
+	}
+	o.plain = plain
+	o.n++
+	return nil
+}
+
+// chunkNonce fills the counter part of nonce for chunk n.
+func chunkNonce(nonce []byte, n uint64, last bool) []byte {
+	if last {
+		n |= lastChunk
+	}
+	binary.BigEndian.PutUint64(nonce[prefixSize:], n)
+	return nonce
+}
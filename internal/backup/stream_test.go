@@ -0,0 +1,112 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func mustSeal(t *testing.T, plain []byte, passphrase string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newSealWriter(&buf, passphrase)
+	if err != nil {
+		t.Fatalf("newSealWriter() error = %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	r, err := newOpenReader(bytes.NewReader(sealed), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// withHeader returns sealed with its header changed by edit.
+func withHeader(t *testing.T, sealed []byte, edit func(*header)) []byte {
+	t.Helper()
+	size := binary.BigEndian.Uint32(sealed[len(magic):])
+	rest := sealed[len(magic)+4:]
+	var h header
+	if err := json.Unmarshal(rest[:size], &h); err != nil {
+		t.Fatalf("decode header: %v", err)
+	}
+	edit(&h)
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("encode header: %v", err)
+	}
+	out := binary.BigEndian.AppendUint32([]byte(magic), uint32(len(b)))
+	return append(append(out, b...), rest[size:]...)
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		got, err := open(mustSeal(t, plain, "secret"), "secret")
+		if err != nil {
+			t.Fatalf("size %d: open error = %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip mismatch", size)
+		}
+	}
+}
+
+func TestStream_Tampering(t *testing.T) {
+	plain := make([]byte, 2*chunkSize+100)
+	rand.Read(plain)
+	sealed := mustSeal(t, plain, "secret")
+	headerLen := len(sealed) - (len(plain) + 3*16)
+	chunk := chunkSize + 16
+
+	flip := func(i int) []byte {
+		b := bytes.Clone(sealed)
+		b[i] ^= 1
+		return b
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		wantErr    error
+	}{
+		{name: "wrong passphrase", data: sealed, passphrase: "wrong", wantErr: domain.ErrWrongPassphrase},
+		{name: "not a backup", data: []byte("hello"), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "header", data: flip(len(magic) + 10), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "zero time", data: withHeader(t, sealed, func(h *header) { h.Time = 0 }), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "zero threads", data: withHeader(t, sealed, func(h *header) { h.Threads = 0 }), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "huge memory", data: withHeader(t, sealed, func(h *header) { h.Memory = 1<<32 - 1 }), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "payload", data: flip(headerLen + chunk + 5), passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "last chunk dropped", data: sealed[:headerLen+2*chunk], passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{name: "truncated", data: sealed[:len(sealed)-1], passphrase: "secret", wantErr: domain.ErrCorruptBackup},
+		{
+			name:       "chunks swapped",
+			data:       append(append(bytes.Clone(sealed[:headerLen]), sealed[headerLen+chunk:headerLen+2*chunk]...), sealed[headerLen:headerLen+chunk]...),
+			passphrase: "secret",
+			wantErr:    domain.ErrCorruptBackup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := open(tt.data, tt.passphrase); !errors.Is(err, tt.wantErr) {
+				t.Errorf("open error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Package chatimport brings chat history exported from other messengers
+// into a Quillet chat.
+//
+// An export is read twice, streaming: the first pass lists its senders and
+// counts its messages, the second converts them to domain.Message records
+// and hands them to the messenger in batches. Message IDs are derived from
+// the chat and the message's identity in the export, so importing the
+// same export again, or a later one that overlaps it, adds only what is new.
+package chatimport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"quillet/internal/domain"
+)
+
+// batchSize is how many messages are stored per ImportMessages call;
+// progress is reported after each batch.
+const batchSize = 500
+
+// idNamespace seeds the name-based UUIDs of imported messages.
+var idNamespace = uuid.MustParse("5b0e8f0a-63c5-4d1e-9c41-0d6a3f3e7a52")
+
+// Target is the part of a messenger.Messenger an import writes to.
+type Target interface {
+	GetProfile(ctx context.Context) (*domain.User, error)
+	ImportMessages(ctx context.Context, contactID string, msgs []domain.Message, dryRun bool) (int, error)
+}
+
+// Options describe an import.
+type Options struct {
+	Format    domain.ImportFormat
+	ContactID string
+
+	// SelfName is the local user's sender name in the export; their
+	// messages become outgoing. Everyone else is mapped to the contact.
+	// Empty means the export has no messages from the local user.
+	SelfName string
+
+	// DryRun previews the import without storing anything.
+	DryRun bool
+
+	// Progress, if set, is called after each batch.
+	Progress func(domain.ImportProgress)
+}
+
+// record is one entry of an export. Skip marks entries that carry no
+// message, such as service notices.
+type record struct {
+	Key       string // identifies the entry within the export
+	Sender    string
+	Timestamp int64 // Unix milliseconds
+	Content   string
+	Skip      bool
+}
+
+// parseFunc streams the records of an export to fn, oldest first.
+type parseFunc func(r io.Reader, fn func(record) error) error
+
+// parsers set up a parser for an export format. They may read the export
+// once first, e.g. to tell its date format.
+var parsers = map[domain.ImportFormat]func(r io.Reader, loc *time.Location) (parseFunc, error){
+	domain.ImportTelegram: newTelegramParser,
+	domain.ImportWhatsApp: newWhatsAppParser,
+}
+
+// Import reads the export at path and imports it into opts.ContactID.
+// An unknown format fails with domain.ErrInvalidImportFormat, a file that
+// is not such an export with domain.ErrInvalidImportFile and a SelfName
+// that is not among the senders with domain.ErrUnknownImportSender.
+// Times without a zone in the export are read as local time.
+func Import(ctx context.Context, t Target, path string, opts Options) (*domain.ImportResult, error) {
+	res, err := run(ctx, t, path, opts, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("import chat: %w", err)
+	}
+	return res, nil
+}
+
+func run(ctx context.Context, t Target, path string, opts Options, loc *time.Location) (*domain.ImportResult, error) {
+	newParser, ok := parsers[opts.Format]
+	if !ok {
+		return nil, domain.ErrInvalidImportFormat
+	}
+	me, err := t.GetProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var parse parseFunc
+	err = readFile(path, func(r io.Reader) error {
+		p, err := newParser(r, loc)
+		parse = p
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	each := func(fn func(record) error) error {
+		return readFile(path, func(r io.Reader) error { return parse(r, fn) })
+	}
+
+	res, err := preview(each, opts.SelfName)
+	if err != nil {
+		return nil, err
+	}
+	res.DryRun = opts.DryRun
+
+	var (
+		batch     []domain.Message
+		processed int
+		// seen holds the IDs passed on so far, so that an entry repeated in
+		// a later batch is not counted again by a dry run, which stores
+		// nothing for ImportMessages to find.
+		seen = make(map[string]bool)
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var fresh []domain.Message
+		for _, msg := range batch {
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				fresh = append(fresh, msg)
+			}
+		}
+		n, err := t.ImportMessages(ctx, opts.ContactID, fresh, opts.DryRun)
+		if err != nil {
+			return err
+		}
+		processed += len(batch)
+		res.Imported += n
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(domain.ImportProgress{
+				ContactID: opts.ContactID,
+				Processed: processed,
+				Total:     res.Total,
+				Imported:  res.Imported,
+			})
+		}
+		return nil
+	}
+	err = each(func(rec record) error {
+		if rec.Skip {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg := domain.Message{
+			ID:        messageID(opts.ContactID, opts.Format, rec.Key),
+			ChatID:    opts.ContactID,
+			SenderID:  opts.ContactID,
+			Content:   rec.Content,
+			Timestamp: rec.Timestamp,
+			Status:    domain.StatusRead,
+		}
+		if opts.SelfName != "" && rec.Sender == opts.SelfName {
+			msg.SenderID = me.PublicID
+			msg.Status = domain.StatusDelivered
+		}
+		batch = append(batch, msg)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Duplicates = res.Total - res.Imported
+	return res, nil
+}
+
+// preview makes the first pass: senders, counts and time span.
+func preview(each func(func(record) error) error, selfName string) (*domain.ImportResult, error) {
+	res := &domain.ImportResult{Senders: []domain.ImportSender{}}
+	index := make(map[string]int) // sender name -> position in res.Senders
+	err := each(func(rec record) error {
+		if rec.Skip {
+			res.Skipped++
+			return nil
+		}
+		i, ok := index[rec.Sender]
+		if !ok {
+			i = len(res.Senders)
+			index[rec.Sender] = i
+			res.Senders = append(res.Senders, domain.ImportSender{Name: rec.Sender, IsSelf: rec.Sender == selfName})
+		}
+		res.Senders[i].Messages++
+		res.Total++
+		if res.First == 0 || rec.Timestamp < res.First {
+			res.First = rec.Timestamp
+		}
+		res.Last = max(res.Last, rec.Timestamp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := index[selfName]; selfName != "" && !ok {
+		return nil, fmt.Errorf("%w: %q", domain.ErrUnknownImportSender, selfName)
+	}
+	return res, nil
+}
+
+// messageID returns the stable ID of an imported message.
+func messageID(contactID string, format domain.ImportFormat, key string) string {
+	return uuid.NewSHA1(idNamespace, []byte(contactID+"\x00"+string(format)+"\x00"+key)).String()
+}
+
+func readFile(path string, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
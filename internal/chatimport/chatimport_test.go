@@ -0,0 +1,248 @@
+package chatimport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/local"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+// newTarget returns a messenger with an identity and the contact Alice.
+func newTarget(t *testing.T) (*local.Messenger, string) {
+	t.Helper()
+	dir := t.TempDir()
+	self, err := identity.OpenManager(filepath.Join(dir, "identity.key"))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	m, err := local.Open(dir, self)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	alice, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	return m, alice.PublicID
+}
+
+func mustImport(t *testing.T, m *local.Messenger, opts Options, file string) *domain.ImportResult {
+	t.Helper()
+	res, err := run(newCtx(), m, filepath.Join("testdata", file), opts, time.UTC)
+	if err != nil {
+		t.Fatalf("import %s error = %v", file, err)
+	}
+	return res
+}
+
+func mustHistory(t *testing.T, m *local.Messenger, contactID string) []domain.Message {
+	t.Helper()
+	msgs, err := m.GetMessages(newCtx(), contactID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	return msgs
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		file      string
+		format    domain.ImportFormat
+		skipped   int
+		contents  []string
+		firstTime time.Time
+	}{
+		{
+			file:      "telegram.json",
+			format:    domain.ImportTelegram,
+			skipped:   1,
+			contents:  []string{"Happy new year!", "You too, Alice!", "[photo]\nfireworks"},
+			firstTime: time.Date(2023, 12, 31, 21, 41, 5, 0, time.UTC),
+		},
+		{
+			file:      "whatsapp_android.txt",
+			format:    domain.ImportWhatsApp,
+			skipped:   1,
+			contents:  []string{"Happy new year!\nSee you\n\ntomorrow?", "You too!", "You too!", "<Media omitted>"},
+			firstTime: time.Date(2023, 12, 31, 21, 41, 0, 0, time.UTC),
+		},
+		{
+			file:      "whatsapp_ios.txt",
+			format:    domain.ImportWhatsApp,
+			skipped:   1,
+			contents:  []string{"Frohes neues Jahr!", "Danke, dir auch", "image omitted"},
+			firstTime: time.Date(2023, 12, 31, 21, 41, 5, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			m, alice := newTarget(t)
+			opts := Options{Format: tt.format, ContactID: alice, SelfName: "Me Myself", DryRun: true}
+
+			preview := mustImport(t, m, opts, tt.file)
+			if got := len(mustHistory(t, m, alice)); got != 0 {
+				t.Fatalf("dry run stored %d messages", got)
+			}
+			want := len(tt.contents)
+			if preview.Total != want || preview.Imported != want || preview.Skipped != tt.skipped {
+				t.Errorf("preview = %+v; want %d new, %d skipped", preview, want, tt.skipped)
+			}
+			if len(preview.Senders) != 2 || preview.Senders[0].Name != "Alice" || !preview.Senders[1].IsSelf {
+				t.Errorf("senders = %+v; want Alice, then Me Myself as self", preview.Senders)
+			}
+			if preview.First != tt.firstTime.UnixMilli() {
+				t.Errorf("first = %v; want %v", time.UnixMilli(preview.First).UTC(), tt.firstTime)
+			}
+
+			opts.DryRun = false
+			var progress []domain.ImportProgress
+			opts.Progress = func(p domain.ImportProgress) { progress = append(progress, p) }
+			res := mustImport(t, m, opts, tt.file)
+			if res.Imported != want || res.Duplicates != 0 {
+				t.Errorf("import = %+v; want %d new", res, want)
+			}
+			if len(progress) == 0 || progress[len(progress)-1].Processed != want {
+				t.Errorf("progress = %+v; want a final report of %d", progress, want)
+			}
+
+			history := mustHistory(t, m, alice)
+			var contents []string
+			for _, msg := range history {
+				contents = append(contents, msg.Content)
+			}
+			if !slices.Equal(contents, tt.contents) {
+				t.Errorf("history = %q; want %q", contents, tt.contents)
+			}
+			if history[0].SenderID != alice || history[1].SenderID == alice {
+				t.Errorf("senders = %s, %s; want Alice then me", history[0].SenderID, history[1].SenderID)
+			}
+
+			// Importing the same export again adds nothing.
+			again := mustImport(t, m, opts, tt.file)
+			if again.Imported != 0 || again.Duplicates != want {
+				t.Errorf("re-import = %+v; want %d duplicates", again, want)
+			}
+			if got := len(mustHistory(t, m, alice)); got != want {
+				t.Errorf("history after re-import has %d messages; want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestImport_DuplicatesAcrossBatches(t *testing.T) {
+	// A Telegram export whose first message comes again after a full batch.
+	var b strings.Builder
+	b.WriteString(`{"name": "Alice", "type": "personal_chat", "id": 4242, "messages": [`)
+	for i := range batchSize + 1 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		id := i%batchSize + 1
+		fmt.Fprintf(&b, `{"id": %d, "type": "message", "date": "2024-01-01T10:00:00", "from": "Alice", "text": "message %d"}`, id, id)
+	}
+	b.WriteString("]}")
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, alice := newTarget(t)
+	opts := Options{Format: domain.ImportTelegram, ContactID: alice, DryRun: true}
+	preview, err := run(newCtx(), m, path, opts, time.UTC)
+	if err != nil {
+		t.Fatalf("dry run error = %v", err)
+	}
+	opts.DryRun = false
+	res, err := run(newCtx(), m, path, opts, time.UTC)
+	if err != nil {
+		t.Fatalf("import error = %v", err)
+	}
+	if preview.Imported != batchSize || res.Imported != batchSize || res.Duplicates != 1 {
+		t.Errorf("preview = %+v, import = %+v; want %d new and 1 duplicate in both", preview, res, batchSize)
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	m, alice := newTarget(t)
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("just some notes\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(dir, "result.json")
+	if err := os.WriteFile(full, []byte(`{"about": "", "chats": {"list": []}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    Options
+		wantErr error
+	}{
+		{name: "format", path: "testdata/telegram.json", opts: Options{Format: "signal"}, wantErr: domain.ErrInvalidImportFormat},
+		{name: "not whatsapp", path: notes, opts: Options{Format: domain.ImportWhatsApp}, wantErr: domain.ErrInvalidImportFile},
+		{name: "not json", path: notes, opts: Options{Format: domain.ImportTelegram}, wantErr: domain.ErrInvalidImportFile},
+		{name: "full telegram export", path: full, opts: Options{Format: domain.ImportTelegram}, wantErr: domain.ErrInvalidImportFile},
+		{name: "unknown self", path: "testdata/telegram.json", opts: Options{Format: domain.ImportTelegram, SelfName: "Bob"}, wantErr: domain.ErrUnknownImportSender},
+		{name: "unknown contact", path: "testdata/telegram.json", opts: Options{Format: domain.ImportTelegram, ContactID: "fedcba9876543210"}, wantErr: domain.ErrContactNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.ContactID == "" {
+				tt.opts.ContactID = alice
+			}
+			_, err := Import(newCtx(), m, tt.path, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Import() error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := len(mustHistory(t, m, alice)); got != 0 {
+		t.Errorf("failed imports stored %d messages", got)
+	}
+}
+
+func TestWhatsAppDates(t *testing.T) {
+	tests := []struct {
+		line     string
+		dayFirst bool
+		want     time.Time
+	}{
+		{"03/04/24, 13:05 - A: x", true, time.Date(2024, 4, 3, 13, 5, 0, 0, time.UTC)},
+		{"03/04/24, 13:05 - A: x", false, time.Date(2024, 3, 4, 13, 5, 0, 0, time.UTC)},
+		{"[3/4/2024, 12:05:09 AM] A: x", false, time.Date(2024, 3, 4, 0, 5, 9, 0, time.UTC)},
+		{"2024-03-04, 1:05 p.m. - A: x", false, time.Date(2024, 3, 4, 13, 5, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		h, ok := parseWhatsAppHeader(tt.line)
+		if !ok {
+			t.Errorf("parseWhatsAppHeader(%q) did not match", tt.line)
+			continue
+		}
+		got, ok := h.time(tt.dayFirst, time.UTC)
+		if !ok || !got.Equal(tt.want) {
+			t.Errorf("time(%q, dayFirst=%v) = %v, %v; want %v", tt.line, tt.dayFirst, got, ok, tt.want)
+		}
+	}
+	if _, ok := parseWhatsAppHeader("just " + strings.Repeat("text ", 3)); ok {
+		t.Error("plain text matched as a message header")
+	}
+}
@@ -0,0 +1,187 @@
+package chatimport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// telegramMessage is an entry of the "messages" array of a Telegram
+// Desktop export (result.json), limited to the fields an import uses.
+type telegramMessage struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"` // "message" or "service"
+	Date      string          `json:"date"` // local time, no zone
+	DateUnix  string          `json:"date_unixtime"`
+	From      string          `json:"from"`
+	FromID    string          `json:"from_id"`
+	Text      json.RawMessage `json:"text"`
+	MediaType string          `json:"media_type"`
+	Photo     string          `json:"photo"`
+	File      string          `json:"file"`
+}
+
+// telegramDate is the layout of the "date" field.
+const telegramDate = "2006-01-02T15:04:05"
+
+// newTelegramParser returns a parser for a single-chat export. The export
+// is one JSON object; its "messages" array is decoded one entry at a time.
+func newTelegramParser(_ io.Reader, loc *time.Location) (parseFunc, error) {
+	return func(r io.Reader, fn func(record) error) error {
+		err := parseTelegram(r, loc, fn)
+		var syntax *json.SyntaxError
+		var typ *json.UnmarshalTypeError
+		if errors.As(err, &syntax) || errors.As(err, &typ) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%w: %v", domain.ErrInvalidImportFile, err)
+		}
+		return err
+	}, nil
+}
+
+func parseTelegram(r io.Reader, loc *time.Location, fn func(record) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	chatID := ""
+	seen := false
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "id":
+			var id json.Number
+			if err := dec.Decode(&id); err != nil {
+				return err
+			}
+			chatID = id.String()
+		case "messages":
+			seen = true
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var m telegramMessage
+				if err := dec.Decode(&m); err != nil {
+					return err
+				}
+				rec, err := m.record(chatID, loc)
+				if err != nil {
+					return err
+				}
+				if err := fn(rec); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		case "chats":
+			return fmt.Errorf("%w: full account export; export a single chat instead", domain.ErrInvalidImportFile)
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	if !seen {
+		return fmt.Errorf("%w: no messages", domain.ErrInvalidImportFile)
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("%w: expected %v", domain.ErrInvalidImportFile, want)
+	}
+	return nil
+}
+
+// record converts m. The chat ID scopes message IDs, which Telegram only
+// keeps unique within a chat.
+func (m *telegramMessage) record(chatID string, loc *time.Location) (record, error) {
+	rec := record{Key: chatID + ":" + strconv.FormatInt(m.ID, 10), Sender: m.From}
+	if rec.Sender == "" {
+		rec.Sender = m.FromID // deleted accounts have no name
+	}
+	if unix, err := strconv.ParseInt(m.DateUnix, 10, 64); err == nil {
+		rec.Timestamp = unix * 1000
+	} else {
+		t, err := time.ParseInLocation(telegramDate, m.Date, loc)
+		if err != nil {
+			return record{}, fmt.Errorf("%w: message %d: bad date %q", domain.ErrInvalidImportFile, m.ID, m.Date)
+		}
+		rec.Timestamp = t.UnixMilli()
+	}
+
+	text, err := telegramText(m.Text)
+	if err != nil {
+		return record{}, fmt.Errorf("%w: message %d: %v", domain.ErrInvalidImportFile, m.ID, err)
+	}
+	var parts []string
+	if media := m.media(); media != "" {
+		parts = append(parts, "["+media+"]")
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	rec.Content = strings.Join(parts, "\n")
+	rec.Skip = m.Type != "message" || rec.Content == ""
+	return rec, nil
+}
+
+// media names the attachment of m, if any; the file itself is not imported.
+func (m *telegramMessage) media() string {
+	switch {
+	case m.MediaType != "":
+		return strings.ReplaceAll(m.MediaType, "_", " ")
+	case m.Photo != "":
+		return "photo"
+	case m.File != "":
+		return "file"
+	}
+	return ""
+}
+
+// telegramText flattens the "text" field: either a string or an array of
+// strings and formatted {"type", "text"} entities.
+func telegramText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(p, &s); err == nil {
+			b.WriteString(s)
+		} else if err := json.Unmarshal(p, &entity); err == nil {
+			b.WriteString(entity.Text)
+		} else {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
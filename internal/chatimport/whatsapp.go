@@ -0,0 +1,193 @@
+package chatimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// whatsAppLine matches the first line of a message in a WhatsApp text
+// export, in the Android form "31/12/23, 21:41 - Alice: Hi" and the iOS
+// form "[31/12/2023, 21:41:05] Alice: Hi", with either date order, a
+// 12- or 24-hour clock and an optional four-digit year in front.
+// Lines that do not match continue the previous message.
+var whatsAppLine = regexp.MustCompile(
+	`^\[?(\d{1,4})[./-](\d{1,2})[./-](\d{1,4}),? (\d{1,2}):(\d{2})(?::(\d{2}))?(?: ?([AaPp])\.? ?[Mm]\.?)?(?:\] | - )(.*)$`)
+
+const (
+	maxWhatsAppLine  = 1 << 20
+	encryptionNotice = "Messages and calls are end-to-end encrypted"
+)
+
+// whatsAppHeader is a parsed whatsAppLine.
+type whatsAppHeader struct {
+	a, b, c, hour, min, sec int
+	yearFirst               bool
+	ampm                    byte // 'a', 'p' or 0 for a 24-hour clock
+	rest                    string
+}
+
+func parseWhatsAppHeader(line string) (whatsAppHeader, bool) {
+	m := whatsAppLine.FindStringSubmatch(line)
+	if m == nil {
+		return whatsAppHeader{}, false
+	}
+	num := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	h := whatsAppHeader{
+		a: num(m[1]), b: num(m[2]), c: num(m[3]),
+		hour: num(m[4]), min: num(m[5]), sec: num(m[6]),
+		yearFirst: len(m[1]) == 4,
+		rest:      m[8],
+	}
+	if m[7] != "" {
+		h.ampm = strings.ToLower(m[7])[0]
+	}
+	return h, true
+}
+
+// time resolves the header's date with dayFirst telling "03/04" apart.
+func (h whatsAppHeader) time(dayFirst bool, loc *time.Location) (time.Time, bool) {
+	year, month, day := h.c, h.b, h.a
+	switch {
+	case h.yearFirst:
+		year, month, day = h.a, h.b, h.c
+	case !dayFirst:
+		month, day = h.a, h.b
+	}
+	if year < 100 {
+		year += 2000
+	}
+	hour := h.hour
+	switch h.ampm {
+	case 'a':
+		if hour == 12 {
+			hour = 0
+		}
+	case 'p':
+		if hour != 12 {
+			hour += 12
+		}
+	}
+	t := time.Date(year, time.Month(month), day, hour, h.min, h.sec, 0, loc)
+	// time.Date normalizes out-of-range values; reject them instead.
+	if t.Day() != day || int(t.Month()) != month || hour > 23 || h.min > 59 || h.sec > 59 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// whatsAppLines calls fn for every line of r, cleaned of the byte order
+// mark, direction marks and the non-breaking spaces newer versions put
+// around the time.
+func whatsAppLines(r io.Reader, fn func(line string) error) error {
+	clean := strings.NewReplacer("\ufeff", "", "\u200e", "", "\u200f", "", "\u202f", " ", "\u00a0", " ")
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxWhatsAppLine)
+	for sc.Scan() {
+		if err := fn(clean.Replace(strings.TrimRight(sc.Text(), "\r"))); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidImportFile, err)
+	}
+	return nil
+}
+
+// newWhatsAppParser reads the export once to learn its date order: a
+// first field above 12 means day first, a second one month first. An
+// export that never says is read day first.
+func newWhatsAppParser(r io.Reader, loc *time.Location) (parseFunc, error) {
+	var dayFirst, monthFirst, found bool
+	err := whatsAppLines(r, func(line string) error {
+		h, ok := parseWhatsAppHeader(line)
+		if !ok {
+			return nil
+		}
+		found = true
+		if !h.yearFirst {
+			dayFirst = dayFirst || h.a > 12
+			monthFirst = monthFirst || h.b > 12
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: no WhatsApp messages found", domain.ErrInvalidImportFile)
+	}
+	if dayFirst && monthFirst {
+		return nil, fmt.Errorf("%w: mixed date formats", domain.ErrInvalidImportFile)
+	}
+	return func(r io.Reader, fn func(record) error) error {
+		return parseWhatsApp(r, !monthFirst, loc, fn)
+	}, nil
+}
+
+func parseWhatsApp(r io.Reader, dayFirst bool, loc *time.Location, fn func(record) error) error {
+	var (
+		cur     *record
+		lastTS  int64
+		ordinal int // position among messages with the same timestamp
+	)
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		rec := *cur
+		cur = nil
+		rec.Skip = rec.Skip || strings.TrimSpace(rec.Content) == ""
+		if !rec.Skip {
+			if rec.Timestamp == lastTS {
+				ordinal++
+			} else {
+				lastTS, ordinal = rec.Timestamp, 0
+			}
+			// No IDs in the export: the time, the position within that
+			// minute and the message itself identify it.
+			rec.Key = fmt.Sprintf("%d:%d:%s:%s", rec.Timestamp, ordinal, rec.Sender, rec.Content)
+		}
+		return fn(rec)
+	}
+
+	err := whatsAppLines(r, func(line string) error {
+		h, ok := parseWhatsAppHeader(line)
+		if !ok {
+			if cur != nil && !cur.Skip {
+				cur.Content += "\n" + line
+			}
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		t, ok := h.time(dayFirst, loc)
+		if !ok {
+			return fmt.Errorf("%w: bad date in %q", domain.ErrInvalidImportFile, line)
+		}
+		cur = &record{Timestamp: t.UnixMilli()}
+		// Notices have no sender on Android; iOS puts the chat name in
+		// front of the encryption notice.
+		sender, content, ok := strings.Cut(h.rest, ": ")
+		if !ok || strings.HasPrefix(content, encryptionNotice) {
+			cur.Skip = true
+			return nil
+		}
+		cur.Sender, cur.Content = sender, content
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -8,3 +8,56 @@ const (
 	ConnectionConnecting   ConnectionState = "connecting"
 	ConnectionDisconnected ConnectionState = "disconnected"
 )
+
+// NATType is how the network between the node and its relay treats UDP,
+// which decides whether hole punching can work.
+type NATType string
+
+const (
+	NATUnknown   NATType = ""          // not detected, e.g. without a relay
+	NATOpen      NATType = "open"      // no NAT: the node's own address is public
+	NATCone      NATType = "cone"      // one public address for all destinations
+	NATSymmetric NATType = "symmetric" // a public address per destination; punching rarely works
+	NATBlocked   NATType = "blocked"   // UDP does not get through
+)
+
+// Path is how messages reach a contact.
+type Path string
+
+const (
+	PathDirect  Path = "direct"  // a TCP connection
+	PathPunched Path = "punched" // a UDP connection through NATs, opened by hole punching
+	PathRelay   Path = "relay"   // stored and forwarded by the relay
+	PathNone    Path = "none"    // messages wait until one of the above is available
+)
+
+// NetworkStatus describes the peer-to-peer node for connection diagnostics.
+// ListenAddress is "" while the node is not listening; Relay is the relay
+// the node uses, "" if none. NAT and MappedAddress, the public UDP address
+// the relay sees, are detected while the relay is connected. Contacts
+// tells the path to each contact that is not blocked.
+type NetworkStatus struct {
+	ListenAddress  string        `json:"listenAddress"`
+	Peers          []PeerStatus  `json:"peers"`
+	Relay          string        `json:"relay"`
+	RelayConnected bool          `json:"relayConnected"`
+	NAT            NATType       `json:"nat"`
+	MappedAddress  string        `json:"mappedAddress"`
+	Contacts       []ContactPath `json:"contacts"`
+}
+
+// PeerStatus is an authenticated connection to a contact. Inbound tells
+// whether the contact dialed us; ConnectedAt is in Unix milliseconds.
+type PeerStatus struct {
+	ContactID     string `json:"contactID"`
+	RemoteAddress string `json:"remoteAddress"`
+	Inbound       bool   `json:"inbound"`
+	ConnectedAt   int64  `json:"connectedAt"`
+	Path          Path   `json:"path"`
+}
+
+// ContactPath is the path messages to a contact currently take.
+type ContactPath struct {
+	ContactID string `json:"contactID"`
+	Path      Path   `json:"path"`
+}
@@ -1,13 +1,21 @@
 package domain
 
 // Contact represents a remote peer in the contact list.
+// Verified is set once the user has compared safety numbers out of band
+// and is cleared whenever PublicKey changes.
+// RetentionDays overrides Settings.RetentionDays for the chat: 0 follows
+// the global setting and RetentionForever keeps the history for good.
+// Address is the host:port the contact is dialed at, "" if unknown.
 type Contact struct {
-	PublicID    string `json:"publicID"`
-	PublicKey   string `json:"publicKey"`
-	DisplayName string `json:"displayName"`
-	AvatarPath  string `json:"avatarPath"`
-	IsOnline    bool   `json:"isOnline"`
-	IsBlocked   bool   `json:"isBlocked"`
-	LastSeen    int64  `json:"lastSeen"`
-	AddedAt     int64  `json:"addedAt"`
+	PublicID      string `json:"publicID"`
+	PublicKey     string `json:"publicKey"`
+	DisplayName   string `json:"displayName"`
+	AvatarPath    string `json:"avatarPath"`
+	IsOnline      bool   `json:"isOnline"`
+	IsBlocked     bool   `json:"isBlocked"`
+	Verified      bool   `json:"verified"`
+	LastSeen      int64  `json:"lastSeen"`
+	AddedAt       int64  `json:"addedAt"`
+	RetentionDays int    `json:"retentionDays"`
+	Address       string `json:"address"`
 }
@@ -7,3 +7,11 @@ type ChatSummary struct {
 	LastMessage *Message `json:"lastMessage"`
 	UnreadCount int      `json:"unreadCount"`
 }
+
+// ExpiredMessages reports that retention deleted Count messages of a chat,
+// all of those sent before Before (Unix milliseconds).
+type ExpiredMessages struct {
+	ChatID string `json:"chatID"`
+	Before int64  `json:"before"`
+	Count  int    `json:"count"`
+}
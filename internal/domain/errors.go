@@ -2,24 +2,113 @@ package domain
 
 import "errors"
 
+// Sentinel errors for identity operations.
+var (
+	ErrNoIdentity        = errors.New("identity not created")
+	ErrIdentityExists    = errors.New("identity already exists")
+	ErrLocked            = errors.New("identity is locked")
+	ErrWrongPassphrase   = errors.New("wrong passphrase")
+	ErrNoPassphrase      = errors.New("identity has no passphrase")
+	ErrCorruptKeystore   = errors.New("identity key file is corrupt")
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+	ErrInvalidRotation   = errors.New("invalid key rotation certificate")
+)
+
+// Sentinel errors for recovery phrase validation.
+var (
+	ErrMnemonicWordCount   = errors.New("recovery phrase has the wrong number of words")
+	ErrMnemonicUnknownWord = errors.New("recovery phrase contains an unknown word")
+	ErrMnemonicChecksum    = errors.New("recovery phrase checksum mismatch")
+)
+
+// Sentinel errors for local profile operations.
+var (
+	ErrProfileNotFound    = errors.New("profile not found")
+	ErrProfileExists      = errors.New("profile already exists")
+	ErrProfileActive      = errors.New("profile is active")
+	ErrEmptyProfileName   = errors.New("profile name is empty")
+	ErrProfileNameTooLong = errors.New("profile name is too long")
+)
+
+// Sentinel errors for avatar images.
+var (
+	ErrInvalidImage  = errors.New("unsupported or corrupt image")
+	ErrImageTooLarge = errors.New("image is too large")
+)
+
 // Sentinel errors for contact operations.
 var (
-	ErrContactNotFound = errors.New("contact not found")
-	ErrContactExists   = errors.New("contact already exists")
-	ErrContactBlocked  = errors.New("contact is blocked")
+	ErrContactNotFound   = errors.New("contact not found")
+	ErrContactExists     = errors.New("contact already exists")
+	ErrContactBlocked    = errors.New("contact is blocked")
+	ErrInvalidPublicKey  = errors.New("invalid public key")
+	ErrInvalidInvite     = errors.New("invalid invite link")
+	ErrInvalidPublicID   = errors.New("invalid public ID")
+	ErrPublicIDMismatch  = errors.New("public ID does not match public key")
+	ErrSelfContact       = errors.New("cannot add yourself as a contact")
+	ErrContactKeyUnknown = errors.New("contact's public key is not known yet")
+)
+
+// Sentinel errors for the local database.
+var (
+	ErrDatabaseTooNew  = errors.New("database was written by a newer version of Quillet")
+	ErrDatabaseKey     = errors.New("database is encrypted with a different key")
+	ErrCorruptDatabase = errors.New("database is corrupt")
+)
+
+// Sentinel errors for the data directory.
+var (
+	ErrDataDirLocked = errors.New("data directory is in use by another Quillet process")
+)
+
+// Sentinel errors for the peer-to-peer network.
+var (
+	ErrHandshake      = errors.New("peer handshake failed")
+	ErrUnexpectedPeer = errors.New("peer is not the contact that was dialed")
+	ErrInvalidAddress = errors.New("invalid network address")
+)
+
+// Sentinel errors for relays.
+var (
+	ErrRelayRejected   = errors.New("relay refused the envelope")
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+)
+
+// Sentinel errors for chat imports.
+var (
+	ErrInvalidImportFormat = errors.New("invalid import format")
+	ErrInvalidImportFile   = errors.New("file is not a supported chat export")
+	ErrUnknownImportSender = errors.New("sender does not appear in the chat export")
+)
+
+// Sentinel errors for backups.
+var (
+	ErrCorruptBackup = errors.New("backup file is corrupt")
 )
 
 // Sentinel errors for message operations.
 var (
-	ErrMessageNotFound = errors.New("message not found")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageNotFailed = errors.New("message has not failed")
+)
+
+// Sentinel errors for message search.
+var (
+	ErrEmptySearchQuery   = errors.New("search query is empty")
+	ErrInvalidSearchQuery = errors.New("invalid search query")
 )
 
 // Sentinel errors for validation.
 var (
-	ErrEmptyDisplayName = errors.New("display name is empty")
-	ErrEmptyContent     = errors.New("message content is empty")
-	ErrEmptyPublicID    = errors.New("public ID is empty")
-	ErrInvalidLimit     = errors.New("limit must be positive")
-	ErrInvalidTheme     = errors.New("invalid theme")
-	ErrInvalidSidebar   = errors.New("sidebar width must be positive")
+	ErrEmptyDisplayName    = errors.New("display name is empty")
+	ErrDisplayNameTooLong  = errors.New("display name is too long")
+	ErrPassphraseTooShort  = errors.New("passphrase is too short")
+	ErrEmptyContent        = errors.New("message content is empty")
+	ErrEmptyPublicID       = errors.New("public ID is empty")
+	ErrInvalidLimit        = errors.New("limit must be positive")
+	ErrInvalidTheme        = errors.New("invalid theme")
+	ErrInvalidSidebar      = errors.New("sidebar width must be positive")
+	ErrInvalidExportFormat = errors.New("invalid export format")
+	ErrInvalidRetention    = errors.New("invalid retention period")
+	ErrInvalidOutboxTTL    = errors.New("invalid outbox TTL")
 )
@@ -0,0 +1,40 @@
+package domain
+
+// ImportFormat is the kind of chat export an import reads.
+type ImportFormat string
+
+const (
+	ImportTelegram ImportFormat = "telegram" // Telegram Desktop JSON export of one chat
+	ImportWhatsApp ImportFormat = "whatsapp" // WhatsApp "Export chat" text file
+)
+
+// ImportSender is a sender name found in a chat export, with the number of
+// messages under it.
+type ImportSender struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	IsSelf   bool   `json:"isSelf"`
+}
+
+// ImportResult summarizes an import, or for a dry run what it would do.
+// Total counts the messages in the export; Imported those added (or that
+// would be), Duplicates those already in the chat and Skipped the entries
+// without a message, such as service notices.
+type ImportResult struct {
+	DryRun     bool           `json:"dryRun"`
+	Senders    []ImportSender `json:"senders"`
+	Total      int            `json:"total"`
+	Imported   int            `json:"imported"`
+	Duplicates int            `json:"duplicates"`
+	Skipped    int            `json:"skipped"`
+	First      int64          `json:"first"` // timestamp of the oldest message, 0 if none
+	Last       int64          `json:"last"`
+}
+
+// ImportProgress reports how far a running import has got.
+type ImportProgress struct {
+	ContactID string `json:"contactID"`
+	Processed int    `json:"processed"`
+	Total     int    `json:"total"`
+	Imported  int    `json:"imported"`
+}
@@ -0,0 +1,14 @@
+package domain
+
+// Invite is a signed invitation to add its issuer as a contact.
+// Link is the quillet://add URL that carries it; PublicID, PublicKey and
+// DisplayName prefill AddContact. QRCode holds a PNG rendering of Link
+// and is only set on invites created locally.
+type Invite struct {
+	Link        string `json:"link"`
+	PublicID    string `json:"publicID"`
+	PublicKey   string `json:"publicKey"`
+	DisplayName string `json:"displayName"`
+	IssuedAt    int64  `json:"issuedAt"`
+	QRCode      []byte `json:"qrCode,omitempty"`
+}
@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Identifier lengths in hex characters (UC-002).
+const (
+	PublicIDLength  = 16
+	PublicKeyLength = 64
+)
+
+// PeerID identifies a remote identity by its Public ID and, when known,
+// its full Ed25519 public key. Both are normalized to lowercase hex.
+// Build one with ParsePeerID or NewPeerID rather than by hand.
+type PeerID struct {
+	PublicID  string `json:"publicID"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// ParsePeerID accepts either a 16-hex Public ID or a 64-hex public key,
+// as typed or pasted by the user. For a key the Public ID is derived.
+func ParsePeerID(s string) (PeerID, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch len(s) {
+	case PublicIDLength:
+		return NewPeerID(s, "")
+	case PublicKeyLength:
+		return NewPeerID("", s)
+	case 0:
+		return PeerID{}, ErrEmptyPublicID
+	default:
+		return PeerID{}, fmt.Errorf("%w: want %d or %d hex characters, got %d",
+			ErrInvalidPublicID, PublicIDLength, PublicKeyLength, len(s))
+	}
+}
+
+// NewPeerID builds a PeerID from a Public ID, a public key or both.
+// When both are given the Public ID must be the one derived from the key.
+func NewPeerID(publicID, publicKey string) (PeerID, error) {
+	publicID = strings.ToLower(strings.TrimSpace(publicID))
+	publicKey = strings.ToLower(strings.TrimSpace(publicKey))
+
+	if publicKey != "" {
+		key, err := hex.DecodeString(publicKey)
+		if err != nil || len(publicKey) != PublicKeyLength {
+			return PeerID{}, ErrInvalidPublicKey
+		}
+		derived := PublicIDFromKey(key)
+		if publicID != "" && publicID != derived {
+			return PeerID{}, ErrPublicIDMismatch
+		}
+		return PeerID{PublicID: derived, PublicKey: publicKey}, nil
+	}
+
+	if publicID == "" {
+		return PeerID{}, ErrEmptyPublicID
+	}
+	if _, err := hex.DecodeString(publicID); err != nil || len(publicID) != PublicIDLength {
+		return PeerID{}, ErrInvalidPublicID
+	}
+	return PeerID{PublicID: publicID}, nil
+}
+
+// HasKey reports whether the full public key is known.
+func (p PeerID) HasKey() bool {
+	return p.PublicKey != ""
+}
+
+// PublicIDFromKey derives a Public ID: the first 16 hex characters of
+// SHA-256(publicKey).
+func PublicIDFromKey(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])[:PublicIDLength]
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsePeerID(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	raw, _ := hex.DecodeString(key)
+	sum := sha256.Sum256(raw)
+	id := hex.EncodeToString(sum[:])[:PublicIDLength]
+
+	tests := []struct {
+		name    string
+		input   string
+		want    PeerID
+		wantErr error
+	}{
+		{name: "public ID", input: "0123456789abcdef", want: PeerID{PublicID: "0123456789abcdef"}},
+		{name: "public ID upper case", input: "  0123456789ABCDEF\n", want: PeerID{PublicID: "0123456789abcdef"}},
+		{name: "public key", input: key, want: PeerID{PublicID: id, PublicKey: key}},
+		{name: "public key upper case", input: strings.ToUpper(key), want: PeerID{PublicID: id, PublicKey: key}},
+		{name: "empty", input: "   ", wantErr: ErrEmptyPublicID},
+		{name: "wrong length", input: "0123456789abcde", wantErr: ErrInvalidPublicID},
+		{name: "not hex ID", input: "0123456789abcdeg", wantErr: ErrInvalidPublicID},
+		{name: "not hex key", input: strings.Repeat("zz", 32), wantErr: ErrInvalidPublicKey},
+		{name: "legacy stub key", input: "alice-id-pub-key", wantErr: ErrInvalidPublicID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePeerID(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParsePeerID(%q) error = %v; want %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePeerID(%q) = %+v; want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPeerID(t *testing.T) {
+	key := strings.Repeat("cd", 32)
+	raw, _ := hex.DecodeString(key)
+	id := PublicIDFromKey(raw)
+
+	tests := []struct {
+		name      string
+		publicID  string
+		publicKey string
+		want      PeerID
+		wantErr   error
+	}{
+		{name: "matching pair", publicID: id, publicKey: key, want: PeerID{PublicID: id, PublicKey: key}},
+		{name: "key only", publicKey: key, want: PeerID{PublicID: id, PublicKey: key}},
+		{name: "mismatch", publicID: "0123456789abcdef", publicKey: key, wantErr: ErrPublicIDMismatch},
+		{name: "neither", wantErr: ErrEmptyPublicID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPeerID(tt.publicID, tt.publicKey)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewPeerID() error = %v; want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NewPeerID() = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
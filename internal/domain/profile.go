@@ -0,0 +1,11 @@
+package domain
+
+// LocalProfile is a named, isolated set of local data — identity keys,
+// contacts, history and settings — kept in its own data directory.
+// One installation can hold several profiles; exactly one is active.
+type LocalProfile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"createdAt"`
+	Active    bool   `json:"active"`
+}
@@ -0,0 +1,133 @@
+package domain
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchHit is a message matching a search. Snippet is an HTML-escaped
+// excerpt of its content with the matched words wrapped in <mark>.
+type SearchHit struct {
+	Message Message `json:"message"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResult is one page of search hits, newest first. NextCursor is
+// passed back to fetch the following page and is empty on the last one.
+type SearchResult struct {
+	Hits       []SearchHit `json:"hits"`
+	NextCursor string      `json:"nextCursor"`
+}
+
+// SearchFromMe is the from: value that selects the user's own messages.
+const SearchFromMe = "me"
+
+// SearchQuery is a parsed search string.
+//
+// Terms must all occur in a message; each matches words it is a prefix of,
+// case-insensitively, and a quoted term matches as a phrase. From selects
+// the sender: SearchFromMe, a contact's public ID or display name.
+// After and Before bound the message timestamp (Unix milliseconds) as
+// After <= timestamp < Before; zero means unbounded.
+type SearchQuery struct {
+	Terms  []string
+	From   string
+	After  int64
+	Before int64
+}
+
+// ParseSearchQuery parses free text mixed with the filters from:<sender>,
+// before:<YYYY-MM-DD> and after:<YYYY-MM-DD>. Values with spaces are
+// quoted, as in from:"Jane Doe". Dates are whole days in loc:
+// before:2024-05-01 keeps messages older than that day and
+// after:2024-05-01 keeps messages newer than it.
+//
+// Terms without a letter or digit are dropped. A query that is empty
+// after that fails with ErrEmptySearchQuery, a malformed filter with
+// ErrInvalidSearchQuery.
+func ParseSearchQuery(s string, loc *time.Location) (SearchQuery, error) {
+	var q SearchQuery
+	for _, field := range splitQuery(s) {
+		key, value, isFilter := strings.Cut(field.text, ":")
+		isFilter = isFilter && !field.quoted
+		switch key = strings.ToLower(key); {
+		case isFilter && key == "from":
+			value = unquote(value)
+			if value == "" {
+				return SearchQuery{}, ErrInvalidSearchQuery
+			}
+			q.From = value
+		case isFilter && (key == "before" || key == "after"):
+			day, err := time.ParseInLocation(time.DateOnly, unquote(value), loc)
+			if err != nil {
+				return SearchQuery{}, ErrInvalidSearchQuery
+			}
+			if key == "before" {
+				q.Before = day.UnixMilli()
+			} else {
+				q.After = day.AddDate(0, 0, 1).UnixMilli()
+			}
+		default:
+			term := field.text
+			if field.quoted {
+				term = unquote(term)
+			}
+			if hasWordChar(term) {
+				q.Terms = append(q.Terms, term)
+			}
+		}
+	}
+	if len(q.Terms) == 0 && q.From == "" && q.After == 0 && q.Before == 0 {
+		return SearchQuery{}, ErrEmptySearchQuery
+	}
+	if q.After != 0 && q.Before != 0 && q.After >= q.Before {
+		return SearchQuery{}, ErrInvalidSearchQuery
+	}
+	return q, nil
+}
+
+type queryField struct {
+	text   string
+	quoted bool // the whole field is a "quoted phrase"
+}
+
+// splitQuery splits s at spaces outside double quotes.
+func splitQuery(s string) []queryField {
+	var (
+		fields  []queryField
+		cur     strings.Builder
+		inQuote bool
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			text := cur.String()
+			quoted := len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"'
+			fields = append(fields, queryField{text: text, quoted: quoted})
+			cur.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			cur.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuote:
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return fields
+}
+
+func unquote(s string) string {
+	return strings.TrimSpace(strings.Trim(s, `"`))
+}
+
+func hasWordChar(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) >= 0
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	may1 := time.Date(2024, 5, 1, 0, 0, 0, 0, loc).UnixMilli()
+	may2 := time.Date(2024, 5, 2, 0, 0, 0, 0, loc).UnixMilli()
+	june1 := time.Date(2024, 6, 1, 0, 0, 0, 0, loc).UnixMilli()
+
+	tests := []struct {
+		name    string
+		input   string
+		want    SearchQuery
+		wantErr error
+	}{
+		{name: "words", input: "  hello  world ", want: SearchQuery{Terms: []string{"hello", "world"}}},
+		{name: "cyrillic", input: "Привет мир", want: SearchQuery{Terms: []string{"Привет", "мир"}}},
+		{name: "phrase", input: `"good morning" sun`, want: SearchQuery{Terms: []string{"good morning", "sun"}}},
+		{name: "from me", input: "from:me lunch", want: SearchQuery{Terms: []string{"lunch"}, From: "me"}},
+		{name: "from quoted name", input: `FROM:"Jane Doe"`, want: SearchQuery{From: "Jane Doe"}},
+		{name: "quoted filter is text", input: `"from:me"`, want: SearchQuery{Terms: []string{"from:me"}}},
+		{name: "unknown key is text", input: "re:lunch", want: SearchQuery{Terms: []string{"re:lunch"}}},
+		{name: "before", input: "x before:2024-05-01", want: SearchQuery{Terms: []string{"x"}, Before: may1}},
+		{name: "after excludes the day", input: "after:2024-05-01", want: SearchQuery{After: may2}},
+		{name: "range", input: "after:2024-05-01 before:2024-06-01", want: SearchQuery{After: may2, Before: june1}},
+		{name: "punctuation dropped", input: "?? ok", want: SearchQuery{Terms: []string{"ok"}}},
+		{name: "empty", input: "   ", wantErr: ErrEmptySearchQuery},
+		{name: "only punctuation", input: `!! ""`, wantErr: ErrEmptySearchQuery},
+		{name: "empty from", input: "from: x", wantErr: ErrInvalidSearchQuery},
+		{name: "bad date", input: "before:yesterday", wantErr: ErrInvalidSearchQuery},
+		{name: "empty range", input: "after:2024-05-01 before:2024-05-02", wantErr: ErrInvalidSearchQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSearchQuery(tt.input, loc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseSearchQuery(%q) error = %v; want %v", tt.input, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSearchQuery(%q) = %+v; want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
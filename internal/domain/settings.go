@@ -1,10 +1,45 @@
 package domain
 
+import "time"
+
 // Settings holds user-configurable application preferences.
+// RetentionDays deletes messages once they are that many days old;
+// 0 keeps them forever. Contact.RetentionDays overrides it per chat.
+// OutboxTTLHours is how long an outgoing message is retried before it
+// fails; 0 means DefaultOutboxTTLHours.
 type Settings struct {
 	Theme              string `json:"theme"`
 	NotificationsOn    bool   `json:"notificationsOn"`
 	SoundOn            bool   `json:"soundOn"`
 	ShowMessagePreview bool   `json:"showMessagePreview"`
 	SidebarWidth       int    `json:"sidebarWidth"`
+	RetentionDays      int    `json:"retentionDays"`
+	OutboxTTLHours     int    `json:"outboxTTLHours"`
+}
+
+// DefaultOutboxTTLHours is the outbox TTL when Settings.OutboxTTLHours is 0.
+const DefaultOutboxTTLHours = 72
+
+// OutboxTTL returns how long outgoing messages are retried.
+func (s Settings) OutboxTTL() time.Duration {
+	hours := s.OutboxTTLHours
+	if hours <= 0 {
+		hours = DefaultOutboxTTLHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RetentionForever as a chat's retention keeps its history regardless of
+// Settings.RetentionDays.
+const RetentionForever = -1
+
+// DefaultSettings returns the settings of a fresh profile.
+func DefaultSettings() Settings {
+	return Settings{
+		Theme:              "system",
+		NotificationsOn:    true,
+		SoundOn:            true,
+		ShowMessagePreview: true,
+		SidebarWidth:       320,
+	}
 }
@@ -0,0 +1,29 @@
+package domain
+
+// StorageStats breaks down the disk space a profile uses. Message bytes
+// count the stored, encrypted content. Attachments are the files kept
+// beside the database, which so far are the avatar images.
+type StorageStats struct {
+	DatabaseBytes   int64         `json:"databaseBytes"` // database file with its write-ahead log
+	FreeBytes       int64         `json:"freeBytes"`     // unused database pages CompactStorage can return
+	MessageCount    int           `json:"messageCount"`
+	MessageBytes    int64         `json:"messageBytes"`
+	AttachmentCount int           `json:"attachmentCount"`
+	AttachmentBytes int64         `json:"attachmentBytes"`
+	Chats           []ChatStorage `json:"chats"` // largest first
+}
+
+// ChatStorage is the share of one chat in StorageStats.
+type ChatStorage struct {
+	ContactID    string `json:"contactID"`
+	DisplayName  string `json:"displayName"`
+	MessageCount int    `json:"messageCount"`
+	MessageBytes int64  `json:"messageBytes"`
+}
+
+// CompactResult reports the database size around a compaction.
+type CompactResult struct {
+	BytesBefore    int64 `json:"bytesBefore"`
+	BytesAfter     int64 `json:"bytesAfter"`
+	BytesReclaimed int64 `json:"bytesReclaimed"`
+}
@@ -0,0 +1,254 @@
+// Package export writes a chat history to a file for record keeping: a
+// self-contained HTML page, a Markdown transcript or JSON.
+//
+// Messages are read through GetMessages one page at a time and never held
+// in memory all at once. Since pages run backwards from the newest message
+// while a transcript runs forwards, the pages are spooled to a temporary
+// file next to the export and replayed in reverse.
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	HTML     Format = "html"
+	Markdown Format = "markdown"
+	JSON     Format = "json"
+)
+
+// pageSize is how many messages are read per GetMessages call.
+const pageSize = 200
+
+// Source is the part of a messenger.Messenger an export reads.
+type Source interface {
+	GetProfile(ctx context.Context) (*domain.User, error)
+	GetContacts(ctx context.Context) ([]domain.Contact, error)
+	GetMessages(ctx context.Context, contactID string, limit int, beforeID string) ([]domain.Message, error)
+}
+
+// chatInfo heads an export.
+type chatInfo struct {
+	Contact    string `json:"contact"`
+	ContactID  string `json:"contactID"`
+	Self       string `json:"self"`
+	SelfID     string `json:"selfID"`
+	ExportedAt string `json:"exportedAt"` // RFC 3339, local time
+}
+
+// entry is one exported message, with the sender resolved to a name and
+// the timestamp to local time.
+type entry struct {
+	ID        string               `json:"id"`
+	SenderID  string               `json:"senderID"`
+	Sender    string               `json:"sender"`
+	Outgoing  bool                 `json:"outgoing"`
+	Timestamp int64                `json:"timestamp"`
+	Time      string               `json:"time"` // RFC 3339, local time
+	Status    domain.MessageStatus `json:"status"`
+	Content   string               `json:"content"`
+
+	// For the transcript formats.
+	Day    string `json:"-"`
+	Clock  string `json:"-"`
+	NewDay bool   `json:"-"` // first message of its day
+}
+
+// WriteChat writes the history of contactID to path in format, replacing
+// the file atomically. An unknown format fails with
+// domain.ErrInvalidExportFormat.
+func WriteChat(ctx context.Context, src Source, contactID string, format Format, path string) error {
+	if err := writeChat(ctx, src, contactID, format, path, time.Local); err != nil {
+		return fmt.Errorf("export chat: %w", err)
+	}
+	return nil
+}
+
+func writeChat(ctx context.Context, src Source, contactID string, format Format, path string, loc *time.Location) error {
+	newRenderer, ok := renderers[format]
+	if !ok {
+		return domain.ErrInvalidExportFormat
+	}
+	me, err := src.GetProfile(ctx)
+	if err != nil {
+		return err
+	}
+	contact, err := findContact(ctx, src, contactID)
+	if err != nil {
+		return err
+	}
+
+	sp, err := spoolHistory(ctx, src, contactID, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer sp.Close()
+
+	info := chatInfo{
+		Contact:    contact.DisplayName,
+		ContactID:  contact.PublicID,
+		Self:       me.DisplayName,
+		SelfID:     me.PublicID,
+		ExportedAt: time.Now().In(loc).Format(time.RFC3339),
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		r := newRenderer(bw)
+		if err := r.begin(info); err != nil {
+			return err
+		}
+		lastDay := ""
+		err := sp.each(func(m domain.Message) error {
+			t := time.UnixMilli(m.Timestamp).In(loc)
+			e := entry{
+				ID:        m.ID,
+				SenderID:  m.SenderID,
+				Sender:    contact.DisplayName,
+				Outgoing:  m.SenderID != m.ChatID,
+				Timestamp: m.Timestamp,
+				Time:      t.Format(time.RFC3339),
+				Status:    m.Status,
+				Content:   m.Content,
+				Day:       t.Format("Monday, 2 January 2006"),
+				Clock:     t.Format("15:04"),
+			}
+			if e.Outgoing {
+				e.Sender = me.DisplayName
+			}
+			e.NewDay = e.Day != lastDay
+			lastDay = e.Day
+			return r.message(e)
+		})
+		if err != nil {
+			return err
+		}
+		if err := r.end(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+// findContact returns contactID, or domain.ErrContactNotFound.
+func findContact(ctx context.Context, src Source, contactID string) (*domain.Contact, error) {
+	contacts, err := src.GetContacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range contacts {
+		if c.PublicID == contactID {
+			return &c, nil
+		}
+	}
+	return nil, domain.ErrContactNotFound
+}
+
+// spool holds a chat history on disk as one JSON line per GetMessages
+// page, newest page first.
+type spool struct {
+	f    *os.File
+	ends []int64 // end offset of each page
+}
+
+// spoolHistory pages through the history of contactID into a temporary
+// file in dir.
+func spoolHistory(ctx context.Context, src Source, contactID, dir string) (*spool, error) {
+	f, err := os.CreateTemp(dir, ".export-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	sp := &spool{f: f}
+	enc := json.NewEncoder(f)
+	before := ""
+	for {
+		msgs, err := src.GetMessages(ctx, contactID, pageSize, before)
+		if err != nil {
+			sp.Close()
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+		if err := enc.Encode(msgs); err != nil {
+			sp.Close()
+			return nil, err
+		}
+		end, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			sp.Close()
+			return nil, err
+		}
+		sp.ends = append(sp.ends, end)
+		if len(msgs) < pageSize {
+			break
+		}
+		before = msgs[0].ID // pages are oldest first
+	}
+	return sp, nil
+}
+
+// each calls fn for every spooled message, oldest first.
+func (sp *spool) each(fn func(domain.Message) error) error {
+	for i := len(sp.ends) - 1; i >= 0; i-- {
+		var start int64
+		if i > 0 {
+			start = sp.ends[i-1]
+		}
+		var msgs []domain.Message
+		if err := json.NewDecoder(io.NewSectionReader(sp.f, start, sp.ends[i]-start)).Decode(&msgs); err != nil {
+			return fmt.Errorf("read spool: %w", err)
+		}
+		for _, m := range msgs {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close removes the spool file.
+func (sp *spool) Close() error {
+	sp.f.Close()
+	return os.Remove(sp.f.Name())
+}
+
+// writeAtomic writes a file at path through fn, replacing it only once
+// everything was written and synced. The file is private to the user, as
+// an export holds the history in clear.
+func writeAtomic(path string, fn func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".export-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := fn(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,188 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+const (
+	selfID  = "1111111111111111"
+	aliceID = "aaaaaaaaaaaaaaaa"
+)
+
+// fakeSource pages through msgs, oldest first, like the store does.
+type fakeSource struct {
+	msgs  []domain.Message
+	calls int
+}
+
+func (f *fakeSource) GetProfile(context.Context) (*domain.User, error) {
+	return &domain.User{PublicID: selfID, DisplayName: "Me"}, nil
+}
+
+func (f *fakeSource) GetContacts(context.Context) ([]domain.Contact, error) {
+	return []domain.Contact{{PublicID: aliceID, DisplayName: "Alice"}}, nil
+}
+
+func (f *fakeSource) GetMessages(_ context.Context, contactID string, limit int, beforeID string) ([]domain.Message, error) {
+	f.calls++
+	if contactID != aliceID {
+		return nil, domain.ErrContactNotFound
+	}
+	end := len(f.msgs)
+	if beforeID != "" {
+		end = -1
+		for i, m := range f.msgs {
+			if m.ID == beforeID {
+				end = i
+			}
+		}
+		if end < 0 {
+			return nil, domain.ErrMessageNotFound
+		}
+	}
+	return append([]domain.Message{}, f.msgs[max(0, end-limit):end]...), nil
+}
+
+// history returns n messages, alternating sender, one minute apart.
+func history(n int) []domain.Message {
+	start := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC).UnixMilli()
+	msgs := make([]domain.Message, n)
+	for i := range msgs {
+		sender := aliceID
+		if i%2 == 1 {
+			sender = selfID
+		}
+		msgs[i] = domain.Message{
+			ID:        fmt.Sprintf("m%03d", i),
+			ChatID:    aliceID,
+			SenderID:  sender,
+			Content:   fmt.Sprintf("message %d", i),
+			Timestamp: start + int64(i)*60_000,
+			Status:    domain.StatusRead,
+		}
+	}
+	return msgs
+}
+
+func mustExport(t *testing.T, src Source, format Format, loc *time.Location) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "chat."+string(format))
+	if err := writeChat(newCtx(), src, aliceID, format, path, loc); err != nil {
+		t.Fatalf("writeChat(%s) error = %v", format, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteChat_JSONPagesInOrder(t *testing.T) {
+	src := &fakeSource{msgs: history(2*pageSize + 50)}
+	out := mustExport(t, src, JSON, time.UTC)
+
+	if src.calls != 3 {
+		t.Errorf("GetMessages called %d times; want 3 pages", src.calls)
+	}
+	var doc struct {
+		Chat     chatInfo `json:"chat"`
+		Messages []entry  `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("export is not valid JSON: %v", err)
+	}
+	if doc.Chat.Contact != "Alice" || doc.Chat.Self != "Me" {
+		t.Errorf("chat = %+v; want Alice and Me", doc.Chat)
+	}
+	if len(doc.Messages) != len(src.msgs) {
+		t.Fatalf("exported %d messages; want %d", len(doc.Messages), len(src.msgs))
+	}
+	for i, e := range doc.Messages {
+		if e.ID != src.msgs[i].ID {
+			t.Fatalf("message %d = %s; want %s", i, e.ID, src.msgs[i].ID)
+		}
+	}
+	first, second := doc.Messages[0], doc.Messages[1]
+	if first.Sender != "Alice" || first.Outgoing || second.Sender != "Me" || !second.Outgoing {
+		t.Errorf("senders = %+v, %+v; want Alice then Me", first, second)
+	}
+	if first.Time != "2024-05-01T23:00:00Z" || first.Status != domain.StatusRead {
+		t.Errorf("first = %+v; want UTC time and read status", first)
+	}
+}
+
+func TestWriteChat_LocalTime(t *testing.T) {
+	src := &fakeSource{msgs: history(2)}
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	out := mustExport(t, src, Markdown, loc)
+
+	// 23:00 UTC is already the next day two hours east.
+	for _, want := range []string{"## Thursday, 2 May 2024", "**Alice** · 01:00 · read", "**Me** · 01:01 · read"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("transcript lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteChat_Escaping(t *testing.T) {
+	src := &fakeSource{msgs: []domain.Message{{
+		ID: "m1", ChatID: aliceID, SenderID: aliceID, Timestamp: 1, Status: domain.StatusDelivered,
+		Content: "<script>alert(1)</script>\n# not a heading\n1. not a list *or bold*",
+	}}}
+
+	html := mustExport(t, src, HTML, time.UTC)
+	if strings.Contains(html, "<script>") || !strings.Contains(html, "&lt;script&gt;") {
+		t.Errorf("HTML content not escaped:\n%s", html)
+	}
+	if !strings.HasSuffix(html, "</html>\n") {
+		t.Errorf("HTML page not closed")
+	}
+
+	md := mustExport(t, src, Markdown, time.UTC)
+	for _, want := range []string{`> \<script\>alert(1)\</script\>`, `> \# not a heading`, `> 1\. not a list \*or bold\*`} {
+		if !strings.Contains(md, want) {
+			t.Errorf("transcript lacks %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestWriteChat_Errors(t *testing.T) {
+	dir := t.TempDir()
+	src := &fakeSource{msgs: history(3)}
+
+	tests := []struct {
+		name      string
+		contactID string
+		format    Format
+		wantErr   error
+	}{
+		{name: "format", contactID: aliceID, format: "pdf", wantErr: domain.ErrInvalidExportFormat},
+		{name: "contact", contactID: "0123456789abcdef", format: JSON, wantErr: domain.ErrContactNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := WriteChat(newCtx(), src, tt.contactID, tt.format, filepath.Join(dir, "out"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("WriteChat() error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+	// Neither the export nor a spool is left behind.
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("files left after failed exports: %v", left)
+	}
+}
@@ -0,0 +1,179 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// renderer writes one export format: begin once, message for every
+// message oldest first, then end.
+type renderer interface {
+	begin(info chatInfo) error
+	message(e entry) error
+	end() error
+}
+
+var renderers = map[Format]func(w io.Writer) renderer{
+	HTML:     func(w io.Writer) renderer { return &htmlRenderer{w: w} },
+	Markdown: func(w io.Writer) renderer { return &markdownRenderer{w: w} },
+	JSON:     func(w io.Writer) renderer { return &jsonRenderer{w: w} },
+}
+
+// --- HTML ---
+
+// htmlTemplates render a standalone page with inline styles, so the export
+// opens anywhere without the app.
+var htmlTemplates = template.Must(template.New("begin").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Chat with {{.Contact}}</title>
+<style>
+body { font: 15px/1.45 system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+header { border-bottom: 1px solid #d0d7de; margin-bottom: 1rem; }
+header p, .meta { color: #656d76; font-size: 13px; }
+h2 { font-size: 13px; text-align: center; color: #656d76; margin: 1.5rem 0 .5rem; }
+article { margin: .5rem 0; padding: .5rem .75rem; border-radius: 8px; background: #f6f8fa; max-width: 80%; }
+article.out { margin-left: auto; background: #ddf4ff; }
+.meta { margin: 0 0 .25rem; }
+.content { margin: 0; white-space: pre-wrap; overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+<header>
+<h1>Chat with {{.Contact}}</h1>
+<p>{{.ContactID}} · exported by {{.Self}} on <time datetime="{{.ExportedAt}}">{{.ExportedAt}}</time></p>
+</header>
+<main>
+`))
+
+func init() {
+	template.Must(htmlTemplates.New("message").Parse(`{{if .NewDay}}<h2>{{.Day}}</h2>
+{{end}}<article class="{{if .Outgoing}}out{{else}}in{{end}}">
+<p class="meta"><strong>{{.Sender}}</strong> · <time datetime="{{.Time}}">{{.Clock}}</time> · {{.Status}}</p>
+<p class="content">{{.Content}}</p>
+</article>
+`))
+	template.Must(htmlTemplates.New("end").Parse(`</main>
+</body>
+</html>
+`))
+}
+
+type htmlRenderer struct {
+	w io.Writer
+}
+
+func (r *htmlRenderer) begin(info chatInfo) error {
+	return htmlTemplates.ExecuteTemplate(r.w, "begin", info)
+}
+
+func (r *htmlRenderer) message(e entry) error {
+	return htmlTemplates.ExecuteTemplate(r.w, "message", e)
+}
+
+func (r *htmlRenderer) end() error {
+	return htmlTemplates.ExecuteTemplate(r.w, "end", nil)
+}
+
+// --- Markdown ---
+
+type markdownRenderer struct {
+	w io.Writer
+}
+
+func (r *markdownRenderer) begin(info chatInfo) error {
+	_, err := fmt.Fprintf(r.w, "# Chat with %s\n\n%s · exported by %s on %s\n",
+		escapeMarkdown(info.Contact), info.ContactID, escapeMarkdown(info.Self), info.ExportedAt)
+	return err
+}
+
+func (r *markdownRenderer) message(e entry) error {
+	var b strings.Builder
+	if e.NewDay {
+		fmt.Fprintf(&b, "\n## %s\n", e.Day)
+	}
+	fmt.Fprintf(&b, "\n**%s** · %s · %s\n\n", escapeMarkdown(e.Sender), e.Clock, e.Status)
+	for _, line := range strings.Split(e.Content, "\n") {
+		if line == "" {
+			b.WriteString(">\n")
+			continue
+		}
+		b.WriteString("> " + escapeMarkdown(line) + "\n")
+	}
+	_, err := io.WriteString(r.w, b.String())
+	return err
+}
+
+func (r *markdownRenderer) end() error {
+	return nil
+}
+
+// escapeMarkdown keeps a line of user text from being read as Markdown:
+// inline markup characters are escaped everywhere, and characters that
+// start headings or lists only at the start of the line.
+func escapeMarkdown(line string) string {
+	var b strings.Builder
+	for i, c := range line {
+		switch {
+		case strings.ContainsRune("\\`*_[]<>|~", c):
+			b.WriteByte('\\')
+		case i == 0 && strings.ContainsRune("#+-=", c):
+			b.WriteByte('\\')
+		case (c == '.' || c == ')') && i > 0 && isDigits(line[:i]):
+			b.WriteByte('\\') // "1. " would start an ordered list
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// --- JSON ---
+
+// jsonRenderer writes {"chat": {...}, "messages": [...]} one message per
+// line.
+type jsonRenderer struct {
+	w io.Writer
+	n int
+}
+
+func (r *jsonRenderer) begin(info chatInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(r.w, "{\n  \"chat\": %s,\n  \"messages\": [", data)
+	return err
+}
+
+func (r *jsonRenderer) message(e entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if r.n == 0 {
+		sep = ""
+	}
+	r.n++
+	_, err = fmt.Fprintf(r.w, "%s\n    %s", sep, data)
+	return err
+}
+
+func (r *jsonRenderer) end() error {
+	_, err := io.WriteString(r.w, "\n  ]\n}\n")
+	return err
+}
@@ -0,0 +1,317 @@
+// Package handshake authenticates the two ends of a connection by their
+// identity keys and encrypts what they exchange after. Nodes use it with
+// each other and with relays.
+package handshake
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"quillet/internal/domain"
+)
+
+// The handshake has the shape of Noise XX, with signatures by the
+// Ed25519 identity keys in place of static Diffie-Hellman keys:
+//
+//	-> e
+//	<- e, seal(s, sig)
+//	-> seal(s, sig)
+//
+// Both ends derive keys from the X25519 exchange of the ephemeral keys, so
+// every session has fresh keys, and each signs the transcript hash up to
+// its own identity key. The initiator checks who answered before it sends
+// its own identity, which therefore only the expected peer learns.
+const (
+	prologue         = "quillet p2p v1"
+	responderContext = "quillet p2p responder v1\x00"
+	initiatorContext = "quillet p2p initiator v1\x00"
+	handshakeInfo    = "quillet p2p handshake keys"
+	trafficInfo      = "quillet p2p traffic keys"
+)
+
+// MaxFrameSize bounds a frame's plaintext, and with it the size of a message.
+const MaxFrameSize = 1 << 20
+
+// Timeout bounds how long a handshake may take.
+const Timeout = 10 * time.Second
+
+// identityPayload is a sealed identity key followed by its signature.
+const identityPayload = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// ErrCorruptFrame is returned for a frame that fails to open.
+var ErrCorruptFrame = errors.New("corrupt frame")
+
+// Signer signs with the local identity key; *identity.Manager is one.
+type Signer interface {
+	Sign(msg []byte) (ed25519.PublicKey, []byte, error)
+}
+
+// Session is an authenticated, encrypted connection. Frames are sealed
+// with ChaCha20-Poly1305 under a key per direction and a counter nonce, so
+// a frame that is dropped, replayed or reordered fails to open.
+type Session struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	remote ed25519.PublicKey
+
+	wmu     sync.Mutex
+	send    cipher.AEAD
+	sendSeq uint64
+
+	recv    cipher.AEAD // used by the reading goroutine only
+	recvSeq uint64
+}
+
+// Conn returns the underlying connection.
+func (s *Session) Conn() net.Conn {
+	return s.conn
+}
+
+// Remote returns the identity key of the other end.
+func (s *Session) Remote() ed25519.PublicKey {
+	return s.remote
+}
+
+// WriteFrame seals and sends b, giving up after timeout. It is safe to
+// call from several goroutines.
+func (s *Session) WriteFrame(b []byte, timeout time.Duration) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	sealed := s.send.Seal(nil, nonce(s.sendSeq), b, nil)
+	s.sendSeq++
+	s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return writeRaw(s.conn, sealed)
+}
+
+// ReadFrame receives and opens the next frame. Only one goroutine may read.
+func (s *Session) ReadFrame() ([]byte, error) {
+	sealed, err := readRaw(s.r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.recv.Open(sealed[:0], nonce(s.recvSeq), sealed, nil)
+	if err != nil {
+		return nil, ErrCorruptFrame
+	}
+	s.recvSeq++
+	return b, nil
+}
+
+// Client runs the initiator's side over conn. It fails with
+// domain.ErrUnexpectedPeer unless the responder's key has the Public ID
+// expect.
+func Client(conn net.Conn, self Signer, expect string) (*Session, error) {
+	conn.SetDeadline(time.Now().Add(Timeout))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+	t := newTranscript()
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRaw(conn, e.PublicKey().Bytes()); err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	t.mix(e.PublicKey().Bytes())
+
+	msg, err := readRaw(r)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	if len(msg) != 32+identityPayload+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("%w: bad responder message", domain.ErrHandshake)
+	}
+	re, err := ecdh.X25519().NewPublicKey(msg[:32])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrHandshake, err)
+	}
+	t.mix(msg[:32])
+	secret, err := e.ECDH(re)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrHandshake, err)
+	}
+	toResponder, toInitiator, err := deriveKeys(secret, t, handshakeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := openIdentity(toInitiator, t, msg[32:], responderContext)
+	if err != nil {
+		return nil, err
+	}
+	if id := domain.PublicIDFromKey(remote); id != expect {
+		return nil, fmt.Errorf("%w: want %s, got %s", domain.ErrUnexpectedPeer, expect, id)
+	}
+	payload, err := sealIdentity(self, toResponder, t, initiatorContext)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRaw(conn, payload); err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+
+	send, recv, err := deriveKeys(secret, t, trafficInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{conn: conn, r: r, remote: remote, send: send, recv: recv}, nil
+}
+
+// Server runs the responder's side over conn. Whether the initiator,
+// known by the session's remote key, may stay is up to the caller.
+func Server(conn net.Conn, self Signer) (*Session, error) {
+	conn.SetDeadline(time.Now().Add(Timeout))
+	defer conn.SetDeadline(time.Time{})
+	r := bufio.NewReader(conn)
+	t := newTranscript()
+
+	msg, err := readRaw(r)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	ie, err := ecdh.X25519().NewPublicKey(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrHandshake, err)
+	}
+	t.mix(msg)
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	t.mix(e.PublicKey().Bytes())
+	secret, err := e.ECDH(ie)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrHandshake, err)
+	}
+	toResponder, toInitiator, err := deriveKeys(secret, t, handshakeInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := sealIdentity(self, toInitiator, t, responderContext)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRaw(conn, append(e.PublicKey().Bytes(), payload...)); err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	msg, err = readRaw(r)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	remote, err := openIdentity(toResponder, t, msg, initiatorContext)
+	if err != nil {
+		return nil, err
+	}
+
+	recv, send, err := deriveKeys(secret, t, trafficInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{conn: conn, r: r, remote: remote, send: send, recv: recv}, nil
+}
+
+// transcript hashes everything the handshake has exchanged so far.
+type transcript struct {
+	h [sha256.Size]byte
+}
+
+func newTranscript() *transcript {
+	return &transcript{h: sha256.Sum256([]byte(prologue))}
+}
+
+func (t *transcript) mix(data []byte) {
+	t.h = sha256.Sum256(append(t.h[:], data...))
+}
+
+// sealIdentity signs the transcript with the identity key and seals the
+// key and signature under aead, then adds the key to the transcript.
+func sealIdentity(self Signer, aead cipher.AEAD, t *transcript, context string) ([]byte, error) {
+	pub, sig, err := self.Sign(append([]byte(context), t.h[:]...))
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, nonce(0), append(pub[:len(pub):len(pub)], sig...), t.h[:])
+	t.mix(pub)
+	return sealed, nil
+}
+
+// openIdentity is the counterpart of sealIdentity: it returns the peer's
+// identity key once its signature over the transcript checks out.
+func openIdentity(aead cipher.AEAD, t *transcript, sealed []byte, context string) (ed25519.PublicKey, error) {
+	b, err := aead.Open(nil, nonce(0), sealed, t.h[:])
+	if err != nil || len(b) != identityPayload {
+		return nil, fmt.Errorf("%w: bad identity", domain.ErrHandshake)
+	}
+	pub := ed25519.PublicKey(b[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pub, append([]byte(context), t.h[:]...), b[ed25519.PublicKeySize:]) {
+		return nil, fmt.Errorf("%w: bad signature", domain.ErrHandshake)
+	}
+	t.mix(pub)
+	return pub, nil
+}
+
+// deriveKeys derives the initiator's and the responder's sending keys from
+// the shared secret and the transcript.
+func deriveKeys(secret []byte, t *transcript, info string) (toResponder, toInitiator cipher.AEAD, err error) {
+	key := make([]byte, 2*chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, t.h[:], []byte(info)), key); err != nil {
+		return nil, nil, fmt.Errorf("derive session keys: %w", err)
+	}
+	if toResponder, err = chacha20poly1305.New(key[:chacha20poly1305.KeySize]); err != nil {
+		return nil, nil, err
+	}
+	if toInitiator, err = chacha20poly1305.New(key[chacha20poly1305.KeySize:]); err != nil {
+		return nil, nil, err
+	}
+	return toResponder, toInitiator, nil
+}
+
+// nonce encodes a frame counter as a ChaCha20-Poly1305 nonce.
+func nonce(seq uint64) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(n[chacha20poly1305.NonceSize-8:], seq)
+	return n
+}
+
+// writeRaw sends b with a 4-byte big-endian length prefix.
+func writeRaw(w io.Writer, b []byte) error {
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRaw receives a frame written by writeRaw.
+func readRaw(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > MaxFrameSize+chacha20poly1305.Overhead {
+		return nil, fmt.Errorf("%w: %d bytes", ErrCorruptFrame, n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,110 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+func newSigner(t *testing.T) *identity.Manager {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return identity.NewManagerFor(keys, domain.User{DisplayName: "Peer"})
+}
+
+// handshakePair runs both sides of a handshake over an in-memory pipe.
+func handshakePair(t *testing.T, client, server Signer, expect string) (c, s *Session, cerr, serr error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s, serr = Server(b, server)
+		if serr != nil {
+			b.Close()
+		}
+	}()
+	c, cerr = Client(a, client, expect)
+	if cerr != nil {
+		a.Close()
+	}
+	<-done
+	return c, s, cerr, serr
+}
+
+func TestHandshake(t *testing.T) {
+	alice, bob := newSigner(t), newSigner(t)
+	c, s, cerr, serr := handshakePair(t, alice, bob, bob.PublicID())
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake error = %v, %v", cerr, serr)
+	}
+	if domain.PublicIDFromKey(c.remote) != bob.PublicID() || domain.PublicIDFromKey(s.remote) != alice.PublicID() {
+		t.Fatalf("remotes = %x, %x; want Bob and Alice", c.remote, s.remote)
+	}
+
+	// Frames go both ways, in order.
+	for i, msg := range [][]byte{[]byte("one"), []byte("two")} {
+		go c.WriteFrame(msg, time.Second)
+		got, err := s.ReadFrame()
+		if err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("frame %d to server = %q, %v; want %q", i, got, err, msg)
+		}
+		go s.WriteFrame(msg, time.Second)
+		got, err = c.ReadFrame()
+		if err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("frame %d to client = %q, %v; want %q", i, got, err, msg)
+		}
+	}
+}
+
+func TestHandshake_UnexpectedPeer(t *testing.T) {
+	alice, bob, mallory := newSigner(t), newSigner(t), newSigner(t)
+	_, _, cerr, serr := handshakePair(t, alice, mallory, bob.PublicID())
+	if !errors.Is(cerr, domain.ErrUnexpectedPeer) {
+		t.Errorf("client error = %v; want %v", cerr, domain.ErrUnexpectedPeer)
+	}
+	if serr == nil {
+		t.Error("server learned an identity the client did not mean to reveal")
+	}
+}
+
+func TestHandshake_Locked(t *testing.T) {
+	alice := newSigner(t)
+	locked, err := identity.OpenManager(filepath.Join(t.TempDir(), "identity.key"))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	_, _, cerr, serr := handshakePair(t, alice, locked, "")
+	if !errors.Is(serr, domain.ErrNoIdentity) {
+		t.Errorf("server error = %v; want %v", serr, domain.ErrNoIdentity)
+	}
+	if cerr == nil {
+		t.Error("client handshake succeeded against a server without identity")
+	}
+}
+
+func TestSession_RejectsTampering(t *testing.T) {
+	alice, bob := newSigner(t), newSigner(t)
+	c, s, cerr, serr := handshakePair(t, alice, bob, bob.PublicID())
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake error = %v, %v", cerr, serr)
+	}
+
+	// Seal a frame as WriteFrame would, then flip one bit of it.
+	sealed := c.send.Seal(nil, nonce(c.sendSeq), []byte("hello"), nil)
+	sealed[len(sealed)-1] ^= 1
+	go writeRaw(c.conn, sealed)
+	if _, err := s.ReadFrame(); !errors.Is(err, ErrCorruptFrame) {
+		t.Errorf("ReadFrame() of a tampered frame error = %v; want %v", err, ErrCorruptFrame)
+	}
+}
@@ -0,0 +1,53 @@
+// Package identity implements the local user's cryptographic identity:
+// the Ed25519 key pair and the public ID derived from it.
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"quillet/internal/domain"
+)
+
+// PublicIDLength is the number of hex characters in a public ID.
+const PublicIDLength = domain.PublicIDLength
+
+// KeyPair is an Ed25519 identity key pair.
+type KeyPair struct {
+	Public  ed25519.PublicKey
+	Private ed25519.PrivateKey
+}
+
+// Generate creates a new random Ed25519 key pair.
+func Generate() (*KeyPair, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key pair: %w", err)
+	}
+	return &KeyPair{Public: pub, Private: priv}, nil
+}
+
+// NewKeyPair wraps an existing Ed25519 private key.
+func NewKeyPair(priv ed25519.PrivateKey) *KeyPair {
+	return &KeyPair{
+		Public:  priv.Public().(ed25519.PublicKey),
+		Private: priv,
+	}
+}
+
+// PublicID returns the public ID derived from the key pair's public key.
+func (k *KeyPair) PublicID() string {
+	return PublicIDFromKey(k.Public)
+}
+
+// PublicKeyHex returns the public key encoded as 64 hex characters.
+func (k *KeyPair) PublicKeyHex() string {
+	return hex.EncodeToString(k.Public)
+}
+
+// PublicIDFromKey derives a public ID: the first 16 hex characters of SHA-256(publicKey).
+func PublicIDFromKey(pub ed25519.PublicKey) string {
+	return domain.PublicIDFromKey(pub)
+}
@@ -0,0 +1,72 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func TestPublicIDFromKey(t *testing.T) {
+	seed := sha256.Sum256([]byte("seed"))
+	pub := ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey)
+
+	sum := sha256.Sum256(pub)
+	want := hex.EncodeToString(sum[:])[:PublicIDLength]
+
+	if got := PublicIDFromKey(pub); got != want {
+		t.Errorf("PublicIDFromKey() = %q; want %q", got, want)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	k1, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	k2, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if len(k1.PublicKeyHex()) != 2*ed25519.PublicKeySize {
+		t.Errorf("len(PublicKeyHex()) = %d; want %d", len(k1.PublicKeyHex()), 2*ed25519.PublicKeySize)
+	}
+	if k1.PublicID() == k2.PublicID() {
+		t.Error("Generate() produced the same PublicID twice")
+	}
+	if !k1.Public.Equal(k1.Private.Public()) {
+		t.Error("Generate() public key does not match private key")
+	}
+}
+
+func TestX25519PublicKey(t *testing.T) {
+	for range 8 {
+		k, err := Generate()
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		pub, err := X25519PublicKey(k.Public)
+		if err != nil {
+			t.Fatalf("X25519PublicKey() error = %v", err)
+		}
+		priv, err := k.X25519PrivateKey()
+		if err != nil {
+			t.Fatalf("X25519PrivateKey() error = %v", err)
+		}
+		if !pub.Equal(priv.PublicKey()) {
+			t.Errorf("X25519PublicKey() = %x; want %x", pub.Bytes(), priv.PublicKey().Bytes())
+		}
+	}
+
+	y1 := make([]byte, ed25519.PublicKeySize) // y = 1, the neutral element
+	y1[0] = 1
+	for _, pub := range [][]byte{y1, y1[:31]} {
+		if _, err := X25519PublicKey(pub); !errors.Is(err, domain.ErrInvalidPublicKey) {
+			t.Errorf("X25519PublicKey(%x) error = %v; want %v", pub, err, domain.ErrInvalidPublicKey)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// importedDisplayName is used for identities imported from a bare key,
+// which carries no profile.
+const importedDisplayName = "Me"
+
+// ParsePrivateKey decodes a hex-encoded Ed25519 private key: either the
+// 32-byte seed (64 hex chars) or the full 64-byte key (128 hex chars).
+// Whitespace anywhere in the input is ignored.
+func ParsePrivateKey(text string) (*KeyPair, error) {
+	text = strings.Join(strings.Fields(text), "")
+	raw, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, domain.ErrInvalidPrivateKey
+	}
+
+	switch len(raw) {
+	case ed25519.SeedSize:
+		return NewKeyPair(ed25519.NewKeyFromSeed(raw)), nil
+	case ed25519.PrivateKeySize:
+		keys := NewKeyPair(ed25519.NewKeyFromSeed(raw[:ed25519.SeedSize]))
+		if !keys.Public.Equal(ed25519.PublicKey(raw[ed25519.SeedSize:])) {
+			return nil, domain.ErrInvalidPrivateKey
+		}
+		return keys, nil
+	default:
+		return nil, domain.ErrInvalidPrivateKey
+	}
+}
+
+// decodeKeyMaterial accepts either the contents of an identity.key file,
+// decrypted with passphrase, or a bare hex private key.
+// The returned profile is nil for bare keys.
+func decodeKeyMaterial(material, passphrase string) (*KeyPair, *domain.User, error) {
+	trimmed := strings.TrimSpace(material)
+	if !strings.HasPrefix(trimmed, "{") {
+		keys, err := ParsePrivateKey(trimmed)
+		return keys, nil, err
+	}
+
+	f, err := parseKeystore([]byte(trimmed))
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, _, err := f.open(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := f.profile()
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, profile, nil
+}
+
+// Import replaces the identity with one restored from material: a hex
+// private key or the contents of an identity.key file. PublicID and
+// PublicKey are always rebuilt from the private key. The passphrase
+// protects the imported key and must also decrypt an encrypted key file.
+// An existing identity is only replaced when overwrite is true;
+// otherwise Import fails with domain.ErrIdentityExists.
+func (m *Manager) Import(material, passphrase string, overwrite bool) (*domain.User, error) {
+	keys, imported, err := decodeKeyMaterial(material, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("import identity: %w", err)
+	}
+	u, err := m.replace(keys, imported, passphrase, overwrite)
+	if err != nil {
+		return nil, fmt.Errorf("import identity: %w", err)
+	}
+	return u, nil
+}
+
+// replace installs keys as the identity, taking the display name and
+// creation time from imported when it is non-nil.
+func (m *Manager) replace(keys *KeyPair, imported *domain.User, passphrase string, overwrite bool) (*domain.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profile != nil && !overwrite {
+		return nil, domain.ErrIdentityExists
+	}
+
+	profile := &domain.User{
+		DisplayName: importedDisplayName,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if imported != nil {
+		profile.DisplayName = imported.DisplayName
+		profile.CreatedAt = imported.CreatedAt
+	}
+	profile.PublicID = keys.PublicID()
+	profile.PublicKey = keys.PublicKeyHex()
+
+	if err := m.persist(keys, profile, passphrase); err != nil {
+		return nil, err
+	}
+	m.profile = profile
+
+	u := *m.profile
+	return &u, nil
+}
+
+// ExportPrivateKey returns the full Ed25519 private key as 128 hex chars
+// after re-checking passphrase, even if the identity is unlocked.
+// An identity without a passphrase is exported with an empty passphrase.
+func (m *Manager) ExportPrivateKey(passphrase string) (string, error) {
+	keys, err := m.reopen(passphrase)
+	if err != nil {
+		return "", fmt.Errorf("export private key: %w", err)
+	}
+	return hex.EncodeToString(keys.Private), nil
+}
+
+// reopen decrypts the stored key with passphrase regardless of the lock state.
+// An identity without a passphrase only accepts the empty passphrase.
+func (m *Manager) reopen(passphrase string) (*KeyPair, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.profile == nil {
+		return nil, domain.ErrNoIdentity
+	}
+	if !m.file.encrypted() && passphrase != "" {
+		return nil, domain.ErrWrongPassphrase
+	}
+	keys, _, err := m.file.open(passphrase)
+	return keys, err
+}
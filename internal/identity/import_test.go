@@ -0,0 +1,171 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	keys, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	other, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	mismatched := append(keys.Private.Seed(), other.Public...)
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr error
+	}{
+		{name: "seed", input: hex.EncodeToString(keys.Private.Seed())},
+		{name: "full key", input: hex.EncodeToString(keys.Private)},
+		{name: "with whitespace", input: " " + hex.EncodeToString(keys.Private)[:64] + "\n" + hex.EncodeToString(keys.Private)[64:] + " "},
+		{name: "not hex", input: "zz", wantErr: domain.ErrInvalidPrivateKey},
+		{name: "wrong length", input: "abcd", wantErr: domain.ErrInvalidPrivateKey},
+		{name: "mismatched public half", input: hex.EncodeToString(mismatched), wantErr: domain.ErrInvalidPrivateKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePrivateKey(tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParsePrivateKey() error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePrivateKey() error = %v", err)
+			}
+			if got.PublicID() != keys.PublicID() {
+				t.Errorf("PublicID() = %q; want %q", got.PublicID(), keys.PublicID())
+			}
+		})
+	}
+}
+
+func TestManager_ExportImportRoundtrip(t *testing.T) {
+	src := mustOpen(t, keystorePath(t))
+	created, err := src.Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := src.ExportPrivateKey("wrong"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("ExportPrivateKey(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	exported, err := src.ExportPrivateKey("secret")
+	if err != nil {
+		t.Fatalf("ExportPrivateKey() error = %v", err)
+	}
+	if len(exported) != 2*ed25519.PrivateKeySize {
+		t.Errorf("len(exported) = %d; want %d", len(exported), 2*ed25519.PrivateKeySize)
+	}
+
+	dst := mustOpen(t, keystorePath(t))
+	imported, err := dst.Import(exported, "", false)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported.PublicID != created.PublicID || imported.PublicKey != created.PublicKey {
+		t.Errorf("Import() = %s/%s; want %s/%s",
+			imported.PublicID, imported.PublicKey, created.PublicID, created.PublicKey)
+	}
+}
+
+func TestManager_ImportKeystoreFile(t *testing.T) {
+	path := keystorePath(t)
+	created, err := mustOpen(t, path).Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	dst := mustOpen(t, keystorePath(t))
+	if _, err := dst.Import(string(data), "wrong", false); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("Import(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	imported, err := dst.Import(string(data), "secret", false)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if imported.PublicID != created.PublicID || imported.DisplayName != "Alice" {
+		t.Errorf("Import() = %+v; want PublicID %q and name %q", *imported, created.PublicID, "Alice")
+	}
+	if !dst.HasPassphrase() {
+		t.Error("HasPassphrase() = false; want true after importing with a passphrase")
+	}
+}
+
+func TestManager_ImportKeystoreFile_BadKDF(t *testing.T) {
+	path := keystorePath(t)
+	if _, err := mustOpen(t, path).Create("Alice", "secret", ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*kdfParams)
+	}{
+		{name: "zero time", edit: func(p *kdfParams) { p.Time = 0 }},
+		{name: "zero threads", edit: func(p *kdfParams) { p.Threads = 0 }},
+		{name: "huge memory", edit: func(p *kdfParams) { p.Memory = 1<<32 - 1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f keystoreFile
+			if err := json.Unmarshal(data, &f); err != nil {
+				t.Fatalf("decode keystore: %v", err)
+			}
+			tt.edit(f.KDF)
+			b, err := json.Marshal(f)
+			if err != nil {
+				t.Fatalf("encode keystore: %v", err)
+			}
+			if _, err := mustOpen(t, keystorePath(t)).Import(string(b), "secret", false); !errors.Is(err, domain.ErrCorruptKeystore) {
+				t.Errorf("Import() error = %v; want %v", err, domain.ErrCorruptKeystore)
+			}
+		})
+	}
+}
+
+func TestManager_ImportOverwrite(t *testing.T) {
+	other, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	key := hex.EncodeToString(other.Private)
+
+	m := mustOpen(t, keystorePath(t))
+	if _, err := m.Create("Alice", "", ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := m.Import(key, "", false); !errors.Is(err, domain.ErrIdentityExists) {
+		t.Fatalf("Import(overwrite=false) error = %v; want %v", err, domain.ErrIdentityExists)
+	}
+	u, err := m.Import(key, "", true)
+	if err != nil {
+		t.Fatalf("Import(overwrite=true) error = %v", err)
+	}
+	if u.PublicID != other.PublicID() {
+		t.Errorf("PublicID = %q; want %q", u.PublicID, other.PublicID())
+	}
+}
@@ -0,0 +1,111 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// inviteContext domain-separates invite signatures from every other
+// signature made with an identity key.
+const inviteContext = "quillet invite v1\x00"
+
+// Invite link layout: quillet://add?id=…&key=…&name=…&t=…&sig=…
+const (
+	InviteScheme = "quillet"
+	inviteHost   = "add"
+)
+
+// SignInvite creates an invite link for keys, suggesting displayName to
+// whoever adds it. The signature covers the key, the name and issuedAt.
+func SignInvite(keys *KeyPair, displayName string, issuedAt int64) *domain.Invite {
+	sig := ed25519.Sign(keys.Private, inviteMessage(keys.Public, displayName, issuedAt))
+
+	q := url.Values{}
+	q.Set("id", keys.PublicID())
+	q.Set("key", keys.PublicKeyHex())
+	q.Set("name", displayName)
+	q.Set("t", strconv.FormatInt(issuedAt, 10))
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(sig))
+	link := url.URL{Scheme: InviteScheme, Host: inviteHost, RawQuery: q.Encode()}
+
+	return &domain.Invite{
+		Link:        link.String(),
+		PublicID:    keys.PublicID(),
+		PublicKey:   keys.PublicKeyHex(),
+		DisplayName: displayName,
+		IssuedAt:    issuedAt,
+	}
+}
+
+// ParseInvite decodes an invite link and checks its signature and that the
+// public ID belongs to the public key. Any failure wraps domain.ErrInvalidInvite.
+func ParseInvite(link string) (*domain.Invite, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidInvite, err)
+	}
+	if u.Scheme != InviteScheme || u.Host != inviteHost {
+		return nil, fmt.Errorf("%w: not a %s://%s link", domain.ErrInvalidInvite, InviteScheme, inviteHost)
+	}
+	q := u.Query()
+
+	peer, err := domain.NewPeerID(q.Get("id"), q.Get("key"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidInvite, err)
+	}
+	if !peer.HasKey() {
+		return nil, fmt.Errorf("%w: missing public key", domain.ErrInvalidInvite)
+	}
+	pub, err := ParsePublicKey(peer.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidInvite, err)
+	}
+	issuedAt, err := strconv.ParseInt(q.Get("t"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp", domain.ErrInvalidInvite)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: bad signature encoding", domain.ErrInvalidInvite)
+	}
+	name := q.Get("name")
+	if !ed25519.Verify(pub, inviteMessage(pub, name, issuedAt), sig) {
+		return nil, fmt.Errorf("%w: bad signature", domain.ErrInvalidInvite)
+	}
+
+	return &domain.Invite{
+		Link:        link,
+		PublicID:    peer.PublicID,
+		PublicKey:   peer.PublicKey,
+		DisplayName: name,
+		IssuedAt:    issuedAt,
+	}, nil
+}
+
+// inviteMessage is the byte string an invite signature covers.
+func inviteMessage(pub ed25519.PublicKey, displayName string, issuedAt int64) []byte {
+	msg := make([]byte, 0, len(inviteContext)+ed25519.PublicKeySize+8+len(displayName))
+	msg = append(msg, inviteContext...)
+	msg = append(msg, pub...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(issuedAt))
+	return append(msg, displayName...)
+}
+
+// CreateInvite signs an invite link for the current identity, suggesting
+// the profile's display name. The identity must be unlocked.
+func (m *Manager) CreateInvite() (*domain.Invite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("create invite: %w", err)
+	}
+	return SignInvite(m.keys, m.profile.DisplayName, time.Now().UnixMilli()), nil
+}
@@ -0,0 +1,94 @@
+package identity
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func TestInviteRoundtrip(t *testing.T) {
+	keys, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	inv := SignInvite(keys, "Алиса & Bob", 1700000000000)
+
+	if !strings.HasPrefix(inv.Link, "quillet://add?") {
+		t.Fatalf("Link = %q; want quillet://add?...", inv.Link)
+	}
+	got, err := ParseInvite(inv.Link)
+	if err != nil {
+		t.Fatalf("ParseInvite() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, inv) {
+		t.Errorf("ParseInvite() = %+v; want %+v", *got, *inv)
+	}
+}
+
+func TestParseInvite_Tampered(t *testing.T) {
+	keys, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	other, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	link := SignInvite(keys, "Alice", 1700000000000).Link
+
+	tamper := func(key, value string) string {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set(key, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	tests := []struct {
+		name string
+		link string
+	}{
+		{name: "renamed", link: tamper("name", "Mallory")},
+		{name: "new timestamp", link: tamper("t", "1700000000001")},
+		{name: "foreign key", link: tamper("key", other.PublicKeyHex())},
+		{name: "foreign id", link: tamper("id", other.PublicID())},
+		{name: "bad signature", link: tamper("sig", "AAAA")},
+		{name: "wrong scheme", link: strings.Replace(link, "quillet://", "https://", 1)},
+		{name: "wrong action", link: strings.Replace(link, "://add", "://remove", 1)},
+		{name: "garbage", link: "not a link"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseInvite(tt.link)
+			if !errors.Is(err, domain.ErrInvalidInvite) {
+				t.Errorf("ParseInvite() error = %v; want %v", err, domain.ErrInvalidInvite)
+			}
+		})
+	}
+}
+
+func TestManager_CreateInvite(t *testing.T) {
+	m := NewManager()
+	if _, err := m.CreateInvite(); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Fatalf("CreateInvite() without identity error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+	u, err := m.Create("Alice", "", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	inv, err := m.CreateInvite()
+	if err != nil {
+		t.Fatalf("CreateInvite() error = %v", err)
+	}
+	if inv.PublicID != u.PublicID || inv.PublicKey != u.PublicKey || inv.DisplayName != u.DisplayName {
+		t.Errorf("CreateInvite() = %+v; want details of %+v", *inv, *u)
+	}
+}
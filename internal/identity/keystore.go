@@ -0,0 +1,238 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"quillet/internal/domain"
+)
+
+// keystoreVersion is the current identity.key file format version.
+const keystoreVersion = 1
+
+// Argon2id parameters for new keystores (RFC 9106, second recommended option).
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+	argonSaltLen = 16
+)
+
+// Bounds on the Argon2id parameters a keystore may ask for, so that a
+// damaged or hostile file cannot make deriving the key panic or exhaust
+// memory.
+const (
+	argonMaxTime   = 10
+	argonMinMemory = 8 * 1024 // KiB
+	argonMaxMemory = 1 << 20  // KiB
+)
+
+// kdfParams describes how the key encryption key was derived from the passphrase.
+type kdfParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// keystoreFile is the on-disk layout of identity.key.
+// The public part is stored in clear so the app can show who is locked.
+// Seed holds the Ed25519 seed, sealed with XChaCha20-Poly1305 when KDF is set.
+type keystoreFile struct {
+	Version     int        `json:"version"`
+	PublicKey   string     `json:"publicKey"`
+	DisplayName string     `json:"displayName"`
+	AvatarPath  string     `json:"avatarPath"`
+	CreatedAt   int64      `json:"createdAt"`
+	KDF         *kdfParams `json:"kdf,omitempty"`
+	Nonce       []byte     `json:"nonce,omitempty"`
+	Seed        []byte     `json:"seed"`
+
+	// Rotations lists the certificates of earlier key rotations, oldest first.
+	Rotations []RotationCertificate `json:"rotations,omitempty"`
+}
+
+// encrypted reports whether the seed is protected by a passphrase.
+func (f *keystoreFile) encrypted() bool {
+	return f.KDF != nil
+}
+
+// profile returns the user profile stored in the file.
+func (f *keystoreFile) profile() (*domain.User, error) {
+	pub, err := hex.DecodeString(f.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: bad public key", domain.ErrCorruptKeystore)
+	}
+	return &domain.User{
+		PublicID:    PublicIDFromKey(pub),
+		PublicKey:   f.PublicKey,
+		DisplayName: f.DisplayName,
+		AvatarPath:  f.AvatarPath,
+		CreatedAt:   f.CreatedAt,
+	}, nil
+}
+
+// sealPlain stores keys in the file without encryption.
+func (f *keystoreFile) sealPlain(keys *KeyPair) {
+	f.PublicKey = keys.PublicKeyHex()
+	f.KDF, f.Nonce, f.Seed = nil, nil, keys.Private.Seed()
+}
+
+// seal stores keys in the file, encrypting the seed when passphrase is non-empty.
+// It returns the derived key encryption key, or nil for a plain file.
+func (f *keystoreFile) seal(keys *KeyPair, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		f.sealPlain(keys)
+		return nil, nil
+	}
+
+	kdf := &kdfParams{
+		Salt:    make([]byte, argonSaltLen),
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+	}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	f.KDF = kdf
+	kek := kdf.derive(passphrase)
+	if err := f.sealWith(keys, kek); err != nil {
+		return nil, err
+	}
+	return kek, nil
+}
+
+// sealWith encrypts keys with an already derived key encryption key,
+// keeping the file's KDF parameters and drawing a fresh nonce.
+func (f *keystoreFile) sealWith(keys *KeyPair, kek []byte) error {
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return fmt.Errorf("init cipher: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	f.PublicKey = keys.PublicKeyHex()
+	f.Nonce = nonce
+	f.Seed = aead.Seal(nil, nonce, keys.Private.Seed(), keys.Public)
+	return nil
+}
+
+// open recovers the key pair, decrypting the seed with passphrase if needed.
+// It also returns the key encryption key, or nil for a plain file.
+// It fails with domain.ErrWrongPassphrase if the passphrase does not match.
+func (f *keystoreFile) open(passphrase string) (*KeyPair, []byte, error) {
+	pub, err := hex.DecodeString(f.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("%w: bad public key", domain.ErrCorruptKeystore)
+	}
+
+	var kek []byte
+	seed := f.Seed
+	if f.encrypted() {
+		kek = f.KDF.derive(passphrase)
+		aead, err := chacha20poly1305.NewX(kek)
+		if err != nil {
+			return nil, nil, fmt.Errorf("init cipher: %w", err)
+		}
+		if len(f.Nonce) != aead.NonceSize() {
+			return nil, nil, fmt.Errorf("%w: bad nonce", domain.ErrCorruptKeystore)
+		}
+		seed, err = aead.Open(nil, f.Nonce, f.Seed, pub)
+		if err != nil {
+			return nil, nil, domain.ErrWrongPassphrase
+		}
+	}
+
+	if len(seed) != ed25519.SeedSize {
+		return nil, nil, fmt.Errorf("%w: bad seed", domain.ErrCorruptKeystore)
+	}
+	keys := NewKeyPair(ed25519.NewKeyFromSeed(seed))
+	if !keys.Public.Equal(ed25519.PublicKey(pub)) {
+		return nil, nil, fmt.Errorf("%w: public key mismatch", domain.ErrCorruptKeystore)
+	}
+	return keys, kek, nil
+}
+
+// valid reports whether the parameters are within bounds.
+func (p *kdfParams) valid() bool {
+	return p.Time >= 1 && p.Time <= argonMaxTime &&
+		p.Memory >= argonMinMemory && p.Memory <= argonMaxMemory &&
+		p.Threads >= 1 // at most 255, as a uint8
+}
+
+// derive returns the 32-byte key encryption key for passphrase.
+func (p *kdfParams) derive(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+}
+
+// readKeystore loads identity.key from path.
+// The error wraps os.ErrNotExist if the file does not exist.
+func readKeystore(path string) (*keystoreFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeystore(data)
+}
+
+// parseKeystore decodes the contents of an identity.key file.
+func parseKeystore(data []byte) (*keystoreFile, error) {
+	var f keystoreFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrCorruptKeystore, err)
+	}
+	if f.Version != keystoreVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", domain.ErrCorruptKeystore, f.Version)
+	}
+	if f.KDF != nil && !f.KDF.valid() {
+		return nil, fmt.Errorf("%w: bad key derivation parameters", domain.ErrCorruptKeystore)
+	}
+	return &f, nil
+}
+
+// writeKeystore atomically replaces identity.key at path.
+func writeKeystore(path string, f *keystoreFile) error {
+	f.Version = keystoreVersion
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode keystore: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".identity-*.tmp")
+	if err != nil {
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write keystore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"quillet/internal/domain"
+)
+
+// Storage key derivation: HKDF-SHA256 over the key encryption key.
+const (
+	storageKeySize = 32
+	storageKeyInfo = "quillet storage key v1"
+)
+
+// Manager owns the local identity: the key pair and the user's profile.
+// When backed by a keystore file, every change is persisted to it and an
+// identity protected by a passphrase starts locked until Unlock succeeds.
+// It is safe for concurrent use.
+type Manager struct {
+	mu      sync.RWMutex
+	path    string        // identity.key location; empty for in-memory identities
+	file    *keystoreFile // sealed state, written to path; nil without an identity
+	keys    *KeyPair      // nil while locked or without an identity
+	kek     []byte        // key encryption key while unlocked; nil without a passphrase
+	profile *domain.User
+
+	onReseal func(storageKey []byte) error // see OnReseal
+}
+
+// NewManager creates an in-memory Manager without an identity.
+func NewManager() *Manager {
+	return &Manager{}
+}
+
+// NewManagerFor creates an in-memory Manager holding an existing identity
+// without a passphrase. PublicID and PublicKey of the profile are derived from keys.
+func NewManagerFor(keys *KeyPair, profile domain.User) *Manager {
+	profile.PublicID = keys.PublicID()
+	profile.PublicKey = keys.PublicKeyHex()
+
+	f := &keystoreFile{
+		DisplayName: profile.DisplayName,
+		AvatarPath:  profile.AvatarPath,
+		CreatedAt:   profile.CreatedAt,
+	}
+	f.sealPlain(keys)
+	return &Manager{file: f, keys: keys, profile: &profile}
+}
+
+// OpenManager creates a Manager backed by the keystore file at path.
+// A missing file means no identity yet. A passphrase-protected identity
+// is returned locked; an unprotected one is unlocked right away.
+func OpenManager(path string) (*Manager, error) {
+	m := &Manager{path: path}
+
+	f, err := readKeystore(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open identity: %w", err)
+	}
+
+	profile, err := f.profile()
+	if err != nil {
+		return nil, fmt.Errorf("open identity: %w", err)
+	}
+	m.file = f
+	m.profile = profile
+
+	if !f.encrypted() {
+		keys, _, err := f.open("")
+		if err != nil {
+			return nil, fmt.Errorf("open identity: %w", err)
+		}
+		m.keys = keys
+	}
+	return m, nil
+}
+
+// HasIdentity reports whether an identity has been created.
+func (m *Manager) HasIdentity() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.profile != nil
+}
+
+// Create generates a new key pair and profile. A non-empty passphrase
+// encrypts the private key at rest.
+// It fails with domain.ErrIdentityExists if an identity is already present.
+func (m *Manager) Create(displayName, passphrase, avatarPath string) (*domain.User, error) {
+	keys, err := Generate()
+	if err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profile != nil {
+		return nil, fmt.Errorf("create identity: %w", domain.ErrIdentityExists)
+	}
+
+	profile := &domain.User{
+		PublicID:    keys.PublicID(),
+		PublicKey:   keys.PublicKeyHex(),
+		DisplayName: displayName,
+		AvatarPath:  avatarPath,
+		CreatedAt:   time.Now().UnixMilli(),
+	}
+	if err := m.persist(keys, profile, passphrase); err != nil {
+		return nil, fmt.Errorf("create identity: %w", err)
+	}
+	m.profile = profile
+
+	u := *m.profile
+	return &u, nil
+}
+
+// Profile returns a copy of the user's profile.
+func (m *Manager) Profile() (*domain.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	u := *m.profile
+	return &u, nil
+}
+
+// PublicID returns the user's public ID, or "" if there is no identity.
+// It is available while locked.
+func (m *Manager) PublicID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.profile == nil {
+		return ""
+	}
+	return m.profile.PublicID
+}
+
+// SetProfile changes the profile's display name and avatar.
+func (m *Manager) SetProfile(displayName, avatarPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return err
+	}
+	f := *m.file
+	f.DisplayName = displayName
+	f.AvatarPath = avatarPath
+	if err := m.write(&f); err != nil {
+		return fmt.Errorf("set profile: %w", err)
+	}
+	m.profile.DisplayName = displayName
+	m.profile.AvatarPath = avatarPath
+	return nil
+}
+
+// Locked reports whether an identity exists but its private key is not loaded.
+func (m *Manager) Locked() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.profile != nil && m.keys == nil
+}
+
+// HasPassphrase reports whether the private key is encrypted with a passphrase.
+func (m *Manager) HasPassphrase() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.file != nil && m.file.encrypted()
+}
+
+// Unlock decrypts the private key with passphrase.
+// Unlocking an identity that is already unlocked is a no-op.
+func (m *Manager) Unlock(passphrase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profile == nil {
+		return fmt.Errorf("unlock identity: %w", domain.ErrNoIdentity)
+	}
+	if m.keys != nil {
+		return nil
+	}
+	keys, kek, err := m.file.open(passphrase)
+	if err != nil {
+		return fmt.Errorf("unlock identity: %w", err)
+	}
+	m.keys = keys
+	m.kek = kek
+	return nil
+}
+
+// Lock wipes the private key from memory.
+// Only a passphrase-protected identity can be locked.
+func (m *Manager) Lock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profile == nil {
+		return fmt.Errorf("lock identity: %w", domain.ErrNoIdentity)
+	}
+	if !m.file.encrypted() {
+		return fmt.Errorf("lock identity: %w", domain.ErrNoPassphrase)
+	}
+	m.wipe()
+	return nil
+}
+
+// ChangePassphrase re-encrypts the private key with newPassphrase after
+// checking oldPassphrase, which must be empty if there is none. An empty
+// newPassphrase removes the encryption.
+func (m *Manager) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profile == nil {
+		return fmt.Errorf("change passphrase: %w", domain.ErrNoIdentity)
+	}
+	if !m.file.encrypted() && oldPassphrase != "" {
+		return fmt.Errorf("change passphrase: %w", domain.ErrWrongPassphrase)
+	}
+	keys, _, err := m.file.open(oldPassphrase)
+	if err != nil {
+		return fmt.Errorf("change passphrase: %w", err)
+	}
+	locked := m.keys == nil
+	if err := m.persist(keys, m.profile, newPassphrase); err != nil {
+		return fmt.Errorf("change passphrase: %w", err)
+	}
+	if locked {
+		m.wipe()
+	}
+	return nil
+}
+
+// StorageKey returns the key that protects local data at rest, derived
+// from the key encryption key, or nil if the identity has no passphrase.
+// It changes whenever the keystore is sealed anew: on Create, Import and
+// ChangePassphrase. It fails with domain.ErrLocked while locked.
+func (m *Manager) StorageKey() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, err
+	}
+	return storageKey(m.kek)
+}
+
+// OnReseal registers fn to be called with the new storage key before the
+// keystore is sealed anew and written, so that data protected with the
+// storage key can be made to open with both the old and the new key. If
+// fn fails, the keystore stays as it was. fn must not call the Manager.
+func (m *Manager) OnReseal(fn func(storageKey []byte) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onReseal = fn
+}
+
+// storageKey derives the storage key from kek, nil for nil.
+func storageKey(kek []byte) ([]byte, error) {
+	if kek == nil {
+		return nil, nil
+	}
+	key := make([]byte, storageKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, kek, nil, []byte(storageKeyInfo)), key); err != nil {
+		return nil, fmt.Errorf("derive storage key: %w", err)
+	}
+	return key, nil
+}
+
+// Sign signs msg with the identity key and returns the public key that
+// verifies the signature, read together so that a concurrent rotation
+// cannot pair one key with the other's signature. Callers prefix msg with
+// a context string of their own, as rotations and invites do.
+// It fails with domain.ErrLocked while locked.
+func (m *Manager) Sign(msg []byte) (ed25519.PublicKey, []byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, nil, err
+	}
+	return m.keys.Public, ed25519.Sign(m.keys.Private, msg), nil
+}
+
+// persist seals keys and profile with passphrase, writes the result and
+// makes keys current, calling the OnReseal hook in between. Rotation
+// history is kept when keys are the current keys, and dropped for a
+// different identity.
+// Must be called with m.mu held.
+func (m *Manager) persist(keys *KeyPair, profile *domain.User, passphrase string) error {
+	f := &keystoreFile{
+		DisplayName: profile.DisplayName,
+		AvatarPath:  profile.AvatarPath,
+		CreatedAt:   profile.CreatedAt,
+	}
+	if m.file != nil && m.file.PublicKey == keys.PublicKeyHex() {
+		f.Rotations = m.file.Rotations
+	}
+	kek, err := f.seal(keys, passphrase)
+	if err != nil {
+		return err
+	}
+	if m.onReseal != nil {
+		key, err := storageKey(kek)
+		if err != nil {
+			return err
+		}
+		if err := m.onReseal(key); err != nil {
+			return err
+		}
+	}
+	if err := m.write(f); err != nil {
+		return err
+	}
+	m.wipe()
+	m.keys = keys
+	m.kek = kek
+	return nil
+}
+
+// wipe erases the private key and key encryption key from memory.
+// Must be called with m.mu held.
+func (m *Manager) wipe() {
+	if m.keys != nil {
+		clear(m.keys.Private)
+		m.keys = nil
+	}
+	clear(m.kek)
+	m.kek = nil
+}
+
+// write stores f to the keystore file, if there is one, and makes it current.
+// Must be called with m.mu held.
+func (m *Manager) write(f *keystoreFile) error {
+	if m.path != "" {
+		if err := writeKeystore(m.path, f); err != nil {
+			return err
+		}
+	}
+	m.file = f
+	return nil
+}
+
+// checkUnlocked returns an error unless an identity exists and is unlocked.
+// Must be called with m.mu held.
+func (m *Manager) checkUnlocked() error {
+	if m.profile == nil {
+		return domain.ErrNoIdentity
+	}
+	if m.keys == nil {
+		return domain.ErrLocked
+	}
+	return nil
+}
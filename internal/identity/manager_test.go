@@ -0,0 +1,325 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func keystorePath(t *testing.T) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), "identity.key")
+}
+
+func mustOpen(t *testing.T, path string) *Manager {
+	t.Helper()
+	m, err := OpenManager(path)
+	if err != nil {
+		t.Fatalf("OpenManager(%q) error = %v", path, err)
+	}
+	return m
+}
+
+func TestOpenManager_Missing(t *testing.T) {
+	m := mustOpen(t, keystorePath(t))
+
+	if m.HasIdentity() {
+		t.Error("HasIdentity() = true; want false")
+	}
+	if _, err := m.Profile(); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Errorf("Profile() error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+}
+
+func TestManager_PersistsWithoutPassphrase(t *testing.T) {
+	path := keystorePath(t)
+	created, err := mustOpen(t, path).Create("Alice", "", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	m := mustOpen(t, path)
+	if m.Locked() {
+		t.Fatal("Locked() = true; want false without passphrase")
+	}
+	p, err := m.Profile()
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+	if *p != *created {
+		t.Errorf("Profile() = %+v; want %+v", *p, *created)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("keystore mode = %o; want 600", perm)
+	}
+}
+
+func TestManager_LockedWithPassphrase(t *testing.T) {
+	path := keystorePath(t)
+	created, err := mustOpen(t, path).Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	m := mustOpen(t, path)
+	if !m.Locked() {
+		t.Fatal("Locked() = false; want true with passphrase")
+	}
+	if !m.HasPassphrase() {
+		t.Error("HasPassphrase() = false; want true")
+	}
+	if got := m.PublicID(); got != created.PublicID {
+		t.Errorf("PublicID() = %q; want %q while locked", got, created.PublicID)
+	}
+	if _, err := m.Profile(); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("Profile() error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := m.Unlock("wrong"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("Unlock(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	if err := m.Unlock("secret"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if _, err := m.Profile(); err != nil {
+		t.Errorf("Profile() after unlock error = %v", err)
+	}
+
+	if err := m.Lock(); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if !m.Locked() {
+		t.Error("Locked() after Lock = false; want true")
+	}
+}
+
+func TestManager_LockWithoutPassphrase(t *testing.T) {
+	m := mustOpen(t, keystorePath(t))
+	if _, err := m.Create("Alice", "", ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := m.Lock(); !errors.Is(err, domain.ErrNoPassphrase) {
+		t.Errorf("Lock() error = %v; want %v", err, domain.ErrNoPassphrase)
+	}
+}
+
+func TestManager_ChangePassphrase(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string
+		old     string
+		new     string
+		wantErr error
+		locked  bool
+	}{
+		{name: "set", initial: "", old: "", new: "secret", locked: true},
+		{name: "change", initial: "old-pass", old: "old-pass", new: "new-pass", locked: true},
+		{name: "remove", initial: "old-pass", old: "old-pass", new: "", locked: false},
+		{name: "wrong old", initial: "old-pass", old: "nope", new: "new-pass", wantErr: domain.ErrWrongPassphrase},
+		{name: "old without one", initial: "", old: "nope", new: "new-pass", wantErr: domain.ErrWrongPassphrase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := keystorePath(t)
+			if _, err := mustOpen(t, path).Create("Alice", tt.initial, ""); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			m := mustOpen(t, path)
+			err := m.ChangePassphrase(tt.old, tt.new)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ChangePassphrase() error = %v; want %v", err, tt.wantErr)
+				}
+				if got := mustOpen(t, path).HasPassphrase(); got != (tt.initial != "") {
+					t.Errorf("HasPassphrase() = %v after a failed change; want %v", got, tt.initial != "")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ChangePassphrase() error = %v", err)
+			}
+
+			reopened := mustOpen(t, path)
+			if reopened.Locked() != tt.locked {
+				t.Fatalf("Locked() = %v; want %v", reopened.Locked(), tt.locked)
+			}
+			if err := reopened.Unlock(tt.new); err != nil {
+				t.Errorf("Unlock(new) error = %v", err)
+			}
+		})
+	}
+}
+
+func TestManager_StorageKey(t *testing.T) {
+	path := keystorePath(t)
+	m := mustOpen(t, path)
+	if _, err := m.Create("Alice", "secret", ""); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	key, err := m.StorageKey()
+	if err != nil || len(key) != storageKeySize {
+		t.Fatalf("StorageKey() = %x, %v; want a %d-byte key", key, err, storageKeySize)
+	}
+	if _, _, err := m.Rotate(); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	// Stable across restarts and rotations while the passphrase stays.
+	m = mustOpen(t, path)
+	if _, err := m.StorageKey(); !errors.Is(err, domain.ErrLocked) {
+		t.Fatalf("StorageKey() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := m.Unlock("secret"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	again, err := m.StorageKey()
+	if err != nil || !bytes.Equal(again, key) {
+		t.Fatalf("StorageKey() after unlock = %x, %v; want %x", again, err, key)
+	}
+
+	if err := m.ChangePassphrase("secret", "other"); err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+	if changed, _ := m.StorageKey(); bytes.Equal(changed, key) {
+		t.Error("StorageKey() unchanged after ChangePassphrase")
+	}
+	if err := m.ChangePassphrase("other", ""); err != nil {
+		t.Fatalf("ChangePassphrase(remove) error = %v", err)
+	}
+	if plain, err := m.StorageKey(); plain != nil || err != nil {
+		t.Errorf("StorageKey() without passphrase = %x, %v; want nil, nil", plain, err)
+	}
+}
+
+func TestManager_Sign(t *testing.T) {
+	m := mustOpen(t, keystorePath(t))
+	msg := []byte("test context\x00payload")
+	if _, _, err := m.Sign(msg); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Errorf("Sign() without identity error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+	u, err := m.Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	pub, sig, err := m.Sign(msg)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if PublicIDFromKey(pub) != u.PublicID || !ed25519.Verify(pub, msg, sig) {
+		t.Errorf("Sign() = %x, %x; want a signature by %s", pub, sig, u.PublicID)
+	}
+	if err := m.Lock(); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if _, _, err := m.Sign(msg); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("Sign() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+}
+
+func TestManager_ECDH(t *testing.T) {
+	alice, bob := mustOpen(t, keystorePath(t)), mustOpen(t, keystorePath(t))
+	for _, m := range []*Manager{alice, bob} {
+		if _, err := m.Create("Peer", "secret", ""); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	x25519 := func(m *Manager) *ecdh.PublicKey {
+		u, _ := m.Profile()
+		pub, err := ParsePublicKey(u.PublicKey)
+		if err != nil {
+			t.Fatalf("ParsePublicKey() error = %v", err)
+		}
+		x, err := X25519PublicKey(pub)
+		if err != nil {
+			t.Fatalf("X25519PublicKey() error = %v", err)
+		}
+		return x
+	}
+
+	_, ab, err := alice.ECDH(x25519(bob))
+	if err != nil {
+		t.Fatalf("ECDH() error = %v", err)
+	}
+	pub, ba, err := bob.ECDH(x25519(alice))
+	if err != nil || !bytes.Equal(ab, ba) || PublicIDFromKey(pub) != bob.PublicID() {
+		t.Errorf("ECDH() = %x, %x, %v; want Bob's key and the secret %x", pub, ba, err, ab)
+	}
+	if err := bob.Lock(); err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if _, _, err := bob.ECDH(x25519(alice)); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("ECDH() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+}
+
+func TestOpenManager_Corrupt(t *testing.T) {
+	path := keystorePath(t)
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := OpenManager(path); !errors.Is(err, domain.ErrCorruptKeystore) {
+		t.Errorf("OpenManager() error = %v; want %v", err, domain.ErrCorruptKeystore)
+	}
+}
+
+func TestManager_Rotate(t *testing.T) {
+	path := keystorePath(t)
+	m := mustOpen(t, path)
+	before, err := m.Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	cert, after, err := m.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if err := cert.Verify(); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if cert.OldPublicKey != before.PublicKey || cert.NewPublicKey != after.PublicKey {
+		t.Errorf("certificate %s -> %s; want %s -> %s",
+			cert.OldPublicKey, cert.NewPublicKey, before.PublicKey, after.PublicKey)
+	}
+
+	// the rotated key stays behind the same passphrase
+	reopened := mustOpen(t, path)
+	if err := reopened.Unlock("secret"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	p, err := reopened.Profile()
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+	if p.PublicKey != after.PublicKey {
+		t.Errorf("PublicKey = %q; want %q", p.PublicKey, after.PublicKey)
+	}
+	if got := reopened.Rotations(); len(got) != 1 || got[0] != *cert {
+		t.Errorf("Rotations() = %+v; want [%+v]", got, *cert)
+	}
+}
+
+func TestRotationCertificate_Tampered(t *testing.T) {
+	old, _ := Generate()
+	next, _ := Generate()
+	cert := SignRotation(old, next, 42)
+
+	cert.IssuedAt++
+	if err := cert.Verify(); !errors.Is(err, domain.ErrInvalidRotation) {
+		t.Errorf("Verify() error = %v; want %v", err, domain.ErrInvalidRotation)
+	}
+}
@@ -0,0 +1,140 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"fmt"
+	"strings"
+
+	"quillet/internal/domain"
+)
+
+// MnemonicWords is the number of words in a recovery phrase.
+// 24 words × 11 bits = 256 bits of seed + 8 bits of SHA-256 checksum,
+// the BIP-39 encoding of 32 bytes of entropy.
+const MnemonicWords = 24
+
+const (
+	mnemonicBitsPerWord = 11
+	mnemonicChecksumLen = 8 // bits
+	mnemonicPrefixLen   = 4 // BIP-39 words are unique by their first four letters
+)
+
+//go:embed wordlist_english.txt
+var wordlistText string
+
+var (
+	wordlist  = strings.Fields(wordlistText)
+	wordIndex = indexWords(wordlist)
+)
+
+func indexWords(words []string) map[string]int {
+	idx := make(map[string]int, len(words))
+	for i, w := range words {
+		idx[w] = i
+	}
+	return idx
+}
+
+// SeedToMnemonic encodes a 32-byte Ed25519 seed as a 24-word recovery
+// phrase using the BIP-39 English word list. The words encode the seed
+// itself (as BIP-39 entropy), not a seed stretched from the words.
+func SeedToMnemonic(seed []byte) (string, error) {
+	if len(seed) != ed25519.SeedSize {
+		return "", domain.ErrInvalidPrivateKey
+	}
+
+	sum := sha256.Sum256(seed)
+	data := append(append([]byte(nil), seed...), sum[0])
+
+	words := make([]string, MnemonicWords)
+	for i := range words {
+		words[i] = wordlist[readBits(data, i*mnemonicBitsPerWord, mnemonicBitsPerWord)]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToSeed decodes a recovery phrase back into the 32-byte seed.
+// Words are case-insensitive and may be separated by any whitespace.
+// Errors wrap domain.ErrMnemonicWordCount, domain.ErrMnemonicUnknownWord
+// (naming the position of the first bad word) or domain.ErrMnemonicChecksum.
+func MnemonicToSeed(phrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != MnemonicWords {
+		return nil, fmt.Errorf("%w: got %d, want %d", domain.ErrMnemonicWordCount, len(words), MnemonicWords)
+	}
+
+	data := make([]byte, ed25519.SeedSize+1)
+	for i, w := range words {
+		n, ok := wordIndex[w]
+		if !ok {
+			return nil, unknownWordError(i, w)
+		}
+		writeBits(data, i*mnemonicBitsPerWord, mnemonicBitsPerWord, n)
+	}
+
+	seed := data[:ed25519.SeedSize]
+	sum := sha256.Sum256(seed)
+	if sum[0] != data[ed25519.SeedSize] {
+		return nil, domain.ErrMnemonicChecksum
+	}
+	return seed, nil
+}
+
+// unknownWordError reports the 1-based position of a word that is not in
+// the word list, suggesting the list word sharing its first four letters.
+func unknownWordError(i int, w string) error {
+	if len(w) >= mnemonicPrefixLen {
+		prefix := w[:mnemonicPrefixLen]
+		for _, candidate := range wordlist {
+			if strings.HasPrefix(candidate, prefix) {
+				return fmt.Errorf("%w: word %d %q (did you mean %q?)", domain.ErrMnemonicUnknownWord, i+1, w, candidate)
+			}
+		}
+	}
+	return fmt.Errorf("%w: word %d %q", domain.ErrMnemonicUnknownWord, i+1, w)
+}
+
+// readBits returns n bits of data starting at bit offset off, MSB first.
+func readBits(data []byte, off, n int) int {
+	v := 0
+	for i := off; i < off+n; i++ {
+		v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+	}
+	return v
+}
+
+// writeBits stores the low n bits of v into data at bit offset off, MSB first.
+func writeBits(data []byte, off, n, v int) {
+	for i := 0; i < n; i++ {
+		if v>>(n-1-i)&1 == 1 {
+			bit := off + i
+			data[bit/8] |= 1 << (7 - bit%8)
+		}
+	}
+}
+
+// ExportMnemonic returns the recovery phrase for the identity's seed
+// after re-checking passphrase, like ExportPrivateKey.
+func (m *Manager) ExportMnemonic(passphrase string) (string, error) {
+	keys, err := m.reopen(passphrase)
+	if err != nil {
+		return "", fmt.Errorf("export recovery phrase: %w", err)
+	}
+	return SeedToMnemonic(keys.Private.Seed())
+}
+
+// ImportMnemonic replaces the identity with the one encoded by phrase,
+// protected by passphrase. Overwrite semantics match Import.
+func (m *Manager) ImportMnemonic(phrase, passphrase string, overwrite bool) (*domain.User, error) {
+	seed, err := MnemonicToSeed(phrase)
+	if err != nil {
+		return nil, fmt.Errorf("import recovery phrase: %w", err)
+	}
+	u, err := m.replace(NewKeyPair(ed25519.NewKeyFromSeed(seed)), nil, passphrase, overwrite)
+	if err != nil {
+		return nil, fmt.Errorf("import recovery phrase: %w", err)
+	}
+	return u, nil
+}
@@ -0,0 +1,107 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+// BIP-39 reference vectors for 256-bit entropy.
+func TestSeedToMnemonic_Vectors(t *testing.T) {
+	tests := []struct {
+		seed string
+		want string
+	}{
+		{
+			seed: strings.Repeat("00", 32),
+			want: strings.Repeat("abandon ", 23) + "art",
+		},
+		{
+			seed: strings.Repeat("7f", 32),
+			want: "legal winner thank year wave sausage worth useful legal winner thank year " +
+				"wave sausage worth useful legal winner thank year wave sausage worth title",
+		},
+		{
+			seed: strings.Repeat("ff", 32),
+			want: strings.Repeat("zoo ", 23) + "vote",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.seed[:4], func(t *testing.T) {
+			seed, _ := hex.DecodeString(tt.seed)
+			got, err := SeedToMnemonic(seed)
+			if err != nil {
+				t.Fatalf("SeedToMnemonic() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("SeedToMnemonic() = %q; want %q", got, tt.want)
+			}
+
+			back, err := MnemonicToSeed(got)
+			if err != nil {
+				t.Fatalf("MnemonicToSeed() error = %v", err)
+			}
+			if !bytes.Equal(back, seed) {
+				t.Errorf("MnemonicToSeed() = %x; want %x", back, seed)
+			}
+		})
+	}
+}
+
+func TestMnemonicToSeed_Errors(t *testing.T) {
+	valid := strings.Repeat("abandon ", 23) + "art"
+
+	tests := []struct {
+		name    string
+		phrase  string
+		wantErr error
+		wantMsg string
+	}{
+		{name: "too few words", phrase: "abandon art", wantErr: domain.ErrMnemonicWordCount},
+		{name: "typo", phrase: strings.Replace(valid, "abandon", "abandn", 1), wantErr: domain.ErrMnemonicUnknownWord, wantMsg: "word 1"},
+		{name: "typo with suggestion", phrase: strings.Replace(valid, "abandon", "abandom", 1), wantErr: domain.ErrMnemonicUnknownWord, wantMsg: `did you mean "abandon"`},
+		{name: "bad checksum", phrase: strings.Repeat("abandon ", 24), wantErr: domain.ErrMnemonicChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MnemonicToSeed(tt.phrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MnemonicToSeed() error = %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("MnemonicToSeed() error = %q; want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestManager_MnemonicRoundtrip(t *testing.T) {
+	src := mustOpen(t, keystorePath(t))
+	created, err := src.Create("Alice", "secret", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := src.ExportMnemonic("wrong"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("ExportMnemonic(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	phrase, err := src.ExportMnemonic("secret")
+	if err != nil {
+		t.Fatalf("ExportMnemonic() error = %v", err)
+	}
+
+	dst := mustOpen(t, keystorePath(t))
+	restored, err := dst.ImportMnemonic(strings.ToUpper(phrase), "", false)
+	if err != nil {
+		t.Fatalf("ImportMnemonic() error = %v", err)
+	}
+	if restored.PublicID != created.PublicID {
+		t.Errorf("PublicID = %q; want %q", restored.PublicID, created.PublicID)
+	}
+}
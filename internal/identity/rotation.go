@@ -0,0 +1,134 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// rotationContext domain-separates rotation signatures from every other
+// signature made with an identity key.
+const rotationContext = "quillet key rotation v1\x00"
+
+// RotationCertificate announces that an identity moved from OldPublicKey
+// to NewPublicKey. The old key signs the statement so contacts can trust
+// the new key; the new key co-signs it to prove possession of the private half.
+type RotationCertificate struct {
+	OldPublicKey string `json:"oldPublicKey"`
+	NewPublicKey string `json:"newPublicKey"`
+	IssuedAt     int64  `json:"issuedAt"`
+	OldSignature string `json:"oldSignature"`
+	NewSignature string `json:"newSignature"`
+}
+
+// SignRotation creates a certificate for moving from old to next.
+func SignRotation(old, next *KeyPair, issuedAt int64) *RotationCertificate {
+	msg := rotationMessage(old.Public, next.Public, issuedAt)
+	return &RotationCertificate{
+		OldPublicKey: old.PublicKeyHex(),
+		NewPublicKey: next.PublicKeyHex(),
+		IssuedAt:     issuedAt,
+		OldSignature: hex.EncodeToString(ed25519.Sign(old.Private, msg)),
+		NewSignature: hex.EncodeToString(ed25519.Sign(next.Private, msg)),
+	}
+}
+
+// Verify checks both signatures of the certificate.
+// It fails with domain.ErrInvalidRotation if either is missing or wrong.
+func (c *RotationCertificate) Verify() error {
+	oldPub, err := ParsePublicKey(c.OldPublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: old key: %w", domain.ErrInvalidRotation, err)
+	}
+	newPub, err := ParsePublicKey(c.NewPublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: new key: %w", domain.ErrInvalidRotation, err)
+	}
+	if oldPub.Equal(newPub) {
+		return fmt.Errorf("%w: keys are identical", domain.ErrInvalidRotation)
+	}
+
+	msg := rotationMessage(oldPub, newPub, c.IssuedAt)
+	if !verifyHex(oldPub, msg, c.OldSignature) {
+		return fmt.Errorf("%w: bad old-key signature", domain.ErrInvalidRotation)
+	}
+	if !verifyHex(newPub, msg, c.NewSignature) {
+		return fmt.Errorf("%w: bad new-key signature", domain.ErrInvalidRotation)
+	}
+	return nil
+}
+
+// rotationMessage is the byte string both keys sign.
+func rotationMessage(oldPub, newPub ed25519.PublicKey, issuedAt int64) []byte {
+	msg := make([]byte, 0, len(rotationContext)+2*ed25519.PublicKeySize+8)
+	msg = append(msg, rotationContext...)
+	msg = append(msg, oldPub...)
+	msg = append(msg, newPub...)
+	return binary.BigEndian.AppendUint64(msg, uint64(issuedAt))
+}
+
+// verifyHex verifies a hex-encoded Ed25519 signature.
+func verifyHex(pub ed25519.PublicKey, msg []byte, sigHex string) bool {
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, msg, sig)
+}
+
+// Rotate replaces the identity key with a freshly generated one and returns
+// the certificate, signed by the old key, that contacts need to accept the
+// new key. The passphrase protection of the key file is kept.
+// The identity must be unlocked.
+func (m *Manager) Rotate() (*RotationCertificate, *domain.User, error) {
+	next, err := Generate()
+	if err != nil {
+		return nil, nil, fmt.Errorf("rotate identity key: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, nil, fmt.Errorf("rotate identity key: %w", err)
+	}
+
+	cert := SignRotation(m.keys, next, time.Now().UnixMilli())
+
+	f := *m.file
+	f.Rotations = append(append([]RotationCertificate(nil), m.file.Rotations...), *cert)
+	if f.encrypted() {
+		if err := f.sealWith(next, m.kek); err != nil {
+			return nil, nil, fmt.Errorf("rotate identity key: %w", err)
+		}
+	} else {
+		f.sealPlain(next)
+	}
+	if err := m.write(&f); err != nil {
+		return nil, nil, fmt.Errorf("rotate identity key: %w", err)
+	}
+
+	clear(m.keys.Private)
+	m.keys = next
+	m.profile.PublicID = next.PublicID()
+	m.profile.PublicKey = next.PublicKeyHex()
+
+	u := *m.profile
+	return cert, &u, nil
+}
+
+// Rotations returns the certificates of all earlier key rotations, oldest first.
+// Peers that still know an old key can follow the chain to the current one.
+func (m *Manager) Rotations() []RotationCertificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.file == nil {
+		return nil
+	}
+	return append([]RotationCertificate(nil), m.file.Rotations...)
+}
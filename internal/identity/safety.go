@@ -0,0 +1,75 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"quillet/internal/domain"
+)
+
+// Safety number parameters. Each party contributes a 30-digit fingerprint
+// of its public key; the two halves are concatenated in key order, so both
+// sides of a conversation compute the same 60 digits.
+const (
+	safetyVersion      = 1
+	safetyIterations   = 5200
+	safetyDigitsPerKey = 30
+	safetyGroupSize    = 5
+)
+
+// ParsePublicKey decodes a 64-hex-character Ed25519 public key.
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, domain.ErrInvalidPublicKey
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// SafetyNumber returns the safety number for a pair of identity keys as
+// twelve space-separated groups of five digits. It does not depend on the
+// order of the arguments and changes whenever either key changes.
+func SafetyNumber(a, b ed25519.PublicKey) string {
+	fa, fb := keyFingerprint(a), keyFingerprint(b)
+	if bytes.Compare(a, b) > 0 {
+		fa, fb = fb, fa
+	}
+	digits := fa + fb
+
+	groups := make([]string, 0, len(digits)/safetyGroupSize)
+	for i := 0; i < len(digits); i += safetyGroupSize {
+		groups = append(groups, digits[i:i+safetyGroupSize])
+	}
+	return strings.Join(groups, " ")
+}
+
+// keyFingerprint stretches a public key with iterated SHA-512 and encodes
+// the first 30 bytes as 30 decimal digits, five digits per 5-byte chunk.
+func keyFingerprint(pub ed25519.PublicKey) string {
+	var version [2]byte
+	binary.BigEndian.PutUint16(version[:], safetyVersion)
+
+	h := sha512.New()
+	h.Write(version[:])
+	h.Write(pub)
+	digest := h.Sum(nil)
+	for range safetyIterations {
+		h.Reset()
+		h.Write(digest)
+		h.Write(pub)
+		digest = h.Sum(digest[:0])
+	}
+
+	var sb strings.Builder
+	for i := 0; i < safetyDigitsPerKey/safetyGroupSize; i++ {
+		chunk := digest[i*5 : i*5+5]
+		n := uint64(chunk[0])<<32 | uint64(chunk[1])<<24 | uint64(chunk[2])<<16 | uint64(chunk[3])<<8 | uint64(chunk[4])
+		fmt.Fprintf(&sb, "%05d", n%100000)
+	}
+	return sb.String()
+}
@@ -0,0 +1,35 @@
+package identity
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSafetyNumber(t *testing.T) {
+	a, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	b, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	c, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	ab := SafetyNumber(a.Public, b.Public)
+	if !regexp.MustCompile(`^(\d{5} ){11}\d{5}$`).MatchString(ab) {
+		t.Errorf("SafetyNumber() = %q; want 12 groups of 5 digits", ab)
+	}
+	if ba := SafetyNumber(b.Public, a.Public); ba != ab {
+		t.Errorf("SafetyNumber(b, a) = %q; want %q", ba, ab)
+	}
+	if again := SafetyNumber(a.Public, b.Public); again != ab {
+		t.Errorf("SafetyNumber() is not stable: %q then %q", ab, again)
+	}
+	if ac := SafetyNumber(a.Public, c.Public); ac == ab {
+		t.Error("SafetyNumber() did not change with the contact's key")
+	}
+}
@@ -0,0 +1,77 @@
+package identity
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"quillet/internal/domain"
+)
+
+// An identity key also serves for Diffie-Hellman: its X25519 form is the
+// Montgomery form of the same point, and the X25519 scalar is the one the
+// Ed25519 private key signs with. This lets anyone who knows a contact's
+// identity key encrypt to it, as relayed envelopes do.
+
+// fieldPrime is 2^255 - 19, the prime both curves are defined over.
+var fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// X25519PublicKey converts an Ed25519 public key to its X25519 form,
+// u = (1 + y) / (1 - y).
+func X25519PublicKey(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: %d bytes", domain.ErrInvalidPublicKey, len(pub))
+	}
+	le := slices.Clone(pub)
+	le[31] &= 0x7f // the sign of x
+	slices.Reverse(le)
+	y := new(big.Int).SetBytes(le)
+	if y.Cmp(fieldPrime) >= 0 {
+		return nil, fmt.Errorf("%w: y out of range", domain.ErrInvalidPublicKey)
+	}
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, fieldPrime)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("%w: neutral element", domain.ErrInvalidPublicKey)
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, fieldPrime))
+	u.Mod(u, fieldPrime)
+
+	b := u.FillBytes(make([]byte, 32))
+	slices.Reverse(b)
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// X25519PrivateKey returns the X25519 form of the private key.
+func (k *KeyPair) X25519PrivateKey() (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(k.Private.Seed())
+	defer clear(h[:])
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// ECDH returns the X25519 shared secret of the identity key and remote,
+// and the public identity key it belongs to, read together as in Sign.
+// It fails with domain.ErrLocked while locked.
+func (m *Manager) ECDH(remote *ecdh.PublicKey) (ed25519.PublicKey, []byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkUnlocked(); err != nil {
+		return nil, nil, err
+	}
+	priv, err := m.keys.X25519PrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := priv.ECDH(remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.keys.Public, secret, nil
+}
@@ -0,0 +1,902 @@
+// Package local implements messenger.Messenger on top of a persistent
+// profile: the identity key in identity.key and everything else in the
+// SQLite store, encrypted with the identity's storage key and locked and
+// unlocked together with the identity. It has no network transport of its own; outgoing messages
+// stay in StatusSending until a transport built on it delivers them, using
+// the delivery methods that store what arrives and fire the callbacks.
+// Undelivered messages wait in the store's outbox, which the transport
+// retries; the janitor fails those that outlive the outbox TTL.
+package local
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/messenger"
+	"quillet/internal/store"
+)
+
+// compile-time checks
+var (
+	_ messenger.Messenger      = (*Messenger)(nil)
+	_ messenger.StorageManager = (*Messenger)(nil)
+	_ messenger.Outbox         = (*Messenger)(nil)
+)
+
+// janitorInterval is how often the janitor looks for expired messages
+// and outbox entries when no setting changes in between.
+const janitorInterval = 10 * time.Minute
+
+// Messenger is a messenger.Messenger backed by a profile data directory.
+type Messenger struct {
+	self *identity.Manager
+	db   *store.Store
+	wg   sync.WaitGroup
+
+	// sweep wakes the janitor after a retention setting changed.
+	sweep chan struct{}
+
+	mu                     sync.RWMutex
+	onNewMessage           func(domain.Message)
+	onContactStatusChanged messenger.ContactStatusHandler
+	onMessageStatusChanged messenger.MessageStatusHandler
+	onTypingChanged        messenger.TypingHandler
+	onConnectionChanged    messenger.ConnectionHandler
+	onContactKeyChanged    messenger.ContactKeyHandler
+	onMessagesExpired      messenger.ExpiryHandler
+
+	// Reported by a transport; see SetContactOnline and SetConnectionState.
+	online    map[string]bool
+	connState domain.ConnectionState
+}
+
+// Open creates a Messenger for the profile in dir, using self as the
+// local identity and dir/quillet.db as the store. The store is unlocked
+// right away if the identity is.
+func Open(dir string, self *identity.Manager) (*Messenger, error) {
+	db, err := store.Open(filepath.Join(dir, store.FileName), self)
+	if err != nil {
+		return nil, err
+	}
+	m := &Messenger{self: self, db: db, sweep: make(chan struct{}, 1), online: map[string]bool{}}
+	// Whatever identity.key ends up on disk must open the store; see
+	// store.Store.StageKey. A locked store is keyed when it is unlocked.
+	self.OnReseal(func(storageKey []byte) error {
+		if db.Locked() {
+			return nil
+		}
+		return db.StageKey(context.Background(), storageKey)
+	})
+	if self.HasIdentity() && !self.Locked() {
+		if err := m.unlockData(context.Background()); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("open messenger: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Close closes the store. Call it after Wait.
+func (m *Messenger) Close() error {
+	return m.db.Close()
+}
+
+// Snapshot writes a consistent copy of the database to path; see
+// backup.Snapshotter. It works while locked, as the copy stays encrypted.
+func (m *Messenger) Snapshot(ctx context.Context, path string) error {
+	return m.db.Snapshot(ctx, path)
+}
+
+// data returns the store once the identity exists and is unlocked.
+func (m *Messenger) data() (*store.Store, error) {
+	if !m.self.HasIdentity() {
+		return nil, domain.ErrNoIdentity
+	}
+	if m.self.Locked() {
+		return nil, domain.ErrLocked
+	}
+	return m.db, nil
+}
+
+// unlockData unlocks the store with the identity's storage key.
+func (m *Messenger) unlockData(ctx context.Context) error {
+	key, err := m.self.StorageKey()
+	if err != nil {
+		return err
+	}
+	return m.db.Unlock(ctx, key)
+}
+
+// adopt brings the store in line with an identity that was just created
+// or imported: an unlocked store keeps only the key staged for it, a new
+// one is keyed by it, and own messages are attributed to it.
+func (m *Messenger) adopt(ctx context.Context, u *domain.User, wasUnlocked bool) error {
+	if wasUnlocked {
+		m.commitKey(ctx)
+	} else {
+		key, err := m.self.StorageKey()
+		if err != nil {
+			return err
+		}
+		if err := m.db.Unlock(ctx, key); err != nil {
+			return err
+		}
+	}
+	return m.db.RebindOwnMessages(ctx, u.PublicID)
+}
+
+// commitKey drops the store's old key once identity.key was replaced. If
+// that fails, the next unlock does it.
+func (m *Messenger) commitKey(ctx context.Context) {
+	if err := m.db.CommitKey(ctx); err != nil {
+		slog.Warn("commit store key", "error", err)
+	}
+}
+
+// discardKey drops the key staged for an identity.key that was not
+// written. If that fails, the next unlock does it.
+func (m *Messenger) discardKey(ctx context.Context) {
+	if err := m.db.DiscardKey(ctx); err != nil {
+		slog.Warn("discard store key", "error", err)
+	}
+}
+
+// replaceIdentity runs create, which installs a new identity, and adopts
+// it. Replacing an existing identity requires the store to be unlocked,
+// since the history is only readable with the old identity's key.
+func (m *Messenger) replaceIdentity(ctx context.Context, op string, overwrite bool, create func() (*domain.User, error)) (*domain.User, error) {
+	wasUnlocked := !m.db.Locked()
+	if overwrite && m.self.HasIdentity() && !wasUnlocked {
+		return nil, fmt.Errorf("%s: %w", op, domain.ErrLocked)
+	}
+	u, err := create()
+	if err != nil {
+		if wasUnlocked {
+			m.discardKey(ctx)
+		}
+		return nil, err
+	}
+	if err := m.adopt(ctx, u, wasUnlocked); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return u, nil
+}
+
+// --- Identity ---
+
+func (m *Messenger) HasIdentity(_ context.Context) (bool, error) {
+	return m.self.HasIdentity(), nil
+}
+
+func (m *Messenger) CreateIdentity(ctx context.Context, displayName, passphrase, avatarPath string) (*domain.User, error) {
+	return m.replaceIdentity(ctx, "create identity", false, func() (*domain.User, error) {
+		return m.self.Create(displayName, passphrase, avatarPath)
+	})
+}
+
+func (m *Messenger) GetProfile(_ context.Context) (*domain.User, error) {
+	return m.self.Profile()
+}
+
+func (m *Messenger) UpdateProfile(_ context.Context, displayName, avatarPath string) error {
+	return m.self.SetProfile(displayName, avatarPath)
+}
+
+func (m *Messenger) IsLocked(_ context.Context) (bool, error) {
+	return m.self.Locked(), nil
+}
+
+// UnlockIdentity unlocks the identity and the store together; if the store
+// does not open with the identity's key, the identity is locked again.
+func (m *Messenger) UnlockIdentity(ctx context.Context, passphrase string) error {
+	if err := m.self.Unlock(passphrase); err != nil {
+		return err
+	}
+	if err := m.unlockData(ctx); err != nil {
+		m.self.Lock()
+		return fmt.Errorf("unlock identity: %w", err)
+	}
+	return nil
+}
+
+func (m *Messenger) LockIdentity(_ context.Context) error {
+	if err := m.self.Lock(); err != nil {
+		return err
+	}
+	m.db.Lock()
+	return nil
+}
+
+func (m *Messenger) HasPassphrase(_ context.Context) (bool, error) {
+	return m.self.HasPassphrase(), nil
+}
+
+// ChangePassphrase re-encrypts the identity and re-keys the store. A
+// locked identity is unlocked with oldPassphrase for the change and locked
+// again afterwards, unless the passphrase was removed. If the store cannot
+// be re-keyed the identity keeps its old passphrase.
+func (m *Messenger) ChangePassphrase(ctx context.Context, oldPassphrase, newPassphrase string) error {
+	if m.self.Locked() {
+		if err := m.UnlockIdentity(ctx, oldPassphrase); err != nil {
+			return fmt.Errorf("change passphrase: %w", err)
+		}
+		defer func() {
+			if m.self.HasPassphrase() {
+				m.LockIdentity(ctx)
+			}
+		}()
+	}
+	if err := m.self.ChangePassphrase(oldPassphrase, newPassphrase); err != nil {
+		m.discardKey(ctx)
+		return err
+	}
+	m.commitKey(ctx)
+	return nil
+}
+
+func (m *Messenger) ImportIdentity(ctx context.Context, privateKey, passphrase string, overwrite bool) (*domain.User, error) {
+	return m.replaceIdentity(ctx, "import identity", overwrite, func() (*domain.User, error) {
+		return m.self.Import(privateKey, passphrase, overwrite)
+	})
+}
+
+func (m *Messenger) ExportPrivateKey(_ context.Context, passphrase string) (string, error) {
+	return m.self.ExportPrivateKey(passphrase)
+}
+
+func (m *Messenger) ImportRecoveryPhrase(ctx context.Context, phrase, passphrase string, overwrite bool) (*domain.User, error) {
+	return m.replaceIdentity(ctx, "import recovery phrase", overwrite, func() (*domain.User, error) {
+		return m.self.ImportMnemonic(phrase, passphrase, overwrite)
+	})
+}
+
+func (m *Messenger) ExportRecoveryPhrase(_ context.Context, passphrase string) (string, error) {
+	return m.self.ExportMnemonic(passphrase)
+}
+
+func (m *Messenger) RotateIdentityKey(ctx context.Context) (*domain.User, error) {
+	_, u, err := m.self.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.db.RebindOwnMessages(ctx, u.PublicID); err != nil {
+		return nil, fmt.Errorf("rotate identity key: %w", err)
+	}
+	// A transport sends contacts the certificate; see PendingRotations.
+	slog.Info("identity key rotated", "publicID", u.PublicID)
+	return u, nil
+}
+
+func (m *Messenger) CreateInvite(_ context.Context) (*domain.Invite, error) {
+	return m.self.CreateInvite()
+}
+
+// --- Contacts ---
+
+func (m *Messenger) GetContacts(ctx context.Context) ([]domain.Contact, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("get contacts: %w", err)
+	}
+	contacts, err := db.GetContacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range contacts {
+		contacts[i].IsOnline = m.online[contacts[i].PublicID]
+	}
+	return contacts, nil
+}
+
+func (m *Messenger) AddContact(ctx context.Context, peer domain.PeerID, displayName string) (*domain.Contact, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("add contact: %w", err)
+	}
+	return db.AddContact(ctx, peer, displayName)
+}
+
+func (m *Messenger) RemoveContact(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("remove contact: %w", err)
+	}
+	return db.RemoveContact(ctx, contactID)
+}
+
+func (m *Messenger) BlockContact(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("block contact: %w", err)
+	}
+	return db.BlockContact(ctx, contactID)
+}
+
+func (m *Messenger) UnblockContact(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("unblock contact: %w", err)
+	}
+	return db.UnblockContact(ctx, contactID)
+}
+
+func (m *Messenger) GetSafetyNumber(ctx context.Context, contactID string) (string, error) {
+	db, err := m.data()
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	return db.GetSafetyNumber(ctx, contactID)
+}
+
+func (m *Messenger) MarkContactVerified(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("mark contact verified: %w", err)
+	}
+	return db.MarkContactVerified(ctx, contactID)
+}
+
+func (m *Messenger) UnverifyContact(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("unverify contact: %w", err)
+	}
+	return db.UnverifyContact(ctx, contactID)
+}
+
+func (m *Messenger) SetContactAvatar(ctx context.Context, contactID, avatarPath string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("set contact avatar: %w", err)
+	}
+	return db.SetContactAvatar(ctx, contactID, avatarPath)
+}
+
+// --- Conversations ---
+
+func (m *Messenger) GetChatSummaries(ctx context.Context) ([]domain.ChatSummary, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
+	summaries, err := db.GetChatSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range summaries {
+		summaries[i].Contact.IsOnline = m.online[summaries[i].ContactID]
+	}
+	return summaries, nil
+}
+
+func (m *Messenger) SendMessage(ctx context.Context, contactID, content string) (*domain.Message, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	return db.SendMessage(ctx, contactID, content)
+}
+
+func (m *Messenger) GetMessages(ctx context.Context, contactID string, limit int, beforeID string) ([]domain.Message, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("get messages: %w", err)
+	}
+	return db.GetMessages(ctx, contactID, limit, beforeID)
+}
+
+func (m *Messenger) MarkAsRead(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("mark as read: %w", err)
+	}
+	return db.MarkAsRead(ctx, contactID)
+}
+
+func (m *Messenger) ClearHistory(ctx context.Context, contactID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("clear history: %w", err)
+	}
+	return db.ClearHistory(ctx, contactID)
+}
+
+func (m *Messenger) SearchMessages(ctx context.Context, query, contactID string, limit int, cursor string) (*domain.SearchResult, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	return db.SearchMessages(ctx, query, contactID, limit, cursor)
+}
+
+func (m *Messenger) ImportMessages(ctx context.Context, contactID string, msgs []domain.Message, dryRun bool) (int, error) {
+	db, err := m.data()
+	if err != nil {
+		return 0, fmt.Errorf("import messages: %w", err)
+	}
+	return db.ImportMessages(ctx, contactID, msgs, dryRun)
+}
+
+// RetryMessage queues a failed outgoing message again; see messenger.Outbox.
+func (m *Messenger) RetryMessage(ctx context.Context, messageID string) (*domain.Message, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("retry message: %w", err)
+	}
+	msg, err := db.RetryMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	m.messageStatusChanged(msg.ChatID, msg.Status, msg.ID)
+	return msg, nil
+}
+
+func (m *Messenger) SetChatRetention(ctx context.Context, contactID string, days int) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("set chat retention: %w", err)
+	}
+	if err := db.SetChatRetention(ctx, contactID, days); err != nil {
+		return err
+	}
+	m.wakeJanitor()
+	return nil
+}
+
+// --- Settings ---
+
+func (m *Messenger) GetSettings(ctx context.Context) (*domain.Settings, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
+	return db.GetSettings(ctx)
+}
+
+func (m *Messenger) UpdateSettings(ctx context.Context, settings domain.Settings) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("update settings: %w", err)
+	}
+	if err := db.UpdateSettings(ctx, settings); err != nil {
+		return err
+	}
+	m.wakeJanitor()
+	return nil
+}
+
+// --- Storage ---
+
+func (m *Messenger) GetStorageStats(ctx context.Context) (*domain.StorageStats, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	return db.StorageStats(ctx)
+}
+
+func (m *Messenger) CompactStorage(ctx context.Context) (*domain.CompactResult, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("compact store: %w", err)
+	}
+	return db.Compact(ctx)
+}
+
+// --- Delivery ---
+
+// GetContact returns one contact, e.g. to check a peer that connects.
+func (m *Messenger) GetContact(ctx context.Context, contactID string) (*domain.Contact, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("get contact: %w", err)
+	}
+	return db.GetContact(ctx, contactID)
+}
+
+// SetContactAddress sets the host:port contactID is dialed at, "" for none.
+func (m *Messenger) SetContactAddress(ctx context.Context, contactID, address string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("set contact address: %w", err)
+	}
+	return db.SetContactAddress(ctx, contactID, address)
+}
+
+// LearnContactKey fills in the key of a contact added by Public ID alone
+// once the peer has proven that it holds key.
+func (m *Messenger) LearnContactKey(ctx context.Context, contactID string, key ed25519.PublicKey) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("learn contact key: %w", err)
+	}
+	return db.LearnContactKey(ctx, contactID, key)
+}
+
+// ReplaceContactKey gives contactID a new key, moving it to the Public ID
+// of key, and reports the change through OnContactKeyChanged. The
+// contact's verification is cleared. It returns the contact's new ID.
+func (m *Messenger) ReplaceContactKey(ctx context.Context, contactID string, key ed25519.PublicKey) (string, error) {
+	db, err := m.data()
+	if err != nil {
+		return "", fmt.Errorf("replace contact key: %w", err)
+	}
+	c, wasVerified, err := db.ReplaceContactKey(ctx, contactID, key)
+	if err != nil {
+		return "", err
+	}
+	if c.PublicID != contactID {
+		m.contactKeyChanged(c, contactID, wasVerified)
+	}
+	return c.PublicID, nil
+}
+
+// ReceiveKeyRotation applies a rotation certificate from a contact like
+// ReplaceContactKey and returns the contact's new ID. A certificate that
+// was applied before changes nothing.
+func (m *Messenger) ReceiveKeyRotation(ctx context.Context, cert identity.RotationCertificate) (string, error) {
+	db, err := m.data()
+	if err != nil {
+		return "", fmt.Errorf("receive key rotation: %w", err)
+	}
+	c, wasVerified, err := db.RotateContactKey(ctx, cert)
+	if errors.Is(err, domain.ErrContactNotFound) || errors.Is(err, domain.ErrContactExists) {
+		if c, getErr := db.GetContact(ctx, keyID(cert.NewPublicKey)); getErr == nil {
+			return c.PublicID, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	m.contactKeyChanged(c, keyID(cert.OldPublicKey), wasVerified)
+	return c.PublicID, nil
+}
+
+// keyID returns the Public ID of the hex-encoded key, "" if it is not one.
+func keyID(hexKey string) string {
+	key, err := identity.ParsePublicKey(hexKey)
+	if err != nil {
+		return ""
+	}
+	return domain.PublicIDFromKey(key)
+}
+
+// contactKeyChanged moves what is kept in memory about previousID to c
+// and reports the change through OnContactKeyChanged.
+func (m *Messenger) contactKeyChanged(c *domain.Contact, previousID string, wasVerified bool) {
+	m.mu.Lock()
+	if m.online[previousID] {
+		delete(m.online, previousID)
+		m.online[c.PublicID] = true
+	}
+	cb := m.onContactKeyChanged
+	m.mu.Unlock()
+	if cb != nil {
+		cb(c.PublicID, previousID, c.PublicKey, wasVerified)
+	}
+}
+
+// PendingRotations returns the rotation certificates of the local
+// identity that contactID has not acknowledged yet, oldest first.
+func (m *Messenger) PendingRotations(ctx context.Context, contactID string) ([]identity.RotationCertificate, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("pending rotations: %w", err)
+	}
+	acked, err := db.RotationAcked(ctx, contactID)
+	if err != nil {
+		return nil, err
+	}
+	var pending []identity.RotationCertificate
+	for _, cert := range m.self.Rotations() {
+		if cert.IssuedAt > acked {
+			pending = append(pending, cert)
+		}
+	}
+	return pending, nil
+}
+
+// AckRotation records that contactID took in the rotation of the local
+// identity to the key with Public ID newID, and every one before it. An
+// ack for a rotation the identity never made is ignored.
+func (m *Messenger) AckRotation(ctx context.Context, contactID, newID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("ack rotation: %w", err)
+	}
+	for _, cert := range m.self.Rotations() {
+		if keyID(cert.NewPublicKey) == newID {
+			return db.AckRotation(ctx, contactID, cert.IssuedAt)
+		}
+	}
+	return nil
+}
+
+// PendingMessages returns the outgoing messages contactID has not
+// acknowledged yet, oldest first.
+func (m *Messenger) PendingMessages(ctx context.Context, contactID string) ([]domain.Message, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("pending messages: %w", err)
+	}
+	return db.PendingMessages(ctx, contactID)
+}
+
+// DueMessages returns the messages of contactID's chat in the outbox that
+// are due to be tried by now, oldest first.
+func (m *Messenger) DueMessages(ctx context.Context, contactID string, now time.Time) ([]domain.Message, error) {
+	db, err := m.data()
+	if err != nil {
+		return nil, fmt.Errorf("due messages: %w", err)
+	}
+	return db.DueMessages(ctx, contactID, now)
+}
+
+// ScheduleRetry records an attempt to send messageID at now; the message
+// is due again after base, doubling with every attempt up to max.
+func (m *Messenger) ScheduleRetry(ctx context.Context, messageID string, now time.Time, base, max time.Duration) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("schedule retry: %w", err)
+	}
+	return db.ScheduleRetry(ctx, messageID, now, base, max)
+}
+
+// ReceiveMessage stores a message from the contact msg.ChatID and reports
+// it through OnNewMessage, unless it was received before.
+func (m *Messenger) ReceiveMessage(ctx context.Context, msg domain.Message) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("receive message: %w", err)
+	}
+	isNew, err := db.ReceiveMessage(ctx, msg)
+	if err != nil || !isNew {
+		return err
+	}
+	m.mu.RLock()
+	cb := m.onNewMessage
+	m.mu.RUnlock()
+	if cb != nil {
+		cb(msg)
+	}
+	return nil
+}
+
+// UpdateMessageStatus advances an outgoing message to status and reports
+// the change through OnMessageStatusChanged; a status never goes back.
+func (m *Messenger) UpdateMessageStatus(ctx context.Context, contactID, messageID string, status domain.MessageStatus) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("update message status: %w", err)
+	}
+	changed, err := db.UpdateMessageStatus(ctx, contactID, messageID, status)
+	if err != nil || !changed {
+		return err
+	}
+	m.messageStatusChanged(contactID, status, messageID)
+	return nil
+}
+
+// ReceiveReadReceipt marks the outgoing messages of contactID's chat up to
+// messageID as read, as contactID reported.
+func (m *Messenger) ReceiveReadReceipt(ctx context.Context, contactID, messageID string) error {
+	db, err := m.data()
+	if err != nil {
+		return fmt.Errorf("receive read receipt: %w", err)
+	}
+	ids, err := db.MarkReadUpTo(ctx, contactID, messageID)
+	if err != nil {
+		return err
+	}
+	m.messageStatusChanged(contactID, domain.StatusRead, ids...)
+	return nil
+}
+
+func (m *Messenger) messageStatusChanged(chatID string, status domain.MessageStatus, messageIDs ...string) {
+	m.mu.RLock()
+	cb := m.onMessageStatusChanged
+	m.mu.RUnlock()
+	if cb == nil {
+		return
+	}
+	for _, id := range messageIDs {
+		cb(id, chatID, status)
+	}
+}
+
+// ReceiveTyping reports through OnTypingChanged that contactID started or
+// stopped typing.
+func (m *Messenger) ReceiveTyping(contactID string, isTyping bool) {
+	m.mu.RLock()
+	cb := m.onTypingChanged
+	m.mu.RUnlock()
+	if cb != nil {
+		cb(contactID, isTyping)
+	}
+}
+
+// SetContactOnline records whether contactID is reachable. A change is
+// reported through OnContactStatusChanged and, if the store is unlocked,
+// saved as the contact's last seen time.
+func (m *Messenger) SetContactOnline(ctx context.Context, contactID string, online bool) {
+	m.mu.Lock()
+	if m.online[contactID] == online {
+		m.mu.Unlock()
+		return
+	}
+	if online {
+		m.online[contactID] = true
+	} else {
+		delete(m.online, contactID)
+	}
+	cb := m.onContactStatusChanged
+	m.mu.Unlock()
+
+	lastSeen := time.Now().UnixMilli()
+	if db, err := m.data(); err == nil {
+		if err := db.SetContactLastSeen(ctx, contactID, lastSeen); err != nil {
+			slog.Warn("save last seen", "contact", contactID, "error", err)
+		}
+	}
+	if cb != nil {
+		cb(contactID, online, lastSeen)
+	}
+}
+
+// SetConnectionState records the transport's state and reports a change
+// through OnConnectionStateChanged.
+func (m *Messenger) SetConnectionState(state domain.ConnectionState) {
+	m.mu.Lock()
+	if m.connState == state {
+		m.mu.Unlock()
+		return
+	}
+	m.connState = state
+	cb := m.onConnectionChanged
+	m.mu.Unlock()
+	if cb != nil {
+		cb(string(state))
+	}
+}
+
+// ConnectionState returns the state last set by SetConnectionState, or ""
+// without a transport. Late-joining frontends use it to catch up.
+func (m *Messenger) ConnectionState() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return string(m.connState)
+}
+
+// --- Callbacks ---
+
+func (m *Messenger) OnNewMessage(fn func(msg domain.Message)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onNewMessage = fn
+}
+
+func (m *Messenger) OnContactStatusChanged(fn messenger.ContactStatusHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onContactStatusChanged = fn
+}
+
+func (m *Messenger) OnMessageStatusChanged(fn messenger.MessageStatusHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onMessageStatusChanged = fn
+}
+
+func (m *Messenger) OnTypingChanged(fn messenger.TypingHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onTypingChanged = fn
+}
+
+func (m *Messenger) OnConnectionStateChanged(fn messenger.ConnectionHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnectionChanged = fn
+}
+
+func (m *Messenger) OnContactKeyChanged(fn messenger.ContactKeyHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onContactKeyChanged = fn
+}
+
+func (m *Messenger) OnMessagesExpired(fn messenger.ExpiryHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onMessagesExpired = fn
+}
+
+// --- Background work ---
+
+// StartStatusSimulation is a no-op: without a transport there is no
+// contact presence to follow. It exists to satisfy messenger.StatusSimulator.
+func (m *Messenger) StartStatusSimulation(_ context.Context) {}
+
+// StartJanitor starts deleting expired messages and failing outgoing ones
+// that outlived the outbox TTL; see messenger.Janitor. Neither needs
+// keys, so it goes on while the identity is locked.
+func (m *Messenger) StartJanitor(ctx context.Context) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			m.expire(ctx)
+			m.failExpired(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-m.sweep:
+			}
+		}
+	}()
+}
+
+// expire runs one janitor pass and reports what it deleted.
+func (m *Messenger) expire(ctx context.Context) {
+	expired, err := m.db.ExpireMessages(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("expire messages", "error", err)
+		}
+		return
+	}
+	m.mu.RLock()
+	cb := m.onMessagesExpired
+	m.mu.RUnlock()
+	for _, e := range expired {
+		slog.Debug("messages expired", "chat", e.ChatID, "count", e.Count)
+		if cb != nil {
+			cb(e)
+		}
+	}
+}
+
+// failExpired fails the outgoing messages queued longer than the outbox
+// TTL before now and reports them through OnMessageStatusChanged.
+func (m *Messenger) failExpired(ctx context.Context, now time.Time) {
+	failed, err := m.db.FailExpired(ctx, now)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("fail expired messages", "error", err)
+		}
+		return
+	}
+	for chatID, ids := range failed {
+		slog.Info("messages failed", "chat", chatID, "count", len(ids))
+		m.messageStatusChanged(chatID, domain.StatusFailed, ids...)
+	}
+}
+
+// wakeJanitor makes a running janitor apply changed retention settings
+// now rather than at its next tick.
+func (m *Messenger) wakeJanitor() {
+	select {
+	case m.sweep <- struct{}{}:
+	default:
+	}
+}
+
+// Wait blocks until all background goroutines have stopped.
+func (m *Messenger) Wait() {
+	m.wg.Wait()
+}
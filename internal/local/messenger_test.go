@@ -0,0 +1,423 @@
+package local
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/messenger"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+func mustOpen(t *testing.T, dir string) *Messenger {
+	t.Helper()
+	self, err := identity.OpenManager(filepath.Join(dir, "identity.key"))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	m, err := Open(dir, self)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestDataRequiresUnlockedIdentity(t *testing.T) {
+	dir := t.TempDir()
+	m := mustOpen(t, dir)
+
+	if _, err := m.GetContacts(newCtx()); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Fatalf("GetContacts() without identity error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+	if _, err := m.CreateIdentity(newCtx(), "Me", "secret", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	if _, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice"); err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	m.Close()
+
+	// A passphrase-protected identity starts locked after a restart.
+	m = mustOpen(t, dir)
+	if _, err := m.GetContacts(newCtx()); !errors.Is(err, domain.ErrLocked) {
+		t.Fatalf("GetContacts() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := m.UnlockIdentity(newCtx(), "secret"); err != nil {
+		t.Fatalf("UnlockIdentity() error = %v", err)
+	}
+	contacts, err := m.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	if len(contacts) != 1 || contacts[0].DisplayName != "Alice" {
+		t.Errorf("GetContacts() after restart = %+v; want Alice", contacts)
+	}
+}
+
+func TestRotateKeepsOwnMessages(t *testing.T) {
+	m := mustOpen(t, t.TempDir())
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	c, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	if _, err := m.SendMessage(newCtx(), c.PublicID, "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	u, err := m.RotateIdentityKey(newCtx())
+	if err != nil {
+		t.Fatalf("RotateIdentityKey() error = %v", err)
+	}
+	msgs, err := m.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].SenderID != u.PublicID {
+		t.Errorf("GetMessages() = %+v; want one message from %q", msgs, u.PublicID)
+	}
+}
+
+func TestChangePassphraseRekeysStore(t *testing.T) {
+	dir := t.TempDir()
+	m := mustOpen(t, dir)
+	if _, err := m.CreateIdentity(newCtx(), "Me", "secret", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	c, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	if _, err := m.SendMessage(newCtx(), c.PublicID, "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	m.Close()
+
+	// Changing the passphrase of a locked identity leaves it locked.
+	m = mustOpen(t, dir)
+	if err := m.ChangePassphrase(newCtx(), "wrong", "other"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("ChangePassphrase(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	if err := m.ChangePassphrase(newCtx(), "secret", "other"); err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+	if locked, _ := m.IsLocked(newCtx()); !locked {
+		t.Error("IsLocked() after ChangePassphrase = false; want true")
+	}
+	m.Close()
+
+	m = mustOpen(t, dir)
+	if err := m.UnlockIdentity(newCtx(), "secret"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("UnlockIdentity(old) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	if err := m.UnlockIdentity(newCtx(), "other"); err != nil {
+		t.Fatalf("UnlockIdentity(new) error = %v", err)
+	}
+	msgs, err := m.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil || len(msgs) != 1 || msgs[0].Content != "hi" {
+		t.Fatalf("GetMessages() = %+v, %v; want the message", msgs, err)
+	}
+
+	// Removing the passphrase keeps the store readable after a restart.
+	if err := m.ChangePassphrase(newCtx(), "other", ""); err != nil {
+		t.Fatalf("ChangePassphrase(remove) error = %v", err)
+	}
+	m.Close()
+	m = mustOpen(t, dir)
+	if _, err := m.GetMessages(newCtx(), c.PublicID, 0, ""); err != nil {
+		t.Errorf("GetMessages() without passphrase error = %v", err)
+	}
+}
+
+func TestChangePassphraseInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "identity.key")
+	m := mustOpen(t, dir)
+	if _, err := m.CreateIdentity(newCtx(), "Me", "secret", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	c, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	if _, err := m.SendMessage(newCtx(), c.PublicID, "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	readable := func(m *Messenger, passphrase string) {
+		t.Helper()
+		if err := m.UnlockIdentity(newCtx(), passphrase); err != nil {
+			t.Fatalf("UnlockIdentity(%q) error = %v", passphrase, err)
+		}
+		msgs, err := m.GetMessages(newCtx(), c.PublicID, 0, "")
+		if err != nil || len(msgs) != 1 {
+			t.Fatalf("GetMessages() = %+v, %v; want the message", msgs, err)
+		}
+	}
+
+	// identity.key cannot be replaced: the old passphrase still works.
+	hidden := filepath.Join(dir, "identity.key.bak")
+	if err := os.Rename(keyFile, hidden); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(keyFile, "in-the-way"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ChangePassphrase(newCtx(), "secret", "other"); err == nil {
+		t.Fatal("ChangePassphrase() with identity.key in the way succeeded")
+	}
+	m.Close()
+	if err := os.RemoveAll(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(hidden, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	m = mustOpen(t, dir)
+	readable(m, "secret")
+
+	// A crash after identity.key was replaced, before the store dropped its
+	// old key: the new passphrase works.
+	if err := m.self.ChangePassphrase("secret", "other"); err != nil {
+		t.Fatalf("identity ChangePassphrase() error = %v", err)
+	}
+	m.Close()
+	m = mustOpen(t, dir)
+	readable(m, "other")
+}
+
+func TestImportOverLockedIdentity(t *testing.T) {
+	dir := t.TempDir()
+	m := mustOpen(t, dir)
+	if _, err := m.CreateIdentity(newCtx(), "Me", "secret", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	m.Close()
+
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	m = mustOpen(t, dir)
+	// The history is only readable with the old identity, so it must be unlocked first.
+	_, err = m.ImportIdentity(newCtx(), hex.EncodeToString(keys.Private.Seed()), "", true)
+	if !errors.Is(err, domain.ErrLocked) {
+		t.Fatalf("ImportIdentity() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := m.UnlockIdentity(newCtx(), "secret"); err != nil {
+		t.Fatalf("UnlockIdentity() error = %v", err)
+	}
+	if _, err := m.ImportIdentity(newCtx(), hex.EncodeToString(keys.Private.Seed()), "", true); err != nil {
+		t.Fatalf("ImportIdentity() error = %v", err)
+	}
+	m.Close()
+
+	// The store now follows the imported identity, which has no passphrase.
+	m = mustOpen(t, dir)
+	if _, err := m.GetContacts(newCtx()); err != nil {
+		t.Errorf("GetContacts() after import error = %v", err)
+	}
+}
+
+func TestJanitorExpiresOnRetentionChange(t *testing.T) {
+	m := mustOpen(t, t.TempDir())
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	alice, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	old := time.Now().AddDate(0, 0, -60).UnixMilli()
+	history := []domain.Message{
+		{ID: "old", SenderID: alice.PublicID, Content: "old", Timestamp: old, Status: domain.StatusRead},
+		{ID: "new", SenderID: alice.PublicID, Content: "new", Timestamp: time.Now().UnixMilli(), Status: domain.StatusRead},
+	}
+	if _, err := m.ImportMessages(newCtx(), alice.PublicID, history, false); err != nil {
+		t.Fatalf("ImportMessages() error = %v", err)
+	}
+
+	events := make(chan domain.ExpiredMessages, 1)
+	m.OnMessagesExpired(func(e domain.ExpiredMessages) { events <- e })
+	ctx, cancel := context.WithCancel(newCtx())
+	m.StartJanitor(ctx)
+
+	// Nothing expires by default; the janitor wakes up on the change.
+	if err := m.SetChatRetention(newCtx(), alice.PublicID, 30); err != nil {
+		t.Fatalf("SetChatRetention() error = %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.ChatID != alice.PublicID || e.Count != 1 || e.Before <= old {
+			t.Errorf("event = %+v; want one message of Alice's", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry event after SetChatRetention")
+	}
+	msgs, err := m.GetMessages(newCtx(), alice.PublicID, 0, "")
+	if err != nil || len(msgs) != 1 || msgs[0].ID != "new" {
+		t.Errorf("GetMessages() = %+v, %v; want only the new message", msgs, err)
+	}
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		m.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return after cancel")
+	}
+}
+
+func TestDeliveryCallbacks(t *testing.T) {
+	m := mustOpen(t, t.TempDir())
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	alice, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+
+	var received []string
+	var online []bool
+	m.OnNewMessage(func(msg domain.Message) { received = append(received, msg.ID) })
+	m.OnContactStatusChanged(func(_ string, isOnline bool, _ int64) { online = append(online, isOnline) })
+
+	msg := domain.Message{ID: "m1", ChatID: alice.PublicID, SenderID: alice.PublicID, Content: "hi", Timestamp: 1, Status: domain.StatusDelivered}
+	for range 2 {
+		if err := m.ReceiveMessage(newCtx(), msg); err != nil {
+			t.Fatalf("ReceiveMessage() error = %v", err)
+		}
+	}
+	if len(received) != 1 {
+		t.Errorf("OnNewMessage calls = %v; want one for a message received twice", received)
+	}
+
+	m.SetContactOnline(newCtx(), alice.PublicID, true)
+	m.SetContactOnline(newCtx(), alice.PublicID, true)
+	contacts, err := m.GetContacts(newCtx())
+	if err != nil || !contacts[0].IsOnline {
+		t.Errorf("GetContacts() = %+v, %v; want Alice online", contacts, err)
+	}
+	m.SetContactOnline(newCtx(), alice.PublicID, false)
+	if len(online) != 2 || !online[0] || online[1] {
+		t.Errorf("OnContactStatusChanged calls = %v; want [true false]", online)
+	}
+}
+
+func TestReplaceContactKeyClearsVerification(t *testing.T) {
+	m := mustOpen(t, t.TempDir())
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	first, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	alice, err := m.AddContact(newCtx(), domain.PeerID{PublicID: first.PublicID(), PublicKey: first.PublicKeyHex()}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	if err := m.MarkContactVerified(newCtx(), alice.PublicID); err != nil {
+		t.Fatalf("MarkContactVerified() error = %v", err)
+	}
+	m.SetContactOnline(newCtx(), alice.PublicID, true)
+
+	var got []messenger.ContactKeyEvent
+	m.OnContactKeyChanged(func(contactID, previousID, publicKey string, wasVerified bool) {
+		got = append(got, messenger.ContactKeyEvent{
+			ContactID: contactID, PreviousID: previousID, PublicKey: publicKey, WasVerified: wasVerified,
+		})
+	})
+
+	next, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	id, err := m.ReplaceContactKey(newCtx(), alice.PublicID, next.Public)
+	if err != nil {
+		t.Fatalf("ReplaceContactKey() error = %v", err)
+	}
+	if id != next.PublicID() {
+		t.Errorf("ReplaceContactKey() = %q; want %q", id, next.PublicID())
+	}
+	want := messenger.ContactKeyEvent{
+		ContactID: next.PublicID(), PreviousID: alice.PublicID, PublicKey: next.PublicKeyHex(), WasVerified: true,
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("OnContactKeyChanged calls = %+v; want [%+v]", got, want)
+	}
+	contacts, err := m.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	if len(contacts) != 1 || contacts[0].PublicID != id || contacts[0].Verified || !contacts[0].IsOnline {
+		t.Errorf("GetContacts() = %+v; want %s online and unverified", contacts, id)
+	}
+
+	if _, err := m.ReplaceContactKey(newCtx(), id, next.Public); err != nil {
+		t.Fatalf("ReplaceContactKey(same key) error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("OnContactKeyChanged calls = %d after setting the same key; want 1", len(got))
+	}
+}
+
+func TestOutboxFailsAndRetries(t *testing.T) {
+	m := mustOpen(t, t.TempDir())
+	if _, err := m.CreateIdentity(newCtx(), "Me", "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	alice, err := m.AddContact(newCtx(), domain.PeerID{PublicID: "0123456789abcdef"}, "Alice")
+	if err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	settings := domain.DefaultSettings()
+	settings.OutboxTTLHours = 1
+	if err := m.UpdateSettings(newCtx(), settings); err != nil {
+		t.Fatalf("UpdateSettings() error = %v", err)
+	}
+	msg, err := m.SendMessage(newCtx(), alice.PublicID, "hello")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	var statuses []domain.MessageStatus
+	m.OnMessageStatusChanged(func(id, chatID string, status domain.MessageStatus) {
+		if id == msg.ID && chatID == alice.PublicID {
+			statuses = append(statuses, status)
+		}
+	})
+	if _, err := m.RetryMessage(newCtx(), msg.ID); !errors.Is(err, domain.ErrMessageNotFailed) {
+		t.Errorf("RetryMessage(sending) error = %v; want %v", err, domain.ErrMessageNotFailed)
+	}
+	m.failExpired(newCtx(), time.Now().Add(30*time.Minute))
+	m.failExpired(newCtx(), time.Now().Add(2*time.Hour))
+	retried, err := m.RetryMessage(newCtx(), msg.ID)
+	if err != nil {
+		t.Fatalf("RetryMessage() error = %v", err)
+	}
+	if retried.Status != domain.StatusSending {
+		t.Errorf("RetryMessage() status = %q; want %q", retried.Status, domain.StatusSending)
+	}
+	want := []domain.MessageStatus{domain.StatusFailed, domain.StatusSending}
+	if fmt.Sprint(statuses) != fmt.Sprint(want) {
+		t.Errorf("OnMessageStatusChanged calls = %v; want %v", statuses, want)
+	}
+}
@@ -6,28 +6,73 @@ import (
 	"quillet/internal/domain"
 )
 
-// IdentityProvider manages the local user's profile.
+// IdentityProvider manages the local user's identity and profile.
+// GetProfile fails with domain.ErrNoIdentity until CreateIdentity succeeds.
+// An identity protected by a passphrase starts locked; UnlockIdentity
+// loads its private key and LockIdentity wipes it from memory again.
+// ImportIdentity accepts a hex private key or the contents of an
+// identity.key file and replaces an existing identity only if overwrite is set;
+// ImportRecoveryPhrase does the same for a 24-word recovery phrase.
+// CreateInvite returns a quillet://add link signed by the identity key.
+// avatarPath values are avatar URLs produced by the avatar store, or "" for none.
+// RotateIdentityKey replaces the key pair and sends every contact a rotation
+// certificate signed by the old key, so they can follow to the new one.
 type IdentityProvider interface {
+	HasIdentity(ctx context.Context) (bool, error)
+	CreateIdentity(ctx context.Context, displayName, passphrase, avatarPath string) (*domain.User, error)
 	GetProfile(ctx context.Context) (*domain.User, error)
-	UpdateProfile(ctx context.Context, displayName string) error
+	UpdateProfile(ctx context.Context, displayName, avatarPath string) error
+	IsLocked(ctx context.Context) (bool, error)
+	UnlockIdentity(ctx context.Context, passphrase string) error
+	LockIdentity(ctx context.Context) error
+	HasPassphrase(ctx context.Context) (bool, error)
+	ChangePassphrase(ctx context.Context, oldPassphrase, newPassphrase string) error
+	ImportIdentity(ctx context.Context, privateKey, passphrase string, overwrite bool) (*domain.User, error)
+	ExportPrivateKey(ctx context.Context, passphrase string) (string, error)
+	ImportRecoveryPhrase(ctx context.Context, phrase, passphrase string, overwrite bool) (*domain.User, error)
+	ExportRecoveryPhrase(ctx context.Context, passphrase string) (string, error)
+	RotateIdentityKey(ctx context.Context) (*domain.User, error)
+	CreateInvite(ctx context.Context) (*domain.Invite, error)
 }
 
 // ContactManager handles the contact list.
+// AddContact fails with domain.ErrSelfContact for the local identity; when
+// peer carries only a Public ID the contact's key stays empty until the
+// peer is reached.
+// GetSafetyNumber returns the number users compare out of band before
+// marking a contact verified; it covers both the local and the contact's key.
+// SetContactAvatar sets a locally chosen avatar URL for a contact, "" clears it.
 type ContactManager interface {
 	GetContacts(ctx context.Context) ([]domain.Contact, error)
-	AddContact(ctx context.Context, publicID, displayName string) (*domain.Contact, error)
+	AddContact(ctx context.Context, peer domain.PeerID, displayName string) (*domain.Contact, error)
 	RemoveContact(ctx context.Context, contactID string) error
 	BlockContact(ctx context.Context, contactID string) error
 	UnblockContact(ctx context.Context, contactID string) error
+	GetSafetyNumber(ctx context.Context, contactID string) (string, error)
+	MarkContactVerified(ctx context.Context, contactID string) error
+	UnverifyContact(ctx context.Context, contactID string) error
+	SetContactAvatar(ctx context.Context, contactID, avatarPath string) error
 }
 
 // ChatService handles conversations and messages.
+// SearchMessages runs a query in domain.ParseSearchQuery syntax over every
+// chat, or only contactID's if it is not empty. Hits come newest first, up
+// to limit per page (0 means the default); cursor is "" for the first page
+// and the previous result's NextCursor after that.
+// ImportMessages adds history from elsewhere to contactID's chat, skipping
+// messages whose ID is already stored, so importing again adds nothing
+// twice; it returns how many were added, or with dryRun how many would be.
+// SetChatRetention sets how many days contactID's messages are kept; see
+// domain.Contact.RetentionDays.
 type ChatService interface {
 	GetChatSummaries(ctx context.Context) ([]domain.ChatSummary, error)
 	SendMessage(ctx context.Context, contactID, content string) (*domain.Message, error)
 	GetMessages(ctx context.Context, contactID string, limit int, beforeID string) ([]domain.Message, error)
 	MarkAsRead(ctx context.Context, contactID string) error
 	ClearHistory(ctx context.Context, contactID string) error
+	SearchMessages(ctx context.Context, query, contactID string, limit int, cursor string) (*domain.SearchResult, error)
+	ImportMessages(ctx context.Context, contactID string, msgs []domain.Message, dryRun bool) (int, error)
+	SetChatRetention(ctx context.Context, contactID string, days int) error
 }
 
 // SettingsManager handles user-configurable preferences.
@@ -48,6 +93,16 @@ type TypingHandler func(contactID string, isTyping bool)
 // ConnectionHandler is called when the connection state changes.
 type ConnectionHandler func(state string)
 
+// ContactKeyHandler is called when a contact's public key changes.
+// contactID is the contact's Public ID after the change and previousID
+// the one before; they differ when the Public ID follows the key.
+// wasVerified reports whether the contact was verified before the change;
+// verification is always cleared by then.
+type ContactKeyHandler func(contactID, previousID, publicKey string, wasVerified bool)
+
+// ExpiryHandler is called when retention deleted messages of a chat.
+type ExpiryHandler func(expired domain.ExpiredMessages)
+
 // EventSubscriber allows registering callbacks for real-time events.
 // Each On* method replaces the previously registered callback.
 // Only one handler per event type is supported.
@@ -57,6 +112,8 @@ type EventSubscriber interface {
 	OnMessageStatusChanged(fn MessageStatusHandler)
 	OnTypingChanged(fn TypingHandler)
 	OnConnectionStateChanged(fn ConnectionHandler)
+	OnContactKeyChanged(fn ContactKeyHandler)
+	OnMessagesExpired(fn ExpiryHandler)
 }
 
 // StatusSimulator runs background simulation of contact status changes.
@@ -67,8 +124,52 @@ type StatusSimulator interface {
 	Wait()
 }
 
+// Janitor enforces message retention in the background: it deletes
+// expired messages right away, then periodically and whenever a retention
+// setting changes. Like StatusSimulator, it stops when ctx is cancelled
+// and Wait blocks until it has.
+type Janitor interface {
+	StartJanitor(ctx context.Context)
+}
+
+// StorageManager is implemented by backends that keep their data on disk.
+// GetStorageStats reports the database size and each chat's share of it;
+// CompactStorage returns unused database space to the file system without
+// holding up other calls for its whole duration.
+type StorageManager interface {
+	GetStorageStats(ctx context.Context) (*domain.StorageStats, error)
+	CompactStorage(ctx context.Context) (*domain.CompactResult, error)
+}
+
+// Outbox is implemented by backends that keep undelivered messages to
+// retry them. A message that is not delivered within the outbox TTL (see
+// domain.Settings.OutboxTTLHours) fails; RetryMessage puts a failed
+// outgoing message back in StatusSending with a fresh TTL. It fails with
+// domain.ErrMessageNotFailed for a message that has not failed.
+type Outbox interface {
+	RetryMessage(ctx context.Context, messageID string) (*domain.Message, error)
+}
+
+// Network is implemented by backends that reach contacts over a network.
+// StartNetwork listens for contacts, dials those with an address and keeps
+// their presence, reported through OnContactStatusChanged, until ctx is
+// cancelled; like StatusSimulator, Wait blocks until it has stopped.
+// SetContactAddress sets the host:port contactID is dialed at, "" to stop
+// dialing it. SendTyping tells a connected contact whether the user is
+// typing in its chat and does nothing for one that is not connected.
+// GetNetworkStatus describes the listener and connected peers.
+type Network interface {
+	StartNetwork(ctx context.Context)
+	SetContactAddress(ctx context.Context, contactID, address string) error
+	SendTyping(ctx context.Context, contactID string, isTyping bool) error
+	GetNetworkStatus(ctx context.Context) (*domain.NetworkStatus, error)
+}
+
 // Messenger composes all messaging sub-interfaces into a single contract.
 // Implementations may be a stub (for development), a local p2p node, etc.
+// While the identity is locked, every data call (profile, contacts, chats,
+// settings) fails with domain.ErrLocked; only the identity lifecycle methods,
+// callback registration and Wait keep working.
 type Messenger interface {
 	IdentityProvider
 	ContactManager
@@ -76,6 +177,7 @@ type Messenger interface {
 	SettingsManager
 	EventSubscriber
 	StatusSimulator
+	Janitor
 }
 
 // ContactStatusEvent is the payload emitted for contact status changes.
@@ -98,6 +200,14 @@ type TypingEvent struct {
 	IsTyping  bool   `json:"isTyping"`
 }
 
+// ContactKeyEvent is the payload emitted when a contact's public key changes.
+type ContactKeyEvent struct {
+	ContactID   string `json:"contactID"`
+	PreviousID  string `json:"previousID"`
+	PublicKey   string `json:"publicKey"`
+	WasVerified bool   `json:"wasVerified"`
+}
+
 // ConnectionEvent is the payload emitted when connection state changes.
 type ConnectionEvent struct {
 	State string `json:"state"`
@@ -0,0 +1,335 @@
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Conn makes a reliable, ordered byte stream of UDP datagrams, enough
+// for a session to run over a punched path as it would over TCP. Each
+// datagram is
+//
+//	type (1) | seq (4) | payload
+//
+// Data packets are numbered; the receiver acks cumulatively with the next
+// seq it expects, and the sender resends what stays unacked, up to window
+// packets in flight, at once when the peer acks the same seq three times
+// (it got packets after a missing one). There is no congestion control:
+// chat traffic is small.
+const (
+	pktData  = 1 // payload is stream data number seq
+	pktAck   = 2 // everything before seq arrived
+	pktFin   = 3 // the sender closed the connection
+	pktProbe = 4 // opens and keeps open the NAT mapping; echoed with its payload
+)
+
+const (
+	headerSize  = 5
+	maxPayload  = 1200 // stays below common path MTUs
+	window      = 64
+	maxBuffered = 4 << 20 // unread data beyond this is dropped and resent later
+
+	initialRTO        = 200 * time.Millisecond
+	maxRTO            = 2 * time.Second
+	giveUpAfter       = 15 * time.Second // a packet unacked this long ends the connection
+	keepAliveInterval = 15 * time.Second // below the UDP timeout of most NATs
+	tick              = 50 * time.Millisecond
+)
+
+// errTimeout ends a connection whose peer stopped acking.
+var errTimeout = errors.New("nat: peer stopped responding")
+
+// Conn is a reliable stream over a PacketConn to one remote address. It
+// owns the PacketConn and closes it on Close.
+type Conn struct {
+	pc     net.PacketConn
+	remote net.Addr
+
+	mu       sync.Mutex
+	changed  chan struct{} // closed and replaced whenever the state changes
+	err      error         // why the connection ended, nil while it lasts
+	rdl, wdl time.Time
+
+	next     uint32       // seq of the next data packet to send
+	inflight []*outPacket // unacked, oldest first
+	dupAcks  int          // acks in a row that acked nothing new
+	lastSent time.Time
+
+	expect uint32            // seq of the next data packet to take in
+	early  map[uint32][]byte // data received ahead of expect
+	buf    []byte            // data in order, not yet read
+}
+
+type outPacket struct {
+	seq   uint32
+	pkt   []byte
+	first time.Time
+	sent  time.Time
+	rto   time.Duration
+}
+
+// NewConn starts a connection to remote over pc, whose NAT mapping must
+// be open already, e.g. by Punch.
+func NewConn(pc net.PacketConn, remote net.Addr) *Conn {
+	c := &Conn{
+		pc:       pc,
+		remote:   remote,
+		changed:  make(chan struct{}),
+		early:    map[uint32][]byte{},
+		lastSent: time.Now(),
+	}
+	go c.readLoop()
+	go c.timerLoop()
+	return c
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if err := c.wait(c.rdl); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for written < len(p) {
+		for len(c.inflight) >= window && c.err == nil {
+			if err := c.wait(c.wdl); err != nil {
+				return written, err
+			}
+		}
+		if c.err != nil {
+			return written, c.err
+		}
+		chunk := p[written:min(len(p), written+maxPayload)]
+		now := time.Now()
+		op := &outPacket{seq: c.next, pkt: packet(pktData, c.next, chunk), first: now, sent: now, rto: initialRTO}
+		c.next++
+		c.inflight = append(c.inflight, op)
+		c.send(op.pkt)
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Close ends the connection, telling the peer so, and closes the
+// PacketConn. Data not yet acked is not resent.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.send(packet(pktFin, c.next, nil))
+	}
+	c.mu.Unlock()
+	c.end(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdl, c.wdl = t, t
+	c.notify()
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rdl = t
+	c.notify()
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wdl = t
+	c.notify()
+	return nil
+}
+
+// end ends the connection with err, unless it ended already.
+func (c *Conn) end(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		c.notify()
+	}
+	c.mu.Unlock()
+	c.pc.Close()
+}
+
+// wait releases c.mu until the state changes or deadline passes.
+// Must be called with c.mu held.
+func (c *Conn) wait(deadline time.Time) error {
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		expired = t.C
+	}
+	changed := c.changed
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// notify wakes the goroutines waiting for a change.
+// Must be called with c.mu held.
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// send sends pkt to the peer. Lost packets are resent, so errors are not
+// worth reporting. Must be called with c.mu held.
+func (c *Conn) send(pkt []byte) {
+	c.pc.WriteTo(pkt, c.remote)
+	c.lastSent = time.Now()
+}
+
+// readLoop takes in the peer's packets until the PacketConn is closed.
+func (c *Conn) readLoop() {
+	b := make([]byte, headerSize+maxPayload+64)
+	for {
+		n, from, err := c.pc.ReadFrom(b)
+		if err != nil {
+			c.end(err)
+			return
+		}
+		if n < headerSize || !sameAddr(from, c.remote) {
+			continue
+		}
+		c.receive(b[0], binary.BigEndian.Uint32(b[1:headerSize]), b[headerSize:n])
+	}
+}
+
+func (c *Conn) receive(typ byte, seq uint32, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	switch typ {
+	case pktData:
+		switch {
+		case len(c.buf) >= maxBuffered:
+			return // not acked, so resent once Read caught up
+		case seq == c.expect:
+			c.buf = append(c.buf, payload...)
+			c.expect++
+			for d, ok := c.early[c.expect]; ok; d, ok = c.early[c.expect] {
+				delete(c.early, c.expect)
+				c.buf = append(c.buf, d...)
+				c.expect++
+			}
+			c.notify()
+		case before(c.expect, seq) && seq-c.expect < 2*window:
+			c.early[seq] = append([]byte(nil), payload...)
+		}
+		c.send(packet(pktAck, c.expect, nil))
+	case pktAck:
+		i := 0
+		for i < len(c.inflight) && before(c.inflight[i].seq, seq) {
+			i++
+		}
+		if i > 0 {
+			c.inflight = c.inflight[i:]
+			c.dupAcks = 0
+			c.notify()
+		} else if len(c.inflight) > 0 && c.inflight[0].seq == seq {
+			if c.dupAcks++; c.dupAcks == 3 {
+				op := c.inflight[0]
+				c.send(op.pkt)
+				op.sent = time.Now()
+			}
+		}
+	case pktFin:
+		c.err = io.EOF
+		c.notify()
+	case pktProbe:
+		c.send(packet(pktProbe, 0, payload))
+	}
+}
+
+// timerLoop resends what stays unacked and keeps the mapping open while
+// the connection is idle.
+func (c *Conn) timerLoop() {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		if c.err != nil {
+			c.mu.Unlock()
+			return
+		}
+		now := time.Now()
+		for _, op := range c.inflight {
+			if now.Sub(op.first) > giveUpAfter {
+				c.mu.Unlock()
+				c.end(errTimeout)
+				return
+			}
+			if now.Sub(op.sent) >= op.rto {
+				c.send(op.pkt)
+				op.sent = now
+				op.rto = min(2*op.rto, maxRTO)
+			}
+		}
+		if now.Sub(c.lastSent) >= keepAliveInterval {
+			c.send(packet(pktProbe, 0, nil))
+		}
+		c.mu.Unlock()
+	}
+}
+
+// packet encodes a datagram.
+func packet(typ byte, seq uint32, payload []byte) []byte {
+	b := make([]byte, headerSize, headerSize+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], seq)
+	return append(b, payload...)
+}
+
+// before reports whether seq a comes before b, allowing for wraparound.
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// sameAddr reports whether a and b are the same UDP address.
+func sameAddr(a, b net.Addr) bool {
+	ua, ok1 := a.(*net.UDPAddr)
+	ub, ok2 := b.(*net.UDPAddr)
+	if !ok1 || !ok2 {
+		return a.String() == b.String()
+	}
+	return ua.AddrPort().Addr().Unmap() == ub.AddrPort().Addr().Unmap() && ua.Port == ub.Port
+}
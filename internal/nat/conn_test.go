@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand/v2"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// lossy drops one in dropEvery datagrams it sends, at random.
+type lossy struct {
+	net.PacketConn
+	dropEvery int
+}
+
+func (l *lossy) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if mrand.IntN(l.dropEvery) == 0 {
+		return len(b), nil
+	}
+	return l.PacketConn.WriteTo(b, addr)
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// connPair returns two Conns connected over loopback, losing datagrams
+// both ways if dropEvery > 0.
+func connPair(t *testing.T, dropEvery int) (*Conn, *Conn) {
+	t.Helper()
+	pa, pb := listenUDP(t), listenUDP(t)
+	if dropEvery > 0 {
+		pa, pb = &lossy{PacketConn: pa, dropEvery: dropEvery}, &lossy{PacketConn: pb, dropEvery: dropEvery}
+	}
+	a, b := NewConn(pa, pb.LocalAddr()), NewConn(pb, pa.LocalAddr())
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestConn(t *testing.T) {
+	tests := []struct {
+		name      string
+		dropEvery int
+	}{
+		{"reliable", 0},
+		{"lossy", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := connPair(t, tt.dropEvery)
+			want := make([]byte, 300<<10)
+			rand.Read(want)
+
+			// Both ways at once.
+			errs := make(chan error, 2)
+			for _, c := range []*Conn{a, b} {
+				go func() {
+					_, err := c.Write(want)
+					errs <- err
+				}()
+			}
+			for _, c := range []*Conn{b, a} {
+				c.SetReadDeadline(time.Now().Add(10 * time.Second))
+				got := make([]byte, len(want))
+				if _, err := io.ReadFull(c, got); err != nil {
+					t.Fatalf("ReadFull() error = %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Error("received data differs from what was sent")
+				}
+			}
+			for range 2 {
+				if err := <-errs; err != nil {
+					t.Errorf("Write() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestConn_Close(t *testing.T) {
+	a, b := connPair(t, 0)
+	if _, err := a.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got := make([]byte, 3)
+	if _, err := io.ReadFull(b, got); err != nil || string(got) != "bye" {
+		t.Fatalf("ReadFull() = %q, %v; want bye", got, err)
+	}
+	a.Close()
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := b.Read(got); err != io.EOF {
+		t.Errorf("Read() after the peer closed error = %v; want EOF", err)
+	}
+	if _, err := a.Write([]byte("again")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Write() after Close error = %v; want %v", err, net.ErrClosed)
+	}
+}
+
+func TestConn_Deadline(t *testing.T) {
+	a, _ := connPair(t, 0)
+	a.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := a.Read(make([]byte, 1))
+	var ne net.Error
+	if !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Read() past the deadline error = %v; want a timeout", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package nat gets UDP through the NATs in front of two peers: it asks a
+// rendezvous server (the relay) which public address a NAT mapped a socket
+// to, tells from that what kind of NAT it is, punches holes by having both
+// peers send to each other's mapped address at once, and runs a reliable
+// stream over the resulting path (see conn.go).
+//
+// Binding requests and their responses are single datagrams:
+//
+//	request:  magic (4) | 'B' | transaction (12)
+//	response: magic (4) | 'R' | transaction (12) | port (2) | IP (4 or 16)
+//
+// They are not authenticated: a forged response makes punching fail, and
+// the peers then use the relay instead.
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+const (
+	magic           = "QNB1"
+	kindRequest     = 'B'
+	kindResponse    = 'R'
+	transactionSize = 12
+	requestSize     = len(magic) + 1 + transactionSize
+)
+
+// Binding request timing.
+const (
+	bindingTimeout  = 500 * time.Millisecond
+	bindingAttempts = 3
+)
+
+// ErrNoBinding is returned when the rendezvous server does not answer.
+var ErrNoBinding = errors.New("nat: no binding response")
+
+// ServeBinding answers binding requests on pc until it is closed.
+func ServeBinding(pc net.PacketConn) error {
+	b := make([]byte, 64)
+	for {
+		n, from, err := pc.ReadFrom(b)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ua, ok := from.(*net.UDPAddr)
+		if n != requestSize || string(b[:len(magic)]) != magic || b[len(magic)] != kindRequest || !ok {
+			continue
+		}
+		resp := append([]byte(magic), kindResponse)
+		resp = append(resp, b[len(magic)+1:requestSize]...)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(ua.Port))
+		resp = append(resp, ua.AddrPort().Addr().Unmap().AsSlice()...)
+		pc.WriteTo(resp, from)
+	}
+}
+
+// Mapped asks server which address the NAT in between mapped pc to. pc
+// must not be read from meanwhile.
+func Mapped(pc net.PacketConn, server net.Addr) (netip.AddrPort, error) {
+	req := make([]byte, requestSize)
+	copy(req, magic)
+	req[len(magic)] = kindRequest
+	txn := req[len(magic)+1:]
+	rand.Read(txn)
+	defer pc.SetReadDeadline(time.Time{})
+
+	b := make([]byte, 64)
+	for range bindingAttempts {
+		if _, err := pc.WriteTo(req, server); err != nil {
+			return netip.AddrPort{}, fmt.Errorf("binding request: %w", err)
+		}
+		pc.SetReadDeadline(time.Now().Add(bindingTimeout))
+		for {
+			n, _, err := pc.ReadFrom(b)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return netip.AddrPort{}, fmt.Errorf("binding request: %w", err)
+			}
+			if addr, ok := parseResponse(b[:n], txn); ok {
+				return addr, nil
+			}
+		}
+	}
+	return netip.AddrPort{}, ErrNoBinding
+}
+
+func parseResponse(b, txn []byte) (netip.AddrPort, bool) {
+	head := len(magic) + 1 + transactionSize
+	if len(b) != head+2+4 && len(b) != head+2+16 ||
+		string(b[:len(magic)]) != magic || b[len(magic)] != kindResponse ||
+		!bytes.Equal(b[len(magic)+1:head], txn) {
+		return netip.AddrPort{}, false
+	}
+	ip, _ := netip.AddrFromSlice(b[head+2:])
+	return netip.AddrPortFrom(ip.Unmap(), binary.BigEndian.Uint16(b[head:])), true
+}
+
+// Detect tells the NAT type from the addresses pc is mapped to towards
+// two ports of the rendezvous server: a symmetric NAT maps them apart. It
+// also returns the address towards primary. Without a secondary, or if
+// it does not answer, a symmetric NAT passes for a cone.
+func Detect(pc net.PacketConn, primary, secondary net.Addr) (domain.NATType, netip.AddrPort) {
+	mapped, err := Mapped(pc, primary)
+	if err != nil {
+		return domain.NATBlocked, netip.AddrPort{}
+	}
+	if secondary != nil {
+		if other, err := Mapped(pc, secondary); err == nil && other != mapped {
+			return domain.NATSymmetric, mapped
+		}
+	}
+	if isLocal(mapped, pc.LocalAddr()) {
+		return domain.NATOpen, mapped
+	}
+	return domain.NATCone, mapped
+}
+
+// isLocal reports whether mapped is local itself: an address of this host
+// and the port it bound.
+func isLocal(mapped netip.AddrPort, local net.Addr) bool {
+	ua, ok := local.(*net.UDPAddr)
+	if !ok || ua.Port != int(mapped.Port()) {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipn.IP); ok && ip.Unmap() == mapped.Addr() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package nat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// serveBinding answers binding requests on loopback and returns where.
+func serveBinding(t *testing.T) net.Addr {
+	t.Helper()
+	pc := listenUDP(t)
+	go ServeBinding(pc)
+	return pc.LocalAddr()
+}
+
+// serveFixed answers binding requests with mapped, as if a NAT sat in
+// between, and returns where.
+func serveFixed(t *testing.T, mapped netip.AddrPort) net.Addr {
+	t.Helper()
+	pc := listenUDP(t)
+	go func() {
+		b := make([]byte, 64)
+		for {
+			n, from, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			if n != requestSize {
+				continue
+			}
+			resp := append([]byte(magic), kindResponse)
+			resp = append(resp, b[len(magic)+1:requestSize]...)
+			resp = binary.BigEndian.AppendUint16(resp, mapped.Port())
+			pc.WriteTo(append(resp, mapped.Addr().AsSlice()...), from)
+		}
+	}()
+	return pc.LocalAddr()
+}
+
+func TestDetect(t *testing.T) {
+	primary, secondary := serveBinding(t), serveBinding(t)
+	silent := listenUDP(t).LocalAddr()
+	public := netip.MustParseAddrPort("192.0.2.7:40000")
+	natted := serveFixed(t, public)
+	otherPort := serveFixed(t, netip.AddrPortFrom(public.Addr(), 40001))
+
+	tests := []struct {
+		name               string
+		primary, secondary net.Addr
+		want               domain.NATType
+		wantMapped         netip.AddrPort // zero for the socket's own address
+	}{
+		{"open", primary, secondary, domain.NATOpen, netip.AddrPort{}},
+		{"no secondary", primary, nil, domain.NATOpen, netip.AddrPort{}},
+		{"cone", natted, natted, domain.NATCone, public},
+		{"symmetric", natted, otherPort, domain.NATSymmetric, public},
+		{"secondary silent", natted, silent, domain.NATCone, public},
+		{"blocked", silent, secondary, domain.NATBlocked, netip.AddrPort{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := listenUDP(t)
+			got, mapped := Detect(pc, tt.primary, tt.secondary)
+			if got != tt.want {
+				t.Errorf("Detect() = %q; want %q", got, tt.want)
+			}
+			want := tt.wantMapped
+			if !want.IsValid() && tt.want != domain.NATBlocked {
+				want = pc.LocalAddr().(*net.UDPAddr).AddrPort()
+			}
+			if mapped != want {
+				t.Errorf("Detect() mapped = %v; want %v", mapped, want)
+			}
+		})
+	}
+}
+
+func TestMapped_IgnoresStrayPackets(t *testing.T) {
+	server := listenUDP(t)
+	pc := listenUDP(t)
+	go func() {
+		b := make([]byte, 64)
+		n, from, err := server.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		// A response to another transaction, then the right one.
+		stray := append([]byte(nil), b[:n]...)
+		stray[len(magic)+1] ^= 0xff
+		for _, req := range [][]byte{stray, b[:n]} {
+			resp := append([]byte(magic), kindResponse)
+			resp = append(resp, req[len(magic)+1:requestSize]...)
+			resp = append(resp, 0x12, 0x34, 192, 0, 2, 7) // 192.0.2.7:4660
+			server.WriteTo(resp, from)
+		}
+	}()
+	got, err := Mapped(pc, server.LocalAddr())
+	if want := netip.MustParseAddrPort("192.0.2.7:4660"); err != nil || got != want {
+		t.Errorf("Mapped() = %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestPunch(t *testing.T) {
+	pa, pb := listenUDP(t), listenUDP(t)
+	nonce := []byte("shared secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// b starts late: a's first probes are lost, as a NAT would drop them.
+	type result struct {
+		from net.Addr
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		from, err := Punch(ctx, pa, pb.LocalAddr(), nonce)
+		done <- result{from, err}
+	}()
+	time.Sleep(300 * time.Millisecond)
+	from, err := Punch(ctx, pb, pa.LocalAddr(), nonce)
+	if err != nil || !sameAddr(from, pa.LocalAddr()) {
+		t.Errorf("Punch(b) = %v, %v; want %v", from, err, pa.LocalAddr())
+	}
+	r := <-done
+	if r.err != nil || !sameAddr(r.from, pb.LocalAddr()) {
+		t.Errorf("Punch(a) = %v, %v; want %v", r.from, r.err, pb.LocalAddr())
+	}
+
+	// A stranger's probes do not count.
+	stranger, pc := listenUDP(t), listenUDP(t)
+	go func() {
+		for ctx.Err() == nil {
+			stranger.WriteTo(packet(pktProbe, 0, []byte("guess")), pc.LocalAddr())
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	short, cancelShort := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelShort()
+	if from, err := Punch(short, pc, pb.LocalAddr(), nonce); err == nil {
+		t.Errorf("Punch() with a stranger's probes = %v; want an error", from)
+	}
+}
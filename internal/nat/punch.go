@@ -0,0 +1,67 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Hole punching timing.
+const (
+	punchInterval = 100 * time.Millisecond
+	PunchTimeout  = 5 * time.Second
+)
+
+// Punch opens a path through the NATs between pc and a peer that does the
+// same at once: both send probes carrying nonce, a secret they share, to
+// the address the other's NAT mapped its socket to. Each probe opens the
+// sender's NAT for the peer's; once one gets through, the path is open.
+// It returns the address the peer's packets came from, which a symmetric
+// NAT makes differ from remote, to pass to NewConn.
+func Punch(ctx context.Context, pc net.PacketConn, remote net.Addr, nonce []byte) (net.Addr, error) {
+	defer pc.SetReadDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() {
+		pc.SetReadDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	probe := packet(pktProbe, 0, nonce)
+	deadline := time.Now().Add(PunchTimeout)
+	next := time.Now()
+	b := make([]byte, headerSize+maxPayload+64)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			return nil, fmt.Errorf("punch %s: no answer", remote)
+		}
+		if !now.Before(next) {
+			pc.WriteTo(probe, remote)
+			next = now.Add(punchInterval)
+		}
+		pc.SetReadDeadline(next)
+		n, from, err := pc.ReadFrom(b)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("punch %s: %w", remote, err)
+		}
+		switch {
+		case n >= headerSize && b[0] == pktProbe && bytes.Equal(b[headerSize:n], nonce):
+			// Answer at once, so that the peer need not wait for its next probe.
+			pc.WriteTo(probe, from)
+			return from, nil
+		case n >= headerSize && b[0] == pktData && sameAddr(from, remote):
+			// The peer is through and started its stream; the packet is
+			// resent once our Conn runs.
+			return from, nil
+		}
+	}
+}
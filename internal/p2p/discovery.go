@@ -0,0 +1,269 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// Nodes on a LAN find each other by multicasting signed announcements of
+// their identity key, and with it their Public ID, and TCP listen port.
+// A contact's announcement tells where to dial it, taking the host from
+// the datagram's source address, and keeps the contact online for
+// presenceTTL. A node going offline announces port 0 to say so at once.
+//
+// The signature ties the port to the key, so a stranger cannot move a
+// contact to another port. It can replay a recent announcement from its
+// own host, but the handshake still fails there: the worst it can do is
+// delay finding the contact.
+
+// DefaultDiscoveryGroup is the UDP multicast group and port nodes announce
+// themselves on, in the organization-local scope.
+const DefaultDiscoveryGroup = "239.255.71.77:47322"
+
+// Discovery timing.
+const (
+	announceInterval = 10 * time.Second
+	presenceTTL      = 3 * announceInterval // a contact not heard from this long is offline
+)
+
+// announceContext separates announcement signatures from handshake ones.
+const announceContext = "quillet p2p announce v1\x00"
+
+// maxAnnouncementSize bounds the datagrams read from the group.
+const maxAnnouncementSize = 1024
+
+var errInvalidAnnouncement = errors.New("invalid announcement")
+
+// announcement is what a node multicasts, encoded as JSON.
+type announcement struct {
+	Key       []byte `json:"key"`       // Ed25519 identity key
+	Port      int    `json:"port"`      // TCP listen port, 0 when leaving
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	Signature []byte `json:"signature"`
+}
+
+func (a announcement) signed() []byte {
+	b := make([]byte, 0, len(announceContext)+10)
+	b = append(b, announceContext...)
+	b = binary.BigEndian.AppendUint16(b, uint16(a.Port))
+	return binary.BigEndian.AppendUint64(b, uint64(a.Timestamp))
+}
+
+// encodeAnnouncement signs an announcement of port as self.
+func encodeAnnouncement(self handshake.Signer, port int, now time.Time) ([]byte, error) {
+	a := announcement{Port: port, Timestamp: now.UnixMilli()}
+	key, sig, err := self.Sign(a.signed())
+	if err != nil {
+		return nil, err
+	}
+	a.Key, a.Signature = key, sig
+	return json.Marshal(a)
+}
+
+// decodeAnnouncement returns the announcement in b if it is well formed,
+// signed and no further than maxClockSkew from now.
+func decodeAnnouncement(b []byte, now time.Time) (announcement, error) {
+	var a announcement
+	if err := json.Unmarshal(b, &a); err != nil {
+		return announcement{}, fmt.Errorf("%w: %v", errInvalidAnnouncement, err)
+	}
+	switch {
+	case len(a.Key) != ed25519.PublicKeySize || a.Port < 0 || a.Port > 65535:
+		return announcement{}, fmt.Errorf("%w: malformed", errInvalidAnnouncement)
+	case !ed25519.Verify(a.Key, a.signed(), a.Signature):
+		return announcement{}, fmt.Errorf("%w: bad signature", errInvalidAnnouncement)
+	case time.UnixMilli(a.Timestamp).Sub(now).Abs() > maxClockSkew:
+		return announcement{}, fmt.Errorf("%w: stale", errInvalidAnnouncement)
+	}
+	return a, nil
+}
+
+// startDiscovery announces the node on the discovery group and listens
+// for contacts there until ctx is cancelled. Without multicast the node
+// carries on with the addresses it has.
+func (m *Messenger) startDiscovery(ctx context.Context) {
+	group, err := net.ResolveUDPAddr("udp4", m.discoveryGroup)
+	if err == nil && !group.IP.IsMulticast() {
+		err = fmt.Errorf("%s is not a multicast address", group.IP)
+	}
+	var recv, send *net.UDPConn
+	if err == nil {
+		recv, err = net.ListenMulticastUDP("udp4", nil, group)
+	}
+	if err == nil {
+		if send, err = net.ListenUDP("udp4", nil); err != nil {
+			recv.Close()
+		}
+	}
+	if err != nil {
+		slog.Warn("LAN discovery unavailable", "group", m.discoveryGroup, "error", err)
+		return
+	}
+	slog.Info("discovering peers", "group", group)
+
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.listenAnnouncements(ctx, recv)
+	}()
+	go func() {
+		defer m.wg.Done()
+		defer send.Close()
+		defer recv.Close()
+		m.announceLoop(ctx, send, group)
+	}()
+}
+
+// announceLoop announces the node periodically and whenever wakeAnnouncer
+// asks it to, and expires the presence of contacts gone quiet. On leaving
+// it says so.
+func (m *Messenger) announceLoop(ctx context.Context, conn *net.UDPConn, group *net.UDPAddr) {
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		m.announce(conn, group, m.listenPort())
+		select {
+		case <-ctx.Done():
+			m.announce(conn, group, 0)
+			return
+		case <-ticker.C:
+			m.expirePresence(ctx, time.Now())
+		case <-m.announceNow:
+		}
+	}
+}
+
+// listenPort returns the port the node listens on, or 0.
+func (m *Messenger) listenPort() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ln == nil {
+		return 0
+	}
+	return m.ln.Addr().(*net.TCPAddr).Port
+}
+
+// announce sends an announcement of port to group. Without an unlocked
+// identity there is nothing to announce.
+func (m *Messenger) announce(conn *net.UDPConn, group *net.UDPAddr, port int) {
+	b, err := encodeAnnouncement(m.self, port, time.Now())
+	if err != nil {
+		return
+	}
+	if _, err := conn.WriteToUDP(b, group); err != nil {
+		slog.Debug("announce", "group", group, "error", err)
+	}
+}
+
+// wakeAnnouncer makes the node announce itself now rather than at its
+// next tick.
+func (m *Messenger) wakeAnnouncer() {
+	select {
+	case m.announceNow <- struct{}{}:
+	default:
+	}
+}
+
+// listenAnnouncements acts on the announcements on conn until it is closed.
+func (m *Messenger) listenAnnouncements(ctx context.Context, conn *net.UDPConn) {
+	buf := make([]byte, maxAnnouncementSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Debug("read announcement", "error", err)
+			continue
+		}
+		if ctx.Err() != nil {
+			return // the node is leaving; no one is online any more
+		}
+		if err := m.discovered(ctx, buf[:n], from); err != nil {
+			slog.Debug("ignore announcement", "from", from, "error", err)
+		}
+	}
+}
+
+// discovered acts on an announcement from a contact: it learns where to
+// dial the contact and marks it online, or offline if it is leaving.
+// Announcements of strangers and blocked contacts are turned away.
+func (m *Messenger) discovered(ctx context.Context, b []byte, from *net.UDPAddr) error {
+	a, err := decodeAnnouncement(b, time.Now())
+	if err != nil {
+		return err
+	}
+	id := domain.PublicIDFromKey(a.Key)
+	if id == m.self.PublicID() {
+		return nil // our own, looped back
+	}
+	if _, err := m.authorize(ctx, a.Key); err != nil {
+		return err
+	}
+
+	if a.Port == 0 {
+		m.mu.Lock()
+		delete(m.seen, id)
+		m.mu.Unlock()
+		m.updatePresence(ctx, id)
+		return nil
+	}
+	c, err := m.GetContact(ctx, id)
+	if err != nil {
+		return err
+	}
+	if addr := net.JoinHostPort(from.IP.String(), strconv.Itoa(a.Port)); c.Address != addr {
+		slog.Info("found contact", "contact", id, "address", addr)
+		if err := m.SetContactAddress(ctx, id, addr); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	_, known := m.seen[id]
+	m.seen[id] = time.Now()
+	m.mu.Unlock()
+	if !known {
+		m.updatePresence(ctx, id)
+		m.wakeAnnouncer() // so that it finds us as quickly
+	}
+	return nil
+}
+
+// expirePresence forgets the contacts not heard from within presenceTTL
+// of now.
+func (m *Messenger) expirePresence(ctx context.Context, now time.Time) {
+	m.mu.Lock()
+	var gone []string
+	for id, at := range m.seen {
+		if now.Sub(at) >= presenceTTL {
+			delete(m.seen, id)
+			gone = append(gone, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, id := range gone {
+		m.updatePresence(ctx, id)
+	}
+}
+
+// updatePresence reports contactID online while it is connected or heard
+// from on the LAN.
+func (m *Messenger) updatePresence(ctx context.Context, contactID string) {
+	m.mu.Lock()
+	_, seen := m.seen[contactID]
+	online := seen || m.peers[contactID] != nil
+	m.mu.Unlock()
+	m.SetContactOnline(ctx, contactID, online)
+}
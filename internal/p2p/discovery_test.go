@@ -0,0 +1,178 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+func TestDecodeAnnouncement(t *testing.T) {
+	s := newSigner(t)
+	now := time.Now()
+	valid := func(port int, at time.Time) []byte {
+		b, err := encodeAnnouncement(s, port, at)
+		if err != nil {
+			t.Fatalf("encodeAnnouncement() error = %v", err)
+		}
+		return b
+	}
+	// retarget re-encodes a valid announcement with another port, keeping
+	// the signature.
+	retarget := func(port int) []byte {
+		var a announcement
+		json.Unmarshal(valid(47321, now), &a)
+		a.Port = port
+		b, _ := json.Marshal(a)
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		b       []byte
+		wantErr bool
+	}{
+		{"valid", valid(47321, now), false},
+		{"leaving", valid(0, now), false},
+		{"clock skew", valid(47321, now.Add(-time.Minute)), false},
+		{"stale", valid(47321, now.Add(-maxClockSkew-time.Second)), true},
+		{"future", valid(47321, now.Add(maxClockSkew+time.Second)), true},
+		{"other port", retarget(47322), true},
+		{"port out of range", retarget(1 << 16), true},
+		{"garbage", []byte("hello"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := decodeAnnouncement(tt.b, now)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidAnnouncement) {
+					t.Errorf("decodeAnnouncement() error = %v; want %v", err, errInvalidAnnouncement)
+				}
+				return
+			}
+			if err != nil || domain.PublicIDFromKey(a.Key) != s.PublicID() {
+				t.Errorf("decodeAnnouncement() = %+v, %v; want the signer's", a, err)
+			}
+		})
+	}
+}
+
+func TestDiscovered(t *testing.T) {
+	n := newNode(t, "Alice")
+	bob, blocked, stranger := newSigner(t), newSigner(t), newSigner(t)
+	for _, s := range []*identity.Manager{bob, blocked} {
+		if _, err := n.AddContact(newCtx(), domain.PeerID{PublicID: s.PublicID()}, "Peer"); err != nil {
+			t.Fatalf("AddContact() error = %v", err)
+		}
+	}
+	if err := n.BlockContact(newCtx(), blocked.PublicID()); err != nil {
+		t.Fatalf("BlockContact() error = %v", err)
+	}
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5353}
+	announce := func(s *identity.Manager, port int) error {
+		b, err := encodeAnnouncement(s, port, time.Now())
+		if err != nil {
+			t.Fatalf("encodeAnnouncement() error = %v", err)
+		}
+		return n.discovered(newCtx(), b, from)
+	}
+
+	if err := announce(stranger, 47321); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("stranger's announcement error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+	if err := announce(blocked, 47321); !errors.Is(err, domain.ErrContactBlocked) {
+		t.Errorf("blocked contact's announcement error = %v; want %v", err, domain.ErrContactBlocked)
+	}
+
+	if err := announce(bob, 47321); err != nil {
+		t.Fatalf("contact's announcement error = %v", err)
+	}
+	if !await(t, n.online, "Bob online") {
+		t.Error("Bob offline after announcing himself")
+	}
+	c, err := n.GetContact(newCtx(), bob.PublicID())
+	if err != nil || c.Address != "192.0.2.7:47321" || c.PublicKey == "" {
+		t.Errorf("contact = %+v, %v; want the announced address and key", c, err)
+	}
+
+	// Announcing again changes nothing; going quiet or leaving ends presence.
+	if err := announce(bob, 47321); err != nil {
+		t.Fatalf("repeated announcement error = %v", err)
+	}
+	n.expirePresence(newCtx(), time.Now().Add(presenceTTL))
+	if await(t, n.online, "Bob offline") {
+		t.Error("Bob still online after going quiet")
+	}
+	if err := announce(bob, 47321); err != nil {
+		t.Fatalf("announcement error = %v", err)
+	}
+	await(t, n.online, "Bob back online")
+	if err := announce(bob, 0); err != nil {
+		t.Fatalf("leaving announcement error = %v", err)
+	}
+	if await(t, n.online, "Bob leaving") {
+		t.Error("Bob still online after leaving")
+	}
+	select {
+	case online := <-n.online:
+		t.Errorf("unexpected presence change to %v", online)
+	default:
+	}
+}
+
+// discoveryGroup returns a multicast group on a port of its own, so that
+// tests do not hear other instances, or skips t without multicast.
+func discoveryGroup(t *testing.T) string {
+	t.Helper()
+	probe, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		t.Skipf("no UDP: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 71, 77), Port: port}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		t.Skipf("no multicast: %v", err)
+	}
+	conn.Close()
+	return group.String()
+}
+
+func TestDiscovery(t *testing.T) {
+	cfg := Config{ListenAddress: ":0", DiscoveryGroup: discoveryGroup(t)}
+	alice, bob := startNode(t, "Alice", cfg), startNode(t, "Bob", cfg)
+	aliceID, bobID := alice.peerID(t), bob.peerID(t)
+
+	// Each knows no more than the other's Public ID.
+	if _, err := alice.AddContact(newCtx(), domain.PeerID{PublicID: bobID.PublicID}, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), domain.PeerID{PublicID: aliceID.PublicID}, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+
+	if !await(t, alice.online, "Bob online") || !await(t, bob.online, "Alice online") {
+		t.Fatal("contacts did not come online")
+	}
+	if _, err := alice.SendMessage(newCtx(), bobID.PublicID, "found you"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := await(t, bob.messages, "message"); got.Content != "found you" {
+		t.Errorf("Bob received %q; want %q", got.Content, "found you")
+	}
+	c, err := alice.GetContact(newCtx(), bobID.PublicID)
+	if _, port, _ := net.SplitHostPort(c.Address); err != nil || port != fmt.Sprint(bob.listenPort()) {
+		t.Errorf("Alice's contact Bob = %+v, %v; want his address found", c, err)
+	}
+
+	bob.stop()
+	if await(t, alice.online, "Bob offline") {
+		t.Error("Bob still online after leaving")
+	}
+}
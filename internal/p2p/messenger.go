@@ -0,0 +1,734 @@
+// Package p2p connects local.Messenger to other Quillet instances over
+// TCP. Each end proves it holds its identity key in a handshake (see
+// package handshake); over the resulting encrypted session, contacts
+// exchange messages, delivery and read acks and typing signals.
+//
+// A node listens for its contacts and dials those whose address is
+// known, set by the user or found on the LAN (see discovery.go). Messages
+// to a contact that is not connected go through a relay, if there is one
+// (see relay.go), or stay in StatusSending and go out once it is, in
+// either direction. Until a message is acknowledged, it is tried again
+// with backoff (see outbox.go). Contacts that cannot be dialed, e.g. behind NATs, are
+// reached over UDP through holes punched with the relay's help (see
+// punch.go). A contact is online while it is connected or announces
+// itself on the LAN.
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+	"quillet/internal/identity"
+	"quillet/internal/local"
+	"quillet/internal/messenger"
+	"quillet/internal/relay"
+)
+
+// compile-time checks
+var (
+	_ messenger.Messenger      = (*Messenger)(nil)
+	_ messenger.Network        = (*Messenger)(nil)
+	_ messenger.StorageManager = (*Messenger)(nil)
+	_ messenger.Outbox         = (*Messenger)(nil)
+)
+
+// DefaultListenAddress is where a node listens unless told otherwise. If
+// its port is taken, e.g. by a second instance on the same host, the node
+// listens on a free port instead.
+const DefaultListenAddress = ":47321"
+
+// Connection timing.
+const (
+	dialTimeout    = 10 * time.Second
+	writeTimeout   = 10 * time.Second
+	pingInterval   = 30 * time.Second
+	idleTimeout    = 3 * pingInterval // a peer silent this long is gone
+	redialInterval = 15 * time.Second
+	minBackoff     = time.Second
+	maxBackoff     = 5 * time.Minute
+	stableAfter    = time.Minute // a connection that lasted this long resets the backoff
+)
+
+// Config configures the network side of a Messenger.
+type Config struct {
+	// ListenAddress is the TCP address to listen on; see DefaultListenAddress.
+	ListenAddress string
+	// DiscoveryGroup is the UDP multicast group:port to find contacts on
+	// the LAN with, or "" not to; see DefaultDiscoveryGroup.
+	DiscoveryGroup string
+	// Relay is the relay to reach contacts through while they are not
+	// connected, as "<public ID>@host:port", or "" for none.
+	Relay string
+}
+
+// Messenger is a local.Messenger that reaches its contacts over TCP.
+type Messenger struct {
+	*local.Messenger
+	self           *identity.Manager
+	listenAddr     string
+	discoveryGroup string
+	relayAddr      string
+
+	wg          sync.WaitGroup
+	dial        chan struct{} // wakes the dialer
+	announceNow chan struct{} // wakes the announcer
+	relayNow    chan struct{} // wakes the relay dialer
+
+	mu         sync.Mutex
+	ln         net.Listener  // nil while not listening
+	relay      *relay.Client // nil while not connected
+	natType    domain.NATType
+	mappedAddr string
+	peers      map[string]*peer
+	dialing    map[string]bool
+	retry      map[string]backoff     // before dialing again
+	punchRetry map[string]backoff     // before punching a hole again
+	punches    map[string]chan string // punch offers awaiting an answer by ID
+	seen       map[string]time.Time   // when contacts last announced themselves
+}
+
+// backoff delays redialing a contact after failed attempts.
+type backoff struct {
+	next  time.Time
+	delay time.Duration
+}
+
+// Open opens the profile in dir like local.Open. The node goes on the
+// network as cfg says once StartNetwork is called.
+func Open(dir string, self *identity.Manager, cfg Config) (*Messenger, error) {
+	if cfg.Relay != "" {
+		if _, _, err := relay.ParseAddress(cfg.Relay); err != nil {
+			return nil, fmt.Errorf("open: relay: %w", err)
+		}
+	}
+	lm, err := local.Open(dir, self)
+	if err != nil {
+		return nil, err
+	}
+	return &Messenger{
+		Messenger:      lm,
+		self:           self,
+		listenAddr:     cfg.ListenAddress,
+		discoveryGroup: cfg.DiscoveryGroup,
+		relayAddr:      cfg.Relay,
+		dial:           make(chan struct{}, 1),
+		announceNow:    make(chan struct{}, 1),
+		relayNow:       make(chan struct{}, 1),
+		peers:          map[string]*peer{},
+		dialing:        map[string]bool{},
+		retry:          map[string]backoff{},
+		punchRetry:     map[string]backoff{},
+		punches:        map[string]chan string{},
+		seen:           map[string]time.Time{},
+	}, nil
+}
+
+// --- Network ---
+
+// StartNetwork listens for contacts, dials those with an address, looks
+// for them on the LAN and connects to the relay; see messenger.Network. The connection state
+// is connected while the node listens.
+func (m *Messenger) StartNetwork(ctx context.Context) {
+	m.SetConnectionState(domain.ConnectionConnecting)
+	ln, err := listen(m.listenAddr)
+	if err != nil {
+		slog.Error("listen for peers", "address", m.listenAddr, "error", err)
+		m.SetConnectionState(domain.ConnectionDisconnected)
+		return
+	}
+	m.mu.Lock()
+	m.ln = ln
+	m.mu.Unlock()
+	slog.Info("listening for peers", "address", ln.Addr())
+	m.SetConnectionState(domain.ConnectionConnected)
+
+	m.wg.Add(3)
+	go func() {
+		defer m.wg.Done()
+		<-ctx.Done()
+		m.mu.Lock()
+		m.ln = nil
+		seen := m.seen
+		m.seen = map[string]time.Time{}
+		m.mu.Unlock()
+		ln.Close()
+		m.disconnectAll()
+		for id := range seen {
+			m.updatePresence(context.WithoutCancel(ctx), id)
+		}
+		m.SetConnectionState(domain.ConnectionDisconnected)
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.accept(ctx, ln)
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.dialLoop(ctx)
+	}()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.outboxLoop(ctx)
+	}()
+	if m.discoveryGroup != "" {
+		m.startDiscovery(ctx)
+	}
+	if m.relayAddr != "" {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.relayLoop(ctx)
+		}()
+	}
+}
+
+// listen listens on addr, or on a free port of the same host if addr's
+// port cannot be had.
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		return ln, nil
+	}
+	host, port, splitErr := net.SplitHostPort(addr)
+	if splitErr != nil || port == "0" {
+		return nil, err
+	}
+	slog.Warn("listen address unavailable, using a free port", "address", addr, "error", err)
+	return net.Listen("tcp", net.JoinHostPort(host, "0"))
+}
+
+// accept serves inbound connections until ln is closed.
+func (m *Messenger) accept(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("accept peer", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.serve(ctx, conn)
+		}()
+	}
+}
+
+// serve authenticates an inbound connection and runs it if it comes from
+// a contact, including one that rotated its key since; see awaitRotation.
+func (m *Messenger) serve(ctx context.Context, conn net.Conn) {
+	sess, err := handshake.Server(conn, m.self)
+	var id string
+	if err == nil {
+		id, err = m.authorize(ctx, sess.Remote())
+		if errors.Is(err, domain.ErrContactNotFound) {
+			id, err = m.awaitRotation(ctx, sess)
+		}
+	}
+	if err != nil {
+		slog.Info("reject peer", "remote", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return
+	}
+	m.run(ctx, newPeer(id, sess, true, domain.PathDirect))
+}
+
+// dialLoop dials contacts that are not connected, periodically and
+// whenever wakeDialer asks it to.
+func (m *Messenger) dialLoop(ctx context.Context) {
+	ticker := time.NewTicker(redialInterval)
+	defer ticker.Stop()
+	for {
+		m.dialContacts(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.dial:
+		}
+	}
+}
+
+// dialContacts starts dialing every contact that is not connected or
+// being dialed: at its address unless that is backing off, or else
+// through a hole punched in the NATs unless that is.
+func (m *Messenger) dialContacts(ctx context.Context) {
+	contacts, err := m.GetContacts(ctx)
+	if err != nil {
+		return // no identity yet, or locked
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range contacts {
+		if c.IsBlocked || m.peers[c.PublicID] != nil || m.dialing[c.PublicID] {
+			continue
+		}
+		switch {
+		case c.Address != "" && !now.Before(m.retry[c.PublicID].next):
+			m.startDial(ctx, c, "dial contact", m.retry, m.connect)
+		case m.canPunch(c, now):
+			m.startDial(ctx, c, "punch hole", m.punchRetry, m.punch)
+		}
+	}
+}
+
+// startDial connects to c with connect in the background and runs the
+// connection, backing off in retry if it fails.
+// Must be called with m.mu held.
+func (m *Messenger) startDial(ctx context.Context, c domain.Contact, what string, retry map[string]backoff,
+	connect func(context.Context, domain.Contact) (*peer, error)) {
+	m.dialing[c.PublicID] = true
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		// The dial lasts as long as the connection it made.
+		defer func() {
+			m.mu.Lock()
+			delete(m.dialing, c.PublicID)
+			m.mu.Unlock()
+		}()
+		p, err := connect(ctx, c)
+		if err != nil {
+			slog.Debug(what, "contact", c.PublicID, "address", c.Address, "error", err)
+			m.mu.Lock()
+			backOff(retry, c.PublicID)
+			m.mu.Unlock()
+			return
+		}
+		m.run(ctx, p)
+	}()
+}
+
+// connect dials c and authenticates it.
+func (m *Messenger) connect(ctx context.Context, c domain.Contact) (*peer, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := handshake.Client(conn, m.self, c.PublicID)
+	if err == nil {
+		_, err = m.authorize(ctx, sess.Remote())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newPeer(c.PublicID, sess, false, domain.PathDirect), nil
+}
+
+// backOff doubles the delay in retry before contactID is tried again.
+// Must be called with m.mu held.
+func backOff(retry map[string]backoff, contactID string) {
+	b := retry[contactID]
+	b.delay = min(max(2*b.delay, minBackoff), maxBackoff)
+	b.next = time.Now().Add(b.delay)
+	retry[contactID] = b
+}
+
+// wakeDialer makes the dialer look for contacts to dial now rather than
+// at its next tick.
+func (m *Messenger) wakeDialer() {
+	select {
+	case m.dial <- struct{}{}:
+	default:
+	}
+}
+
+// register makes p the connection to its contact. If both ends dialed at
+// once, each keeps the connection dialed by the end with the lower Public
+// ID, so that they keep the same one; otherwise the newer connection
+// replaces the older. It reports false if p lost and must be dropped.
+func (m *Messenger) register(ctx context.Context, p *peer) bool {
+	m.mu.Lock()
+	old := m.peers[p.id]
+	if ctx.Err() != nil || (old != nil && m.preferred(old) && !m.preferred(p)) {
+		m.mu.Unlock()
+		return false
+	}
+	m.peers[p.id] = p
+	m.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	} else {
+		m.updatePresence(ctx, p.id)
+	}
+	return true
+}
+
+// preferred reports whether p was dialed by the end with the lower Public ID.
+func (m *Messenger) preferred(p *peer) bool {
+	self := m.self.PublicID()
+	dialer := self
+	if p.inbound {
+		dialer = p.id
+	}
+	return dialer == min(self, p.id)
+}
+
+// unregister forgets p, unless another connection replaced it. A
+// connection that drops soon after it was made counts as a failed dial,
+// unless the node dropped it.
+func (m *Messenger) unregister(ctx context.Context, p *peer) {
+	m.mu.Lock()
+	current := m.peers[p.id] == p
+	if current {
+		delete(m.peers, p.id)
+	}
+	retry := m.retry
+	if p.path == domain.PathPunched {
+		retry = m.punchRetry
+	}
+	switch {
+	case p.dropped.Load():
+	case time.Since(p.since) < stableAfter:
+		backOff(retry, p.id)
+	default:
+		delete(retry, p.id)
+	}
+	m.mu.Unlock()
+
+	if current {
+		m.updatePresence(ctx, p.id)
+	}
+}
+
+// peer returns the connection to contactID, or nil.
+func (m *Messenger) peer(contactID string) *peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peers[contactID]
+}
+
+// disconnect closes the connections to the contacts match selects.
+func (m *Messenger) disconnect(match func(contactID string) bool) {
+	m.mu.Lock()
+	var drop []*peer
+	for id, p := range m.peers {
+		if match(id) {
+			drop = append(drop, p)
+		}
+	}
+	m.mu.Unlock()
+	for _, p := range drop {
+		p.drop()
+	}
+}
+
+// disconnectAll closes the connections to all contacts and the relay.
+func (m *Messenger) disconnectAll() {
+	m.disconnect(func(string) bool { return true })
+	m.mu.Lock()
+	c := m.relay
+	m.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
+
+// SetContactAddress checks and stores the address contactID is dialed at
+// and dials it right away.
+func (m *Messenger) SetContactAddress(ctx context.Context, contactID, address string) error {
+	address = strings.TrimSpace(address)
+	if address != "" {
+		if err := checkAddress(address); err != nil {
+			return fmt.Errorf("set contact address: %w", err)
+		}
+	}
+	if err := m.Messenger.SetContactAddress(ctx, contactID, address); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.retry, contactID)
+	m.mu.Unlock()
+	m.wakeDialer()
+	return nil
+}
+
+// checkAddress accepts host:port with a port from 1 to 65535.
+func checkAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidAddress, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || host == "" {
+		return fmt.Errorf("%w: %q", domain.ErrInvalidAddress, address)
+	}
+	return nil
+}
+
+func (m *Messenger) SendTyping(_ context.Context, contactID string, isTyping bool) error {
+	p := m.peer(contactID)
+	if p == nil {
+		return nil
+	}
+	if err := p.send(frame{Type: frameTyping, Typing: isTyping}); err != nil {
+		p.close()
+		return fmt.Errorf("send typing: %w", err)
+	}
+	return nil
+}
+
+// GetNetworkStatus also tells the path to each contact: its connection if
+// it is connected, or else the relay if messages can be sealed to it.
+func (m *Messenger) GetNetworkStatus(ctx context.Context) (*domain.NetworkStatus, error) {
+	contacts, _ := m.GetContacts(ctx) // none while locked
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &domain.NetworkStatus{
+		Peers:          []domain.PeerStatus{},
+		Relay:          m.relayAddr,
+		RelayConnected: m.relay != nil,
+		NAT:            m.natType,
+		MappedAddress:  m.mappedAddr,
+		Contacts:       []domain.ContactPath{},
+	}
+	if m.ln != nil {
+		st.ListenAddress = m.ln.Addr().String()
+	}
+	for _, p := range m.peers {
+		st.Peers = append(st.Peers, domain.PeerStatus{
+			ContactID:     p.id,
+			RemoteAddress: p.sess.Conn().RemoteAddr().String(),
+			Inbound:       p.inbound,
+			ConnectedAt:   p.since.UnixMilli(),
+			Path:          p.path,
+		})
+	}
+	slices.SortFunc(st.Peers, func(a, b domain.PeerStatus) int {
+		return strings.Compare(a.ContactID, b.ContactID)
+	})
+	for _, c := range contacts {
+		if c.IsBlocked {
+			continue
+		}
+		path := domain.PathNone
+		if p := m.peers[c.PublicID]; p != nil {
+			path = p.path
+		} else if m.relay != nil && c.PublicKey != "" {
+			path = domain.PathRelay
+		}
+		st.Contacts = append(st.Contacts, domain.ContactPath{ContactID: c.PublicID, Path: path})
+	}
+	return st, nil
+}
+
+// --- Identity ---
+// Sessions are authenticated with the identity key and feed the store, so
+// they end when the key is locked away or replaced.
+
+func (m *Messenger) CreateIdentity(ctx context.Context, displayName, passphrase, avatarPath string) (*domain.User, error) {
+	u, err := m.Messenger.CreateIdentity(ctx, displayName, passphrase, avatarPath)
+	if err == nil {
+		m.identityReady()
+	}
+	return u, err
+}
+
+func (m *Messenger) UnlockIdentity(ctx context.Context, passphrase string) error {
+	if err := m.Messenger.UnlockIdentity(ctx, passphrase); err != nil {
+		return err
+	}
+	m.identityReady()
+	return nil
+}
+
+func (m *Messenger) LockIdentity(ctx context.Context) error {
+	if err := m.Messenger.LockIdentity(ctx); err != nil {
+		return err
+	}
+	m.disconnectAll()
+	return nil
+}
+
+func (m *Messenger) ImportIdentity(ctx context.Context, privateKey, passphrase string, overwrite bool) (*domain.User, error) {
+	return m.reconnectAs(m.Messenger.ImportIdentity(ctx, privateKey, passphrase, overwrite))
+}
+
+func (m *Messenger) ImportRecoveryPhrase(ctx context.Context, phrase, passphrase string, overwrite bool) (*domain.User, error) {
+	return m.reconnectAs(m.Messenger.ImportRecoveryPhrase(ctx, phrase, passphrase, overwrite))
+}
+
+// RotateIdentityKey replaces the key pair and sends the certificate to
+// the connected contacts before dropping their sessions, and to the others
+// through the relay. Contacts get it again when they or the relay connect
+// until they acknowledge it; see catchUp and flushRelay.
+func (m *Messenger) RotateIdentityKey(ctx context.Context) (*domain.User, error) {
+	u, err := m.Messenger.RotateIdentityKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := m.GetContacts(ctx)
+	if err != nil {
+		return m.reconnectAs(u, nil)
+	}
+	for _, c := range contacts {
+		if c.IsBlocked {
+			continue
+		}
+		if p := m.peer(c.PublicID); p != nil {
+			m.sendRotations(ctx, c.PublicID, p.reply)
+		} else if c.PublicKey != "" && m.relayAddr != "" {
+			m.sendRotations(ctx, c.PublicID, func(f frame) error {
+				return m.putRelay(ctx, c.PublicID, f, true)
+			})
+		}
+	}
+	return m.reconnectAs(u, nil)
+}
+
+// reconnectAs drops the sessions of the previous identity once u replaced it.
+func (m *Messenger) reconnectAs(u *domain.User, err error) (*domain.User, error) {
+	if err != nil {
+		return nil, err
+	}
+	m.disconnectAll()
+	m.identityReady()
+	return u, nil
+}
+
+// identityReady puts a newly usable identity on the network at once.
+func (m *Messenger) identityReady() {
+	m.wakeDialer()
+	m.wakeAnnouncer()
+	m.wakeRelay()
+}
+
+// --- Contacts ---
+
+// AddContact adds the contact, dials it and announces the node, so that a
+// contact on the LAN that already added it finds it now.
+func (m *Messenger) AddContact(ctx context.Context, peer domain.PeerID, displayName string) (*domain.Contact, error) {
+	c, err := m.Messenger.AddContact(ctx, peer, displayName)
+	if err == nil {
+		m.wakeDialer()
+		m.wakeAnnouncer()
+	}
+	return c, err
+}
+
+func (m *Messenger) RemoveContact(ctx context.Context, contactID string) error {
+	if err := m.Messenger.RemoveContact(ctx, contactID); err != nil {
+		return err
+	}
+	m.drop(ctx, contactID)
+	return nil
+}
+
+func (m *Messenger) BlockContact(ctx context.Context, contactID string) error {
+	if err := m.Messenger.BlockContact(ctx, contactID); err != nil {
+		return err
+	}
+	m.drop(ctx, contactID)
+	return nil
+}
+
+// drop disconnects contactID and stops counting it online because of its
+// announcements.
+func (m *Messenger) drop(ctx context.Context, contactID string) {
+	m.mu.Lock()
+	delete(m.seen, contactID)
+	m.mu.Unlock()
+	m.disconnect(func(id string) bool { return id == contactID })
+	m.updatePresence(ctx, contactID)
+}
+
+func (m *Messenger) UnblockContact(ctx context.Context, contactID string) error {
+	if err := m.Messenger.UnblockContact(ctx, contactID); err != nil {
+		return err
+	}
+	m.wakeDialer()
+	return nil
+}
+
+// --- Conversations ---
+
+// SendMessage stores the message and sends it at once if the contact is
+// connected, or else leaves it with the relay; otherwise it goes out when
+// the contact or the relay connects.
+func (m *Messenger) SendMessage(ctx context.Context, contactID, content string) (*domain.Message, error) {
+	msg, err := m.Messenger.SendMessage(ctx, contactID, content)
+	if err != nil {
+		return nil, err
+	}
+	m.dispatch(ctx, msg)
+	return msg, nil
+}
+
+// RetryMessage queues a failed message again and sends it like SendMessage.
+func (m *Messenger) RetryMessage(ctx context.Context, messageID string) (*domain.Message, error) {
+	msg, err := m.Messenger.RetryMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	m.dispatch(ctx, msg)
+	return msg, nil
+}
+
+// dispatch sends msg to its contact if it is connected, or else leaves it
+// with the relay, updating msg.Status if either worked.
+func (m *Messenger) dispatch(ctx context.Context, msg *domain.Message) {
+	p := m.peer(msg.ChatID)
+	if p == nil {
+		m.wakeDialer()
+		if err := m.relayMessage(ctx, *msg); err == nil {
+			msg.Status = domain.StatusSent
+		} else if !errors.Is(err, errNoRelay) {
+			slog.Warn("relay message", "contact", msg.ChatID, "error", err)
+		}
+		return
+	}
+	if err := m.deliver(ctx, p, *msg); err != nil {
+		slog.Warn("send message", "contact", msg.ChatID, "error", err)
+		return
+	}
+	msg.Status = domain.StatusSent
+}
+
+// MarkAsRead marks the chat read and sends the contact a read receipt,
+// through the relay if it is not connected.
+func (m *Messenger) MarkAsRead(ctx context.Context, contactID string) error {
+	if err := m.Messenger.MarkAsRead(ctx, contactID); err != nil {
+		return err
+	}
+	if p := m.peer(contactID); p != nil {
+		m.sendReadReceipt(ctx, contactID, p.reply)
+	} else {
+		m.sendReadReceipt(ctx, contactID, func(f frame) error {
+			return m.postRelay(ctx, contactID, f)
+		})
+	}
+	return nil
+}
+
+// --- Background work ---
+
+// StartStatusSimulation is a no-op: presence follows connections and LAN
+// announcements, which StartNetwork takes care of.
+func (m *Messenger) StartStatusSimulation(_ context.Context) {}
+
+// Wait blocks until the network and the local background work have stopped.
+func (m *Messenger) Wait() {
+	m.wg.Wait()
+	m.Messenger.Wait()
+}
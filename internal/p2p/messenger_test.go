@@ -0,0 +1,342 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/messenger"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+func newSigner(t *testing.T) *identity.Manager {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return identity.NewManagerFor(keys, domain.User{DisplayName: "Peer"})
+}
+
+// events collects what a node reports through its callbacks.
+type events struct {
+	messages chan domain.Message
+	statuses chan domain.MessageStatus
+	typing   chan bool
+	online   chan bool
+	keys     chan messenger.ContactKeyEvent
+}
+
+// node is a running Messenger with an identity.
+type node struct {
+	*Messenger
+	events
+	stop func() // takes the node off the network
+}
+
+// newNode starts a node listening on loopback, without discovery.
+func newNode(t *testing.T, name string) *node {
+	t.Helper()
+	return startNode(t, name, Config{ListenAddress: "127.0.0.1:0"})
+}
+
+func startNode(t *testing.T, name string, cfg Config) *node {
+	t.Helper()
+	dir := t.TempDir()
+	self, err := identity.OpenManager(filepath.Join(dir, "identity.key"))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	m, err := Open(dir, self, cfg)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := m.CreateIdentity(newCtx(), name, "", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	n := &node{Messenger: m, events: events{
+		messages: make(chan domain.Message, 16),
+		statuses: make(chan domain.MessageStatus, 16),
+		typing:   make(chan bool, 16),
+		online:   make(chan bool, 16),
+		keys:     make(chan messenger.ContactKeyEvent, 16),
+	}}
+	m.OnNewMessage(func(msg domain.Message) { n.messages <- msg })
+	m.OnMessageStatusChanged(func(_, _ string, status domain.MessageStatus) { n.statuses <- status })
+	m.OnTypingChanged(func(_ string, isTyping bool) { n.typing <- isTyping })
+	m.OnContactStatusChanged(func(_ string, isOnline bool, _ int64) { n.online <- isOnline })
+	m.OnContactKeyChanged(func(contactID, previousID, publicKey string, wasVerified bool) {
+		n.keys <- messenger.ContactKeyEvent{
+			ContactID: contactID, PreviousID: previousID, PublicKey: publicKey, WasVerified: wasVerified,
+		}
+	})
+
+	n.start()
+	t.Cleanup(func() {
+		n.stop()
+		m.Close()
+	})
+	return n
+}
+
+// start puts the node on the network, again after stop.
+func (n *node) start() {
+	ctx, cancel := context.WithCancel(newCtx())
+	n.StartNetwork(ctx)
+	n.stop = func() {
+		cancel()
+		n.Wait()
+	}
+}
+
+func (n *node) peerID(t *testing.T) domain.PeerID {
+	t.Helper()
+	u, err := n.GetProfile(newCtx())
+	if err != nil {
+		t.Fatalf("GetProfile() error = %v", err)
+	}
+	return domain.PeerID{PublicID: u.PublicID, PublicKey: u.PublicKey}
+}
+
+func (n *node) listenAddress(t *testing.T) string {
+	t.Helper()
+	st, err := n.GetNetworkStatus(newCtx())
+	if err != nil || st.ListenAddress == "" {
+		t.Fatalf("GetNetworkStatus() = %+v, %v; want a listen address", st, err)
+	}
+	return st.ListenAddress
+}
+
+func contacts(t *testing.T, n *node) []domain.Contact {
+	t.Helper()
+	cs, err := n.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	return cs
+}
+
+// await returns the next value from ch or fails after a while.
+func await[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+// awaitStatus waits until a status event reports want.
+func awaitStatus(t *testing.T, n *node, want domain.MessageStatus) {
+	t.Helper()
+	for {
+		if got := await(t, n.statuses, "status "+string(want)); got == want {
+			return
+		}
+	}
+}
+
+func TestChatEndToEnd(t *testing.T) {
+	alice, bob := newNode(t, "Alice"), newNode(t, "Bob")
+	bobID, aliceID := bob.peerID(t), alice.peerID(t)
+
+	// Alice knows Bob's key and address; Bob only Alice's Public ID.
+	if _, err := alice.AddContact(newCtx(), bobID, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), domain.PeerID{PublicID: aliceID.PublicID}, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+
+	// Sent while Bob cannot be reached yet, it goes out on connecting.
+	queued, err := alice.SendMessage(newCtx(), bobID.PublicID, "are you there?")
+	if err != nil || queued.Status != domain.StatusSending {
+		t.Fatalf("SendMessage() offline = %+v, %v; want it sending", queued, err)
+	}
+	if err := alice.SetContactAddress(newCtx(), bobID.PublicID, bob.listenAddress(t)); err != nil {
+		t.Fatalf("SetContactAddress() error = %v", err)
+	}
+
+	got := await(t, bob.messages, "queued message")
+	if got.ID != queued.ID || got.Content != "are you there?" || got.SenderID != aliceID.PublicID {
+		t.Errorf("Bob received %+v; want %+v from Alice", got, *queued)
+	}
+	awaitStatus(t, alice, domain.StatusDelivered)
+	if !await(t, alice.online, "Bob online") || !await(t, bob.online, "Alice online") {
+		t.Fatal("contacts did not come online")
+	}
+	contact, err := bob.GetContact(newCtx(), aliceID.PublicID)
+	if err != nil || contact.PublicKey != aliceID.PublicKey || !contacts(t, bob)[0].IsOnline {
+		t.Errorf("Bob's contact Alice = %+v, %v; want her key learned and online", contact, err)
+	}
+
+	// Replies travel over the connection Alice made.
+	reply, err := bob.SendMessage(newCtx(), aliceID.PublicID, "yes!")
+	if err != nil || reply.Status != domain.StatusSent {
+		t.Fatalf("SendMessage() online = %+v, %v; want it sent", reply, err)
+	}
+	if got := await(t, alice.messages, "reply"); got.Content != "yes!" {
+		t.Errorf("Alice received %q; want %q", got.Content, "yes!")
+	}
+
+	if err := bob.SendTyping(newCtx(), aliceID.PublicID, true); err != nil {
+		t.Fatalf("SendTyping() error = %v", err)
+	}
+	if !await(t, alice.typing, "typing") {
+		t.Error("typing = false; want true")
+	}
+
+	if err := bob.MarkAsRead(newCtx(), aliceID.PublicID); err != nil {
+		t.Fatalf("MarkAsRead() error = %v", err)
+	}
+	awaitStatus(t, alice, domain.StatusRead)
+
+	st, err := alice.GetNetworkStatus(newCtx())
+	if err != nil || len(st.Peers) != 1 || st.Peers[0].ContactID != bobID.PublicID || st.Peers[0].Inbound {
+		t.Errorf("Alice's network status = %+v, %v; want an outbound connection to Bob", st, err)
+	}
+
+	// Blocking Bob drops the connection.
+	if err := alice.BlockContact(newCtx(), bobID.PublicID); err != nil {
+		t.Fatalf("BlockContact() error = %v", err)
+	}
+	if await(t, bob.online, "Alice offline") {
+		t.Error("Alice still online for Bob after blocking him")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	alice, bob := newNode(t, "Alice"), newNode(t, "Bob")
+	aliceID, bobID := alice.peerID(t), bob.peerID(t)
+	if _, err := alice.AddContact(newCtx(), bobID, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), aliceID, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+	if err := bob.MarkContactVerified(newCtx(), aliceID.PublicID); err != nil {
+		t.Fatalf("MarkContactVerified() error = %v", err)
+	}
+	if err := alice.SetContactAddress(newCtx(), bobID.PublicID, bob.listenAddress(t)); err != nil {
+		t.Fatalf("SetContactAddress() error = %v", err)
+	}
+	before, err := alice.SendMessage(newCtx(), bobID.PublicID, "before")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	await(t, bob.messages, "message before rotating")
+	awaitStatus(t, alice, domain.StatusDelivered)
+
+	// Bob is connected: the certificate goes over the old session.
+	u, err := alice.RotateIdentityKey(newCtx())
+	if err != nil {
+		t.Fatalf("RotateIdentityKey() error = %v", err)
+	}
+	want := messenger.ContactKeyEvent{
+		ContactID: u.PublicID, PreviousID: aliceID.PublicID, PublicKey: u.PublicKey, WasVerified: true,
+	}
+	if got := await(t, bob.keys, "key change"); got != want {
+		t.Errorf("Bob's key change = %+v; want %+v", got, want)
+	}
+
+	after, err := alice.SendMessage(newCtx(), bobID.PublicID, "after")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := await(t, bob.messages, "message after rotating"); got.ID != after.ID || got.SenderID != u.PublicID {
+		t.Errorf("Bob received %+v; want %q from %s", got, after.ID, u.PublicID)
+	}
+	contact, err := bob.GetContact(newCtx(), u.PublicID)
+	if err != nil || contact.PublicKey != u.PublicKey || contact.Verified || contact.DisplayName != "Alice" {
+		t.Errorf("Bob's contact Alice = %+v, %v; want her new key, unverified", contact, err)
+	}
+	msgs, err := bob.GetMessages(newCtx(), u.PublicID, 0, "")
+	if err != nil || len(msgs) != 2 || msgs[0].ID != before.ID || msgs[0].SenderID != u.PublicID {
+		t.Errorf("Bob's chat with Alice = %+v, %v; want both messages", msgs, err)
+	}
+
+	// Bob is offline: he takes the certificate in when Alice connects.
+	bob.stop()
+	awaitPath(t, alice, bobID.PublicID, domain.PathNone)
+	u, err = alice.RotateIdentityKey(newCtx())
+	if err != nil {
+		t.Fatalf("RotateIdentityKey() error = %v", err)
+	}
+	bob.start()
+	if err := alice.SetContactAddress(newCtx(), bobID.PublicID, bob.listenAddress(t)); err != nil {
+		t.Fatalf("SetContactAddress() error = %v", err)
+	}
+	if got := await(t, bob.keys, "second key change"); got.ContactID != u.PublicID || got.WasVerified {
+		t.Errorf("Bob's key change = %+v; want Alice at %s", got, u.PublicID)
+	}
+	if _, err := alice.SendMessage(newCtx(), bobID.PublicID, "again"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := await(t, bob.messages, "message after rotating again"); got.SenderID != u.PublicID {
+		t.Errorf("Bob received %+v; want it from %s", got, u.PublicID)
+	}
+	if _, err := bob.SendMessage(newCtx(), u.PublicID, "got it"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := await(t, alice.messages, "reply"); got.Content != "got it" || got.ChatID != bobID.PublicID {
+		t.Errorf("Alice received %+v; want Bob's reply", got)
+	}
+	if pending, err := alice.PendingRotations(newCtx(), bobID.PublicID); err != nil || len(pending) != 0 {
+		t.Errorf("PendingRotations() = %d, %v; want none once Bob acked", len(pending), err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	n := newNode(t, "Alice")
+	known, blocked, stranger := newSigner(t), newSigner(t), newSigner(t)
+	for _, s := range []*identity.Manager{known, blocked} {
+		u, _ := s.Profile()
+		if _, err := n.AddContact(newCtx(), domain.PeerID{PublicID: u.PublicID, PublicKey: u.PublicKey}, u.DisplayName); err != nil {
+			t.Fatalf("AddContact() error = %v", err)
+		}
+	}
+	if err := n.BlockContact(newCtx(), blocked.PublicID()); err != nil {
+		t.Fatalf("BlockContact() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		signer  *identity.Manager
+		wantErr error
+	}{
+		{"contact", known, nil},
+		{"blocked", blocked, domain.ErrContactBlocked},
+		{"stranger", stranger, domain.ErrContactNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := tt.signer.Profile()
+			key, err := identity.ParsePublicKey(u.PublicKey)
+			if err != nil {
+				t.Fatalf("ParsePublicKey() error = %v", err)
+			}
+			id, err := n.authorize(newCtx(), key)
+			if !errors.Is(err, tt.wantErr) || (err == nil && id != u.PublicID) {
+				t.Errorf("authorize() = %q, %v; want %q, %v", id, err, u.PublicID, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetContactAddress_Invalid(t *testing.T) {
+	n := newNode(t, "Alice")
+	for _, addr := range []string{"localhost", ":47321", "example.org:0", "example.org:http"} {
+		if err := n.SetContactAddress(newCtx(), "0123456789abcdef", addr); !errors.Is(err, domain.ErrInvalidAddress) {
+			t.Errorf("SetContactAddress(%q) error = %v; want %v", addr, err, domain.ErrInvalidAddress)
+		}
+	}
+}
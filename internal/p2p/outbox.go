@@ -0,0 +1,87 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// Outgoing messages stay in the store's outbox until the contact acks
+// them. Each attempt, whether to the contact or the relay, makes a message
+// due again after retryInterval, doubling up to maxBackoff; the outbox
+// loop tries what is due. A contact that connects gets everything that is
+// pending right away (see catchUp), and a connecting relay everything
+// still sending (see flushRelay). The local janitor fails what outlives
+// the outbox TTL; RetryMessage puts it back.
+
+// Outbox timing.
+const (
+	outboxInterval = 5 * time.Second  // how often the outbox loop looks for due messages
+	retryInterval  = 30 * time.Second // before the first retry of an unacked message
+)
+
+// outboxLoop tries the due messages of the outbox until ctx is cancelled.
+func (m *Messenger) outboxLoop(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.retryDue(ctx, time.Now())
+		}
+	}
+}
+
+// retryDue sends the messages due by now to their contacts if they are
+// connected, and otherwise leaves those the relay does not hold yet with
+// it. Messages to contacts that are reachable neither way wait.
+func (m *Messenger) retryDue(ctx context.Context, now time.Time) {
+	contacts, err := m.GetContacts(ctx)
+	if err != nil {
+		return // locked
+	}
+	for _, c := range contacts {
+		if c.IsBlocked {
+			continue
+		}
+		msgs, err := m.DueMessages(ctx, c.PublicID, now)
+		if err != nil {
+			slog.Warn("due messages", "contact", c.PublicID, "error", err)
+			continue
+		}
+		if p := m.peer(c.PublicID); p != nil {
+			for _, msg := range msgs {
+				if err := m.deliver(ctx, p, msg); errors.Is(err, errFrameTooLarge) {
+					m.attempted(ctx, msg.ID)
+				} else if err != nil {
+					break
+				}
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.Status != domain.StatusSending {
+				continue // the relay holds it
+			}
+			err := m.relayMessage(ctx, msg)
+			if errors.Is(err, errNoRelay) {
+				return
+			} else if err != nil {
+				m.attempted(ctx, msg.ID)
+				break
+			}
+		}
+	}
+}
+
+// attempted records an attempt to send messageID.
+func (m *Messenger) attempted(ctx context.Context, messageID string) {
+	if err := m.ScheduleRetry(ctx, messageID, time.Now(), retryInterval, maxBackoff); err != nil {
+		slog.Warn("schedule retry", "id", messageID, "error", err)
+	}
+}
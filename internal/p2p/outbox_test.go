@@ -0,0 +1,100 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// readMessage returns the ID of the next message frame on sess.
+func readMessage(t *testing.T, sess *handshake.Session) string {
+	t.Helper()
+	sess.Conn().SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		b, err := sess.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+		f, err := decodeFrame(b)
+		if err != nil {
+			t.Fatalf("decodeFrame() error = %v", err)
+		}
+		if f.Type == frameMessage {
+			return f.ID
+		}
+	}
+}
+
+// expectNoMessage fails if a message frame arrives on sess shortly.
+func expectNoMessage(t *testing.T, sess *handshake.Session, when string) {
+	t.Helper()
+	sess.Conn().SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		b, err := sess.ReadFrame()
+		if err != nil {
+			return
+		}
+		if f, _ := decodeFrame(b); f.Type == frameMessage {
+			t.Fatalf("got message %q %s", f.ID, when)
+		}
+	}
+}
+
+func TestOutbox_RetriesUntilAcked(t *testing.T) {
+	alice := newNode(t, "Alice")
+	bob := newSigner(t)
+	u, _ := bob.Profile()
+	if _, err := alice.AddContact(newCtx(), domain.PeerID{PublicID: u.PublicID, PublicKey: u.PublicKey}, "Bob"); err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	msg, err := alice.SendMessage(newCtx(), u.PublicID, "hello")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	// Bob takes the message when Alice connects, but the ack gets lost.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+	if err := alice.SetContactAddress(newCtx(), u.PublicID, ln.Addr().String()); err != nil {
+		t.Fatalf("SetContactAddress() error = %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+	sess, err := handshake.Server(conn, bob)
+	if err != nil {
+		t.Fatalf("handshake.Server() error = %v", err)
+	}
+	if got := readMessage(t, sess); got != msg.ID {
+		t.Fatalf("Bob got message %q; want %q", got, msg.ID)
+	}
+	awaitStatus(t, alice, domain.StatusSent)
+
+	// Not due yet, it is sent again once it is.
+	alice.retryDue(newCtx(), time.Now())
+	expectNoMessage(t, sess, "before it was due")
+	alice.retryDue(newCtx(), time.Now().Add(retryInterval))
+	if got := readMessage(t, sess); got != msg.ID {
+		t.Fatalf("Bob got message %q again; want %q", got, msg.ID)
+	}
+	b, err := encodeFrame(frame{Type: frameAck, ID: msg.ID})
+	if err != nil {
+		t.Fatalf("encodeFrame() error = %v", err)
+	}
+	if err := sess.WriteFrame(b, writeTimeout); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+	awaitStatus(t, alice, domain.StatusDelivered)
+
+	// Delivered, it is not sent again.
+	alice.retryDue(newCtx(), time.Now().Add(maxBackoff))
+	expectNoMessage(t, sess, "after acking it")
+}
@@ -0,0 +1,302 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// peer is an authenticated connection to a contact.
+type peer struct {
+	id      string // the contact's Public ID
+	sess    *handshake.Session
+	inbound bool
+	path    domain.Path // domain.PathDirect or domain.PathPunched
+	since   time.Time
+
+	done      chan struct{} // closed by close
+	closeOnce sync.Once
+	dropped   atomic.Bool // closed by drop rather than for failing
+}
+
+func newPeer(id string, sess *handshake.Session, inbound bool, path domain.Path) *peer {
+	return &peer{id: id, sess: sess, inbound: inbound, path: path, since: time.Now(), done: make(chan struct{})}
+}
+
+// send encodes and sends f.
+func (p *peer) send(f frame) error {
+	b, err := encodeFrame(f)
+	if err != nil {
+		return err
+	}
+	return p.sess.WriteFrame(b, writeTimeout)
+}
+
+// reply sends f, dropping the connection if that fails.
+func (p *peer) reply(f frame) error {
+	if err := p.send(f); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// drop closes the connection without counting it as failed, e.g. when
+// the contact is blocked or the identity changes.
+func (p *peer) drop() {
+	p.dropped.Store(true)
+	p.close()
+}
+
+// close drops the connection; the goroutine serving it then stops.
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.sess.Conn().Close()
+	})
+}
+
+// authorize returns the contact that holds key, filling in the key of a
+// contact added by Public ID alone. Strangers and blocked contacts are
+// turned away.
+func (m *Messenger) authorize(ctx context.Context, key []byte) (string, error) {
+	id := domain.PublicIDFromKey(key)
+	c, err := m.GetContact(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case c.IsBlocked:
+		return "", domain.ErrContactBlocked
+	case c.PublicKey == "":
+		return id, m.LearnContactKey(ctx, id, key)
+	case c.PublicKey != hex.EncodeToString(key):
+		return "", domain.ErrPublicIDMismatch
+	}
+	return id, nil
+}
+
+// authorizeRotated is authorize for a contact that rotated its key, which
+// is known by an earlier key until the rotation frame f that carries the
+// certificate is taken in. Certificates are signed by both keys, so one
+// is taken in whoever brings it.
+func (m *Messenger) authorizeRotated(ctx context.Context, key []byte, f frame) (string, error) {
+	if f.Type != frameRotate || f.Rotation == nil {
+		return "", domain.ErrContactNotFound
+	}
+	if _, err := m.ReceiveKeyRotation(ctx, *f.Rotation); err != nil {
+		return "", err
+	}
+	return m.authorize(ctx, key)
+}
+
+// awaitRotation authorizes a peer whose key no contact has by the
+// rotation frames it sends first, oldest first, if it is a contact that
+// rotated its key while disconnected. Each is acked once the peer is
+// known by its key.
+func (m *Messenger) awaitRotation(ctx context.Context, sess *handshake.Session) (string, error) {
+	sess.Conn().SetReadDeadline(time.Now().Add(handshake.Timeout))
+	for {
+		b, err := sess.ReadFrame()
+		if err != nil {
+			return "", err
+		}
+		f, err := decodeFrame(b)
+		if err != nil {
+			return "", err
+		}
+		id, err := m.authorizeRotated(ctx, sess.Remote(), f)
+		if errors.Is(err, domain.ErrContactNotFound) && f.Type == frameRotate {
+			continue // an earlier rotation; the one to this key follows
+		} else if err != nil {
+			return "", err
+		}
+		ack, err := encodeFrame(frame{Type: frameRotateAck, ID: f.ID})
+		if err != nil {
+			return "", err
+		}
+		return id, sess.WriteFrame(ack, writeTimeout)
+	}
+}
+
+// run serves an authenticated peer until the connection drops.
+func (m *Messenger) run(ctx context.Context, p *peer) {
+	defer p.close()
+	if !m.register(ctx, p) {
+		return
+	}
+	defer m.unregister(ctx, p)
+	slog.Info("peer connected", "contact", p.id, "inbound", p.inbound, "path", p.path, "remote", p.sess.Conn().RemoteAddr())
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.catchUp(ctx, p)
+		m.keepAlive(p)
+	}()
+
+	for {
+		p.sess.Conn().SetReadDeadline(time.Now().Add(idleTimeout))
+		b, err := p.sess.ReadFrame()
+		if err != nil {
+			slog.Info("peer disconnected", "contact", p.id, "error", err)
+			return
+		}
+		f, err := decodeFrame(b)
+		if err != nil {
+			slog.Warn("peer sent garbage", "contact", p.id, "error", err)
+			return
+		}
+		if err := m.handle(ctx, p.id, f, p.reply); err != nil {
+			logFrameError(p.id, f, err)
+		}
+	}
+}
+
+// handle acts on a frame from contactID, sending acks with reply.
+func (m *Messenger) handle(ctx context.Context, contactID string, f frame, reply func(frame) error) error {
+	switch f.Type {
+	case frameMessage:
+		msg, err := f.message(contactID, time.Now())
+		if err != nil {
+			return err
+		}
+		// Ack only what is stored; the sender tries again otherwise.
+		if err := m.ReceiveMessage(ctx, msg); err != nil {
+			return err
+		}
+		return reply(frame{Type: frameAck, ID: msg.ID})
+	case frameAck:
+		return m.UpdateMessageStatus(ctx, contactID, f.ID, domain.StatusDelivered)
+	case frameRead:
+		return m.ReceiveReadReceipt(ctx, contactID, f.ID)
+	case frameTyping:
+		m.ReceiveTyping(contactID, f.Typing)
+	case framePunch:
+		return m.punchOffered(ctx, contactID, f)
+	case framePunchAnswer:
+		return m.punchAnswered(f)
+	case frameRotate:
+		if f.Rotation == nil {
+			return fmt.Errorf("%w: rotation without certificate", errInvalidFrame)
+		}
+		if _, err := m.ReceiveKeyRotation(ctx, *f.Rotation); err != nil {
+			return err
+		}
+		return reply(frame{Type: frameRotateAck, ID: f.ID})
+	case frameRotateAck:
+		return m.AckRotation(ctx, contactID, f.ID)
+	}
+	return nil
+}
+
+// logFrameError logs why handle failed.
+func logFrameError(contactID string, f frame, err error) {
+	if errors.Is(err, domain.ErrMessageNotFound) {
+		// Acks for a message deleted since, e.g. by clearing the chat.
+		slog.Debug("frame for unknown message", "contact", contactID, "type", f.Type, "id", f.ID)
+	} else {
+		slog.Warn("handle frame", "contact", contactID, "type", f.Type, "error", err)
+	}
+}
+
+// catchUp sends p what it missed while disconnected: the key rotations
+// and messages it has not acknowledged and how far its chat has been read.
+// Rotations go first, as a contact that missed one cannot tell who
+// connected before; see awaitRotation.
+func (m *Messenger) catchUp(ctx context.Context, p *peer) {
+	if err := m.sendRotations(ctx, p.id, p.reply); err != nil {
+		return
+	}
+	msgs, err := m.PendingMessages(ctx, p.id)
+	if err != nil {
+		slog.Warn("pending messages", "contact", p.id, "error", err)
+		return
+	}
+	for _, msg := range msgs {
+		if err := m.deliver(ctx, p, msg); errors.Is(err, errFrameTooLarge) {
+			slog.Warn("message too large to send", "contact", p.id, "id", msg.ID)
+		} else if err != nil {
+			return
+		}
+	}
+	m.sendReadReceipt(ctx, p.id, p.reply)
+}
+
+// sendRotations sends contactID with send the certificates of the key
+// rotations it has not acknowledged, oldest first.
+func (m *Messenger) sendRotations(ctx context.Context, contactID string, send func(frame) error) error {
+	certs, err := m.PendingRotations(ctx, contactID)
+	if err != nil {
+		slog.Warn("pending rotations", "contact", contactID, "error", err)
+		return err
+	}
+	for _, cert := range certs {
+		if err := send(rotationFrame(cert)); err != nil {
+			slog.Debug("send rotation", "contact", contactID, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// keepAlive pings p while it is idle, so that both ends notice a dead
+// connection within idleTimeout.
+func (m *Messenger) keepAlive(p *peer) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if err := p.send(frame{Type: framePing}); err != nil {
+				p.close()
+				return
+			}
+		}
+	}
+}
+
+// deliver sends msg to p, schedules its next attempt in case no ack
+// comes and marks it sent.
+func (m *Messenger) deliver(ctx context.Context, p *peer, msg domain.Message) error {
+	if err := p.send(messageFrame(msg)); err != nil {
+		if !errors.Is(err, errFrameTooLarge) {
+			p.close()
+		}
+		return err
+	}
+	m.attempted(ctx, msg.ID)
+	if err := m.UpdateMessageStatus(ctx, p.id, msg.ID, domain.StatusSent); err != nil {
+		slog.Warn("mark message sent", "id", msg.ID, "error", err)
+	}
+	return nil
+}
+
+// sendReadReceipt tells contactID with send how far its chat has been
+// read: up to the newest incoming message, if that is read.
+func (m *Messenger) sendReadReceipt(ctx context.Context, contactID string, send func(frame) error) {
+	msgs, err := m.GetMessages(ctx, contactID, 0, "")
+	if err != nil {
+		return
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msg := msgs[i]; msg.SenderID == msg.ChatID {
+			if msg.Status == domain.StatusRead {
+				if err := send(frame{Type: frameRead, ID: msg.ID}); err != nil {
+					slog.Debug("send read receipt", "contact", contactID, "error", err)
+				}
+			}
+			return
+		}
+	}
+}
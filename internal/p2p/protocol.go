@@ -0,0 +1,111 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+	"quillet/internal/identity"
+)
+
+// frameType tells what a frame carries. Peers ignore types they do not
+// know, so newer versions can add some.
+type frameType string
+
+const (
+	frameMessage frameType = "message" // a chat message
+	frameAck     frameType = "ack"     // ID was stored by the recipient
+	frameRead    frameType = "read"    // the recipient read up to ID
+	frameTyping  frameType = "typing"  // the sender started or stopped typing
+	framePing    frameType = "ping"    // keeps an idle connection alive
+
+	framePunch       frameType = "punch"        // let us punch a hole, nonce ID, from Address
+	framePunchAnswer frameType = "punch-answer" // punch to Address for offer ID
+
+	frameRotate    frameType = "rotate"     // the sender moved to the key with Public ID ID
+	frameRotateAck frameType = "rotate-ack" // the rotation to ID was taken in
+)
+
+// Limits on what a peer may send.
+const (
+	maxMessageIDLength = 64
+	maxClockSkew       = 5 * time.Minute
+)
+
+var (
+	errInvalidFrame  = errors.New("invalid frame")
+	errFrameTooLarge = errors.New("frame too large")
+)
+
+// frame is the unit exchanged over a session, encoded as JSON.
+type frame struct {
+	Type      frameType `json:"type"`
+	ID        string    `json:"id,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Timestamp int64     `json:"timestamp,omitempty"`
+	Typing    bool      `json:"typing,omitempty"`
+	Address   string    `json:"address,omitempty"`
+
+	Rotation *identity.RotationCertificate `json:"rotation,omitempty"`
+}
+
+func encodeFrame(f frame) ([]byte, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > handshake.MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes", errFrameTooLarge, len(b))
+	}
+	return b, nil
+}
+
+func decodeFrame(b []byte) (frame, error) {
+	var f frame
+	if err := json.Unmarshal(b, &f); err != nil {
+		return frame{}, fmt.Errorf("%w: %v", errInvalidFrame, err)
+	}
+	return f, nil
+}
+
+// rotationFrame is the frame that carries cert.
+func rotationFrame(cert identity.RotationCertificate) frame {
+	key, _ := identity.ParsePublicKey(cert.NewPublicKey)
+	return frame{Type: frameRotate, ID: domain.PublicIDFromKey(key), Rotation: &cert}
+}
+
+// messageFrame is the frame that carries msg.
+func messageFrame(msg domain.Message) frame {
+	return frame{Type: frameMessage, ID: msg.ID, Content: msg.Content, Timestamp: msg.Timestamp}
+}
+
+// message returns the incoming message a message frame from contactID
+// carries. The sender's clock dates it, unless it claims to be from the
+// future, which would pin it below newer messages; it is dated now then.
+func (f frame) message(contactID string, now time.Time) (domain.Message, error) {
+	switch {
+	case f.ID == "" || len(f.ID) > maxMessageIDLength:
+		return domain.Message{}, fmt.Errorf("%w: message ID %q", errInvalidFrame, f.ID)
+	case !utf8.ValidString(f.Content):
+		return domain.Message{}, fmt.Errorf("%w: message content is not UTF-8", errInvalidFrame)
+	case strings.TrimSpace(f.Content) == "":
+		return domain.Message{}, domain.ErrEmptyContent
+	}
+	ts := f.Timestamp
+	if ts <= 0 || ts > now.Add(maxClockSkew).UnixMilli() {
+		ts = now.UnixMilli()
+	}
+	return domain.Message{
+		ID:        f.ID,
+		ChatID:    contactID,
+		SenderID:  contactID,
+		Content:   f.Content,
+		Timestamp: ts,
+		Status:    domain.StatusDelivered,
+	}, nil
+}
@@ -0,0 +1,274 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+	"quillet/internal/nat"
+	"quillet/internal/relay"
+)
+
+// Behind home routers, dialing a contact rarely gets through, so a node
+// with a relay also punches holes through the NATs in between (see
+// package nat). Once connected to the relay, the node detects its NAT
+// type. To reach a contact it cannot dial, it opens a UDP socket, learns
+// from the relay the address the NAT mapped the socket to, and sends it
+// in a punch offer, which the relay forwards to the contact if it is
+// connected. The contact answers with a mapped address of its own, both
+// send probes to each other's until one gets through, and the usual
+// handshake runs over a reliable stream on top; the offerer dials. If
+// punching fails, messages keep going through the relay, and the node
+// tries again later with backoff.
+//
+// The offer's ID is a fresh random nonce that the probes carry, so that
+// each end knows the other's probes from stray packets.
+
+// Limits on punch offers.
+const (
+	punchAnswerTimeout = 10 * time.Second
+	maxPunchAge        = 30 * time.Second // older offers come too late to answer
+)
+
+// detectNAT detects the NAT type towards the relay c and wakes the
+// dialer to punch holes.
+func (m *Messenger) detectNAT(ctx context.Context, c *relay.Client) {
+	primary, secondary, err := bindingAddrs(m.relayAddr)
+	if err != nil {
+		slog.Warn("detect NAT", "relay", m.relayAddr, "error", err)
+		return
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		slog.Warn("detect NAT", "error", err)
+		return
+	}
+	defer pc.Close()
+	stop := context.AfterFunc(ctx, func() { pc.Close() })
+	defer stop()
+	typ, mapped := nat.Detect(pc, primary, secondary)
+	addr := ""
+	if mapped.IsValid() {
+		addr = mapped.String()
+	}
+
+	m.mu.Lock()
+	current := m.relay == c && ctx.Err() == nil
+	if current {
+		m.natType, m.mappedAddr = typ, addr
+	}
+	m.mu.Unlock()
+	if current {
+		slog.Info("NAT detected", "type", typ, "mapped", addr)
+		m.wakeDialer()
+	}
+}
+
+// bindingAddrs returns where the relay answers binding requests: on the
+// UDP port of its TCP port, and the port above.
+func bindingAddrs(relayAddr string) (primary, secondary *net.UDPAddr, err error) {
+	_, hostport, err := relay.ParseAddress(relayAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	primary, err = net.ResolveUDPAddr("udp", hostport)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, _, _ := net.SplitHostPort(hostport)
+	secondary, err = net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(primary.Port+1)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return primary, secondary, nil
+}
+
+// canPunch reports whether to punch a hole to c now: the relay is
+// connected, let UDP through and can seal signals to c.
+// Must be called with m.mu held.
+func (m *Messenger) canPunch(c domain.Contact, now time.Time) bool {
+	return m.relay != nil && c.PublicKey != "" &&
+		m.natType != domain.NATUnknown && m.natType != domain.NATBlocked &&
+		!now.Before(m.punchRetry[c.PublicID].next)
+}
+
+// punch offers c to punch a hole and connects through it.
+func (m *Messenger) punch(ctx context.Context, c domain.Contact) (*peer, error) {
+	pc, mapped, err := m.openMapped()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	id := hex.EncodeToString(nonce)
+	answer := make(chan string, 1)
+	m.mu.Lock()
+	m.punches[id] = answer
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.punches, id)
+		m.mu.Unlock()
+	}()
+
+	offer := frame{Type: framePunch, ID: id, Address: mapped, Timestamp: time.Now().UnixMilli()}
+	if err := m.forwardRelay(ctx, c.PublicID, offer); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	var addr string
+	select {
+	case addr = <-answer:
+	case <-ctx.Done():
+		pc.Close()
+		return nil, ctx.Err()
+	case <-time.After(punchAnswerTimeout):
+		pc.Close()
+		return nil, errors.New("no answer to punch offer")
+	}
+	conn, err := punchThrough(ctx, pc, addr, id)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := handshake.Client(conn, m.self, c.PublicID)
+	if err == nil {
+		_, err = m.authorize(ctx, sess.Remote())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newPeer(c.PublicID, sess, false, domain.PathPunched), nil
+}
+
+// punchOffered answers contactID's punch offer f and runs the connection
+// made through the hole. An offer crossing one of ours to a contact with a
+// higher Public ID is left unanswered, so that only one hole is punched.
+func (m *Messenger) punchOffered(ctx context.Context, contactID string, f frame) error {
+	if err := checkPunch(f); err != nil {
+		return err
+	}
+	if time.Since(time.UnixMilli(f.Timestamp)).Abs() > maxPunchAge {
+		slog.Debug("stale punch offer", "contact", contactID)
+		return nil
+	}
+	m.mu.Lock()
+	crossed := m.dialing[contactID] && m.self.PublicID() < contactID
+	m.mu.Unlock()
+	if crossed {
+		return nil
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		p, err := m.answerPunch(ctx, contactID, f)
+		if err != nil {
+			slog.Debug("answer punch offer", "contact", contactID, "error", err)
+			return
+		}
+		m.run(ctx, p)
+	}()
+	return nil
+}
+
+// answerPunch sends the answer to the punch offer f and accepts the
+// connection through the hole.
+func (m *Messenger) answerPunch(ctx context.Context, contactID string, f frame) (*peer, error) {
+	pc, mapped, err := m.openMapped()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.forwardRelay(ctx, contactID, frame{Type: framePunchAnswer, ID: f.ID, Address: mapped}); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	conn, err := punchThrough(ctx, pc, f.Address, f.ID)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := handshake.Server(conn, m.self)
+	var id string
+	if err == nil {
+		id, err = m.authorize(ctx, sess.Remote())
+	}
+	if err == nil && id != contactID {
+		err = fmt.Errorf("%w: %s answered the hole punched for %s", domain.ErrUnexpectedPeer, id, contactID)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newPeer(id, sess, true, domain.PathPunched), nil
+}
+
+// punchAnswered passes the answer f to the punch offer awaiting it.
+func (m *Messenger) punchAnswered(f frame) error {
+	if err := checkPunch(f); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	answer := m.punches[f.ID]
+	m.mu.Unlock()
+	if answer != nil {
+		select {
+		case answer <- f.Address:
+		default:
+		}
+	}
+	return nil
+}
+
+// checkPunch validates a punch offer or answer.
+func checkPunch(f frame) error {
+	if f.ID == "" || len(f.ID) > maxMessageIDLength {
+		return fmt.Errorf("%w: punch ID %q", errInvalidFrame, f.ID)
+	}
+	if err := checkAddress(f.Address); err != nil {
+		return fmt.Errorf("%w: punch address: %v", errInvalidFrame, err)
+	}
+	return nil
+}
+
+// openMapped opens a UDP socket and learns from the relay the address the
+// NAT mapped it to.
+func (m *Messenger) openMapped() (net.PacketConn, string, error) {
+	primary, _, err := bindingAddrs(m.relayAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	mapped, err := nat.Mapped(pc, primary)
+	if err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	return pc, mapped.String(), nil
+}
+
+// punchThrough punches a hole from pc to the peer mapped at addr, with
+// the offer's ID as nonce, and starts a stream through it. It closes pc
+// if that fails.
+func punchThrough(ctx context.Context, pc net.PacketConn, addr, id string) (net.Conn, error) {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	from, err := nat.Punch(ctx, pc, remote, []byte(id))
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return nat.NewConn(pc, from), nil
+}
@@ -0,0 +1,85 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/nat"
+)
+
+// serveBinding answers binding requests where the relay at relayAddr is
+// expected to, so that nodes can punch holes with its help.
+func serveBinding(t *testing.T, relayAddr string) {
+	t.Helper()
+	primary, secondary, err := bindingAddrs(relayAddr)
+	if err != nil {
+		t.Fatalf("bindingAddrs() error = %v", err)
+	}
+	for i, addr := range []*net.UDPAddr{primary, secondary} {
+		pc, err := net.ListenUDP("udp", addr)
+		if err != nil && i > 0 {
+			continue // taken; NAT types are told apart less well
+		} else if err != nil {
+			t.Fatalf("ListenUDP() error = %v", err)
+		}
+		go nat.ServeBinding(pc)
+		t.Cleanup(func() { pc.Close() })
+	}
+}
+
+// awaitPath waits until n reaches contactID by want.
+func awaitPath(t *testing.T, n *node, contactID string, want domain.Path) *domain.NetworkStatus {
+	t.Helper()
+	for range 1000 {
+		st, err := n.GetNetworkStatus(newCtx())
+		if err != nil {
+			t.Fatalf("GetNetworkStatus() error = %v", err)
+		}
+		for _, c := range st.Contacts {
+			if c.ContactID == contactID && c.Path == want {
+				return st
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for path %s to %s", want, contactID)
+	return nil
+}
+
+func TestChatThroughPunchedHole(t *testing.T) {
+	cfg := Config{ListenAddress: "127.0.0.1:0", Relay: startRelay(t)}
+	serveBinding(t, cfg.Relay)
+	alice, bob := startNode(t, "Alice", cfg), startNode(t, "Bob", cfg)
+	aliceID, bobID := alice.peerID(t), bob.peerID(t)
+
+	// Neither knows where the other is; the relay brings them together.
+	if _, err := alice.AddContact(newCtx(), bobID, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), aliceID, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+	st := awaitPath(t, alice, bobID.PublicID, domain.PathPunched)
+	if st.NAT != domain.NATOpen || st.MappedAddress == "" {
+		t.Errorf("Alice's NAT = %q at %q; want %q and a mapped address", st.NAT, st.MappedAddress, domain.NATOpen)
+	}
+	if len(st.Peers) != 1 || st.Peers[0].Path != domain.PathPunched {
+		t.Errorf("Alice's peers = %+v; want Bob through a punched hole", st.Peers)
+	}
+	awaitPath(t, bob, aliceID.PublicID, domain.PathPunched)
+
+	sent, err := alice.SendMessage(newCtx(), bobID.PublicID, "hole in one")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := await(t, bob.messages, "message"); got.ID != sent.ID || got.Content != "hole in one" {
+		t.Errorf("Bob received %+v; want %+v", got, *sent)
+	}
+	awaitStatus(t, alice, domain.StatusDelivered)
+
+	// Once Bob is gone, Alice falls back to the relay.
+	bob.stop()
+	awaitPath(t, alice, bobID.PublicID, domain.PathRelay)
+}
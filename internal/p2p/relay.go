@@ -0,0 +1,253 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+	"quillet/internal/relay"
+)
+
+// A node with a relay keeps a connection to it while the identity is
+// unlocked. Messages for a contact that is not connected are sealed to its
+// identity key and left in its mailbox there; they count as sent once the
+// relay stored them. Acks and read receipts come back the same way.
+// Typing signals are not worth relaying.
+//
+// The relay knows frames by type and message ID, so that putting one
+// again, e.g. when a message goes out both directly and through the relay,
+// does not store it twice.
+
+// errNoRelay is returned when there is no relay to send through.
+var errNoRelay = errors.New("not connected to a relay")
+
+// relayLoop keeps a connection to the relay, redialing with backoff while
+// it fails, until ctx is cancelled.
+func (m *Messenger) relayLoop(ctx context.Context) {
+	var delay time.Duration
+	for {
+		if m.self.HasIdentity() && !m.self.Locked() {
+			c, err := relay.Dial(ctx, m.relayAddr, m.self)
+			if err == nil {
+				delay = 0
+				m.serveRelay(ctx, c)
+			} else {
+				slog.Debug("dial relay", "relay", m.relayAddr, "error", err)
+				delay = min(max(2*delay, minBackoff), maxBackoff)
+			}
+		}
+		wait := redialInterval
+		if delay > 0 {
+			wait = delay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-m.relayNow:
+		}
+	}
+}
+
+// serveRelay runs the connection c to the relay until it drops: it sends
+// what waits for contacts that are not connected and takes in the mailbox.
+func (m *Messenger) serveRelay(ctx context.Context, c *relay.Client) {
+	m.mu.Lock()
+	if ctx.Err() != nil {
+		m.mu.Unlock()
+		c.Close()
+		return
+	}
+	m.relay = c
+	m.mu.Unlock()
+	slog.Info("relay connected", "relay", c.Address())
+
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.flushRelay(ctx)
+	}()
+	go func() {
+		defer m.wg.Done()
+		m.detectNAT(ctx, c)
+	}()
+	err := c.Serve(func(env []byte) error {
+		return m.relayed(ctx, env)
+	})
+
+	m.mu.Lock()
+	if m.relay == c {
+		m.relay = nil
+		m.natType, m.mappedAddr = domain.NATUnknown, ""
+	}
+	m.mu.Unlock()
+	slog.Info("relay disconnected", "relay", c.Address(), "error", err)
+}
+
+// wakeRelay makes the node dial the relay now if it is not connected.
+func (m *Messenger) wakeRelay() {
+	select {
+	case m.relayNow <- struct{}{}:
+	default:
+	}
+}
+
+// flushRelay leaves the key rotations they have not acknowledged and the
+// messages still sending to contacts that are not connected with the
+// relay.
+func (m *Messenger) flushRelay(ctx context.Context) {
+	contacts, err := m.GetContacts(ctx)
+	if err != nil {
+		return
+	}
+	for _, c := range contacts {
+		if c.IsBlocked || c.PublicKey == "" || m.peer(c.PublicID) != nil {
+			continue
+		}
+		err := m.sendRotations(ctx, c.PublicID, func(f frame) error {
+			return m.postRelay(ctx, c.PublicID, f)
+		})
+		if errors.Is(err, errNoRelay) {
+			return
+		}
+		msgs, err := m.PendingMessages(ctx, c.PublicID)
+		if err != nil {
+			slog.Warn("pending messages", "contact", c.PublicID, "error", err)
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.Status != domain.StatusSending {
+				continue // sent before, directly or through the relay
+			}
+			err := m.relayMessage(ctx, msg)
+			if errors.Is(err, domain.ErrRelayRejected) {
+				slog.Warn("relay message", "contact", c.PublicID, "id", msg.ID, "error", err)
+				m.attempted(ctx, msg.ID)
+				break // the mailbox is full, or the message too large
+			} else if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// relayMessage leaves msg with the relay, schedules its next attempt and
+// marks it sent.
+func (m *Messenger) relayMessage(ctx context.Context, msg domain.Message) error {
+	if err := m.putRelay(ctx, msg.ChatID, messageFrame(msg), true); err != nil {
+		return err
+	}
+	m.attempted(ctx, msg.ID)
+	if err := m.UpdateMessageStatus(ctx, msg.ChatID, msg.ID, domain.StatusSent); err != nil {
+		slog.Warn("mark message sent", "id", msg.ID, "error", err)
+	}
+	return nil
+}
+
+// postRelay leaves f for contactID with the relay without waiting for it;
+// see relay.Client.Post.
+func (m *Messenger) postRelay(ctx context.Context, contactID string, f frame) error {
+	return m.putRelay(ctx, contactID, f, false)
+}
+
+// putRelay seals f to contactID's identity key and leaves it in its
+// mailbox, waiting for the relay to store it if wait is set.
+func (m *Messenger) putRelay(ctx context.Context, contactID string, f frame, wait bool) error {
+	c, id, env, err := m.seal(ctx, contactID, f)
+	if err != nil {
+		return err
+	}
+	if wait {
+		return c.Put(contactID, id, env)
+	}
+	return c.Post(contactID, id, env)
+}
+
+// forwardRelay hands f to contactID through the relay if it is connected
+// there; see relay.Client.Forward.
+func (m *Messenger) forwardRelay(ctx context.Context, contactID string, f frame) error {
+	c, id, env, err := m.seal(ctx, contactID, f)
+	if err != nil {
+		return err
+	}
+	return c.Forward(contactID, id, env)
+}
+
+// seal seals f to contactID's identity key for the relay c, returning
+// the ID the relay knows it by.
+func (m *Messenger) seal(ctx context.Context, contactID string, f frame) (c *relay.Client, id string, env []byte, err error) {
+	m.mu.Lock()
+	c = m.relay
+	m.mu.Unlock()
+	if c == nil {
+		return nil, "", nil, errNoRelay
+	}
+	contact, err := m.GetContact(ctx, contactID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if contact.PublicKey == "" {
+		return nil, "", nil, fmt.Errorf("relay to %s: identity key unknown", contactID)
+	}
+	key, err := identity.ParsePublicKey(contact.PublicKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	b, err := encodeFrame(f)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	env, err = relay.SealEnvelope(m.self, key, b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return c, string(f.Type) + ":" + f.ID, env, nil
+}
+
+// relayed acts on an envelope from the mailbox. It fails, so that the
+// relay keeps the envelope, only if the envelope might be taken in later,
+// e.g. once the identity is unlocked again; what can never be taken in is
+// dropped.
+func (m *Messenger) relayed(ctx context.Context, env []byte) error {
+	sender, payload, err := relay.OpenEnvelope(m.self, env)
+	if errors.Is(err, domain.ErrInvalidEnvelope) {
+		slog.Warn("drop relayed envelope", "error", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	f, err := decodeFrame(payload)
+	if err != nil {
+		slog.Warn("drop relayed envelope", "sender", domain.PublicIDFromKey(sender), "error", err)
+		return nil
+	}
+	contactID, err := m.authorize(ctx, sender)
+	if errors.Is(err, domain.ErrContactNotFound) {
+		contactID, err = m.authorizeRotated(ctx, sender, f)
+	}
+	if errors.Is(err, domain.ErrContactNotFound) || errors.Is(err, domain.ErrContactBlocked) ||
+		errors.Is(err, domain.ErrPublicIDMismatch) || errors.Is(err, domain.ErrInvalidRotation) {
+		slog.Info("drop relayed envelope", "sender", domain.PublicIDFromKey(sender), "error", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = m.handle(ctx, contactID, f, func(reply frame) error {
+		return m.postRelay(ctx, contactID, reply)
+	})
+	if err == nil {
+		return nil
+	}
+	logFrameError(contactID, f, err)
+	if errors.Is(err, errInvalidFrame) || errors.Is(err, domain.ErrEmptyContent) ||
+		errors.Is(err, domain.ErrMessageNotFound) || errors.Is(err, domain.ErrInvalidRotation) ||
+		errors.Is(err, domain.ErrContactNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/relay"
+)
+
+// startRelay runs a relay on loopback and returns its address.
+func startRelay(t *testing.T) string {
+	t.Helper()
+	self := newSigner(t)
+	s, err := relay.Open(filepath.Join(t.TempDir(), "relay.db"), self, relay.Config{
+		TTL:          time.Hour,
+		MaxEnvelopes: relay.DefaultMaxEnvelopes,
+		MaxBytes:     relay.DefaultMaxBytes,
+	})
+	if err != nil {
+		t.Fatalf("relay.Open() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(newCtx())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		s.Close()
+	})
+	return self.PublicID() + "@" + ln.Addr().String()
+}
+
+// awaitRelay waits until n is connected to its relay.
+func awaitRelay(t *testing.T, n *node) {
+	t.Helper()
+	for range 500 {
+		if st, err := n.GetNetworkStatus(newCtx()); err == nil && st.RelayConnected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the relay")
+}
+
+func TestChatThroughRelay(t *testing.T) {
+	cfg := Config{ListenAddress: "127.0.0.1:0", Relay: startRelay(t)}
+	alice, bob := startNode(t, "Alice", cfg), startNode(t, "Bob", cfg)
+	aliceID, bobID := alice.peerID(t), bob.peerID(t)
+
+	// Neither knows where the other is.
+	if _, err := alice.AddContact(newCtx(), bobID, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), aliceID, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+	awaitRelay(t, alice)
+
+	// Sent while Bob is offline, the message waits at the relay.
+	bob.stop()
+	sent, err := alice.SendMessage(newCtx(), bobID.PublicID, "see you later")
+	if err != nil || sent.Status != domain.StatusSent {
+		t.Fatalf("SendMessage() = %+v, %v; want it sent to the relay", sent, err)
+	}
+	awaitStatus(t, alice, domain.StatusSent)
+
+	bob.start()
+	got := await(t, bob.messages, "relayed message")
+	if got.ID != sent.ID || got.Content != "see you later" || got.SenderID != aliceID.PublicID {
+		t.Errorf("Bob received %+v; want %+v from Alice", got, *sent)
+	}
+	awaitStatus(t, alice, domain.StatusDelivered)
+
+	if err := bob.MarkAsRead(newCtx(), aliceID.PublicID); err != nil {
+		t.Fatalf("MarkAsRead() error = %v", err)
+	}
+	awaitStatus(t, alice, domain.StatusRead)
+
+	st, err := alice.GetNetworkStatus(newCtx())
+	if err != nil || len(st.Peers) != 0 || st.Relay != cfg.Relay {
+		t.Errorf("Alice's network status = %+v, %v; want no peers and the relay", st, err)
+	}
+}
+
+func TestKeyRotationThroughRelay(t *testing.T) {
+	cfg := Config{ListenAddress: "127.0.0.1:0", Relay: startRelay(t)}
+	alice, bob := startNode(t, "Alice", cfg), startNode(t, "Bob", cfg)
+	aliceID, bobID := alice.peerID(t), bob.peerID(t)
+	if _, err := alice.AddContact(newCtx(), bobID, "Bob"); err != nil {
+		t.Fatalf("AddContact(Bob) error = %v", err)
+	}
+	if _, err := bob.AddContact(newCtx(), aliceID, "Alice"); err != nil {
+		t.Fatalf("AddContact(Alice) error = %v", err)
+	}
+	awaitRelay(t, alice)
+
+	// Rotated while Bob is offline, the certificate waits at the relay
+	// ahead of the messages sent with the new key.
+	bob.stop()
+	u, err := alice.RotateIdentityKey(newCtx())
+	if err != nil {
+		t.Fatalf("RotateIdentityKey() error = %v", err)
+	}
+	sent, err := alice.SendMessage(newCtx(), bobID.PublicID, "new key")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	awaitStatus(t, alice, domain.StatusSent) // once the relay is back
+
+	bob.start()
+	if got := await(t, bob.keys, "key change"); got.ContactID != u.PublicID || got.PreviousID != aliceID.PublicID {
+		t.Errorf("Bob's key change = %+v; want Alice from %s to %s", got, aliceID.PublicID, u.PublicID)
+	}
+	if got := await(t, bob.messages, "relayed message"); got.ID != sent.ID || got.SenderID != u.PublicID {
+		t.Errorf("Bob received %+v; want %q from %s", got, sent.ID, u.PublicID)
+	}
+	awaitStatus(t, alice, domain.StatusDelivered)
+
+	for range 500 {
+		pending, err := alice.PendingRotations(newCtx(), bobID.PublicID)
+		if err != nil {
+			t.Fatalf("PendingRotations() error = %v", err)
+		}
+		if len(pending) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("timed out waiting for Bob to ack the rotation")
+}
+
+func TestOpen_InvalidRelay(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(dir, newSigner(t), Config{Relay: "relay.example.org:47330"}); err == nil {
+		t.Error("Open() with a relay address lacking its Public ID succeeded")
+	}
+}
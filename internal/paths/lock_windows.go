@@ -0,0 +1,30 @@
+//go:build windows
+
+package paths
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRange is the locked region: one byte far past the PID, which Windows
+// would otherwise keep other processes from reading.
+var lockRange = windows.Overlapped{OffsetHigh: 0x7fffffff}
+
+// lockFile takes an exclusive LockFileEx lock on f without waiting.
+func lockFile(f *os.File) error {
+	ol := lockRange
+	err := windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	ol := lockRange
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
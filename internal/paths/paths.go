@@ -0,0 +1,157 @@
+// Package paths locates the per-user Quillet data directory and guards it
+// against concurrent use.
+//
+// The directory is $QUILLET_HOME if set, else the platform's configuration
+// directory (doc/spec.md §6.2):
+//
+//	Linux, BSD  $XDG_CONFIG_HOME/quillet, default ~/.config/quillet
+//	macOS       ~/Library/Application Support/Quillet
+//	Windows     %APPDATA%\Quillet
+//
+// A running instance holds an exclusive lock on the quillet.lock file in
+// the directory, so a second process using the same directory stops at
+// startup instead of writing to databases the first one has open.
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"quillet/internal/domain"
+)
+
+const (
+	// HomeEnv overrides the data directory, e.g. to run a second instance
+	// with separate data or to keep test data apart.
+	HomeEnv = "QUILLET_HOME"
+
+	// LockFileName is the instance lock inside the data directory.
+	LockFileName = "quillet.lock"
+
+	dirName    = "quillet" // under XDG_CONFIG_HOME
+	appDirName = "Quillet" // under Application Support and APPDATA
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("lock is held")
+
+// DataDir returns the data directory for the current user and platform.
+// It does not create it.
+func DataDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil && os.Getenv(HomeEnv) == "" {
+		return "", fmt.Errorf("resolve data dir: %w", err)
+	}
+	dir, err := resolve(runtime.GOOS, os.Getenv, home)
+	if err != nil {
+		return "", fmt.Errorf("resolve data dir: %w", err)
+	}
+	return dir, nil
+}
+
+// resolve implements DataDir for goos with the environment in getenv.
+func resolve(goos string, getenv func(string) string, home string) (string, error) {
+	if dir := getenv(HomeEnv); dir != "" {
+		return filepath.Abs(dir)
+	}
+	switch goos {
+	case "windows":
+		appData := getenv("APPDATA")
+		if appData == "" {
+			return "", errors.New("%APPDATA% is not set")
+		}
+		return filepath.Join(appData, appDirName), nil
+	case "darwin", "ios":
+		return filepath.Join(home, "Library", "Application Support", appDirName), nil
+	}
+	// The XDG spec says relative paths are invalid and must be ignored.
+	if dir := getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, dirName), nil
+	}
+	return filepath.Join(home, ".config", dirName), nil
+}
+
+// Lock is the exclusive hold of one process on a data directory.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire creates dir if needed, restricts it to the current user and
+// locks it for this process. It fails with domain.ErrDataDirLocked while
+// another process holds the lock; the lock goes away with the process, so
+// a crash leaves nothing to clean up.
+func Acquire(dir string) (*Lock, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, fmt.Errorf("lock data dir: %w", err)
+	}
+	path := filepath.Join(dir, LockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("lock data dir: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("lock data dir: %s%s: %w", dir, holder(path), domain.ErrDataDirLocked)
+		}
+		return nil, fmt.Errorf("lock data dir: %w", err)
+	}
+	// The PID is only a hint for the error message of the next process.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release unlocks the directory. The lock file stays; it is reused by the
+// next Acquire.
+func (l *Lock) Release() error {
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unlock data dir: %w", err)
+	}
+	return nil
+}
+
+// ensureDir creates dir with mode 0700, or tightens an existing one that
+// other users can read: it holds private keys and message history.
+func ensureDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		slog.Warn("restricting data directory permissions", "dir", dir, "mode", info.Mode().Perm())
+		return os.Chmod(dir, 0o700)
+	}
+	return nil
+}
+
+// holder describes the process named in the lock file at path, if any.
+func holder(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (process %d)", pid)
+}
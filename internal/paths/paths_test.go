@@ -0,0 +1,88 @@
+package paths
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func TestResolve(t *testing.T) {
+	home := filepath.FromSlash("/home/alice")
+	tests := []struct {
+		name string
+		goos string
+		env  map[string]string
+		want string
+	}{
+		{name: "linux default", goos: "linux", want: "/home/alice/.config/quillet"},
+		{name: "xdg", goos: "linux", env: map[string]string{"XDG_CONFIG_HOME": "/xdg"}, want: "/xdg/quillet"},
+		{name: "relative xdg ignored", goos: "freebsd", env: map[string]string{"XDG_CONFIG_HOME": "xdg"}, want: "/home/alice/.config/quillet"},
+		{name: "macos", goos: "darwin", env: map[string]string{"XDG_CONFIG_HOME": "/xdg"}, want: "/home/alice/Library/Application Support/Quillet"},
+		{name: "windows", goos: "windows", env: map[string]string{"APPDATA": "/appdata"}, want: "/appdata/Quillet"},
+		{name: "override", goos: "linux", env: map[string]string{HomeEnv: "/data/q", "XDG_CONFIG_HOME": "/xdg"}, want: "/data/q"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolve(tt.goos, func(k string) string { return tt.env[k] }, home)
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("resolve() = %q; want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := resolve("windows", func(string) string { return "" }, home); err == nil {
+		t.Error("resolve() without APPDATA succeeded")
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "quillet")
+	lock, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		t.Fatalf("data dir not created: %v", err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0o700 {
+		t.Errorf("data dir mode = %v; want 0700", info.Mode().Perm())
+	}
+
+	_, err = Acquire(dir)
+	if !errors.Is(err, domain.ErrDataDirLocked) {
+		t.Fatalf("second Acquire() error = %v; want %v", err, domain.ErrDataDirLocked)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	again, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() after Release error = %v", err)
+	}
+	again.Release()
+}
+
+func TestAcquire_TightensPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer lock.Release()
+	if info, _ := os.Stat(dir); info.Mode().Perm() != 0o700 {
+		t.Errorf("data dir mode = %v; want 0700", info.Mode().Perm())
+	}
+}
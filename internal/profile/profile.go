@@ -0,0 +1,260 @@
+// Package profile manages the local profiles of one installation.
+// Every profile owns a data directory under <root>/profiles/<id>/ holding
+// its identity key, database and settings; profiles.json in the root
+// records the known profiles and which one is active.
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"quillet/internal/domain"
+)
+
+const (
+	registryFileName = "profiles.json"
+	profilesDirName  = "profiles"
+
+	// DefaultName is the name of the profile created on first start.
+	DefaultName = "Default"
+
+	// legacyIdentityFile is the identity key of installations that predate
+	// profiles; it is moved into the default profile on first start.
+	legacyIdentityFile = "identity.key"
+)
+
+// registry is the on-disk layout of profiles.json.
+type registry struct {
+	Active   string          `json:"active"`
+	Profiles []storedProfile `json:"profiles"`
+}
+
+type storedProfile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Registry is the set of local profiles under a root directory.
+// It is safe for concurrent use.
+type Registry struct {
+	mu   sync.Mutex
+	root string
+	reg  registry
+}
+
+// Open loads the registry in root. On first start it creates the default
+// profile, adopting a pre-profile identity key found in root if there is one.
+func Open(root string) (*Registry, error) {
+	r := &Registry{root: root}
+
+	data, err := os.ReadFile(r.registryPath())
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := r.init(); err != nil {
+			return nil, fmt.Errorf("open profiles: %w", err)
+		}
+		return r, nil
+	case err != nil:
+		return nil, fmt.Errorf("open profiles: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &r.reg); err != nil {
+		return nil, fmt.Errorf("open profiles: %w", err)
+	}
+	if _, ok := r.find(r.reg.Active); !ok {
+		return nil, fmt.Errorf("open profiles: active %q: %w", r.reg.Active, domain.ErrProfileNotFound)
+	}
+	return r, nil
+}
+
+// init creates the default profile and makes it active.
+func (r *Registry) init() error {
+	p, err := r.create(DefaultName)
+	if err != nil {
+		return err
+	}
+
+	legacy := filepath.Join(r.root, legacyIdentityFile)
+	if _, err := os.Stat(legacy); err == nil {
+		if err := os.Rename(legacy, filepath.Join(r.dir(p.ID), legacyIdentityFile)); err != nil {
+			return fmt.Errorf("adopt legacy identity: %w", err)
+		}
+	}
+
+	r.reg.Active = p.ID
+	return r.save()
+}
+
+// List returns all profiles sorted by creation time.
+func (r *Registry) List() []domain.LocalProfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]domain.LocalProfile, 0, len(r.reg.Profiles))
+	for _, sp := range r.reg.Profiles {
+		out = append(out, r.toDomain(sp))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt < out[j].CreatedAt
+	})
+	return out
+}
+
+// Active returns the active profile.
+func (r *Registry) Active() domain.LocalProfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sp, _ := r.find(r.reg.Active)
+	return r.toDomain(sp)
+}
+
+// Get returns the profile with the given ID.
+func (r *Registry) Get(id string) (domain.LocalProfile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sp, ok := r.find(id)
+	if !ok {
+		return domain.LocalProfile{}, domain.ErrProfileNotFound
+	}
+	return r.toDomain(sp), nil
+}
+
+// Dir returns the data directory of the profile with the given ID.
+func (r *Registry) Dir(id string) string {
+	return r.dir(id)
+}
+
+// Create adds a new, inactive profile with its own empty data directory.
+// Names are unique, compared case-insensitively.
+func (r *Registry) Create(name string) (domain.LocalProfile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sp := range r.reg.Profiles {
+		if strings.EqualFold(sp.Name, name) {
+			return domain.LocalProfile{}, fmt.Errorf("create profile: %w", domain.ErrProfileExists)
+		}
+	}
+	sp, err := r.create(name)
+	if err != nil {
+		return domain.LocalProfile{}, fmt.Errorf("create profile: %w", err)
+	}
+	if err := r.save(); err != nil {
+		return domain.LocalProfile{}, fmt.Errorf("create profile: %w", err)
+	}
+	return r.toDomain(sp), nil
+}
+
+// SetActive records id as the active profile.
+func (r *Registry) SetActive(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.find(id); !ok {
+		return fmt.Errorf("set active profile: %w", domain.ErrProfileNotFound)
+	}
+	prev := r.reg.Active
+	r.reg.Active = id
+	if err := r.save(); err != nil {
+		r.reg.Active = prev
+		return fmt.Errorf("set active profile: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a profile and all of its data.
+// The active profile cannot be deleted; switch to another one first.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.find(id); !ok {
+		return fmt.Errorf("delete profile: %w", domain.ErrProfileNotFound)
+	}
+	if id == r.reg.Active {
+		return fmt.Errorf("delete profile: %w", domain.ErrProfileActive)
+	}
+
+	kept := r.reg.Profiles[:0:0]
+	for _, sp := range r.reg.Profiles {
+		if sp.ID != id {
+			kept = append(kept, sp)
+		}
+	}
+	prev := r.reg.Profiles
+	r.reg.Profiles = kept
+	if err := r.save(); err != nil {
+		r.reg.Profiles = prev
+		return fmt.Errorf("delete profile: %w", err)
+	}
+	if err := os.RemoveAll(r.dir(id)); err != nil {
+		return fmt.Errorf("delete profile: %w", err)
+	}
+	return nil
+}
+
+// create makes the data directory of a new profile and adds it to the
+// in-memory registry. Must be called with r.mu held (or before r is shared).
+func (r *Registry) create(name string) (storedProfile, error) {
+	sp := storedProfile{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if err := os.MkdirAll(r.dir(sp.ID), 0o700); err != nil {
+		return storedProfile{}, err
+	}
+	r.reg.Profiles = append(r.reg.Profiles, sp)
+	return sp, nil
+}
+
+func (r *Registry) find(id string) (storedProfile, bool) {
+	for _, sp := range r.reg.Profiles {
+		if sp.ID == id {
+			return sp, true
+		}
+	}
+	return storedProfile{}, false
+}
+
+func (r *Registry) toDomain(sp storedProfile) domain.LocalProfile {
+	return domain.LocalProfile{
+		ID:        sp.ID,
+		Name:      sp.Name,
+		CreatedAt: sp.CreatedAt,
+		Active:    sp.ID == r.reg.Active,
+	}
+}
+
+func (r *Registry) dir(id string) string {
+	return filepath.Join(r.root, profilesDirName, id)
+}
+
+func (r *Registry) registryPath() string {
+	return filepath.Join(r.root, registryFileName)
+}
+
+// save atomically rewrites profiles.json.
+func (r *Registry) save() error {
+	data, err := json.MarshalIndent(r.reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.registryPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.registryPath())
+}
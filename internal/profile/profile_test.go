@@ -0,0 +1,150 @@
+package profile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func mustOpen(t *testing.T, root string) *Registry {
+	t.Helper()
+	r, err := Open(root)
+	if err != nil {
+		t.Fatalf("Open(%q) error = %v", root, err)
+	}
+	return r
+}
+
+func mustCreate(t *testing.T, r *Registry, name string) domain.LocalProfile {
+	t.Helper()
+	p, err := r.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%q) error = %v", name, err)
+	}
+	return p
+}
+
+func TestOpen_CreatesDefault(t *testing.T) {
+	root := t.TempDir()
+	r := mustOpen(t, root)
+
+	list := r.List()
+	if len(list) != 1 {
+		t.Fatalf("List() returned %d profiles; want 1", len(list))
+	}
+	p := list[0]
+	if p.Name != DefaultName || !p.Active {
+		t.Errorf("List()[0] = %+v; want active %q", p, DefaultName)
+	}
+	if info, err := os.Stat(r.Dir(p.ID)); err != nil || !info.IsDir() {
+		t.Errorf("profile dir %q missing: %v", r.Dir(p.ID), err)
+	}
+
+	reopened := mustOpen(t, root)
+	if got := reopened.Active(); got != p {
+		t.Errorf("Active() after reopen = %+v; want %+v", got, p)
+	}
+}
+
+func TestOpen_AdoptsLegacyIdentity(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, "identity.key")
+	if err := os.WriteFile(legacy, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mustOpen(t, root)
+
+	if _, err := os.Stat(legacy); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy identity still in root: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(r.Dir(r.Active().ID), "identity.key"))
+	if err != nil {
+		t.Fatalf("read adopted identity: %v", err)
+	}
+	if string(data) != "key" {
+		t.Errorf("adopted identity = %q; want %q", data, "key")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	root := t.TempDir()
+	r := mustOpen(t, root)
+	work := mustCreate(t, r, "Work")
+
+	if work.Active {
+		t.Error("new profile is active; want inactive")
+	}
+	if work.ID == r.Active().ID {
+		t.Error("new profile shares the default profile's ID")
+	}
+	if _, err := r.Create("work"); !errors.Is(err, domain.ErrProfileExists) {
+		t.Errorf("Create(duplicate) error = %v; want %v", err, domain.ErrProfileExists)
+	}
+
+	reopened := mustOpen(t, root)
+	got, err := reopened.Get(work.ID)
+	if err != nil {
+		t.Fatalf("Get() after reopen error = %v", err)
+	}
+	if got != work {
+		t.Errorf("Get() = %+v; want %+v", got, work)
+	}
+}
+
+func TestSetActive(t *testing.T) {
+	root := t.TempDir()
+	r := mustOpen(t, root)
+	def := r.Active()
+	work := mustCreate(t, r, "Work")
+
+	if err := r.SetActive(work.ID); err != nil {
+		t.Fatalf("SetActive() error = %v", err)
+	}
+	if got := mustOpen(t, root).Active().ID; got != work.ID {
+		t.Errorf("Active() after reopen = %q; want %q", got, work.ID)
+	}
+	if p, _ := r.Get(def.ID); p.Active {
+		t.Error("previous profile still active")
+	}
+	if err := r.SetActive("missing"); !errors.Is(err, domain.ErrProfileNotFound) {
+		t.Errorf("SetActive(missing) error = %v; want %v", err, domain.ErrProfileNotFound)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r := mustOpen(t, t.TempDir())
+	work := mustCreate(t, r, "Work")
+	marker := filepath.Join(r.Dir(work.ID), "identity.key")
+	if err := os.WriteFile(marker, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{name: "active", id: r.Active().ID, wantErr: domain.ErrProfileActive},
+		{name: "missing", id: "missing", wantErr: domain.ErrProfileNotFound},
+		{name: "inactive", id: work.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Delete(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Delete() error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := r.Get(work.ID); !errors.Is(err, domain.ErrProfileNotFound) {
+		t.Errorf("Get(deleted) error = %v; want %v", err, domain.ErrProfileNotFound)
+	}
+	if _, err := os.Stat(r.Dir(work.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleted profile dir still exists: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// errClosed is returned by Put once the connection is gone.
+var errClosed = errors.New("relay connection closed")
+
+// Client is a connection to a relay: it puts envelopes in other clients'
+// mailboxes and drains its own. It is safe for concurrent use.
+type Client struct {
+	addr string
+	sess *handshake.Session
+
+	mu      sync.Mutex
+	waiting map[string][]chan error // Put calls awaiting the relay's answer, in order
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// Dial connects to the relay at address, "<public ID>@host:port", as self.
+// It fails with domain.ErrUnexpectedPeer if another node answers there.
+func Dial(ctx context.Context, address string, self handshake.Signer) (*Client, error) {
+	id, hostport, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", hostport)
+	if err != nil {
+		return nil, fmt.Errorf("dial relay: %w", err)
+	}
+	sess, err := handshake.Client(conn, self, id)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dial relay: %w", err)
+	}
+	return &Client{
+		addr:    address,
+		sess:    sess,
+		waiting: map[string][]chan error{},
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Address returns the address the client dialed.
+func (c *Client) Address() string {
+	return c.addr
+}
+
+// Close drops the connection; Serve then returns.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.sess.Conn().Close()
+	})
+	return nil
+}
+
+// Put leaves env in the mailbox of the client with the Public ID to and
+// waits until the relay stored it. id tells the envelope apart from the
+// others we sent to; putting an envelope again under the same ID is a
+// no-op. It fails with domain.ErrRelayRejected, for instance when the
+// mailbox is full. Put only returns while Serve is reading the answer.
+func (c *Client) Put(to, id string, env []byte) error {
+	return c.put(frame{Type: framePut, ID: id, To: to, Envelope: env})
+}
+
+// Forward hands env to the client with the Public ID to if it is
+// connected to the relay, and fails with domain.ErrRelayRejected if not.
+// The relay does not store env, so it is lost if the recipient drops
+// before taking it in. Like Put, it waits for the relay's answer.
+func (c *Client) Forward(to, id string, env []byte) error {
+	return c.put(frame{Type: framePut, ID: id, To: to, Envelope: env, Live: true})
+}
+
+// put sends the put frame f and waits for the relay's answer. The relay
+// answers puts in order, so puts of the same envelope, e.g. by a message's
+// sender and a flush of the mailbox, take the answers in turn.
+func (c *Client) put(f frame) error {
+	key := f.To + "/" + f.ID
+	answer := make(chan error, 1)
+	c.mu.Lock()
+	c.waiting[key] = append(c.waiting[key], answer)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.waiting[key] = slices.DeleteFunc(c.waiting[key], func(ch chan error) bool { return ch == answer })
+		if len(c.waiting[key]) == 0 {
+			delete(c.waiting, key)
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(f); err != nil {
+		return err
+	}
+	select {
+	case err := <-answer:
+		return err
+	case <-c.done:
+		return errClosed
+	case <-time.After(writeTimeout):
+		c.Close()
+		return fmt.Errorf("put envelope: relay did not answer")
+	}
+}
+
+// Post is Put without waiting for the relay. Handlers called by Serve must
+// use it, since Put waits for an answer Serve would read.
+func (c *Client) Post(to, id string, env []byte) error {
+	return c.send(frame{Type: framePut, ID: id, To: to, Envelope: env})
+}
+
+// send sends the put frame f, dropping the connection if that fails for
+// any reason but f's size.
+func (c *Client) send(f frame) error {
+	if err := writeFrame(c.sess, f); err != nil {
+		if !errors.Is(err, domain.ErrRelayRejected) {
+			c.Close()
+		}
+		return fmt.Errorf("put envelope: %w", err)
+	}
+	return nil
+}
+
+// Serve reads from the relay until the connection drops. It passes each
+// envelope from the mailbox to handle, in the order they arrived, and
+// acknowledges it unless handle fails, so that the relay deletes it; an
+// envelope that is not acknowledged comes again on the next connection.
+// Envelopes forwarded rather than stored are passed to handle too, but
+// come only once.
+func (c *Client) Serve(handle func(env []byte) error) error {
+	defer c.Close()
+	go c.keepAlive()
+	for {
+		f, err := readFrame(c.sess)
+		if err != nil {
+			return err
+		}
+		switch f.Type {
+		case frameDeliver:
+			if handle(f.Envelope) != nil || f.Seq == 0 {
+				continue
+			}
+			if err := writeFrame(c.sess, frame{Type: frameAck, Seq: f.Seq}); err != nil {
+				return err
+			}
+		case frameStored, frameRejected:
+			var result error
+			if f.Type == frameRejected {
+				result = fmt.Errorf("%w: %s", domain.ErrRelayRejected, f.Error)
+			}
+			key := f.To + "/" + f.ID
+			c.mu.Lock()
+			if waiting := c.waiting[key]; len(waiting) > 0 { // else nobody waiting
+				waiting[0] <- result
+				c.waiting[key] = waiting[1:]
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// keepAlive pings the relay while the connection is idle.
+func (c *Client) keepAlive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := writeFrame(c.sess, frame{Type: framePing}); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+	"quillet/internal/identity"
+)
+
+// An envelope is sealed to the recipient's identity key, in its X25519
+// form, under a key agreed with a fresh ephemeral key:
+//
+//	ephemeral key (32) || seal(sender key (32) || signature (64) || payload)
+//
+// The sender signs the payload together with the recipient's key and the
+// ephemeral key, so the recipient cannot pass the envelope off as sent to
+// someone else. Only the recipient learns who sent it.
+const (
+	envelopeContext = "quillet relay envelope v1\x00"
+	envelopeInfo    = "quillet relay envelope key"
+)
+
+const envelopeOverhead = 32 + ed25519.PublicKeySize + ed25519.SignatureSize + chacha20poly1305.Overhead
+
+// Decrypter agrees keys with the local identity key; *identity.Manager is one.
+type Decrypter interface {
+	ECDH(remote *ecdh.PublicKey) (ed25519.PublicKey, []byte, error)
+}
+
+// SealEnvelope encrypts payload for the holder of recipient, signed by self.
+func SealEnvelope(self handshake.Signer, recipient ed25519.PublicKey, payload []byte) ([]byte, error) {
+	rx, err := identity.X25519PublicKey(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("seal envelope: %w", err)
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("seal envelope: %w", err)
+	}
+	secret, err := eph.ECDH(rx)
+	if err != nil {
+		return nil, fmt.Errorf("seal envelope: %w", err)
+	}
+	aead, err := envelopeKey(secret, eph.PublicKey(), rx)
+	if err != nil {
+		return nil, err
+	}
+	sender, sig, err := self.Sign(signedEnvelope(recipient, eph.PublicKey(), payload))
+	if err != nil {
+		return nil, err
+	}
+
+	inner := make([]byte, 0, len(sender)+len(sig)+len(payload))
+	inner = append(append(append(inner, sender...), sig...), payload...)
+	return aead.Seal(eph.PublicKey().Bytes(), make([]byte, chacha20poly1305.NonceSize), inner, nil), nil
+}
+
+// OpenEnvelope decrypts an envelope sealed for self and returns who sent it and
+// the payload. It fails with domain.ErrInvalidEnvelope for an envelope
+// that is not for self or was tampered with.
+func OpenEnvelope(self Decrypter, env []byte) (sender ed25519.PublicKey, payload []byte, err error) {
+	if len(env) < envelopeOverhead {
+		return nil, nil, fmt.Errorf("%w: too short", domain.ErrInvalidEnvelope)
+	}
+	eph, err := ecdh.X25519().NewPublicKey(env[:32])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", domain.ErrInvalidEnvelope, err)
+	}
+	recipient, secret, err := self.ECDH(eph)
+	if errors.Is(err, domain.ErrLocked) || errors.Is(err, domain.ErrNoIdentity) {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", domain.ErrInvalidEnvelope, err)
+	}
+	rx, err := identity.X25519PublicKey(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := envelopeKey(secret, eph, rx)
+	if err != nil {
+		return nil, nil, err
+	}
+	inner, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), env[32:], nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: cannot decrypt", domain.ErrInvalidEnvelope)
+	}
+
+	sender = ed25519.PublicKey(inner[:ed25519.PublicKeySize])
+	sig := inner[ed25519.PublicKeySize : ed25519.PublicKeySize+ed25519.SignatureSize]
+	payload = inner[ed25519.PublicKeySize+ed25519.SignatureSize:]
+	if !ed25519.Verify(sender, signedEnvelope(recipient, eph, payload), sig) {
+		return nil, nil, fmt.Errorf("%w: bad signature", domain.ErrInvalidEnvelope)
+	}
+	return sender, payload, nil
+}
+
+// signedEnvelope is what the sender of an envelope signs.
+func signedEnvelope(recipient ed25519.PublicKey, eph *ecdh.PublicKey, payload []byte) []byte {
+	b := make([]byte, 0, len(envelopeContext)+len(recipient)+32+len(payload))
+	b = append(b, envelopeContext...)
+	b = append(b, recipient...)
+	b = append(b, eph.Bytes()...)
+	return append(b, payload...)
+}
+
+// envelopeKey derives the key an envelope is sealed under.
+func envelopeKey(secret []byte, eph, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(eph.Bytes(), recipient.Bytes()...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(envelopeInfo)), key); err != nil {
+		return nil, fmt.Errorf("derive envelope key: %w", err)
+	}
+	return chacha20poly1305.New(key)
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+func newSigner(t *testing.T) *identity.Manager {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return identity.NewManagerFor(keys, domain.User{DisplayName: "Peer"})
+}
+
+func publicKey(t *testing.T, m *identity.Manager) ed25519.PublicKey {
+	t.Helper()
+	u, err := m.Profile()
+	if err != nil {
+		t.Fatalf("Profile() error = %v", err)
+	}
+	key, err := identity.ParsePublicKey(u.PublicKey)
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	return key
+}
+
+func TestEnvelope(t *testing.T) {
+	alice, bob, mallory := newSigner(t), newSigner(t), newSigner(t)
+	payload := []byte(`{"type":"message"}`)
+	env, err := SealEnvelope(alice, publicKey(t, bob), payload)
+	if err != nil {
+		t.Fatalf("SealEnvelope() error = %v", err)
+	}
+	if bytes.Contains(env, payload) {
+		t.Error("envelope contains the payload in the clear")
+	}
+
+	sender, got, err := OpenEnvelope(bob, env)
+	if err != nil || !bytes.Equal(got, payload) || domain.PublicIDFromKey(sender) != alice.PublicID() {
+		t.Fatalf("OpenEnvelope() = %x, %q, %v; want %q from Alice", sender, got, err, payload)
+	}
+
+	tampered := bytes.Clone(env)
+	tampered[len(tampered)-1] ^= 1
+	for name, tt := range map[string]struct {
+		self *identity.Manager
+		env  []byte
+	}{
+		"other recipient": {mallory, env},
+		"tampered":        {bob, tampered},
+		"truncated":       {bob, env[:envelopeOverhead-1]},
+	} {
+		if _, _, err := OpenEnvelope(tt.self, tt.env); !errors.Is(err, domain.ErrInvalidEnvelope) {
+			t.Errorf("OpenEnvelope(%s) error = %v; want %v", name, err, domain.ErrInvalidEnvelope)
+		}
+	}
+
+	locked, err := identity.OpenManager(filepath.Join(t.TempDir(), "identity.key"))
+	if err != nil {
+		t.Fatalf("OpenManager() error = %v", err)
+	}
+	if _, _, err := OpenEnvelope(locked, env); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Errorf("OpenEnvelope() without identity error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+}
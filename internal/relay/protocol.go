@@ -0,0 +1,98 @@
+// Package relay stores messages for recipients who are offline and hands
+// them over when they connect. Clients reach the relay over the same
+// authenticated handshake as peers, so the relay knows each client by its
+// identity key: a client may leave envelopes in anyone's mailbox, but only
+// drain its own. Envelopes are sealed end to end (see envelope.go); the
+// relay sees who sends to whom, when, and how much, but not what.
+//
+// Envelopes that are only worth something right away, like the signals
+// peers punch holes through their NATs with, are forwarded to recipients
+// that are connected instead of stored. For hole punching, the relay also
+// answers binding requests (see package nat) on the UDP port of its TCP
+// port, and on the port above for telling NAT types apart.
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// Connection timing, shared by the relay and its clients.
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	idleTimeout  = 3 * pingInterval // a connection silent this long is gone
+)
+
+// maxIDLength bounds the ID a client gives an envelope.
+const maxIDLength = 128
+
+// frameType tells what a frame carries. Both ends ignore types they do not
+// know, so newer versions can add some.
+type frameType string
+
+const (
+	framePut      frameType = "put"      // client: store Envelope for To under ID, or just forward it if Live
+	frameStored   frameType = "stored"   // relay: the envelope put under ID is stored
+	frameRejected frameType = "rejected" // relay: the envelope put under ID is not, see Error
+	frameDeliver  frameType = "deliver"  // relay: Envelope number Seq from the client's mailbox, 0 if forwarded
+	frameAck      frameType = "ack"      // client: envelope Seq was handled and can go
+	framePing     frameType = "ping"     // keeps an idle connection alive
+)
+
+var errInvalidFrame = errors.New("invalid frame")
+
+// frame is the unit exchanged with a relay, encoded as JSON.
+type frame struct {
+	Type     frameType `json:"type"`
+	ID       string    `json:"id,omitempty"`
+	To       string    `json:"to,omitempty"`
+	Seq      int64     `json:"seq,omitempty"`
+	Envelope []byte    `json:"envelope,omitempty"`
+	Live     bool      `json:"live,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// writeFrame encodes f and sends it over sess.
+func writeFrame(sess *handshake.Session, f frame) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if len(b) > handshake.MaxFrameSize {
+		return fmt.Errorf("%w: %d bytes", domain.ErrRelayRejected, len(b))
+	}
+	return sess.WriteFrame(b, writeTimeout)
+}
+
+// readFrame receives and decodes the next frame from sess.
+func readFrame(sess *handshake.Session) (frame, error) {
+	sess.Conn().SetReadDeadline(time.Now().Add(idleTimeout))
+	b, err := sess.ReadFrame()
+	if err != nil {
+		return frame{}, err
+	}
+	var f frame
+	if err := json.Unmarshal(b, &f); err != nil {
+		return frame{}, fmt.Errorf("%w: %v", errInvalidFrame, err)
+	}
+	return f, nil
+}
+
+// ParseAddress splits a relay address, the relay's Public ID and where it
+// listens: "<public ID>@host:port". The ID is what lets clients tell the
+// relay from anyone else answering there.
+func ParseAddress(address string) (id, hostport string, err error) {
+	id, hostport, ok := strings.Cut(strings.TrimSpace(address), "@")
+	if !ok || len(id) != domain.PublicIDLength || hostport == "" {
+		return "", "", fmt.Errorf("%w: want <public ID>@host:port, got %q", domain.ErrInvalidAddress, address)
+	}
+	return strings.ToLower(id), hostport, nil
+}
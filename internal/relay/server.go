@@ -0,0 +1,411 @@
+package relay
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+
+	"quillet/internal/domain"
+	"quillet/internal/handshake"
+)
+
+// Defaults for Config.
+const (
+	DefaultListenAddress = ":47330"
+	DefaultTTL           = 7 * 24 * time.Hour
+	DefaultMaxEnvelopes  = 1000
+	DefaultMaxBytes      = 64 << 20
+
+	DefaultMaxSenderEnvelopes = 100
+	DefaultMaxSenderBytes     = 8 << 20
+)
+
+// janitorInterval is how often expired envelopes are deleted.
+const janitorInterval = time.Minute
+
+// deliverBatch is how many envelopes a mailbox hands over per query.
+const deliverBatch = 64
+
+// schema is the relay's database. An envelope is known to its sender by
+// the ID the sender gave it, and to the recipient by its seq.
+const schema = `
+CREATE TABLE IF NOT EXISTS envelopes (
+	seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient  TEXT    NOT NULL,
+	sender     TEXT    NOT NULL,
+	id         TEXT    NOT NULL,
+	body       BLOB    NOT NULL,
+	expires_at INTEGER NOT NULL,
+	UNIQUE (recipient, sender, id)
+);
+CREATE INDEX IF NOT EXISTS envelopes_expiry ON envelopes (expires_at);
+`
+
+// Config limits what a relay holds for each recipient. The sender limits
+// keep a single sender, e.g. a stranger with a throwaway identity, from
+// filling a mailbox for everyone else; 0 takes the smaller of the default
+// and the recipient limit.
+type Config struct {
+	TTL          time.Duration // how long an envelope waits for its recipient
+	MaxEnvelopes int           // envelopes waiting per recipient
+	MaxBytes     int64         // bytes waiting per recipient
+
+	MaxSenderEnvelopes int   // envelopes waiting per recipient from one sender
+	MaxSenderBytes     int64 // bytes waiting per recipient from one sender
+}
+
+// Server is a relay. It is safe for concurrent use.
+type Server struct {
+	self handshake.Signer
+	db   *sql.DB
+	cfg  Config
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	owners map[string]*owner // connected clients by Public ID
+}
+
+// owner is a client connected to drain its mailbox.
+type owner struct {
+	id   string
+	sess *handshake.Session
+	wake chan struct{} // new envelopes arrived
+
+	done      chan struct{} // closed by close
+	closeOnce sync.Once
+}
+
+func (o *owner) close() {
+	o.closeOnce.Do(func() {
+		close(o.done)
+		o.sess.Conn().Close()
+	})
+}
+
+// Open opens or creates the relay database at path. The relay proves
+// itself to clients with self.
+func Open(path string, self handshake.Signer, cfg Config) (*Server, error) {
+	if cfg.TTL <= 0 || cfg.MaxEnvelopes <= 0 || cfg.MaxBytes <= 0 || cfg.MaxSenderEnvelopes < 0 || cfg.MaxSenderBytes < 0 {
+		return nil, fmt.Errorf("open relay: limits must be positive: %+v", cfg)
+	}
+	if cfg.MaxSenderEnvelopes == 0 {
+		cfg.MaxSenderEnvelopes = min(DefaultMaxSenderEnvelopes, cfg.MaxEnvelopes)
+	}
+	if cfg.MaxSenderBytes == 0 {
+		cfg.MaxSenderBytes = min(DefaultMaxSenderBytes, cfg.MaxBytes)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("open relay: %w", err)
+	}
+	// One connection serializes writers, so quota checks cannot race.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open relay: %w", err)
+	}
+	return &Server{self: self, db: db, cfg: cfg, owners: map[string]*owner{}}, nil
+}
+
+// Close closes the database. Serve must have returned.
+func (s *Server) Close() error {
+	return s.db.Close()
+}
+
+// Serve serves clients on ln until ctx is cancelled, then closes ln and
+// waits for the connections to end.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+		ln.Close()
+		s.mu.Lock()
+		for _, o := range s.owners {
+			o.close()
+		}
+		s.mu.Unlock()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.janitor(ctx)
+	}()
+	defer s.wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("accept client", "error", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+// serve authenticates a client and runs its connection: it hands over
+// the client's mailbox and takes envelopes for others.
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	sess, err := handshake.Server(conn, s.self)
+	if err != nil {
+		slog.Debug("reject client", "remote", conn.RemoteAddr(), "error", err)
+		conn.Close()
+		return
+	}
+	o := &owner{
+		id:   domain.PublicIDFromKey(sess.Remote()),
+		sess: sess,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	defer o.close()
+	if !s.register(ctx, o) {
+		return
+	}
+	defer s.unregister(o)
+	slog.Debug("client connected", "client", o.id, "remote", conn.RemoteAddr())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.deliver(ctx, o)
+	}()
+
+	for {
+		f, err := readFrame(sess)
+		if err != nil {
+			slog.Debug("client disconnected", "client", o.id, "error", err)
+			return
+		}
+		switch f.Type {
+		case framePut:
+			reply := frame{Type: frameStored, ID: f.ID, To: f.To}
+			put := s.put
+			if f.Live {
+				put = s.forward
+			}
+			if err := put(ctx, o.id, f); err != nil {
+				reply = frame{Type: frameRejected, ID: f.ID, To: f.To, Error: err.Error()}
+			}
+			if err := writeFrame(sess, reply); err != nil {
+				return
+			}
+		case frameAck:
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM envelopes WHERE seq = ? AND recipient = ?`, f.Seq, o.id); err != nil {
+				slog.Warn("delete envelope", "error", err)
+			}
+		}
+	}
+}
+
+// register makes o the connection of its client, replacing an older one.
+func (s *Server) register(ctx context.Context, o *owner) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	if old := s.owners[o.id]; old != nil {
+		old.close()
+	}
+	s.owners[o.id] = o
+	return true
+}
+
+func (s *Server) unregister(o *owner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[o.id] == o {
+		delete(s.owners, o.id)
+	}
+}
+
+// put stores the envelope f carries from sender, unless the recipient's
+// mailbox is full, or sender's share of it. Putting an envelope again is
+// a no-op.
+func (s *Server) put(ctx context.Context, sender string, f frame) error {
+	if err := checkPut(f); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var count, size, senderCount, senderSize int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(length(body)), 0),
+			COALESCE(SUM(sender = ?), 0), COALESCE(SUM(CASE WHEN sender = ? THEN length(body) ELSE 0 END), 0)
+		FROM envelopes
+		WHERE recipient = ? AND expires_at > ?`, sender, sender, f.To, now.UnixMilli()).Scan(&count, &size, &senderCount, &senderSize)
+	if err != nil {
+		return err
+	}
+	if count+1 > int64(s.cfg.MaxEnvelopes) || size+int64(len(f.Envelope)) > s.cfg.MaxBytes {
+		return fmt.Errorf("%w: mailbox full", domain.ErrRelayRejected)
+	}
+	if senderCount+1 > int64(s.cfg.MaxSenderEnvelopes) || senderSize+int64(len(f.Envelope)) > s.cfg.MaxSenderBytes {
+		return fmt.Errorf("%w: too much waiting from this sender", domain.ErrRelayRejected)
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO envelopes (recipient, sender, id, body, expires_at)
+		VALUES (?, ?, ?, ?, ?)`, f.To, sender, f.ID, f.Envelope, now.Add(s.cfg.TTL).UnixMilli())
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.mu.Lock()
+		if o := s.owners[f.To]; o != nil {
+			select {
+			case o.wake <- struct{}{}:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// forward hands the envelope f carries to its recipient, without storing
+// it. It fails with domain.ErrRelayRejected if the recipient is not connected.
+func (s *Server) forward(_ context.Context, _ string, f frame) error {
+	if err := checkPut(f); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	o := s.owners[f.To]
+	s.mu.Unlock()
+	if o == nil {
+		return fmt.Errorf("%w: recipient offline", domain.ErrRelayRejected)
+	}
+	if err := writeFrame(o.sess, frame{Type: frameDeliver, Envelope: f.Envelope}); err != nil {
+		o.close()
+		return fmt.Errorf("%w: recipient offline", domain.ErrRelayRejected)
+	}
+	return nil
+}
+
+// checkPut validates a put frame.
+func checkPut(f frame) error {
+	switch {
+	case len(f.To) != domain.PublicIDLength:
+		return fmt.Errorf("%w: recipient %q", domain.ErrInvalidPublicID, f.To)
+	case f.ID == "" || len(f.ID) > maxIDLength:
+		return fmt.Errorf("%w: id %q", errInvalidFrame, f.ID)
+	case len(f.Envelope) < envelopeOverhead:
+		return fmt.Errorf("%w: too short", domain.ErrInvalidEnvelope)
+	}
+	return nil
+}
+
+// deliver hands o its mailbox, oldest first, and then whatever arrives
+// for it, until the connection drops. Envelopes stay until acknowledged,
+// so what o does not acknowledge comes again on its next connection. In
+// between, it pings o, so that both ends notice a dead connection.
+func (s *Server) deliver(ctx context.Context, o *owner) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	var last int64
+	for {
+		n, err := s.deliverBatch(ctx, o, &last)
+		if err != nil {
+			o.close()
+			return
+		}
+		if n == deliverBatch {
+			continue
+		}
+		select {
+		case <-o.done:
+			return
+		case <-o.wake:
+		case <-ticker.C:
+			if err := writeFrame(o.sess, frame{Type: framePing}); err != nil {
+				o.close()
+				return
+			}
+		}
+	}
+}
+
+// deliverBatch sends o the next envelopes after *last and advances it.
+func (s *Server) deliverBatch(ctx context.Context, o *owner, last *int64) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT seq, body FROM envelopes
+		WHERE recipient = ? AND seq > ? AND expires_at > ?
+		ORDER BY seq LIMIT ?`, o.id, *last, time.Now().UnixMilli(), deliverBatch)
+	if err != nil {
+		return 0, err
+	}
+	var batch []frame
+	for rows.Next() {
+		f := frame{Type: frameDeliver}
+		if err := rows.Scan(&f.Seq, &f.Envelope); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, f)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	for _, f := range batch {
+		if err := writeFrame(o.sess, f); err != nil {
+			return 0, err
+		}
+		*last = f.Seq
+	}
+	return len(batch), nil
+}
+
+// janitor deletes expired envelopes until ctx is cancelled.
+func (s *Server) janitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.expire(ctx, time.Now()); err != nil {
+				slog.Warn("expire envelopes", "error", err)
+			}
+		}
+	}
+}
+
+// expire deletes the envelopes that expired by now.
+func (s *Server) expire(ctx context.Context, now time.Time) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM envelopes WHERE expires_at <= ?`, now.UnixMilli())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		slog.Info("expired envelopes", "count", n)
+	}
+	return nil
+}
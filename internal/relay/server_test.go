@@ -0,0 +1,291 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+// startRelay runs a relay on loopback and returns its address.
+func startRelay(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	self := newSigner(t)
+	s, err := Open(filepath.Join(t.TempDir(), "relay.db"), self, cfg)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		s.Close()
+	})
+	return s, self.PublicID() + "@" + ln.Addr().String()
+}
+
+// mailbox is a client draining its mailbox into a channel.
+type mailbox struct {
+	*Client
+	envelopes chan []byte
+}
+
+func connect(t *testing.T, addr string, self *identity.Manager) *mailbox {
+	t.Helper()
+	c, err := Dial(context.Background(), addr, self)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	mb := &mailbox{Client: c, envelopes: make(chan []byte, 16)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Serve(func(env []byte) error {
+			mb.envelopes <- env
+			return nil
+		})
+	}()
+	t.Cleanup(func() {
+		c.Close()
+		<-done
+	})
+	return mb
+}
+
+func (mb *mailbox) next(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case env := <-mb.envelopes:
+		return env
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an envelope")
+		return nil
+	}
+}
+
+func (mb *mailbox) empty(t *testing.T) {
+	t.Helper()
+	select {
+	case env := <-mb.envelopes:
+		t.Errorf("unexpected envelope %q", env)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// waitDrained waits until the mailbox of id is empty.
+func waitDrained(t *testing.T, s *Server, id string) {
+	t.Helper()
+	for range 100 {
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM envelopes WHERE recipient = ?`, id).Scan(&n); err != nil {
+			t.Fatalf("count envelopes: %v", err)
+		}
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("mailbox not drained")
+}
+
+// fakeEnvelope is opaque to the relay, as long as it is long enough.
+func fakeEnvelope(s string) []byte {
+	return append(bytes.Repeat([]byte{0}, envelopeOverhead), s...)
+}
+
+func TestRelay(t *testing.T) {
+	s, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 3, MaxBytes: 1 << 20})
+	alice, bob := newSigner(t), newSigner(t)
+	a := connect(t, addr, alice)
+
+	// Held while Bob is away, once however often it is put.
+	for _, id := range []string{"1", "2", "1"} {
+		if err := a.Put(bob.PublicID(), id, fakeEnvelope(id)); err != nil {
+			t.Fatalf("Put(%s) error = %v", id, err)
+		}
+	}
+	b := connect(t, addr, bob)
+	for _, want := range []string{"1", "2"} {
+		if got := b.next(t); !bytes.Equal(got, fakeEnvelope(want)) {
+			t.Errorf("envelope = %q; want %q", got, fakeEnvelope(want))
+		}
+	}
+	b.empty(t)
+
+	// Delivered at once while Bob is connected.
+	if err := a.Put(bob.PublicID(), "3", fakeEnvelope("3")); err != nil {
+		t.Fatalf("Put(3) error = %v", err)
+	}
+	if got := b.next(t); !bytes.Equal(got, fakeEnvelope("3")) {
+		t.Errorf("envelope = %q; want %q", got, fakeEnvelope("3"))
+	}
+
+	// Acknowledged envelopes are gone on reconnecting.
+	waitDrained(t, s, bob.PublicID())
+	b.Close()
+	b = connect(t, addr, bob)
+	b.empty(t)
+
+	// Mallory's connection drains her own mailbox only.
+	if err := a.Put(bob.PublicID(), "4", fakeEnvelope("4")); err != nil {
+		t.Fatalf("Put(4) error = %v", err)
+	}
+	connect(t, addr, newSigner(t)).empty(t)
+	if got := b.next(t); !bytes.Equal(got, fakeEnvelope("4")) {
+		t.Errorf("envelope = %q; want %q", got, fakeEnvelope("4"))
+	}
+}
+
+func TestRelay_ConcurrentPuts(t *testing.T) {
+	_, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 3, MaxBytes: 1 << 20})
+	bob := newSigner(t)
+	a := connect(t, addr, newSigner(t))
+
+	// Each Put of the same envelope gets an answer, not just the last.
+	errs := make(chan error, 8)
+	for range cap(errs) {
+		go func() { errs <- a.Put(bob.PublicID(), "1", fakeEnvelope("1")) }()
+	}
+	for range cap(errs) {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Put() error = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for Put to return")
+		}
+	}
+	b := connect(t, addr, bob)
+	b.next(t)
+	b.empty(t)
+}
+
+func TestRelay_Forward(t *testing.T) {
+	s, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 3, MaxBytes: 1 << 20})
+	alice, bob := newSigner(t), newSigner(t)
+	a := connect(t, addr, alice)
+
+	if err := a.Forward(bob.PublicID(), "1", fakeEnvelope("1")); !errors.Is(err, domain.ErrRelayRejected) {
+		t.Errorf("Forward() to an offline recipient error = %v; want %v", err, domain.ErrRelayRejected)
+	}
+	b := connect(t, addr, bob)
+	b.empty(t)
+
+	if err := a.Forward(bob.PublicID(), "2", fakeEnvelope("2")); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if got := b.next(t); !bytes.Equal(got, fakeEnvelope("2")) {
+		t.Errorf("envelope = %q; want %q", got, fakeEnvelope("2"))
+	}
+	// Forwarded envelopes are not stored, so they do not come again.
+	waitDrained(t, s, bob.PublicID())
+	b.Close()
+	connect(t, addr, bob).empty(t)
+}
+
+func TestRelay_Limits(t *testing.T) {
+	s, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 2, MaxBytes: 3 * envelopeOverhead})
+	alice, bob := newSigner(t), newSigner(t)
+	a := connect(t, addr, alice)
+
+	tests := []struct {
+		name    string
+		to, id  string
+		env     []byte
+		wantErr error
+	}{
+		{"first", bob.PublicID(), "1", fakeEnvelope("1"), nil},
+		{"too many bytes", bob.PublicID(), "2", fakeEnvelope(string(make([]byte, 2*envelopeOverhead))), domain.ErrRelayRejected},
+		{"second", bob.PublicID(), "2", fakeEnvelope("2"), nil},
+		{"too many envelopes", bob.PublicID(), "3", fakeEnvelope("3"), domain.ErrRelayRejected},
+		{"other mailbox", alice.PublicID(), "3", fakeEnvelope("3"), nil},
+		{"bad recipient", "bob", "4", fakeEnvelope("4"), domain.ErrRelayRejected},
+		{"not an envelope", alice.PublicID(), "4", []byte("hi"), domain.ErrRelayRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Put(tt.to, tt.id, tt.env); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Put() error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Expired envelopes free the mailbox and are not delivered.
+	if err := s.expire(context.Background(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("expire() error = %v", err)
+	}
+	if err := a.Put(bob.PublicID(), "3", fakeEnvelope("3")); err != nil {
+		t.Errorf("Put() after expiry error = %v", err)
+	}
+	b := connect(t, addr, bob)
+	if got := b.next(t); !bytes.Equal(got, fakeEnvelope("3")) {
+		t.Errorf("envelope = %q; want only %q", got, fakeEnvelope("3"))
+	}
+	b.empty(t)
+}
+
+func TestRelay_SenderLimits(t *testing.T) {
+	_, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 4, MaxBytes: 1 << 20, MaxSenderEnvelopes: 2})
+	alice, bob, mallory := newSigner(t), newSigner(t), newSigner(t)
+	a, m := connect(t, addr, alice), connect(t, addr, mallory)
+
+	// A stranger cannot fill Bob's mailbox for his contacts.
+	for i, wantErr := range []error{nil, nil, domain.ErrRelayRejected} {
+		id := string(rune('1' + i))
+		if err := m.Put(bob.PublicID(), id, fakeEnvelope(id)); !errors.Is(err, wantErr) {
+			t.Errorf("Mallory's Put(%s) error = %v; want %v", id, err, wantErr)
+		}
+	}
+	if err := m.Put(alice.PublicID(), "1", fakeEnvelope("1")); err != nil {
+		t.Errorf("Mallory's Put() to another mailbox error = %v", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if err := a.Put(bob.PublicID(), id, fakeEnvelope(id)); err != nil {
+			t.Errorf("Alice's Put(%s) error = %v", id, err)
+		}
+	}
+	// The mailbox limit still holds.
+	if err := connect(t, addr, newSigner(t)).Put(bob.PublicID(), "1", fakeEnvelope("1")); !errors.Is(err, domain.ErrRelayRejected) {
+		t.Errorf("Put() to a full mailbox error = %v; want %v", err, domain.ErrRelayRejected)
+	}
+
+	bytesCapped := Config{TTL: time.Hour, MaxEnvelopes: 4, MaxBytes: 1 << 20, MaxSenderBytes: 2 * envelopeOverhead}
+	_, addr = startRelay(t, bytesCapped)
+	m = connect(t, addr, mallory)
+	if err := m.Put(bob.PublicID(), "1", fakeEnvelope("1")); err != nil {
+		t.Fatalf("Put(1) error = %v", err)
+	}
+	if err := m.Put(bob.PublicID(), "2", fakeEnvelope("2")); !errors.Is(err, domain.ErrRelayRejected) {
+		t.Errorf("Put() over the sender's bytes error = %v; want %v", err, domain.ErrRelayRejected)
+	}
+}
+
+func TestDial_UnexpectedRelay(t *testing.T) {
+	_, addr := startRelay(t, Config{TTL: time.Hour, MaxEnvelopes: 1, MaxBytes: 1 << 20})
+	_, hostport, _ := ParseAddress(addr)
+	imposter := newSigner(t).PublicID() + "@" + hostport
+	if _, err := Dial(context.Background(), imposter, newSigner(t)); !errors.Is(err, domain.ErrUnexpectedPeer) {
+		t.Errorf("Dial() error = %v; want %v", err, domain.ErrUnexpectedPeer)
+	}
+	for _, bad := range []string{"", "127.0.0.1:47330", "abc@127.0.0.1:47330", newSigner(t).PublicID() + "@"} {
+		if _, _, err := ParseAddress(bad); !errors.Is(err, domain.ErrInvalidAddress) {
+			t.Errorf("ParseAddress(%q) error = %v; want %v", bad, err, domain.ErrInvalidAddress)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package search holds the word matching shared by the message search
+// backends: case and diacritic folding, the per-word terms an index stores,
+// and verification of candidate messages with highlighted snippets.
+//
+// A word is a run of letters and digits. Query words match as prefixes of
+// message words; the words of a quoted phrase must be consecutive, all but
+// the last matching whole.
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxPrefix is the longest word prefix, in runes, that IndexTerms emits.
+// Longer query words are looked up by their first MaxPrefix runes and
+// confirmed by Match.
+const MaxPrefix = 12
+
+const (
+	snippetWords   = 16  // words in a snippet
+	snippetContext = 4   // words shown before the first match
+	excerptRunes   = 120 // length of a snippet without matches
+)
+
+// Fold lower-cases s and strips combining marks, so "Café" and "cafe",
+// or "Ёлка" and "елка", compare equal.
+func Fold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// span is the byte range of a word.
+type span struct{ start, end int }
+
+// words returns the words of s.
+func words(s string) []span {
+	var out []span
+	start := -1
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			out = append(out, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, span{start, len(s)})
+	}
+	return out
+}
+
+// foldedWords returns the folded words of s.
+func foldedWords(s string) []string {
+	spans := words(s)
+	out := make([]string, len(spans))
+	for i, sp := range spans {
+		out[i] = Fold(s[sp.start:sp.end])
+	}
+	return out
+}
+
+// prefix cuts a folded word to MaxPrefix runes.
+func prefix(word string) string {
+	i, n := 0, 0
+	for i < len(word) && n < MaxPrefix {
+		_, size := utf8.DecodeRuneInString(word[i:])
+		i += size
+		n++
+	}
+	return word[:i]
+}
+
+// IndexTerms returns the distinct terms to index content under: every
+// prefix of up to MaxPrefix runes of each folded word.
+func IndexTerms(content string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, w := range foldedWords(content) {
+		w = prefix(w)
+		for i := range w {
+			if i == 0 {
+				continue
+			}
+			if t := w[:i]; !seen[t] {
+				seen[t] = true
+				terms = append(terms, t)
+			}
+		}
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// Matcher checks messages against the terms of a domain.SearchQuery.
+type Matcher struct {
+	terms [][]string // folded words of each term
+}
+
+// NewMatcher compiles query terms. Terms without words are ignored.
+func NewMatcher(terms []string) *Matcher {
+	m := &Matcher{}
+	for _, t := range terms {
+		if w := foldedWords(t); len(w) > 0 {
+			m.terms = append(m.terms, w)
+		}
+	}
+	return m
+}
+
+// Empty reports whether the matcher has no terms and so matches anything.
+func (m *Matcher) Empty() bool {
+	return len(m.terms) == 0
+}
+
+// LookupTerms returns the index terms a matching message must have; see
+// IndexTerms.
+func (m *Matcher) LookupTerms() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, term := range m.terms {
+		for _, w := range term {
+			if t := prefix(w); !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// Match reports whether content contains every term and returns an
+// HTML-escaped snippet around the first match, matched words wrapped in
+// <mark>. An empty matcher matches with a plain excerpt.
+func (m *Matcher) Match(content string) (string, bool) {
+	if m.Empty() {
+		return excerpt(content), true
+	}
+	spans := words(content)
+	folded := make([]string, len(spans))
+	for i, sp := range spans {
+		folded[i] = Fold(content[sp.start:sp.end])
+	}
+
+	marked := make([]bool, len(spans))
+	for _, term := range m.terms {
+		found := false
+		for i := 0; i+len(term) <= len(folded); i++ {
+			if phraseAt(folded[i:], term) {
+				found = true
+				for k := range term {
+					marked[i+k] = true
+				}
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return snippet(content, spans, marked), true
+}
+
+// phraseAt reports whether words starts with phrase, its last word as a prefix.
+func phraseAt(words, phrase []string) bool {
+	last := len(phrase) - 1
+	for k, w := range phrase[:last] {
+		if words[k] != w {
+			return false
+		}
+	}
+	return strings.HasPrefix(words[last], phrase[last])
+}
+
+// snippet renders up to snippetWords words of content starting a little
+// before the first marked word.
+func snippet(content string, spans []span, marked []bool) string {
+	first := 0
+	for first < len(marked) && !marked[first] {
+		first++
+	}
+	from := max(first-snippetContext, 0)
+	to := min(from+snippetWords, len(spans))
+
+	var b strings.Builder
+	start, end := 0, len(content)
+	if from > 0 {
+		b.WriteString("…")
+		start = spans[from].start
+	}
+	if to < len(spans) {
+		end = spans[to-1].end
+	}
+	last := start
+	for i := from; i < to; i++ {
+		if !marked[i] {
+			continue
+		}
+		sp := spans[i]
+		b.WriteString(html.EscapeString(content[last:sp.start]))
+		b.WriteString("<mark>" + html.EscapeString(content[sp.start:sp.end]) + "</mark>")
+		last = sp.end
+	}
+	b.WriteString(html.EscapeString(content[last:end]))
+	if to < len(spans) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// excerpt returns the HTML-escaped start of content.
+func excerpt(content string) string {
+	if utf8.RuneCountInString(content) <= excerptRunes {
+		return html.EscapeString(content)
+	}
+	return html.EscapeString(string([]rune(content)[:excerptRunes])) + "…"
+}
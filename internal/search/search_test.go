@@ -0,0 +1,90 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Café", "cafe"},
+		{"ПРИВЕТ", "привет"},
+		{"Ёлка", "елка"},
+		{"Straße", "straße"},
+	}
+	for _, tt := range tests {
+		if got := Fold(tt.in); got != tt.want {
+			t.Errorf("Fold(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIndexTerms(t *testing.T) {
+	got := IndexTerms("Hi, hi! Мир")
+	want := []string{"h", "hi", "м", "ми", "мир"}
+	if !slices.Equal(got, want) {
+		t.Errorf("IndexTerms() = %q; want %q", got, want)
+	}
+
+	long := IndexTerms("internationalization")
+	if n := len(long); n != MaxPrefix {
+		t.Errorf("IndexTerms(long word) has %d terms; want %d", n, MaxPrefix)
+	}
+}
+
+func TestLookupTermsAreIndexed(t *testing.T) {
+	content := "Встречаемся в кафе, internationalization team"
+	indexed := IndexTerms(content)
+	for _, query := range []string{"встреч", "КАФЕ", "internationalization", "in te"} {
+		for _, term := range NewMatcher([]string{query}).LookupTerms() {
+			if !slices.Contains(indexed, term) {
+				t.Errorf("lookup term %q of %q is not indexed", term, query)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		terms   []string
+		content string
+		want    string
+		wantOK  bool
+	}{
+		{name: "prefix", terms: []string{"fri"}, content: "See you Friday", want: "See you <mark>Friday</mark>", wantOK: true},
+		{name: "every occurrence", terms: []string{"a"}, content: "a b A", want: "<mark>a</mark> b <mark>A</mark>", wantOK: true},
+		{name: "all terms", terms: []string{"see", "zebra"}, content: "See you Friday"},
+		{name: "cyrillic", terms: []string{"привет"}, content: "ПРИВЕТСТВУЮ!", want: "<mark>ПРИВЕТСТВУЮ</mark>!", wantOK: true},
+		{name: "diacritics", terms: []string{"cafe"}, content: "Le Café", want: "Le <mark>Café</mark>", wantOK: true},
+		{name: "phrase", terms: []string{"good morn"}, content: "good evening, good morning", want: "good evening, <mark>good</mark> <mark>morning</mark>", wantOK: true},
+		{name: "phrase needs whole words", terms: []string{"goo morning"}, content: "good morning"},
+		{name: "escapes html", terms: []string{"hi"}, content: `<b>"hi"</b>`, want: "&lt;b&gt;&#34;<mark>hi</mark>&#34;&lt;/b&gt;", wantOK: true},
+		{name: "no terms", terms: nil, content: "a < b", want: "a &lt; b", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NewMatcher(tt.terms).Match(tt.content)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Match(%q) = %q, %v; want %q, %v", tt.content, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMatch_SnippetWindow(t *testing.T) {
+	words := make([]string, 40)
+	for i := range words {
+		words[i] = "w"
+	}
+	words[20] = "needle"
+	got, ok := NewMatcher([]string{"needle"}).Match(strings.Join(words, " "))
+	if !ok {
+		t.Fatal("Match() = false; want true")
+	}
+	want := "…w w w w <mark>needle</mark>" + strings.Repeat(" w", snippetWords-snippetContext-1) + "…"
+	if got != want {
+		t.Errorf("Match() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+const contactColumns = `public_id, public_key, display_name, avatar_path,
+	is_blocked, is_verified, last_seen, added_at, retention_days, address`
+
+// scanner is satisfied by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanContact(k *dataKey, row scanner) (domain.Contact, error) {
+	var (
+		c      domain.Contact
+		sealed []byte
+	)
+	err := row.Scan(&c.PublicID, &sealed, &c.DisplayName, &c.AvatarPath,
+		&c.IsBlocked, &c.Verified, &c.LastSeen, &c.AddedAt, &c.RetentionDays, &c.Address)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	c.PublicKey, err = openContactKey(k, c.PublicID, sealed)
+	return c, err
+}
+
+// openContactKey decrypts a stored public key and encodes it as hex;
+// contacts added by Public ID alone have an empty key until the peer is
+// reached.
+func openContactKey(k *dataKey, contactID string, sealed []byte) (string, error) {
+	key, err := k.open(sealed, adContactKey+contactID)
+	if err != nil {
+		return "", fmt.Errorf("contact %s key: %w", contactID, err)
+	}
+	if len(key) == 0 {
+		return "", nil
+	}
+	return hex.EncodeToString(key), nil
+}
+
+func (s *Store) GetContacts(ctx context.Context) ([]domain.Contact, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("get contacts: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+contactColumns+` FROM contacts ORDER BY display_name, public_id`)
+	if err != nil {
+		return nil, fmt.Errorf("get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := []domain.Contact{}
+	for rows.Next() {
+		c, err := scanContact(k, rows)
+		if err != nil {
+			return nil, fmt.Errorf("get contacts: %w", err)
+		}
+		contacts = append(contacts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// GetContact returns one contact.
+func (s *Store) GetContact(ctx context.Context, contactID string) (*domain.Contact, error) {
+	c, err := s.getContact(ctx, contactID)
+	if err != nil {
+		return nil, fmt.Errorf("get contact: %w", err)
+	}
+	return &c, nil
+}
+
+func (s *Store) getContact(ctx context.Context, contactID string) (domain.Contact, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+contactColumns+` FROM contacts WHERE public_id = ?`, contactID)
+	c, err := scanContact(k, row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Contact{}, domain.ErrContactNotFound
+	}
+	return c, err
+}
+
+func (s *Store) AddContact(ctx context.Context, peer domain.PeerID, displayName string) (*domain.Contact, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("add contact: %w", err)
+	}
+	if peer.PublicID == s.self.PublicID() {
+		return nil, fmt.Errorf("add contact: %w", domain.ErrSelfContact)
+	}
+	key, err := hex.DecodeString(peer.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("add contact: %w", domain.ErrInvalidPublicKey)
+	}
+
+	now := time.Now().UnixMilli()
+	c := domain.Contact{
+		PublicID:    peer.PublicID,
+		PublicKey:   peer.PublicKey,
+		DisplayName: displayName,
+		LastSeen:    now,
+		AddedAt:     now,
+	}
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO contacts (public_id, public_key, display_name, last_seen, added_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (public_id) DO NOTHING`,
+		c.PublicID, k.seal(key, adContactKey+c.PublicID), c.DisplayName, c.LastSeen, c.AddedAt)
+	if err != nil {
+		return nil, fmt.Errorf("add contact: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("add contact: %w", domain.ErrContactExists)
+	}
+	return &c, nil
+}
+
+// RemoveContact deletes a contact together with its chat history.
+func (s *Store) RemoveContact(ctx context.Context, contactID string) error {
+	return s.updateContact(ctx, "remove contact", contactID,
+		`DELETE FROM contacts WHERE public_id = ?`)
+}
+
+func (s *Store) BlockContact(ctx context.Context, contactID string) error {
+	return s.updateContact(ctx, "block contact", contactID,
+		`UPDATE contacts SET is_blocked = 1 WHERE public_id = ?`)
+}
+
+func (s *Store) UnblockContact(ctx context.Context, contactID string) error {
+	return s.updateContact(ctx, "unblock contact", contactID,
+		`UPDATE contacts SET is_blocked = 0 WHERE public_id = ?`)
+}
+
+// MarkContactVerified records that contactID's safety number was compared.
+// It fails with domain.ErrContactKeyUnknown while the contact's key is not
+// known, since there is nothing to compare yet.
+func (s *Store) MarkContactVerified(ctx context.Context, contactID string) error {
+	c, err := s.getContact(ctx, contactID)
+	if err != nil {
+		return fmt.Errorf("mark contact verified: %w", err)
+	}
+	if c.PublicKey == "" {
+		return fmt.Errorf("mark contact verified: %w", domain.ErrContactKeyUnknown)
+	}
+	return s.updateContact(ctx, "mark contact verified", contactID,
+		`UPDATE contacts SET is_verified = 1 WHERE public_id = ?`)
+}
+
+func (s *Store) UnverifyContact(ctx context.Context, contactID string) error {
+	return s.updateContact(ctx, "unverify contact", contactID,
+		`UPDATE contacts SET is_verified = 0 WHERE public_id = ?`)
+}
+
+func (s *Store) SetContactAvatar(ctx context.Context, contactID, avatarPath string) error {
+	return s.updateContact(ctx, "set contact avatar", contactID,
+		`UPDATE contacts SET avatar_path = ? WHERE public_id = ?`, avatarPath)
+}
+
+// SetContactAddress sets the host:port contactID is dialed at, "" for none.
+func (s *Store) SetContactAddress(ctx context.Context, contactID, address string) error {
+	return s.updateContact(ctx, "set contact address", contactID,
+		`UPDATE contacts SET address = ? WHERE public_id = ?`, address)
+}
+
+// SetContactLastSeen records when contactID was last reachable.
+func (s *Store) SetContactLastSeen(ctx context.Context, contactID string, lastSeen int64) error {
+	return s.updateContact(ctx, "set contact last seen", contactID,
+		`UPDATE contacts SET last_seen = ? WHERE public_id = ?`, lastSeen)
+}
+
+// LearnContactKey fills in the key of a contact that was added by Public
+// ID alone, once the peer has proven it holds key, and leaves the contact
+// unverified: nobody has compared the new key. A known key is left as it
+// is. It fails with domain.ErrPublicIDMismatch if key does not
+// belong to contactID.
+func (s *Store) LearnContactKey(ctx context.Context, contactID string, key ed25519.PublicKey) error {
+	k, err := s.dataKey()
+	if err != nil {
+		return fmt.Errorf("learn contact key: %w", err)
+	}
+	if domain.PublicIDFromKey(key) != contactID {
+		return fmt.Errorf("learn contact key: %w", domain.ErrPublicIDMismatch)
+	}
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var sealed []byte
+		err := tx.QueryRowContext(ctx,
+			`SELECT public_key FROM contacts WHERE public_id = ?`, contactID).Scan(&sealed)
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrContactNotFound
+		}
+		if err != nil {
+			return err
+		}
+		known, err := openContactKey(k, contactID, sealed)
+		if err != nil || known != "" {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE contacts SET public_key = ?, is_verified = 0 WHERE public_id = ?`,
+			k.seal(key, adContactKey+contactID), contactID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("learn contact key: %w", err)
+	}
+	return nil
+}
+
+// ReplaceContactKey gives contactID a new key. A Public ID is derived
+// from the key, so the contact moves to the Public ID of key together
+// with its chat history; verification is cleared in the same
+// transaction. It returns the contact as it is now and whether it was
+// verified before; a key the contact already has changes nothing. It
+// fails with domain.ErrContactExists if the new Public ID is taken.
+func (s *Store) ReplaceContactKey(ctx context.Context, contactID string, key ed25519.PublicKey) (*domain.Contact, bool, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, false, fmt.Errorf("replace contact key: %w", err)
+	}
+	newID := domain.PublicIDFromKey(key)
+	if newID == s.self.PublicID() {
+		return nil, false, fmt.Errorf("replace contact key: %w", domain.ErrSelfContact)
+	}
+	var (
+		c           domain.Contact
+		wasVerified bool
+	)
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		old, err := scanContact(k, tx.QueryRowContext(ctx,
+			`SELECT `+contactColumns+` FROM contacts WHERE public_id = ?`, contactID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrContactNotFound
+		}
+		if err != nil {
+			return err
+		}
+		wasVerified = old.Verified
+		if newID == contactID {
+			c = old
+			return nil
+		}
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO contacts (`+contactColumns+`, rotation_acked_at)
+			SELECT ?, ?, display_name, avatar_path, is_blocked, 0,
+				last_seen, added_at, retention_days, address, rotation_acked_at
+			FROM contacts WHERE public_id = ?
+			ON CONFLICT (public_id) DO NOTHING`,
+			newID, k.seal(key, adContactKey+newID), contactID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return domain.ErrContactExists
+		}
+		// Incoming messages are sent by the chat's contact; see RebindOwnMessages.
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE messages SET chat_id = ?1,
+				sender_id = CASE WHEN sender_id = chat_id THEN ?1 ELSE sender_id END
+			WHERE chat_id = ?2`, newID, contactID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM contacts WHERE public_id = ?`, contactID); err != nil {
+			return err
+		}
+		c = old
+		c.PublicID, c.PublicKey, c.Verified = newID, hex.EncodeToString(key), false
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("replace contact key: %w", err)
+	}
+	return &c, wasVerified, nil
+}
+
+// RotateContactKey applies a contact's rotation certificate: the contact
+// that has cert's old key moves to the new one as with ReplaceContactKey.
+// It fails with domain.ErrInvalidRotation unless both signatures of cert
+// are valid, and with domain.ErrContactNotFound if no contact has the old
+// key, e.g. because cert was applied before.
+func (s *Store) RotateContactKey(ctx context.Context, cert identity.RotationCertificate) (*domain.Contact, bool, error) {
+	if err := cert.Verify(); err != nil {
+		return nil, false, fmt.Errorf("rotate contact key: %w", err)
+	}
+	oldKey, err := identity.ParsePublicKey(cert.OldPublicKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("rotate contact key: %w", err)
+	}
+	newKey, err := identity.ParsePublicKey(cert.NewPublicKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("rotate contact key: %w", err)
+	}
+	// A contact's key, once known, always belongs to its Public ID; see
+	// LearnContactKey.
+	return s.ReplaceContactKey(ctx, domain.PublicIDFromKey(oldKey), newKey)
+}
+
+// RotationAcked returns the IssuedAt of the newest of the local identity's
+// rotation certificates that contactID acknowledged, 0 if none.
+func (s *Store) RotationAcked(ctx context.Context, contactID string) (int64, error) {
+	var issuedAt int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT rotation_acked_at FROM contacts WHERE public_id = ?`, contactID).Scan(&issuedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("rotation acked: %w", domain.ErrContactNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("rotation acked: %w", err)
+	}
+	return issuedAt, nil
+}
+
+// AckRotation records that contactID acknowledged the rotation
+// certificate issued at issuedAt, and so every earlier one.
+func (s *Store) AckRotation(ctx context.Context, contactID string, issuedAt int64) error {
+	return s.updateContact(ctx, "ack rotation", contactID,
+		`UPDATE contacts SET rotation_acked_at = max(rotation_acked_at, ?) WHERE public_id = ?`, issuedAt)
+}
+
+// updateContact runs a statement whose last parameter is the contact ID and
+// fails with domain.ErrContactNotFound if it touched no row.
+func (s *Store) updateContact(ctx context.Context, op, contactID, query string, args ...any) error {
+	res, err := s.db.ExecContext(ctx, query, append(args, contactID)...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%s: %w", op, domain.ErrContactNotFound)
+	}
+	return nil
+}
+
+func (s *Store) GetSafetyNumber(ctx context.Context, contactID string) (string, error) {
+	me, err := s.self.Profile()
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	c, err := s.getContact(ctx, contactID)
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	myKey, err := identity.ParsePublicKey(me.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	theirKey, err := identity.ParsePublicKey(c.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	return identity.SafetyNumber(myKey, theirKey), nil
+}
@@ -0,0 +1,375 @@
+package store
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"quillet/internal/domain"
+	"quillet/internal/search"
+)
+
+const (
+	dataKeySize = chacha20poly1305.KeySize
+	termSize    = 16 // truncated HMAC-SHA256 of an index term
+
+	// Associated data prefixes bind a ciphertext to its row.
+	adContent    = "message content\x00"
+	adContactKey = "contact key\x00"
+	adDataKey    = "quillet data key v1"
+	termKeyInfo  = "quillet search terms v1"
+)
+
+// dataKey encrypts message content and contact keys and keys the blind
+// search index. It never changes for a database; only its wrapping does.
+type dataKey struct {
+	raw  []byte
+	aead cipher.AEAD
+	term []byte // HMAC key for index terms
+}
+
+func newDataKey(raw []byte) (*dataKey, error) {
+	aead, err := chacha20poly1305.NewX(raw)
+	if err != nil {
+		return nil, err
+	}
+	term := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, raw, nil, []byte(termKeyInfo)), term); err != nil {
+		return nil, err
+	}
+	return &dataKey{raw: raw, aead: aead, term: term}, nil
+}
+
+// seal encrypts plain for the row identified by ad; the nonce is prepended.
+func (k *dataKey) seal(plain []byte, ad string) []byte {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plain)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("store: read random nonce: %v", err)) // crypto/rand never fails on supported platforms
+	}
+	return k.aead.Seal(nonce, nonce, plain, []byte(ad))
+}
+
+// open decrypts a value written by seal, failing with
+// domain.ErrCorruptDatabase if it was altered or moved to another row.
+func (k *dataKey) open(sealed []byte, ad string) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, domain.ErrCorruptDatabase
+	}
+	plain, err := k.aead.Open(nil, sealed[:n], sealed[n:], []byte(ad))
+	if err != nil {
+		return nil, domain.ErrCorruptDatabase
+	}
+	return plain, nil
+}
+
+// indexTerm hashes a search term for message_terms.
+func (k *dataKey) indexTerm(term string) []byte {
+	mac := hmac.New(sha256.New, k.term)
+	mac.Write([]byte(term))
+	return mac.Sum(nil)[:termSize]
+}
+
+// wrapKey prepares the data key for the keyring: sealed with storageKey,
+// or stored as is when the identity has no passphrase.
+func wrapKey(raw, storageKey []byte) (stored []byte, sealed bool, err error) {
+	if storageKey == nil {
+		return raw, false, nil
+	}
+	wrap, err := newDataKey(storageKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return wrap.seal(raw, adDataKey), true, nil
+}
+
+// unwrapKey reverses wrapKey, failing with domain.ErrDatabaseKey if
+// storageKey is not the one the data key was wrapped with.
+func unwrapKey(stored []byte, sealed bool, storageKey []byte) ([]byte, error) {
+	if !sealed {
+		if storageKey != nil {
+			return nil, domain.ErrDatabaseKey
+		}
+		return stored, nil
+	}
+	if storageKey == nil {
+		return nil, domain.ErrDatabaseKey
+	}
+	wrap, err := newDataKey(storageKey)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := wrap.open(stored, adDataKey)
+	if err != nil {
+		return nil, domain.ErrDatabaseKey
+	}
+	return raw, nil
+}
+
+// Unlock loads the data key with storageKey, the identity's
+// identity.Manager.StorageKey. The first unlock of a database creates the
+// data key and encrypts everything stored before encryption existed.
+// Unlocking an unlocked store is a no-op.
+//
+// A re-key interrupted after StageKey is settled here: if storageKey opens
+// the staged wrapping, the new identity.key made it to disk and the staged
+// wrapping replaces the old one; if it opens the old one, it did not and
+// the staged wrapping is dropped.
+func (s *Store) Unlock(ctx context.Context, storageKey []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key != nil {
+		return nil
+	}
+	var (
+		stored, next       []byte
+		sealed, nextSealed sql.NullBool
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT data_key, sealed, next_data_key, next_sealed FROM keyring WHERE id = 1`).Scan(&stored, &sealed, &next, &nextSealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		k, err := s.createKey(ctx, storageKey)
+		if err != nil {
+			return fmt.Errorf("unlock store: %w", err)
+		}
+		s.key = k
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unlock store: %w", err)
+	}
+	raw, err := unwrapKey(stored, sealed.Bool, storageKey)
+	settle := `UPDATE keyring SET next_data_key = NULL, next_sealed = NULL WHERE id = 1`
+	if errors.Is(err, domain.ErrDatabaseKey) && next != nil {
+		raw, err = unwrapKey(next, nextSealed.Bool, storageKey)
+		settle = `UPDATE keyring SET data_key = next_data_key, sealed = next_sealed,
+			next_data_key = NULL, next_sealed = NULL WHERE id = 1`
+	}
+	if err != nil {
+		return fmt.Errorf("unlock store: %w", err)
+	}
+	if next != nil {
+		if _, err := s.db.ExecContext(ctx, settle); err != nil {
+			return fmt.Errorf("unlock store: %w", err)
+		}
+	}
+	k, err := newDataKey(raw)
+	if err != nil {
+		return fmt.Errorf("unlock store: %w", err)
+	}
+	s.key = k
+	return nil
+}
+
+// Lock drops the data key. Calls already holding it finish normally.
+func (s *Store) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = nil
+}
+
+// Locked reports whether the data key is not loaded.
+func (s *Store) Locked() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key == nil
+}
+
+// Re-keying wraps the data key with a new storage key, e.g. after the
+// passphrase changed; the data itself is not re-encrypted. The storage key
+// comes from identity.key, which is replaced at the same time, so the
+// keyring holds both wrappings in between: StageKey adds the new one
+// before identity.key is written, and CommitKey drops the old one after,
+// or DiscardKey the new one if writing failed. See Unlock for a re-key
+// that a crash interrupted.
+
+// StageKey wraps the data key with storageKey beside the current wrapping.
+func (s *Store) StageKey(ctx context.Context, storageKey []byte) error {
+	k, err := s.dataKey()
+	if err != nil {
+		return fmt.Errorf("stage store key: %w", err)
+	}
+	stored, sealed, err := wrapKey(k.raw, storageKey)
+	if err != nil {
+		return fmt.Errorf("stage store key: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE keyring SET next_data_key = ?, next_sealed = ? WHERE id = 1`, stored, sealed)
+	if err != nil {
+		return fmt.Errorf("stage store key: %w", err)
+	}
+	return nil
+}
+
+// CommitKey makes the wrapping staged by StageKey the only one. Without
+// one, it does nothing.
+func (s *Store) CommitKey(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE keyring SET data_key = next_data_key, sealed = next_sealed,
+			next_data_key = NULL, next_sealed = NULL
+		WHERE id = 1 AND next_data_key IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("commit store key: %w", err)
+	}
+	return nil
+}
+
+// DiscardKey drops the wrapping staged by StageKey, if any.
+func (s *Store) DiscardKey(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE keyring SET next_data_key = NULL, next_sealed = NULL WHERE id = 1`)
+	if err != nil {
+		return fmt.Errorf("discard store key: %w", err)
+	}
+	return nil
+}
+
+// dataKey returns the data key, or domain.ErrLocked before Unlock.
+func (s *Store) dataKey() (*dataKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.key == nil {
+		return nil, domain.ErrLocked
+	}
+	return s.key, nil
+}
+
+// createKey generates the data key and, in one transaction, stores it and
+// encrypts the plaintext rows. Afterwards it drops what still holds the
+// plaintext: the WAL and the backups taken by earlier migrations.
+// Must be called with s.mu held.
+func (s *Store) createKey(ctx context.Context, storageKey []byte) (*dataKey, error) {
+	raw := make([]byte, dataKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+	k, err := newDataKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	stored, sealed, err := wrapKey(raw, storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO keyring (id, data_key, sealed) VALUES (1, ?, ?)`, stored, sealed); err != nil {
+			return err
+		}
+		return encryptPlaintext(ctx, tx, k)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	for v := range SchemaVersion() {
+		if err := os.Remove(BackupPath(s.path, v)); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("remove plaintext backup: %w", err)
+		}
+	}
+	return k, nil
+}
+
+// encryptPlaintext encrypts every message and contact key in place and
+// indexes the messages.
+func encryptPlaintext(ctx context.Context, tx *sql.Tx, k *dataKey) error {
+	type plainMessage struct {
+		seq     int64
+		id      string
+		content string
+	}
+	var msgs []plainMessage
+	rows, err := tx.QueryContext(ctx, `SELECT seq, id, content FROM messages`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var m plainMessage
+		if err := rows.Scan(&m.seq, &m.id, &m.content); err != nil {
+			rows.Close()
+			return err
+		}
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if err := writeContent(ctx, tx, k, m.seq, m.id, m.content); err != nil {
+			return err
+		}
+	}
+
+	type plainKey struct {
+		id  string
+		key []byte
+	}
+	var keys []plainKey
+	rows, err = tx.QueryContext(ctx, `SELECT public_id, public_key FROM contacts`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c plainKey
+		if err := rows.Scan(&c.id, &c.key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range keys {
+		_, err := tx.ExecContext(ctx, `UPDATE contacts SET public_key = ? WHERE public_id = ?`,
+			k.seal(c.key, adContactKey+c.id), c.id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeContent replaces the content of message seq and re-indexes it.
+func writeContent(ctx context.Context, tx *sql.Tx, k *dataKey, seq int64, id, content string) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET content = ? WHERE seq = ?`,
+		k.seal([]byte(content), adContent+id), seq); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_terms WHERE seq = ?`, seq); err != nil {
+		return err
+	}
+	return indexContent(ctx, tx, k, seq, content)
+}
+
+// indexContent adds the blind index terms of a message.
+func indexContent(ctx context.Context, tx *sql.Tx, k *dataKey, seq int64, content string) error {
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO message_terms (term, seq) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range search.IndexTerms(content) {
+		if _, err := stmt.ExecContext(ctx, k.indexTerm(t), seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"quillet/internal/domain"
+)
+
+func testStorageKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestStore_LockedUntilUnlock(t *testing.T) {
+	s := mustOpenLocked(t, filepath.Join(t.TempDir(), FileName), newSelf(t))
+
+	if _, err := s.GetContacts(newCtx()); !errors.Is(err, domain.ErrLocked) {
+		t.Fatalf("GetContacts() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := s.StageKey(newCtx(), nil); !errors.Is(err, domain.ErrLocked) {
+		t.Fatalf("StageKey() while locked error = %v; want %v", err, domain.ErrLocked)
+	}
+	if err := s.Unlock(newCtx(), nil); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	mustAddContact(t, s, "Alice")
+
+	s.Lock()
+	if _, err := s.SearchMessages(newCtx(), "x", "", 0, ""); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("SearchMessages() after Lock error = %v; want %v", err, domain.ErrLocked)
+	}
+}
+
+func TestStore_NothingInClearOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	s := mustOpenLocked(t, path, newSelf(t))
+	if err := s.Unlock(newCtx(), testStorageKey(1)); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	alice := mustAddContact(t, s, "Alice")
+	if _, err := s.SendMessage(newCtx(), alice.PublicID, "the walrus is in the fridge"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	s.Close()
+
+	for _, name := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if bytes.Contains(data, []byte("walrus")) {
+			t.Errorf("%s contains message content in clear", filepath.Base(name))
+		}
+		if bytes.Contains(bytes.ToLower(data), []byte(alice.PublicKey)) {
+			t.Errorf("%s contains the contact key in clear", filepath.Base(name))
+		}
+	}
+}
+
+// mustRekey re-keys s to storageKey, as a passphrase change does.
+func mustRekey(t *testing.T, s *Store, storageKey []byte) {
+	t.Helper()
+	if err := s.StageKey(newCtx(), storageKey); err != nil {
+		t.Fatalf("StageKey() error = %v", err)
+	}
+	if err := s.CommitKey(newCtx()); err != nil {
+		t.Fatalf("CommitKey() error = %v", err)
+	}
+}
+
+func TestStore_Rekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	self := newSelf(t)
+	s := mustOpenLocked(t, path, self)
+	if err := s.Unlock(newCtx(), testStorageKey(1)); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	mustAddContact(t, s, "Alice")
+	mustRekey(t, s, testStorageKey(2))
+	s.Close()
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr error
+	}{
+		{name: "old key", key: testStorageKey(1), wantErr: domain.ErrDatabaseKey},
+		{name: "no key", key: nil, wantErr: domain.ErrDatabaseKey},
+		{name: "new key", key: testStorageKey(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mustOpenLocked(t, path, self)
+			if err := s.Unlock(newCtx(), tt.key); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unlock() error = %v; want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			contacts, err := s.GetContacts(newCtx())
+			if err != nil || len(contacts) != 1 {
+				t.Fatalf("GetContacts() = %+v, %v; want Alice", contacts, err)
+			}
+			// Removing the passphrase stores the data key unwrapped.
+			mustRekey(t, s, nil)
+			s.Close()
+			mustOpen(t, path, self)
+		})
+	}
+}
+
+func TestStore_InterruptedRekey(t *testing.T) {
+	// Between StageKey and CommitKey, identity.key may or may not have been
+	// replaced; either storage key opens the store, and the one that does
+	// settles which wrapping stays.
+	tests := []struct {
+		name          string
+		key, otherKey []byte
+	}{
+		{name: "identity.key replaced", key: testStorageKey(2), otherKey: testStorageKey(1)},
+		{name: "identity.key kept", key: testStorageKey(1), otherKey: testStorageKey(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			self := newSelf(t)
+			s := mustOpenLocked(t, path, self)
+			if err := s.Unlock(newCtx(), testStorageKey(1)); err != nil {
+				t.Fatalf("Unlock() error = %v", err)
+			}
+			mustAddContact(t, s, "Alice")
+			if err := s.StageKey(newCtx(), testStorageKey(2)); err != nil {
+				t.Fatalf("StageKey() error = %v", err)
+			}
+			s.Close()
+
+			s = mustOpenLocked(t, path, self)
+			if err := s.Unlock(newCtx(), tt.key); err != nil {
+				t.Fatalf("Unlock() error = %v", err)
+			}
+			if contacts, err := s.GetContacts(newCtx()); err != nil || len(contacts) != 1 {
+				t.Fatalf("GetContacts() = %+v, %v; want Alice", contacts, err)
+			}
+			s.Close()
+
+			s = mustOpenLocked(t, path, self)
+			if err := s.Unlock(newCtx(), tt.otherKey); !errors.Is(err, domain.ErrDatabaseKey) {
+				t.Errorf("Unlock(other key) after settling error = %v; want %v", err, domain.ErrDatabaseKey)
+			}
+		})
+	}
+}
+
+func TestStore_EncryptsOnFirstUnlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	loadFixture(t, path, 2)
+	s := mustOpenLocked(t, path, newSelf(t))
+	if _, err := os.Stat(BackupPath(path, 2)); err != nil {
+		t.Fatalf("no backup before upgrade: %v", err)
+	}
+	if err := s.Unlock(newCtx(), testStorageKey(1)); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+
+	var plain int
+	s.db.QueryRow(`SELECT COUNT(*) FROM messages WHERE typeof(content) = 'text'`).Scan(&plain)
+	if plain != 0 {
+		t.Errorf("%d messages left in clear", plain)
+	}
+	if _, err := os.Stat(BackupPath(path, 2)); !os.IsNotExist(err) {
+		t.Errorf("plaintext backup kept: %v", err)
+	}
+	msgs, err := s.GetMessages(newCtx(), fixtureContact, 0, "")
+	if err != nil || len(msgs) != 2 || msgs[0].Content != "Привет, fixture!" {
+		t.Errorf("GetMessages() = %+v, %v; want the fixture history", msgs, err)
+	}
+}
+
+func TestStore_TamperedRow(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, alice.PublicID, "m1", 1)
+	mustReceive(t, s, alice.PublicID, "m2", 2)
+
+	// Ciphertext is bound to its message; moving it is detected.
+	_, err := s.db.Exec(`UPDATE messages SET content = (SELECT content FROM messages WHERE id = 'm2') WHERE id = 'm1'`)
+	if err != nil {
+		t.Fatalf("swap content: %v", err)
+	}
+	if _, err := s.GetMessages(newCtx(), alice.PublicID, 0, ""); !errors.Is(err, domain.ErrCorruptDatabase) {
+		t.Errorf("GetMessages() error = %v; want %v", err, domain.ErrCorruptDatabase)
+	}
+}
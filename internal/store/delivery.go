@@ -0,0 +1,162 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"quillet/internal/domain"
+)
+
+// statusRank orders the delivery lifecycle of an outgoing message. Acks
+// can arrive out of order, so a status only ever moves up; a failed
+// message is still delivered if an ack turns up after all.
+func statusRank(s domain.MessageStatus) int {
+	switch s {
+	case domain.StatusSent:
+		return 1
+	case domain.StatusDelivered:
+		return 2
+	case domain.StatusRead:
+		return 3
+	}
+	return 0 // sending, failed
+}
+
+// ReceiveMessage stores a message that arrived from the contact whose
+// chat msg.ChatID names. It reports false, storing nothing, for a message
+// it already holds, e.g. one sent again because its ack got lost.
+func (s *Store) ReceiveMessage(ctx context.Context, msg domain.Message) (bool, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return false, fmt.Errorf("receive message: %w", err)
+	}
+	var isNew bool
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, msg.ChatID); err != nil {
+			return err
+		}
+		isNew, err = insertIfNew(ctx, tx, k, msg)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("receive message: %w", err)
+	}
+	return isNew, nil
+}
+
+// UpdateMessageStatus advances an outgoing message in contactID's chat to
+// status and reports whether it changed; see statusRank. A delivered
+// message leaves the outbox. It fails with domain.ErrMessageNotFound if
+// there is no such outgoing message.
+func (s *Store) UpdateMessageStatus(ctx context.Context, contactID, messageID string, status domain.MessageStatus) (bool, error) {
+	var changed bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var current domain.MessageStatus
+		err := tx.QueryRowContext(ctx, `
+			SELECT status FROM messages
+			WHERE id = ? AND chat_id = ? AND sender_id <> chat_id`, messageID, contactID).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if statusRank(status) <= statusRank(current) {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE messages SET status = ? WHERE id = ?`, status, messageID); err != nil {
+			return err
+		}
+		changed = true
+		if statusRank(status) >= statusRank(domain.StatusDelivered) {
+			return dequeue(ctx, tx, messageID)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("update message status: %w", err)
+	}
+	return changed, nil
+}
+
+// MarkReadUpTo marks every outgoing message of contactID's chat up to and
+// including messageID as read, as a read receipt for messageID says, and
+// returns the IDs of those that were not read before. They leave the outbox.
+func (s *Store) MarkReadUpTo(ctx context.Context, contactID, messageID string) ([]string, error) {
+	var ids []string
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var ts, row int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT timestamp, rowid FROM messages
+			WHERE id = ? AND chat_id = ? AND sender_id <> chat_id`, messageID, contactID).Scan(&ts, &row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE messages SET status = 'read'
+			WHERE chat_id = ? AND sender_id <> chat_id AND status <> 'read'
+				AND (timestamp, rowid) <= (?, ?)
+			RETURNING id`, contactID, ts, row)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		for _, id := range ids {
+			if err := dequeue(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mark read up to: %w", err)
+	}
+	return ids, nil
+}
+
+// PendingMessages returns the outgoing messages of contactID's chat that
+// the contact has not acknowledged, oldest first: those still sending and
+// those sent on a connection that may have dropped before the ack came.
+func (s *Store) PendingMessages(ctx context.Context, contactID string) ([]domain.Message, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("pending messages: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+messageColumns+` FROM messages
+		WHERE chat_id = ? AND sender_id <> chat_id AND status IN ('sending', 'sent')
+		ORDER BY timestamp, rowid`, contactID)
+	if err != nil {
+		return nil, fmt.Errorf("pending messages: %w", err)
+	}
+	defer rows.Close()
+
+	msgs := []domain.Message{}
+	for rows.Next() {
+		m, err := scanMessage(k, rows)
+		if err != nil {
+			return nil, fmt.Errorf("pending messages: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("pending messages: %w", err)
+	}
+	return msgs, nil
+}
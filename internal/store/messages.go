@@ -0,0 +1,330 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"quillet/internal/domain"
+)
+
+const messageColumns = `id, chat_id, sender_id, content, timestamp, status`
+
+func scanMessage(k *dataKey, row scanner) (domain.Message, error) {
+	var (
+		m      domain.Message
+		sealed []byte
+	)
+	if err := row.Scan(&m.ID, &m.ChatID, &m.SenderID, &sealed, &m.Timestamp, &m.Status); err != nil {
+		return domain.Message{}, err
+	}
+	content, err := openContent(k, m.ID, sealed)
+	m.Content = content
+	return m, err
+}
+
+func openContent(k *dataKey, messageID string, sealed []byte) (string, error) {
+	content, err := k.open(sealed, adContent+messageID)
+	if err != nil {
+		return "", fmt.Errorf("message %s: %w", messageID, err)
+	}
+	return string(content), nil
+}
+
+// GetChatSummaries returns one summary per contact, newest conversation first.
+// A message is unread if it is incoming (sent by the chat's contact) and
+// not yet marked read.
+func (s *Store) GetChatSummaries(ctx context.Context) ([]domain.ChatSummary, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.public_id, c.public_key, c.display_name, c.avatar_path,
+			c.is_blocked, c.is_verified, c.last_seen, c.added_at, c.retention_days, c.address,
+			(SELECT COUNT(*) FROM messages u
+			 WHERE u.chat_id = c.public_id AND u.sender_id = c.public_id AND u.status <> 'read'),
+			m.id, m.chat_id, m.sender_id, m.content, m.timestamp, m.status
+		FROM contacts c
+		LEFT JOIN messages m ON m.rowid = (
+			SELECT rowid FROM messages
+			WHERE chat_id = c.public_id
+			ORDER BY timestamp DESC, rowid DESC
+			LIMIT 1)
+		ORDER BY COALESCE(m.timestamp, c.added_at) DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []domain.ChatSummary{}
+	for rows.Next() {
+		var (
+			cs   domain.ChatSummary
+			key  []byte
+			last struct {
+				id, chatID, senderID, status sql.NullString
+				content                      []byte
+				timestamp                    sql.NullInt64
+			}
+		)
+		c := &cs.Contact
+		err := rows.Scan(&c.PublicID, &key, &c.DisplayName, &c.AvatarPath,
+			&c.IsBlocked, &c.Verified, &c.LastSeen, &c.AddedAt, &c.RetentionDays, &c.Address,
+			&cs.UnreadCount,
+			&last.id, &last.chatID, &last.senderID, &last.content, &last.timestamp, &last.status)
+		if err != nil {
+			return nil, fmt.Errorf("get chat summaries: %w", err)
+		}
+		if c.PublicKey, err = openContactKey(k, c.PublicID, key); err != nil {
+			return nil, fmt.Errorf("get chat summaries: %w", err)
+		}
+		cs.ContactID = c.PublicID
+		if last.id.Valid {
+			content, err := openContent(k, last.id.String, last.content)
+			if err != nil {
+				return nil, fmt.Errorf("get chat summaries: %w", err)
+			}
+			cs.LastMessage = &domain.Message{
+				ID:        last.id.String,
+				ChatID:    last.chatID.String,
+				SenderID:  last.senderID.String,
+				Content:   content,
+				Timestamp: last.timestamp.Int64,
+				Status:    domain.MessageStatus(last.status.String),
+			}
+		}
+		summaries = append(summaries, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
+	return summaries, nil
+}
+
+// SendMessage stores a new outgoing message in StatusSending and puts it
+// in the outbox. Delivering it is up to the messenger built on the store.
+func (s *Store) SendMessage(ctx context.Context, contactID, content string) (*domain.Message, error) {
+	msg := domain.Message{
+		ID:        uuid.New().String(),
+		ChatID:    contactID,
+		SenderID:  s.self.PublicID(),
+		Content:   content,
+		Timestamp: time.Now().UnixMilli(),
+		Status:    domain.StatusSending,
+	}
+	if err := s.insertMessage(ctx, msg); err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	return &msg, nil
+}
+
+// insertMessage encrypts, stores and indexes msg, queueing it if it is
+// still to be sent. It fails with domain.ErrContactNotFound if its chat
+// does not exist.
+func (s *Store) insertMessage(ctx context.Context, msg domain.Message) error {
+	k, err := s.dataKey()
+	if err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, msg.ChatID); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO messages (`+messageColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+			msg.ID, msg.ChatID, msg.SenderID, k.seal([]byte(msg.Content), adContent+msg.ID), msg.Timestamp, msg.Status)
+		if err != nil {
+			return err
+		}
+		seq, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := indexContent(ctx, tx, k, seq, msg.Content); err != nil {
+			return err
+		}
+		if msg.Status == domain.StatusSending {
+			return queue(ctx, tx, seq, time.Now())
+		}
+		return nil
+	})
+}
+
+// GetMessages returns up to limit messages of a chat, oldest first, that
+// precede beforeID, or the latest ones if beforeID is empty. A limit of 0
+// means the default page size.
+func (s *Store) GetMessages(ctx context.Context, contactID string, limit int, beforeID string) ([]domain.Message, error) {
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("get messages: %w", err)
+	}
+
+	var msgs []domain.Message
+	err = s.inReadTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, contactID); err != nil {
+			return err
+		}
+
+		// (timestamp, rowid) orders a chat; rowid breaks ties in insertion order.
+		beforeTS, beforeRow := int64(1<<63-1), int64(1<<63-1)
+		if beforeID != "" {
+			err := tx.QueryRowContext(ctx,
+				`SELECT timestamp, rowid FROM messages WHERE id = ? AND chat_id = ?`,
+				beforeID, contactID).Scan(&beforeTS, &beforeRow)
+			if errors.Is(err, sql.ErrNoRows) {
+				return domain.ErrMessageNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT `+messageColumns+` FROM messages
+			WHERE chat_id = ? AND (timestamp, rowid) < (?, ?)
+			ORDER BY timestamp DESC, rowid DESC
+			LIMIT ?`, contactID, beforeTS, beforeRow, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		msgs = []domain.Message{}
+		for rows.Next() {
+			m, err := scanMessage(k, rows)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, m)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get messages: %w", err)
+	}
+	slices.Reverse(msgs)
+	return msgs, nil
+}
+
+// ImportMessages stores msgs in the chat of contactID, skipping those whose
+// ID exists, including earlier in msgs; see messenger.ChatService. Every message is put in that chat
+// whatever its ChatID. All of msgs are added in one transaction.
+func (s *Store) ImportMessages(ctx context.Context, contactID string, msgs []domain.Message, dryRun bool) (int, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return 0, fmt.Errorf("import messages: %w", err)
+	}
+	added := 0
+	seen := make(map[string]bool) // by a dry run, which stores nothing
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, contactID); err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if dryRun {
+				if seen[msg.ID] {
+					continue
+				}
+				seen[msg.ID] = true
+				var one int
+				err := tx.QueryRowContext(ctx, `SELECT 1 FROM messages WHERE id = ?`, msg.ID).Scan(&one)
+				if errors.Is(err, sql.ErrNoRows) {
+					added++
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			msg.ChatID = contactID
+			isNew, err := insertIfNew(ctx, tx, k, msg)
+			if err != nil {
+				return err
+			}
+			if isNew {
+				added++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("import messages: %w", err)
+	}
+	return added, nil
+}
+
+// insertIfNew encrypts, stores and indexes msg unless a message with its
+// ID exists, and reports whether it did.
+func insertIfNew(ctx context.Context, tx *sql.Tx, k *dataKey, msg domain.Message) (bool, error) {
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO messages (`+messageColumns+`) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		msg.ID, msg.ChatID, msg.SenderID, k.seal([]byte(msg.Content), adContent+msg.ID), msg.Timestamp, msg.Status)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	seq, err := res.LastInsertId()
+	if err != nil {
+		return false, err
+	}
+	return true, indexContent(ctx, tx, k, seq, msg.Content)
+}
+
+// MarkAsRead marks every incoming message of a chat as read.
+func (s *Store) MarkAsRead(ctx context.Context, contactID string) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, contactID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE messages SET status = 'read'
+			WHERE chat_id = ? AND sender_id = chat_id AND status <> 'read'`, contactID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("mark as read: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) ClearHistory(ctx context.Context, contactID string) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := checkContact(ctx, tx, contactID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE chat_id = ?`, contactID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("clear history: %w", err)
+	}
+	return nil
+}
+
+// RebindOwnMessages attributes every outgoing message to selfID, e.g. after
+// the identity was created, imported or rotated. In a 1-on-1 chat a message
+// is outgoing iff its sender is not the chat's contact.
+func (s *Store) RebindOwnMessages(ctx context.Context, selfID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE messages SET sender_id = ? WHERE sender_id <> chat_id`, selfID)
+	if err != nil {
+		return fmt.Errorf("rebind own messages: %w", err)
+	}
+	return nil
+}
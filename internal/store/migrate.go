@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// Migrations are embedded SQL files named NNNN_description.sql, applied in
+// order of NNNN. A released migration must never change; schema changes
+// always go into a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is one schema step.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations returns the embedded migrations sorted by version.
+// Versions must start at 1 and have no gaps.
+func migrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var out []migration
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || !strings.HasSuffix(e.Name(), ".sql") {
+			return nil, fmt.Errorf("bad migration file name %q", e.Name())
+		}
+		data, err := fs.ReadFile(migrationFiles, path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{version: version, name: e.Name(), sql: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	for i, m := range out {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %q: want version %d", m.name, i+1)
+		}
+	}
+	return out, nil
+}
+
+// SchemaVersion is the schema version this build writes.
+func SchemaVersion() int {
+	all, err := migrations()
+	if err != nil {
+		return 0
+	}
+	return len(all)
+}
+
+// migrate brings the database at dbPath up to SchemaVersion. It refuses a
+// database written by a newer build with domain.ErrDatabaseTooNew, and
+// copies a database holding data to a backup file before the first step.
+// Every migration runs in its own transaction together with its record in
+// schema_migrations, so a failure leaves the database at the last good version.
+func migrate(ctx context.Context, db *sql.DB, dbPath string) error {
+	all, err := migrations()
+	if err != nil {
+		return fmt.Errorf("load migrations: %w", err)
+	}
+	return runMigrations(ctx, db, dbPath, all)
+}
+
+// runMigrations applies all[current:]; see migrate.
+func runMigrations(ctx context.Context, db *sql.DB, dbPath string, all []migration) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	current, err := currentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > len(all) {
+		return fmt.Errorf("%w: schema version %d, this build supports %d",
+			domain.ErrDatabaseTooNew, current, len(all))
+	}
+	if current == len(all) {
+		return nil
+	}
+
+	hasData, err := hasUserTables(ctx, db)
+	if err != nil {
+		return err
+	}
+	if hasData {
+		if err := backup(ctx, db, BackupPath(dbPath, current)); err != nil {
+			return fmt.Errorf("backup before migration: %w", err)
+		}
+	}
+
+	for _, m := range all[current:] {
+		if err := apply(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// currentVersion returns the highest applied migration, 0 for none.
+func currentVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var v int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return v, nil
+}
+
+// hasUserTables reports whether the database has tables besides the
+// migration bookkeeping, i.e. whether it is worth backing up.
+func hasUserTables(ctx context.Context, db *sql.DB) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("inspect schema: %w", err)
+	}
+	return n > 0, nil
+}
+
+// BackupPath is where the database at dbPath is copied before it is
+// upgraded from schema version from.
+func BackupPath(dbPath string, from int) string {
+	return fmt.Sprintf("%s.v%d.bak", dbPath, from)
+}
+
+// backup writes a consistent copy of the database to dst, replacing an
+// older backup of the same version.
+func backup(ctx context.Context, db *sql.DB, dst string) error {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `VACUUM INTO ?`, dst)
+	return err
+}
+
+func apply(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
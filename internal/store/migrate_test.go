@@ -0,0 +1,169 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// fixtureContact is the contact every testdata/vN.sql fixture holds.
+const fixtureContact = "0123456789abcdef"
+
+// loadFixture creates a database at path from testdata/v<version>.sql.
+func loadFixture(t *testing.T, path string, version int) {
+	t.Helper()
+	script, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d.sql", version)))
+	if err != nil {
+		t.Fatalf("read fixture v%d: %v", version, err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(script)); err != nil {
+		t.Fatalf("load fixture v%d: %v", version, err)
+	}
+}
+
+func appliedVersion(t *testing.T, s *Store) int {
+	t.Helper()
+	v, err := currentVersion(newCtx(), s.db)
+	if err != nil {
+		t.Fatalf("currentVersion() error = %v", err)
+	}
+	return v
+}
+
+func TestMigrate_UpgradesEveryPastVersion(t *testing.T) {
+	latest := SchemaVersion()
+	if latest == 0 {
+		t.Fatal("SchemaVersion() = 0, embedded migrations are broken")
+	}
+
+	for from := 0; from <= latest; from++ {
+		t.Run(fmt.Sprintf("v%d", from), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			loadFixture(t, path, from)
+
+			s := mustOpenLocked(t, path, newSelf(t))
+			if got := appliedVersion(t, s); got != latest {
+				t.Errorf("version = %d, want %d", got, latest)
+			}
+			var mode int
+			if err := s.db.QueryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil || mode != autoVacuumIncremental {
+				t.Errorf("auto_vacuum = %d, %v; want incremental", mode, err)
+			}
+
+			_, err := os.Stat(BackupPath(path, from))
+			if upgraded := from < latest; upgraded != (err == nil) {
+				t.Errorf("backup exists = %v, want %v", err == nil, upgraded)
+			}
+			if err := s.Unlock(newCtx(), nil); err != nil {
+				t.Fatalf("Unlock() error = %v", err)
+			}
+
+			c, err := s.GetContact(newCtx(), fixtureContact)
+			if err != nil {
+				t.Fatalf("GetContact() error = %v", err)
+			}
+			if c.DisplayName != "Alice" || !c.Verified {
+				t.Errorf("contact = %+v, want verified Alice", c)
+			}
+			msgs, err := s.GetMessages(newCtx(), fixtureContact, 0, "")
+			if err != nil {
+				t.Fatalf("GetMessages() error = %v", err)
+			}
+			if got := messageIDs(msgs); len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+				t.Errorf("messages = %v, want [m1 m2]", got)
+			}
+			settings, err := s.GetSettings(newCtx())
+			if err != nil {
+				t.Fatalf("GetSettings() error = %v", err)
+			}
+			if settings.Theme != "dark" {
+				t.Errorf("Theme = %q, want dark", settings.Theme)
+			}
+			// Messages under way before the outbox existed are queued.
+			due, err := s.DueMessages(newCtx(), fixtureContact, time.Now())
+			if err != nil {
+				t.Fatalf("DueMessages() error = %v", err)
+			}
+			if got := messageIDs(due); !slices.Equal(got, []string{"m2"}) {
+				t.Errorf("due = %v, want [m2]", got)
+			}
+			// Messages written before the search index existed are indexed.
+			if got := mustSearch(t, s, "привет", ""); !slices.Equal(got, []string{"m1"}) {
+				t.Errorf("search = %v, want [m1]", got)
+			}
+		})
+	}
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	s := mustOpen(t, path, newSelf(t))
+
+	if got := appliedVersion(t, s); got != SchemaVersion() {
+		t.Errorf("version = %d, want %d", got, SchemaVersion())
+	}
+	if _, err := os.Stat(BackupPath(path, 0)); !os.IsNotExist(err) {
+		t.Errorf("fresh database was backed up: %v", err)
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	s := mustOpen(t, path, newSelf(t))
+	_, err := s.db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future.sql', 0)`,
+		SchemaVersion()+1)
+	if err != nil {
+		t.Fatalf("insert future version: %v", err)
+	}
+	s.Close()
+
+	_, err = Open(path, newSelf(t))
+	if !errors.Is(err, domain.ErrDatabaseTooNew) {
+		t.Errorf("Open() error = %v, want ErrDatabaseTooNew", err)
+	}
+}
+
+func TestMigrate_FailedStepRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	loadFixture(t, path, 0)
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	all, err := migrations()
+	if err != nil {
+		t.Fatalf("migrations() error = %v", err)
+	}
+	broken := migration{
+		version: len(all) + 1,
+		name:    "broken.sql",
+		sql:     `CREATE TABLE half_done (x INTEGER); INSERT INTO no_such_table VALUES (1);`,
+	}
+	if err := runMigrations(newCtx(), db, path, append(all, broken)); err == nil {
+		t.Fatal("runMigrations() succeeded with a broken migration")
+	}
+
+	s := &Store{db: db}
+	if got := appliedVersion(t, s); got != len(all) {
+		t.Errorf("version = %d, want %d (last good)", got, len(all))
+	}
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&n)
+	if n != 0 {
+		t.Error("table from the failed migration was kept")
+	}
+}
@@ -0,0 +1,33 @@
+-- Initial schema (doc/spec.md §6.2).
+-- Avatars live in the profile's avatars/ directory, so contacts keep the
+-- avatar URL instead of the image bytes; is_verified backs Contact.Verified.
+-- IF NOT EXISTS lets it adopt databases created before migrations existed.
+
+CREATE TABLE IF NOT EXISTS contacts (
+    public_id    TEXT PRIMARY KEY,
+    public_key   BLOB NOT NULL,
+    display_name TEXT NOT NULL,
+    avatar_path  TEXT NOT NULL DEFAULT '',
+    is_blocked   INTEGER NOT NULL DEFAULT 0,
+    is_verified  INTEGER NOT NULL DEFAULT 0,
+    last_seen    INTEGER NOT NULL DEFAULT 0,
+    added_at     INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id        TEXT PRIMARY KEY,
+    chat_id   TEXT NOT NULL REFERENCES contacts(public_id) ON DELETE CASCADE,
+    sender_id TEXT NOT NULL,
+    content   TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    status    TEXT NOT NULL DEFAULT 'sending',
+    CHECK (status IN ('sending','sent','delivered','read','failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_chat_time ON messages(chat_id, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_status ON messages(chat_id, status);
+
+CREATE TABLE IF NOT EXISTS settings (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
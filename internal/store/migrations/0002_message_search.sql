@@ -0,0 +1,49 @@
+-- Full-text search over message content.
+-- The external-content FTS index refers to messages by rowid, which VACUUM
+-- may renumber unless it is an INTEGER PRIMARY KEY, so messages is rebuilt
+-- with an explicit seq. Triggers keep the index in step with every insert,
+-- content edit and delete, including ClearHistory and contact removal.
+-- unicode61 folds case beyond ASCII (Cyrillic included) and, with
+-- remove_diacritics 2, matches "cafe" against "café".
+
+CREATE TABLE messages_new (
+    seq       INTEGER PRIMARY KEY,
+    id        TEXT NOT NULL UNIQUE,
+    chat_id   TEXT NOT NULL REFERENCES contacts(public_id) ON DELETE CASCADE,
+    sender_id TEXT NOT NULL,
+    content   TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    status    TEXT NOT NULL DEFAULT 'sending',
+    CHECK (status IN ('sending','sent','delivered','read','failed'))
+);
+
+INSERT INTO messages_new (seq, id, chat_id, sender_id, content, timestamp, status)
+SELECT rowid, id, chat_id, sender_id, content, timestamp, status FROM messages;
+
+DROP TABLE messages;
+ALTER TABLE messages_new RENAME TO messages;
+
+CREATE INDEX idx_messages_chat_time ON messages(chat_id, timestamp DESC);
+CREATE INDEX idx_messages_chat_status ON messages(chat_id, status);
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+    content,
+    content = 'messages',
+    content_rowid = 'seq',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, content) VALUES (new.seq, new.content);
+END;
+
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.seq, old.content);
+END;
+
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.seq, old.content);
+    INSERT INTO messages_fts (rowid, content) VALUES (new.seq, new.content);
+END;
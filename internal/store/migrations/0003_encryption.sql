@@ -0,0 +1,29 @@
+-- Encryption at rest.
+-- messages.content and contacts.public_key hold XChaCha20-Poly1305
+-- ciphertext under a random data key kept in keyring, wrapped with the
+-- identity's storage key when it has a passphrase. Rows written before
+-- this migration stay plaintext until the first unlock encrypts them, in
+-- the same transaction that creates the keyring row.
+--
+-- The FTS index would keep message words in clear, so it is replaced by
+-- message_terms, a blind index of keyed hashes of word prefixes. Rows are
+-- written by the store; deletes cascade from messages.
+
+DROP TRIGGER messages_fts_insert;
+DROP TRIGGER messages_fts_delete;
+DROP TRIGGER messages_fts_update;
+DROP TABLE messages_fts;
+
+CREATE TABLE keyring (
+    id       INTEGER PRIMARY KEY CHECK (id = 1),
+    data_key BLOB NOT NULL,
+    sealed   INTEGER NOT NULL
+);
+
+CREATE TABLE message_terms (
+    term BLOB NOT NULL,
+    seq  INTEGER NOT NULL REFERENCES messages(seq) ON DELETE CASCADE,
+    PRIMARY KEY (term, seq)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_message_terms_seq ON message_terms(seq);
@@ -0,0 +1,5 @@
+-- Per-chat message retention (Contact.RetentionDays): 0 follows the
+-- global retentionDays setting, -1 keeps the chat's history forever.
+-- Expiry deletes by chat and age, which idx_messages_chat_time covers.
+
+ALTER TABLE contacts ADD COLUMN retention_days INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Network address a contact is dialed at (host:port), empty if unknown.
+-- Set by the user or learned from the peer; not secret enough to seal,
+-- as anyone on the path sees it anyway.
+
+ALTER TABLE contacts ADD COLUMN address TEXT NOT NULL DEFAULT '';
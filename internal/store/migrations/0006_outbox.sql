@@ -0,0 +1,19 @@
+-- Outbox: the outgoing messages that are not delivered yet, with when
+-- they were queued, which the outbox TTL (Settings.OutboxTTLHours) counts
+-- from, and when the transport tries them next. A message leaves the
+-- outbox once it is delivered or fails. Messages under way when this
+-- migration runs are queued now.
+
+CREATE TABLE outbox (
+    seq             INTEGER PRIMARY KEY REFERENCES messages(seq) ON DELETE CASCADE,
+    queued_at       INTEGER NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_outbox_queued ON outbox(queued_at);
+
+INSERT INTO outbox (seq, queued_at, next_attempt_at)
+SELECT seq, CAST(strftime('%s', 'now') AS INTEGER) * 1000, 0
+FROM messages
+WHERE sender_id <> chat_id AND status IN ('sending', 'sent');
@@ -0,0 +1,7 @@
+-- Re-keying in two steps. A new wrapping of the data key waits in
+-- next_data_key until the identity.key it belongs to is on disk, so that
+-- whichever identity.key survives a crash in between still opens the
+-- data key. NULL when no re-key is under way.
+
+ALTER TABLE keyring ADD COLUMN next_data_key BLOB;
+ALTER TABLE keyring ADD COLUMN next_sealed INTEGER;
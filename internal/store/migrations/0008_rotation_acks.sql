@@ -0,0 +1,6 @@
+-- Key rotations a contact has taken in: the IssuedAt of the newest of our
+-- rotation certificates it acknowledged, 0 if none. Certificates issued
+-- later are sent to it until it acknowledges them. Contacts existing when
+-- this migration runs get the whole history once.
+
+ALTER TABLE contacts ADD COLUMN rotation_acked_at INTEGER NOT NULL DEFAULT 0;
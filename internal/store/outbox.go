@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// Outgoing messages wait in the outbox until they are delivered. The
+// transport tries them as the schedule kept here says and records each
+// attempt; FailExpired fails those queued longer than the outbox TTL.
+
+// maxBackoffDoublings bounds the shift in ScheduleRetry.
+const maxBackoffDoublings = 20
+
+// queue puts the message stored as seq in the outbox, due at once.
+func queue(ctx context.Context, tx *sql.Tx, seq int64, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox (seq, queued_at, next_attempt_at) VALUES (?, ?, ?)
+		ON CONFLICT (seq) DO UPDATE SET
+			queued_at = excluded.queued_at, attempts = 0, next_attempt_at = excluded.next_attempt_at`,
+		seq, now.UnixMilli(), now.UnixMilli())
+	return err
+}
+
+// dequeue takes messageID out of the outbox.
+func dequeue(ctx context.Context, tx *sql.Tx, messageID string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE seq = (SELECT seq FROM messages WHERE id = ?)`, messageID)
+	return err
+}
+
+// DueMessages returns the messages of contactID's chat in the outbox that
+// are due to be tried by now, oldest first.
+func (s *Store) DueMessages(ctx context.Context, contactID string, now time.Time) ([]domain.Message, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("due messages: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, m.chat_id, m.sender_id, m.content, m.timestamp, m.status
+		FROM outbox o JOIN messages m ON m.seq = o.seq
+		WHERE m.chat_id = ? AND o.next_attempt_at <= ?
+		ORDER BY m.timestamp, m.seq`, contactID, now.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("due messages: %w", err)
+	}
+	defer rows.Close()
+
+	msgs := []domain.Message{}
+	for rows.Next() {
+		m, err := scanMessage(k, rows)
+		if err != nil {
+			return nil, fmt.Errorf("due messages: %w", err)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("due messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// ScheduleRetry records an attempt to send messageID at now and makes it
+// due again after base, doubling with every attempt up to max. It does
+// nothing for a message that is not in the outbox.
+func (s *Store) ScheduleRetry(ctx context.Context, messageID string, now time.Time, base, max time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE outbox SET
+			next_attempt_at = ? + min(?, ? << min(attempts, ?)),
+			attempts = attempts + 1
+		WHERE seq = (SELECT seq FROM messages WHERE id = ?)`,
+		now.UnixMilli(), max.Milliseconds(), base.Milliseconds(), maxBackoffDoublings, messageID)
+	if err != nil {
+		return fmt.Errorf("schedule retry: %w", err)
+	}
+	return nil
+}
+
+// FailExpired marks the messages queued longer than the outbox TTL before
+// now as failed and takes them out of the outbox. It returns their IDs by
+// chat. It reads no encrypted columns, so it works while the store is locked.
+func (s *Store) FailExpired(ctx context.Context, now time.Time) (map[string][]string, error) {
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fail expired messages: %w", err)
+	}
+	failed := map[string][]string{}
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE messages SET status = 'failed'
+			WHERE seq IN (SELECT seq FROM outbox WHERE queued_at < ?)
+			RETURNING id, chat_id`, now.Add(-settings.OutboxTTL()).UnixMilli())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, chatID string
+			if err := rows.Scan(&id, &chatID); err != nil {
+				return err
+			}
+			failed[chatID] = append(failed[chatID], id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM outbox WHERE queued_at < ?`, now.Add(-settings.OutboxTTL()).UnixMilli())
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fail expired messages: %w", err)
+	}
+	return failed, nil
+}
+
+// RetryMessage queues the failed outgoing message messageID again, in
+// StatusSending, with a fresh TTL. It fails with domain.ErrMessageNotFound
+// if there is no such outgoing message and with domain.ErrMessageNotFailed
+// if it has not failed.
+func (s *Store) RetryMessage(ctx context.Context, messageID string) (*domain.Message, error) {
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("retry message: %w", err)
+	}
+	var msg domain.Message
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		var seq int64
+		err := tx.QueryRowContext(ctx, `
+			SELECT seq FROM messages WHERE id = ? AND sender_id <> chat_id`, messageID).Scan(&seq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE messages SET status = 'sending' WHERE seq = ? AND status = 'failed'`, seq)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return domain.ErrMessageNotFailed
+		}
+		if err := queue(ctx, tx, seq, time.Now()); err != nil {
+			return err
+		}
+		msg, err = scanMessage(k, tx.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages WHERE seq = ?`, seq))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("retry message: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// SetChatRetention sets contactID's retention: days > 0 keeps messages that
+// long, 0 follows the global setting and domain.RetentionForever keeps all.
+func (s *Store) SetChatRetention(ctx context.Context, contactID string, days int) error {
+	return s.updateContact(ctx, "set chat retention", contactID,
+		`UPDATE contacts SET retention_days = ? WHERE public_id = ?`, days)
+}
+
+// ExpireMessages deletes every message older than its chat's retention
+// period as of now and reports the chats it deleted from. It reads no
+// encrypted columns, so it works while the store is locked.
+func (s *Store) ExpireMessages(ctx context.Context, now time.Time) ([]domain.ExpiredMessages, error) {
+	settings, err := s.GetSettings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("expire messages: %w", err)
+	}
+	expired := []domain.ExpiredMessages{}
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		policies, err := retentionPolicies(ctx, tx, settings.RetentionDays)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			before := now.AddDate(0, 0, -p.days).UnixMilli()
+			res, err := tx.ExecContext(ctx,
+				`DELETE FROM messages WHERE chat_id = ? AND timestamp < ?`, p.chatID, before)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				expired = append(expired, domain.ExpiredMessages{ChatID: p.chatID, Before: before, Count: int(n)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("expire messages: %w", err)
+	}
+	return expired, nil
+}
+
+// retentionPolicy is a chat's effective retention period.
+type retentionPolicy struct {
+	chatID string
+	days   int
+}
+
+// retentionPolicies lists the chats whose history expires, resolving a
+// per-chat 0 to the global period.
+func retentionPolicies(ctx context.Context, tx *sql.Tx, global int) ([]retentionPolicy, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT public_id, CASE retention_days WHEN 0 THEN ? ELSE retention_days END
+		FROM contacts`, global)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []retentionPolicy
+	for rows.Next() {
+		var p retentionPolicy
+		if err := rows.Scan(&p.chatID, &p.days); err != nil {
+			return nil, err
+		}
+		if p.days > 0 {
+			policies = append(policies, p)
+		}
+	}
+	return policies, rows.Err()
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/search"
+)
+
+// searchBatch is how many candidates are decrypted and verified at a time.
+const searchBatch = 200
+
+// SearchMessages returns the messages matching query, newest first; see
+// messenger.ChatService. The cursor is the ID of the last hit of the
+// previous page.
+//
+// Candidates are found through message_terms, which only narrows down by
+// word prefixes; each one is decrypted and confirmed with a
+// search.Matcher, which also renders the snippet.
+func (s *Store) SearchMessages(ctx context.Context, query, contactID string, limit int, cursor string) (*domain.SearchResult, error) {
+	q, err := domain.ParseSearchQuery(query, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	k, err := s.dataKey()
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	matcher := search.NewMatcher(q.Terms)
+
+	result := &domain.SearchResult{Hits: []domain.SearchHit{}}
+	err = s.inReadTx(ctx, func(tx *sql.Tx) error {
+		if contactID != "" {
+			if err := checkContact(ctx, tx, contactID); err != nil {
+				return err
+			}
+		}
+
+		var (
+			where []string
+			args  []any
+		)
+		for _, t := range matcher.LookupTerms() {
+			where = append(where, `m.seq IN (SELECT seq FROM message_terms WHERE term = ?)`)
+			args = append(args, k.indexTerm(t))
+		}
+		if contactID != "" {
+			where = append(where, `m.chat_id = ?`)
+			args = append(args, contactID)
+		}
+		if q.From != "" {
+			senders, err := s.resolveSender(ctx, tx, q.From)
+			if err != nil {
+				return err
+			}
+			if len(senders) == 0 {
+				return nil
+			}
+			where = append(where, `m.sender_id IN (?`+strings.Repeat(`, ?`, len(senders)-1)+`)`)
+			for _, id := range senders {
+				args = append(args, id)
+			}
+		}
+		if q.After != 0 {
+			where = append(where, `m.timestamp >= ?`)
+			args = append(args, q.After)
+		}
+		if q.Before != 0 {
+			where = append(where, `m.timestamp < ?`)
+			args = append(args, q.Before)
+		}
+
+		// Page through candidates by (timestamp, seq), starting below the cursor.
+		afterTS, afterSeq := int64(1<<63-1), int64(1<<63-1)
+		if cursor != "" {
+			err := tx.QueryRowContext(ctx,
+				`SELECT timestamp, seq FROM messages WHERE id = ?`, cursor).Scan(&afterTS, &afterSeq)
+			if errors.Is(err, sql.ErrNoRows) {
+				return domain.ErrMessageNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+		stmt := `SELECT m.seq, m.id, m.chat_id, m.sender_id, m.content, m.timestamp, m.status
+			FROM messages m
+			WHERE ` + strings.Join(append(where, `(m.timestamp, m.seq) < (?, ?)`), ` AND `) + `
+			ORDER BY m.timestamp DESC, m.seq DESC LIMIT ?`
+
+		for len(result.Hits) < limit {
+			rows, err := tx.QueryContext(ctx, stmt, append(args, afterTS, afterSeq, searchBatch)...)
+			if err != nil {
+				return err
+			}
+			n, err := matchBatch(rows, k, matcher, limit, result, &afterTS, &afterSeq)
+			if err != nil {
+				return err
+			}
+			if n < searchBatch {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	if len(result.Hits) == limit {
+		result.NextCursor = result.Hits[len(result.Hits)-1].Message.ID
+	}
+	return result, nil
+}
+
+// matchBatch verifies one batch of candidates, appending hits to result
+// until it holds limit, and advances the paging position past every row it
+// looked at. It returns the number of rows read and closes rows.
+func matchBatch(rows *sql.Rows, k *dataKey, matcher *search.Matcher, limit int,
+	result *domain.SearchResult, afterTS, afterSeq *int64) (int, error) {
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var (
+			seq    int64
+			m      domain.Message
+			sealed []byte
+		)
+		if err := rows.Scan(&seq, &m.ID, &m.ChatID, &m.SenderID, &sealed, &m.Timestamp, &m.Status); err != nil {
+			return n, err
+		}
+		n++
+		*afterTS, *afterSeq = m.Timestamp, seq
+
+		content, err := openContent(k, m.ID, sealed)
+		if err != nil {
+			return n, err
+		}
+		m.Content = content
+		if snippet, ok := matcher.Match(content); ok {
+			result.Hits = append(result.Hits, domain.SearchHit{Message: m, Snippet: snippet})
+			if len(result.Hits) == limit {
+				return n, nil // the cursor is this hit
+			}
+		}
+	}
+	return n, rows.Err()
+}
+
+// resolveSender maps a from: value to sender IDs: the local identity for
+// domain.SearchFromMe, otherwise every contact whose public ID or display
+// name matches, ignoring case.
+func (s *Store) resolveSender(ctx context.Context, tx *sql.Tx, from string) ([]string, error) {
+	if strings.EqualFold(from, domain.SearchFromMe) {
+		return []string{s.self.PublicID()}, nil
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT public_id, display_name FROM contacts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(id, from) || strings.EqualFold(name, from) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
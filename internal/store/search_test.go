@@ -0,0 +1,224 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+func mustSearch(t *testing.T, s *Store, query, contactID string) []string {
+	t.Helper()
+	res, err := s.SearchMessages(newCtx(), query, contactID, 0, "")
+	if err != nil {
+		t.Fatalf("SearchMessages(%q) error = %v", query, err)
+	}
+	ids := make([]string, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.Message.ID
+	}
+	return ids
+}
+
+func mustInsert(t *testing.T, s *Store, msg domain.Message) {
+	t.Helper()
+	if msg.Status == "" {
+		msg.Status = domain.StatusDelivered
+	}
+	if err := s.insertMessage(newCtx(), msg); err != nil {
+		t.Fatalf("insertMessage(%q) error = %v", msg.ID, err)
+	}
+}
+
+func TestSearchMessages_Text(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	me := s.self.PublicID()
+	for i, m := range []struct{ id, sender, content string }{
+		{"latin", alice.PublicID, "Meet me at the café on Friday"},
+		{"cyrillic", alice.PublicID, "Привет! Встречаемся в кафе"},
+		{"mixed", me, "ПРИВЕТСТВУЮ all, see you at 5"},
+		{"phrase", me, "friday night, not Friday morning"},
+	} {
+		mustInsert(t, s, domain.Message{ID: m.id, ChatID: alice.PublicID, SenderID: m.sender,
+			Content: m.content, Timestamp: int64(1000 + i)})
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"cafe", []string{"latin"}},
+		{"FRI", []string{"phrase", "latin"}},
+		{"привет", []string{"mixed", "cyrillic"}},
+		{"встреч КАФЕ", []string{"cyrillic"}},
+		{`"friday morning"`, []string{"phrase"}},
+		{"friday zebra", []string{}},
+		{`it"s`, []string{}},
+	}
+	for _, tt := range tests {
+		if got := mustSearch(t, s, tt.query, ""); !slices.Equal(got, tt.want) {
+			t.Errorf("SearchMessages(%q) = %v; want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchMessages_Snippet(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, alice.PublicID, "m1", 1)
+	mustInsert(t, s, domain.Message{ID: "m2", ChatID: alice.PublicID, SenderID: alice.PublicID,
+		Content: `<script>alert("hi")</script> Hello there`, Timestamp: 2})
+
+	res, err := s.SearchMessages(newCtx(), "hello", "", 0, "")
+	if err != nil {
+		t.Fatalf("SearchMessages() error = %v", err)
+	}
+	want := `&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; <mark>Hello</mark> there`
+	if len(res.Hits) != 1 || res.Hits[0].Snippet != want {
+		t.Fatalf("hits = %+v; want one with snippet %q", res.Hits, want)
+	}
+	if res.Hits[0].Message.ID != "m2" {
+		t.Errorf("hit = %q; want m2", res.Hits[0].Message.ID)
+	}
+}
+
+func TestSearchMessages_Filters(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	bob := mustAddContact(t, s, "Bob Builder")
+	me := s.self.PublicID()
+	day := func(d int) int64 { return time.Date(2024, 5, d, 12, 0, 0, 0, time.Local).UnixMilli() }
+	mustInsert(t, s, domain.Message{ID: "a1", ChatID: alice.PublicID, SenderID: alice.PublicID, Content: "lunch?", Timestamp: day(1)})
+	mustInsert(t, s, domain.Message{ID: "a2", ChatID: alice.PublicID, SenderID: me, Content: "lunch!", Timestamp: day(2)})
+	mustInsert(t, s, domain.Message{ID: "b1", ChatID: bob.PublicID, SenderID: bob.PublicID, Content: "lunch at noon", Timestamp: day(3)})
+	mustInsert(t, s, domain.Message{ID: "b2", ChatID: bob.PublicID, SenderID: me, Content: "dinner", Timestamp: day(4)})
+
+	tests := []struct {
+		name      string
+		query     string
+		contactID string
+		want      []string
+	}{
+		{name: "all chats", query: "lunch", want: []string{"b1", "a2", "a1"}},
+		{name: "one chat", query: "lunch", contactID: alice.PublicID, want: []string{"a2", "a1"}},
+		{name: "from me", query: "from:me", want: []string{"b2", "a2"}},
+		{name: "from name", query: `from:"bob builder" lunch`, want: []string{"b1"}},
+		{name: "from public ID", query: "from:" + alice.PublicID, want: []string{"a1"}},
+		{name: "from unknown", query: "from:nobody lunch", want: []string{}},
+		{name: "before", query: "lunch before:2024-05-02", want: []string{"a1"}},
+		{name: "after", query: "after:2024-05-02", want: []string{"b2", "b1"}},
+		{name: "range", query: "after:2024-05-01 before:2024-05-04", want: []string{"b1", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustSearch(t, s, tt.query, tt.contactID); !slices.Equal(got, tt.want) {
+				t.Errorf("SearchMessages(%q) = %v; want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages_Pagination(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		// Equal timestamps: insertion order breaks the tie.
+		mustInsert(t, s, domain.Message{ID: id, ChatID: alice.PublicID, SenderID: alice.PublicID, Content: "ping", Timestamp: 7})
+	}
+
+	var got []string
+	cursor := ""
+	for page := 0; ; page++ {
+		res, err := s.SearchMessages(newCtx(), "ping", "", 2, cursor)
+		if err != nil {
+			t.Fatalf("SearchMessages(page %d) error = %v", page, err)
+		}
+		for _, h := range res.Hits {
+			got = append(got, h.Message.ID)
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+	if want := []string{"m5", "m4", "m3", "m2", "m1"}; !slices.Equal(got, want) {
+		t.Errorf("pages = %v; want %v", got, want)
+	}
+
+	_, err := s.SearchMessages(newCtx(), "ping", "", 2, "missing")
+	if !errors.Is(err, domain.ErrMessageNotFound) {
+		t.Errorf("SearchMessages(bad cursor) error = %v; want ErrMessageNotFound", err)
+	}
+}
+
+func TestSearchMessages_IndexStaysInSync(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	bob := mustAddContact(t, s, "Bob")
+
+	sent, err := s.SendMessage(newCtx(), alice.PublicID, "outgoing walrus")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	mustInsert(t, s, domain.Message{ID: "in", ChatID: alice.PublicID, SenderID: alice.PublicID, Content: "incoming walrus", Timestamp: 1})
+	mustInsert(t, s, domain.Message{ID: "bob", ChatID: bob.PublicID, SenderID: bob.PublicID, Content: "walrus from bob", Timestamp: 1})
+	if got := mustSearch(t, s, "walrus", ""); len(got) != 3 {
+		t.Fatalf("after send and receive = %v; want 3 hits", got)
+	}
+
+	// Edit: the index follows content rewrites.
+	err = s.inTx(newCtx(), func(tx *sql.Tx) error {
+		var seq int64
+		if err := tx.QueryRow(`SELECT seq FROM messages WHERE id = 'in'`).Scan(&seq); err != nil {
+			return err
+		}
+		return writeContent(newCtx(), tx, s.key, seq, "in", "incoming narwhal")
+	})
+	if err != nil {
+		t.Fatalf("edit message: %v", err)
+	}
+	if got := mustSearch(t, s, "narwhal", ""); !slices.Equal(got, []string{"in"}) {
+		t.Errorf("after edit, narwhal = %v; want [in]", got)
+	}
+	if got := mustSearch(t, s, "walrus", alice.PublicID); !slices.Equal(got, []string{sent.ID}) {
+		t.Errorf("after edit, walrus = %v; want [%s]", got, sent.ID)
+	}
+
+	if err := s.ClearHistory(newCtx(), alice.PublicID); err != nil {
+		t.Fatalf("ClearHistory() error = %v", err)
+	}
+	if got := mustSearch(t, s, "walrus", ""); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("after ClearHistory = %v; want [bob]", got)
+	}
+
+	if err := s.RemoveContact(newCtx(), bob.PublicID); err != nil {
+		t.Fatalf("RemoveContact() error = %v", err)
+	}
+	if got := mustSearch(t, s, "walrus", ""); len(got) != 0 {
+		t.Errorf("after RemoveContact = %v; want none", got)
+	}
+
+	var terms int
+	s.db.QueryRow(`SELECT COUNT(*) FROM message_terms`).Scan(&terms)
+	if terms != 0 {
+		t.Errorf("%d index terms left without messages", terms)
+	}
+}
+
+func TestSearchMessages_Errors(t *testing.T) {
+	s := newStore(t)
+
+	if _, err := s.SearchMessages(newCtx(), "  ", "", 0, ""); !errors.Is(err, domain.ErrEmptySearchQuery) {
+		t.Errorf("empty query error = %v; want ErrEmptySearchQuery", err)
+	}
+	if _, err := s.SearchMessages(newCtx(), "before:soon", "", 0, ""); !errors.Is(err, domain.ErrInvalidSearchQuery) {
+		t.Errorf("bad date error = %v; want ErrInvalidSearchQuery", err)
+	}
+	if _, err := s.SearchMessages(newCtx(), "x", "0123456789abcdef", 0, ""); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("unknown contact error = %v; want ErrContactNotFound", err)
+	}
+}
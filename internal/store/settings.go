@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"quillet/internal/domain"
+)
+
+// Settings keys. Values are stored as text; missing keys fall back to
+// domain.DefaultSettings.
+const (
+	keyTheme              = "theme"
+	keyNotificationsOn    = "notificationsOn"
+	keySoundOn            = "soundOn"
+	keyShowMessagePreview = "showMessagePreview"
+	keySidebarWidth       = "sidebarWidth"
+	keyRetentionDays      = "retentionDays"
+	keyOutboxTTLHours     = "outboxTTLHours"
+)
+
+func (s *Store) GetSettings(ctx context.Context) (*domain.Settings, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM settings`)
+	if err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := domain.DefaultSettings()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("get settings: %w", err)
+		}
+		// Unparsable values keep their defaults.
+		switch key {
+		case keyTheme:
+			settings.Theme = value
+		case keyNotificationsOn:
+			settings.NotificationsOn = parseBool(value, settings.NotificationsOn)
+		case keySoundOn:
+			settings.SoundOn = parseBool(value, settings.SoundOn)
+		case keyShowMessagePreview:
+			settings.ShowMessagePreview = parseBool(value, settings.ShowMessagePreview)
+		case keySidebarWidth:
+			if n, err := strconv.Atoi(value); err == nil {
+				settings.SidebarWidth = n
+			}
+		case keyRetentionDays:
+			if n, err := strconv.Atoi(value); err == nil {
+				settings.RetentionDays = n
+			}
+		case keyOutboxTTLHours:
+			if n, err := strconv.Atoi(value); err == nil {
+				settings.OutboxTTLHours = n
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
+	return &settings, nil
+}
+
+func (s *Store) UpdateSettings(ctx context.Context, settings domain.Settings) error {
+	values := map[string]string{
+		keyTheme:              settings.Theme,
+		keyNotificationsOn:    strconv.FormatBool(settings.NotificationsOn),
+		keySoundOn:            strconv.FormatBool(settings.SoundOn),
+		keyShowMessagePreview: strconv.FormatBool(settings.ShowMessagePreview),
+		keySidebarWidth:       strconv.Itoa(settings.SidebarWidth),
+		keyRetentionDays:      strconv.Itoa(settings.RetentionDays),
+		keyOutboxTTLHours:     strconv.Itoa(settings.OutboxTTLHours),
+	}
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for key, value := range values {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO settings (key, value) VALUES (?, ?)
+				ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("update settings: %w", err)
+	}
+	return nil
+}
+
+func parseBool(value string, fallback bool) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"quillet/internal/domain"
+)
+
+// compactStep is how many free pages one incremental vacuum step returns
+// to the file system. Each step is a short write transaction, so other
+// writers get their turn in between.
+const compactStep = 256
+
+// autoVacuumIncremental is the PRAGMA auto_vacuum value of a database whose
+// free pages can be released by incremental_vacuum.
+const autoVacuumIncremental = 2
+
+// enableIncrementalVacuum converts a database created before Compact
+// existed. New databases get the mode from the connection pragma; older
+// ones need a full VACUUM, once, while nothing else is using the store.
+func enableIncrementalVacuum(ctx context.Context, db *sql.DB) error {
+	var mode int
+	if err := db.QueryRowContext(ctx, `PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("enable incremental vacuum: %w", err)
+	}
+	if mode == autoVacuumIncremental {
+		return nil
+	}
+	slog.Info("converting database for incremental vacuum")
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("enable incremental vacuum: %w", err)
+	}
+	return nil
+}
+
+// StorageStats reports the database size and how the messages in it are
+// spread over the chats. Attachment fields are left to the caller, which
+// owns the files beside the database.
+func (s *Store) StorageStats(ctx context.Context) (*domain.StorageStats, error) {
+	size, err := s.fileSize()
+	if err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	free, err := s.freeBytes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.public_id, c.display_name, COUNT(m.seq),
+			COALESCE(SUM(LENGTH(CAST(m.content AS BLOB))), 0) AS bytes
+		FROM contacts c
+		LEFT JOIN messages m ON m.chat_id = c.public_id
+		GROUP BY c.public_id
+		ORDER BY bytes DESC, c.display_name, c.public_id`)
+	if err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &domain.StorageStats{DatabaseBytes: size, FreeBytes: free, Chats: []domain.ChatStorage{}}
+	for rows.Next() {
+		var c domain.ChatStorage
+		if err := rows.Scan(&c.ContactID, &c.DisplayName, &c.MessageCount, &c.MessageBytes); err != nil {
+			return nil, fmt.Errorf("storage stats: %w", err)
+		}
+		stats.MessageCount += c.MessageCount
+		stats.MessageBytes += c.MessageBytes
+		stats.Chats = append(stats.Chats, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Compact returns the database's free pages to the file system and
+// truncates the write-ahead log. Unlike VACUUM it does not hold the
+// database for the whole run: it frees compactStep pages per transaction,
+// and reads and writes of other callers go on in between. Compact stops
+// early, keeping what it has reclaimed so far, when ctx is cancelled.
+func (s *Store) Compact(ctx context.Context) (*domain.CompactResult, error) {
+	before, err := s.fileSize()
+	if err != nil {
+		return nil, fmt.Errorf("compact store: %w", err)
+	}
+	for {
+		var free int
+		if err := s.db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&free); err != nil {
+			return nil, fmt.Errorf("compact store: %w", err)
+		}
+		if free == 0 {
+			break
+		}
+		step := fmt.Sprintf(`PRAGMA incremental_vacuum(%d)`, min(free, compactStep))
+		if _, err := s.db.ExecContext(ctx, step); err != nil {
+			return nil, fmt.Errorf("compact store: %w", err)
+		}
+	}
+	// The freed pages leave the main file when the log is checkpointed.
+	// A reader still holding the log is not waited for; the file then
+	// shrinks at a later checkpoint.
+	var busy, logPages, done int
+	err = s.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &done)
+	if err != nil {
+		return nil, fmt.Errorf("compact store: %w", err)
+	}
+	after, err := s.fileSize()
+	if err != nil {
+		return nil, fmt.Errorf("compact store: %w", err)
+	}
+	return &domain.CompactResult{
+		BytesBefore:    before,
+		BytesAfter:     after,
+		BytesReclaimed: max(0, before-after),
+	}, nil
+}
+
+// freeBytes is the size of the database's unused pages.
+func (s *Store) freeBytes(ctx context.Context) (int64, error) {
+	var free, pageSize int64
+	if err := s.db.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&free); err != nil {
+		return 0, err
+	}
+	if err := s.db.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return free * pageSize, nil
+}
+
+// fileSize is the size of the database file and its write-ahead log.
+func (s *Store) fileSize() (int64, error) {
+	var total int64
+	for _, path := range []string{s.path, s.path + "-wal"} {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
@@ -0,0 +1,132 @@
+// Package store persists a profile's contacts, messages and settings in
+// SQLite. Store implements messenger.ContactManager, messenger.ChatService
+// and messenger.SettingsManager with the same sentinel errors and paging
+// rules as the stub backend; identity, transport and events are left to
+// the messenger built on top of it.
+//
+// Message content and contact keys are encrypted at rest; until Unlock
+// loads the data key, calls that read or write them fail with
+// domain.ErrLocked.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+
+	"quillet/internal/domain"
+	"quillet/internal/messenger"
+)
+
+// compile-time checks
+var (
+	_ messenger.ContactManager  = (*Store)(nil)
+	_ messenger.ChatService     = (*Store)(nil)
+	_ messenger.SettingsManager = (*Store)(nil)
+)
+
+// FileName is the database file inside a profile data directory.
+const FileName = "quillet.db"
+
+const defaultPageLimit = 50
+
+// Self is the local identity a Store belongs to. It attributes outgoing
+// messages and supplies the local key for safety numbers.
+type Self interface {
+	PublicID() string
+	Profile() (*domain.User, error)
+}
+
+// Store is a profile database. It is safe for concurrent use.
+type Store struct {
+	db   *sql.DB
+	path string
+	self Self
+
+	mu  sync.RWMutex
+	key *dataKey // nil while locked
+}
+
+// Open opens or creates the database at path and migrates it to the
+// current schema version.
+func Open(path string, self Self) (*Store, error) {
+	q := url.Values{}
+	q.Add("_pragma", "auto_vacuum(incremental)") // see Compact
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "secure_delete(1)") // overwritten plaintext does not linger in free pages
+	// Write transactions take the lock up front and wait for it, rather
+	// than fail when another writer commits between their reads and writes.
+	q.Add("_txlock", "immediate")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	if err := migrate(context.Background(), db, path); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	if err := enableIncrementalVacuum(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+	return &Store{db: db, path: path, self: self}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Snapshot writes a consistent copy of the database to path, which must
+// not exist. The copy stays encrypted with the same data key.
+func (s *Store) Snapshot(ctx context.Context, path string) error {
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("snapshot store: %w", err)
+	}
+	return nil
+}
+
+// inTx runs fn in a write transaction, committing if it returns nil.
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.runTx(ctx, nil, fn)
+}
+
+// inReadTx runs fn in a read-only transaction, which sees one snapshot of
+// the database without holding up writers.
+func (s *Store) inReadTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.runTx(ctx, &sql.TxOptions{ReadOnly: true}, fn)
+}
+
+func (s *Store) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by shared helpers.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// checkContact fails with domain.ErrContactNotFound unless contactID exists.
+func checkContact(ctx context.Context, q querier, contactID string) error {
+	var one int
+	err := q.QueryRowContext(ctx, `SELECT 1 FROM contacts WHERE public_id = ?`, contactID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrContactNotFound
+	}
+	return err
+}
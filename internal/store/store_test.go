@@ -0,0 +1,972 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/identity"
+)
+
+func newCtx() context.Context {
+	return context.Background()
+}
+
+func newSelf(t *testing.T) *identity.Manager {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return identity.NewManagerFor(keys, domain.User{DisplayName: "Me"})
+}
+
+// mustOpenLocked opens the store at path without loading the data key.
+func mustOpenLocked(t *testing.T, path string, self Self) *Store {
+	t.Helper()
+	s, err := Open(path, self)
+	if err != nil {
+		t.Fatalf("Open(%q) error = %v", path, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// mustOpen opens the store at path and unlocks it as an identity without
+// a passphrase would.
+func mustOpen(t *testing.T, path string, self Self) *Store {
+	t.Helper()
+	s := mustOpenLocked(t, path, self)
+	if err := s.Unlock(newCtx(), nil); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	return s
+}
+
+func newStore(t *testing.T) *Store {
+	t.Helper()
+	return mustOpen(t, filepath.Join(t.TempDir(), FileName), newSelf(t))
+}
+
+// mustAddContact adds a contact with a freshly generated key.
+func mustAddContact(t *testing.T, s *Store, name string) *domain.Contact {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	peer, err := domain.NewPeerID("", keys.PublicKeyHex())
+	if err != nil {
+		t.Fatalf("NewPeerID() error = %v", err)
+	}
+	c, err := s.AddContact(newCtx(), peer, name)
+	if err != nil {
+		t.Fatalf("AddContact(%q) error = %v", name, err)
+	}
+	return c
+}
+
+// mustGenerate returns a fresh key pair.
+func mustGenerate(t *testing.T) *identity.KeyPair {
+	t.Helper()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	return keys
+}
+
+// mustReceive stores an incoming message from contactID.
+func mustReceive(t *testing.T, s *Store, contactID, id string, ts int64) {
+	t.Helper()
+	err := s.insertMessage(newCtx(), domain.Message{
+		ID:        id,
+		ChatID:    contactID,
+		SenderID:  contactID,
+		Content:   "from " + contactID,
+		Timestamp: ts,
+		Status:    domain.StatusDelivered,
+	})
+	if err != nil {
+		t.Fatalf("insertMessage(%q) error = %v", id, err)
+	}
+}
+
+func messageIDs(msgs []domain.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+// --- Contacts ---
+
+func TestAddContact(t *testing.T) {
+	self := newSelf(t)
+	s := mustOpen(t, filepath.Join(t.TempDir(), FileName), self)
+	me, err := self.Profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := mustAddContact(t, s, "Alice")
+	byID := domain.PeerID{PublicID: "0123456789abcdef"}
+
+	tests := []struct {
+		name    string
+		peer    domain.PeerID
+		wantKey string
+		wantErr error
+	}{
+		{name: "by public ID", peer: byID},
+		{name: "duplicate", peer: domain.PeerID{PublicID: alice.PublicID}, wantErr: domain.ErrContactExists},
+		{name: "self", peer: domain.PeerID{PublicID: me.PublicID, PublicKey: me.PublicKey}, wantErr: domain.ErrSelfContact},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := s.AddContact(newCtx(), tt.peer, "Contact")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddContact() error = %v; want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := s.GetContact(newCtx(), c.PublicID)
+			if err != nil {
+				t.Fatalf("GetContact() error = %v", err)
+			}
+			if *got != *c {
+				t.Errorf("GetContact() = %+v; want %+v", *got, *c)
+			}
+			if got.PublicKey != tt.wantKey {
+				t.Errorf("PublicKey = %q; want %q", got.PublicKey, tt.wantKey)
+			}
+		})
+	}
+
+	got, err := s.GetContact(newCtx(), alice.PublicID)
+	if err != nil {
+		t.Fatalf("GetContact() error = %v", err)
+	}
+	if got.PublicKey != alice.PublicKey {
+		t.Errorf("stored key = %q; want %q", got.PublicKey, alice.PublicKey)
+	}
+}
+
+func TestGetContacts_SortedByName(t *testing.T) {
+	s := newStore(t)
+	for _, name := range []string{"Charlie", "Alice", "Bob"} {
+		mustAddContact(t, s, name)
+	}
+
+	contacts, err := s.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	var names []string
+	for _, c := range contacts {
+		names = append(names, c.DisplayName)
+	}
+	if fmt.Sprint(names) != "[Alice Bob Charlie]" {
+		t.Errorf("GetContacts() names = %v; want [Alice Bob Charlie]", names)
+	}
+}
+
+func TestContactUpdates(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	ctx := newCtx()
+
+	steps := []struct {
+		name  string
+		apply func(id string) error
+		check func(c *domain.Contact) bool
+	}{
+		{"block", func(id string) error { return s.BlockContact(ctx, id) }, func(c *domain.Contact) bool { return c.IsBlocked }},
+		{"unblock", func(id string) error { return s.UnblockContact(ctx, id) }, func(c *domain.Contact) bool { return !c.IsBlocked }},
+		{"verify", func(id string) error { return s.MarkContactVerified(ctx, id) }, func(c *domain.Contact) bool { return c.Verified }},
+		{"unverify", func(id string) error { return s.UnverifyContact(ctx, id) }, func(c *domain.Contact) bool { return !c.Verified }},
+		{"avatar", func(id string) error { return s.SetContactAvatar(ctx, id, "/avatars/a.png") }, func(c *domain.Contact) bool { return c.AvatarPath == "/avatars/a.png" }},
+		{"address", func(id string) error { return s.SetContactAddress(ctx, id, "192.0.2.7:47321") }, func(c *domain.Contact) bool { return c.Address == "192.0.2.7:47321" }},
+		{"last seen", func(id string) error { return s.SetContactLastSeen(ctx, id, 42) }, func(c *domain.Contact) bool { return c.LastSeen == 42 }},
+	}
+	for _, st := range steps {
+		t.Run(st.name, func(t *testing.T) {
+			if err := st.apply(c.PublicID); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			got, err := s.GetContact(ctx, c.PublicID)
+			if err != nil {
+				t.Fatalf("GetContact() error = %v", err)
+			}
+			if !st.check(got) {
+				t.Errorf("contact after %s = %+v", st.name, *got)
+			}
+			if err := st.apply("nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+				t.Errorf("%s(nonexistent) error = %v; want %v", st.name, err, domain.ErrContactNotFound)
+			}
+		})
+	}
+}
+
+func TestRemoveContact_DeletesHistory(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, c.PublicID, "m1", 1)
+
+	if err := s.RemoveContact(newCtx(), c.PublicID); err != nil {
+		t.Fatalf("RemoveContact() error = %v", err)
+	}
+	if err := s.RemoveContact(newCtx(), c.PublicID); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("RemoveContact(again) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+
+	// Re-adding the same contact must not resurrect old messages.
+	if _, err := s.AddContact(newCtx(), domain.PeerID{PublicID: c.PublicID, PublicKey: c.PublicKey}, "Alice"); err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	msgs, err := s.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("GetMessages() after re-add = %v; want none", messageIDs(msgs))
+	}
+}
+
+func TestGetSafetyNumber(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+
+	num, err := s.GetSafetyNumber(newCtx(), c.PublicID)
+	if err != nil {
+		t.Fatalf("GetSafetyNumber() error = %v", err)
+	}
+	if len(num) != 71 {
+		t.Errorf("GetSafetyNumber() = %q; want 12 groups of 5 digits", num)
+	}
+	if _, err := s.GetSafetyNumber(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("GetSafetyNumber(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+// --- Messages ---
+
+func TestGetMessages_Pagination(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	// m3 and m4 share a timestamp; insertion order breaks the tie.
+	for i, ts := range []int64{10, 20, 30, 30, 50} {
+		mustReceive(t, s, c.PublicID, fmt.Sprintf("m%d", i+1), ts)
+	}
+
+	tests := []struct {
+		name     string
+		contact  string
+		limit    int
+		beforeID string
+		want     string
+		wantErr  error
+	}{
+		{name: "latest page", contact: c.PublicID, limit: 2, want: "[m4 m5]"},
+		{name: "before m4", contact: c.PublicID, limit: 2, beforeID: "m4", want: "[m2 m3]"},
+		{name: "before m2", contact: c.PublicID, limit: 2, beforeID: "m2", want: "[m1]"},
+		{name: "before first", contact: c.PublicID, limit: 2, beforeID: "m1", want: "[]"},
+		{name: "default limit", contact: c.PublicID, want: "[m1 m2 m3 m4 m5]"},
+		{name: "unknown cursor", contact: c.PublicID, beforeID: "nope", wantErr: domain.ErrMessageNotFound},
+		{name: "unknown contact", contact: "nonexistent", wantErr: domain.ErrContactNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := s.GetMessages(newCtx(), tt.contact, tt.limit, tt.beforeID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetMessages() error = %v; want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := fmt.Sprint(messageIDs(msgs)); got != tt.want {
+				t.Errorf("GetMessages() = %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	self := newSelf(t)
+	s := mustOpen(t, filepath.Join(t.TempDir(), FileName), self)
+	c := mustAddContact(t, s, "Alice")
+
+	msg, err := s.SendMessage(newCtx(), c.PublicID, "hello")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if msg.SenderID != self.PublicID() || msg.Status != domain.StatusSending {
+		t.Errorf("SendMessage() = %+v; want sender %q in %q", *msg, self.PublicID(), domain.StatusSending)
+	}
+	if _, err := s.SendMessage(newCtx(), "nonexistent", "hello"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("SendMessage(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestLearnContactKey(t *testing.T) {
+	s := newStore(t)
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	peer, err := domain.NewPeerID(keys.PublicID(), "")
+	if err != nil {
+		t.Fatalf("NewPeerID() error = %v", err)
+	}
+	if _, err := s.AddContact(newCtx(), peer, "Alice"); err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	// Without a key there is no safety number to compare.
+	if err := s.MarkContactVerified(newCtx(), peer.PublicID); !errors.Is(err, domain.ErrContactKeyUnknown) {
+		t.Errorf("MarkContactVerified() before the key is known error = %v; want %v", err, domain.ErrContactKeyUnknown)
+	}
+	// A database from before that check may hold such a contact verified.
+	if _, err := s.db.ExecContext(newCtx(), `UPDATE contacts SET is_verified = 1`); err != nil {
+		t.Fatalf("mark verified: %v", err)
+	}
+
+	other, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if err := s.LearnContactKey(newCtx(), peer.PublicID, other.Public); !errors.Is(err, domain.ErrPublicIDMismatch) {
+		t.Errorf("LearnContactKey(other key) error = %v; want %v", err, domain.ErrPublicIDMismatch)
+	}
+	if err := s.LearnContactKey(newCtx(), peer.PublicID, keys.Public); err != nil {
+		t.Fatalf("LearnContactKey() error = %v", err)
+	}
+	c, err := s.GetContact(newCtx(), peer.PublicID)
+	if err != nil {
+		t.Fatalf("GetContact() error = %v", err)
+	}
+	if c.PublicKey != keys.PublicKeyHex() || c.Verified {
+		t.Errorf("contact = %+v; want key %q, unverified", c, keys.PublicKeyHex())
+	}
+	if err := s.MarkContactVerified(newCtx(), peer.PublicID); err != nil {
+		t.Errorf("MarkContactVerified() error = %v", err)
+	}
+	if err := s.LearnContactKey(newCtx(), other.PublicID(), other.Public); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("LearnContactKey(unknown) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestReplaceContactKey(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	bob := mustAddContact(t, s, "Bob")
+	mustReceive(t, s, alice.PublicID, "m1", 1)
+	if _, err := s.SendMessage(newCtx(), alice.PublicID, "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if err := s.MarkContactVerified(newCtx(), alice.PublicID); err != nil {
+		t.Fatalf("MarkContactVerified() error = %v", err)
+	}
+
+	next, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	c, wasVerified, err := s.ReplaceContactKey(newCtx(), alice.PublicID, next.Public)
+	if err != nil {
+		t.Fatalf("ReplaceContactKey() error = %v", err)
+	}
+	if !wasVerified {
+		t.Error("ReplaceContactKey() wasVerified = false; want true")
+	}
+	if c.PublicID != next.PublicID() || c.PublicKey != next.PublicKeyHex() || c.Verified || c.DisplayName != "Alice" {
+		t.Errorf("ReplaceContactKey() = %+v; want unverified Alice at %s", *c, next.PublicID())
+	}
+	got, err := s.GetContact(newCtx(), next.PublicID())
+	if err != nil {
+		t.Fatalf("GetContact(new ID) error = %v", err)
+	}
+	if *got != *c {
+		t.Errorf("GetContact(new ID) = %+v; want %+v", *got, *c)
+	}
+	if _, err := s.GetContact(newCtx(), alice.PublicID); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("GetContact(old ID) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+
+	msgs, err := s.GetMessages(newCtx(), next.PublicID(), 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("GetMessages() = %+v; want the two messages of the chat", msgs)
+	}
+	for _, m := range msgs {
+		incoming := m.ID == "m1"
+		if m.ChatID != next.PublicID() || (m.SenderID == next.PublicID()) != incoming {
+			t.Errorf("message %s: chat %q sender %q after key change", m.ID, m.ChatID, m.SenderID)
+		}
+	}
+
+	if _, _, err := s.ReplaceContactKey(newCtx(), next.PublicID(), next.Public); err != nil {
+		t.Errorf("ReplaceContactKey(same key) error = %v", err)
+	}
+	bobKey, err := identity.ParsePublicKey(bob.PublicKey)
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	if _, _, err := s.ReplaceContactKey(newCtx(), next.PublicID(), bobKey); !errors.Is(err, domain.ErrContactExists) {
+		t.Errorf("ReplaceContactKey(taken) error = %v; want %v", err, domain.ErrContactExists)
+	}
+	if _, _, err := s.ReplaceContactKey(newCtx(), alice.PublicID, next.Public); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("ReplaceContactKey(unknown) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestRotateContactKey(t *testing.T) {
+	s := newStore(t)
+	old, next, intruder := mustGenerate(t), mustGenerate(t), mustGenerate(t)
+	peer, err := domain.NewPeerID("", old.PublicKeyHex())
+	if err != nil {
+		t.Fatalf("NewPeerID() error = %v", err)
+	}
+	if _, err := s.AddContact(newCtx(), peer, "Alice"); err != nil {
+		t.Fatalf("AddContact() error = %v", err)
+	}
+	mustReceive(t, s, old.PublicID(), "m1", 1)
+
+	forged := identity.SignRotation(intruder, next, 1)
+	forged.OldPublicKey = old.PublicKeyHex()
+	if _, _, err := s.RotateContactKey(newCtx(), *forged); !errors.Is(err, domain.ErrInvalidRotation) {
+		t.Fatalf("RotateContactKey(forged) error = %v; want %v", err, domain.ErrInvalidRotation)
+	}
+
+	cert := identity.SignRotation(old, next, 1)
+	c, _, err := s.RotateContactKey(newCtx(), *cert)
+	if err != nil {
+		t.Fatalf("RotateContactKey() error = %v", err)
+	}
+	if c.PublicID != next.PublicID() || c.PublicKey != next.PublicKeyHex() {
+		t.Errorf("RotateContactKey() = %+v; want Alice at %s", *c, next.PublicID())
+	}
+	if msgs, err := s.GetMessages(newCtx(), next.PublicID(), 0, ""); err != nil || len(msgs) != 1 {
+		t.Errorf("GetMessages() = %+v, %v; want the message from before", msgs, err)
+	}
+	if _, _, err := s.RotateContactKey(newCtx(), *cert); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("RotateContactKey(again) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestAckRotation(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	for _, issuedAt := range []int64{20, 10} {
+		if err := s.AckRotation(newCtx(), c.PublicID, issuedAt); err != nil {
+			t.Fatalf("AckRotation(%d) error = %v", issuedAt, err)
+		}
+	}
+	if got, err := s.RotationAcked(newCtx(), c.PublicID); err != nil || got != 20 {
+		t.Errorf("RotationAcked() = %d, %v; want 20, the newest ack", got, err)
+	}
+	if err := s.AckRotation(newCtx(), "nonexistent", 1); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("AckRotation(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+	if _, err := s.RotationAcked(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("RotationAcked(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	ctx := newCtx()
+
+	var sent []string
+	for _, text := range []string{"one", "two", "three"} {
+		msg, err := s.SendMessage(ctx, c.PublicID, text)
+		if err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+		sent = append(sent, msg.ID)
+	}
+
+	// Incoming messages are stored once, however often they arrive.
+	in := domain.Message{ID: "in-1", ChatID: c.PublicID, SenderID: c.PublicID, Content: "hi", Timestamp: 1, Status: domain.StatusDelivered}
+	for i, want := range []bool{true, false} {
+		isNew, err := s.ReceiveMessage(ctx, in)
+		if err != nil || isNew != want {
+			t.Errorf("ReceiveMessage() #%d = %v, %v; want %v", i+1, isNew, err, want)
+		}
+	}
+	if _, err := s.ReceiveMessage(ctx, domain.Message{ID: "in-2", ChatID: "nonexistent"}); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("ReceiveMessage(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+
+	// Statuses only advance, and only on outgoing messages.
+	steps := []struct {
+		id      string
+		status  domain.MessageStatus
+		changed bool
+		wantErr error
+	}{
+		{sent[0], domain.StatusSent, true, nil},
+		{sent[0], domain.StatusDelivered, true, nil},
+		{sent[0], domain.StatusSent, false, nil},
+		{sent[1], domain.StatusDelivered, true, nil},
+		{"in-1", domain.StatusRead, false, domain.ErrMessageNotFound},
+		{"nonexistent", domain.StatusSent, false, domain.ErrMessageNotFound},
+	}
+	for _, st := range steps {
+		changed, err := s.UpdateMessageStatus(ctx, c.PublicID, st.id, st.status)
+		if changed != st.changed || !errors.Is(err, st.wantErr) {
+			t.Errorf("UpdateMessageStatus(%s, %s) = %v, %v; want %v, %v", st.id, st.status, changed, err, st.changed, st.wantErr)
+		}
+	}
+
+	pending, err := s.PendingMessages(ctx, c.PublicID)
+	if err != nil {
+		t.Fatalf("PendingMessages() error = %v", err)
+	}
+	if got := messageIDs(pending); len(got) != 1 || got[0] != sent[2] {
+		t.Errorf("PendingMessages() = %v; want [%s]", got, sent[2])
+	}
+
+	read, err := s.MarkReadUpTo(ctx, c.PublicID, sent[1])
+	if err != nil {
+		t.Fatalf("MarkReadUpTo() error = %v", err)
+	}
+	if len(read) != 2 {
+		t.Errorf("MarkReadUpTo() = %v; want the first two messages", read)
+	}
+	msgs, err := s.GetMessages(ctx, c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	for _, m := range msgs {
+		wantRead := m.ID == sent[0] || m.ID == sent[1]
+		if (m.Status == domain.StatusRead) != wantRead {
+			t.Errorf("message %s status = %s", m.Content, m.Status)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	ctx := newCtx()
+
+	due := func(now time.Time) []string {
+		t.Helper()
+		msgs, err := s.DueMessages(ctx, c.PublicID, now)
+		if err != nil {
+			t.Fatalf("DueMessages() error = %v", err)
+		}
+		return messageIDs(msgs)
+	}
+
+	start := time.Now()
+	one, err := s.SendMessage(ctx, c.PublicID, "one")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	two, err := s.SendMessage(ctx, c.PublicID, "two")
+	if err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if got := due(start.Add(time.Second)); !slices.Equal(got, []string{one.ID, two.ID}) {
+		t.Errorf("due after send = %v; want [%s %s]", got, one.ID, two.ID)
+	}
+
+	// Each attempt doubles the wait, up to the maximum.
+	now := start.Add(time.Second)
+	for i, wait := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second} {
+		if err := s.ScheduleRetry(ctx, one.ID, now, 10*time.Second, 30*time.Second); err != nil {
+			t.Fatalf("ScheduleRetry() error = %v", err)
+		}
+		if got := due(now.Add(wait - time.Millisecond)); !slices.Equal(got, []string{two.ID}) {
+			t.Errorf("attempt %d: due before %v = %v; want [%s]", i+1, wait, got, two.ID)
+		}
+		if got := due(now.Add(wait)); len(got) != 2 {
+			t.Errorf("attempt %d: due after %v = %v; want both", i+1, wait, got)
+		}
+	}
+
+	// Sent messages stay queued until they are delivered.
+	for _, status := range []domain.MessageStatus{domain.StatusSent, domain.StatusDelivered} {
+		if _, err := s.UpdateMessageStatus(ctx, c.PublicID, one.ID, status); err != nil {
+			t.Fatalf("UpdateMessageStatus(%s) error = %v", status, err)
+		}
+	}
+	if got := due(now.Add(time.Hour)); !slices.Equal(got, []string{two.ID}) {
+		t.Errorf("due after delivery = %v; want [%s]", got, two.ID)
+	}
+	if _, err := s.RetryMessage(ctx, one.ID); !errors.Is(err, domain.ErrMessageNotFailed) {
+		t.Errorf("RetryMessage(delivered) error = %v; want %v", err, domain.ErrMessageNotFailed)
+	}
+
+	// Expiry works without the data key.
+	s.Lock()
+	failed, err := s.FailExpired(ctx, start.Add(domain.DefaultOutboxTTLHours*time.Hour-time.Minute))
+	if err != nil || len(failed) != 0 {
+		t.Errorf("FailExpired(before TTL) = %v, %v; want none", failed, err)
+	}
+	failed, err = s.FailExpired(ctx, now.Add(domain.DefaultOutboxTTLHours*time.Hour))
+	if err != nil {
+		t.Fatalf("FailExpired() error = %v", err)
+	}
+	if err := s.Unlock(ctx, nil); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if got := failed[c.PublicID]; !slices.Equal(got, []string{two.ID}) {
+		t.Errorf("FailExpired() = %v; want [%s]", failed, two.ID)
+	}
+	if got := due(now.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("due after expiry = %v; want none", got)
+	}
+
+	msg, err := s.RetryMessage(ctx, two.ID)
+	if err != nil {
+		t.Fatalf("RetryMessage() error = %v", err)
+	}
+	if msg.ID != two.ID || msg.Content != "two" || msg.Status != domain.StatusSending {
+		t.Errorf("RetryMessage() = %+v; want %q in %q", *msg, "two", domain.StatusSending)
+	}
+	if got := due(time.Now()); !slices.Equal(got, []string{two.ID}) {
+		t.Errorf("due after retry = %v; want [%s]", got, two.ID)
+	}
+	if _, err := s.RetryMessage(ctx, "nonexistent"); !errors.Is(err, domain.ErrMessageNotFound) {
+		t.Errorf("RetryMessage(nonexistent) error = %v; want %v", err, domain.ErrMessageNotFound)
+	}
+}
+
+func TestChatSummaries(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	bob := mustAddContact(t, s, "Bob")
+	quiet := mustAddContact(t, s, "Quiet")
+
+	mustReceive(t, s, alice.PublicID, "a1", quiet.AddedAt+1)
+	mustReceive(t, s, alice.PublicID, "a2", quiet.AddedAt+2)
+	mustReceive(t, s, bob.PublicID, "b1", quiet.AddedAt+10)
+	err := s.insertMessage(newCtx(), domain.Message{
+		ID:        "reply",
+		ChatID:    alice.PublicID,
+		SenderID:  s.self.PublicID(),
+		Content:   "reply",
+		Timestamp: quiet.AddedAt + 20,
+		Status:    domain.StatusSent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := s.GetChatSummaries(newCtx())
+	if err != nil {
+		t.Fatalf("GetChatSummaries() error = %v", err)
+	}
+	if len(summaries) != 3 {
+		t.Fatalf("GetChatSummaries() returned %d summaries; want 3", len(summaries))
+	}
+	if summaries[0].ContactID != alice.PublicID || summaries[1].ContactID != bob.PublicID || summaries[2].ContactID != quiet.PublicID {
+		t.Errorf("order = %s, %s, %s; want Alice, Bob, Quiet",
+			summaries[0].Contact.DisplayName, summaries[1].Contact.DisplayName, summaries[2].Contact.DisplayName)
+	}
+	if got := summaries[0]; got.UnreadCount != 2 || got.LastMessage == nil || got.LastMessage.Content != "reply" {
+		t.Errorf("Alice summary = %+v; want 2 unread, last message %q", got, "reply")
+	}
+	if summaries[2].LastMessage != nil {
+		t.Errorf("Quiet LastMessage = %+v; want nil", summaries[2].LastMessage)
+	}
+
+	if err := s.MarkAsRead(newCtx(), alice.PublicID); err != nil {
+		t.Fatalf("MarkAsRead() error = %v", err)
+	}
+	summaries, err = s.GetChatSummaries(newCtx())
+	if err != nil {
+		t.Fatalf("GetChatSummaries() error = %v", err)
+	}
+	if summaries[0].UnreadCount != 0 || summaries[1].UnreadCount != 1 {
+		t.Errorf("unread after MarkAsRead = %d, %d; want 0, 1", summaries[0].UnreadCount, summaries[1].UnreadCount)
+	}
+	if err := s.MarkAsRead(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("MarkAsRead(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestClearHistory(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, c.PublicID, "m1", 1)
+
+	if err := s.ClearHistory(newCtx(), c.PublicID); err != nil {
+		t.Fatalf("ClearHistory() error = %v", err)
+	}
+	msgs, err := s.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("GetMessages() after clear = %v; want none", messageIDs(msgs))
+	}
+	if err := s.ClearHistory(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("ClearHistory(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestImportMessages(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, c.PublicID, "m2", 20)
+	imported := []domain.Message{
+		{ID: "m1", SenderID: c.PublicID, Content: "older", Timestamp: 10, Status: domain.StatusRead},
+		{ID: "m2", SenderID: c.PublicID, Content: "already here", Timestamp: 20, Status: domain.StatusRead},
+		{ID: "m3", SenderID: s.self.PublicID(), Content: "reply", Timestamp: 30, Status: domain.StatusDelivered},
+		{ID: "m1", SenderID: c.PublicID, Content: "older, again", Timestamp: 10, Status: domain.StatusRead},
+	}
+
+	n, err := s.ImportMessages(newCtx(), c.PublicID, imported, true)
+	if err != nil || n != 2 {
+		t.Fatalf("ImportMessages(dry run) = %d, %v; want 2", n, err)
+	}
+	if msgs, _ := s.GetMessages(newCtx(), c.PublicID, 0, ""); len(msgs) != 1 {
+		t.Fatalf("dry run stored messages: %v", messageIDs(msgs))
+	}
+
+	n, err = s.ImportMessages(newCtx(), c.PublicID, imported, false)
+	if err != nil || n != 2 {
+		t.Fatalf("ImportMessages() = %d, %v; want 2", n, err)
+	}
+	msgs, err := s.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if got := fmt.Sprint(messageIDs(msgs)); got != "[m1 m2 m3]" {
+		t.Errorf("history = %s; want [m1 m2 m3]", got)
+	}
+	if msgs[1].Content != "from "+c.PublicID {
+		t.Errorf("existing message overwritten: %q", msgs[1].Content)
+	}
+	if got := mustSearch(t, s, "older", ""); len(got) != 1 {
+		t.Errorf("imported message not searchable: %v", got)
+	}
+
+	if _, err := s.ImportMessages(newCtx(), "nonexistent", imported, false); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("ImportMessages(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestRebindOwnMessages(t *testing.T) {
+	s := newStore(t)
+	c := mustAddContact(t, s, "Alice")
+	mustReceive(t, s, c.PublicID, "in", 1)
+	if _, err := s.SendMessage(newCtx(), c.PublicID, "out"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RebindOwnMessages(newCtx(), "new-self"); err != nil {
+		t.Fatalf("RebindOwnMessages() error = %v", err)
+	}
+	msgs, err := s.GetMessages(newCtx(), c.PublicID, 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	for _, m := range msgs {
+		want := "new-self"
+		if m.ID == "in" {
+			want = c.PublicID
+		}
+		if m.SenderID != want {
+			t.Errorf("message %q SenderID = %q; want %q", m.ID, m.SenderID, want)
+		}
+	}
+}
+
+// --- Retention ---
+
+func TestExpireMessages(t *testing.T) {
+	s := newStore(t)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) int64 { return now.AddDate(0, 0, -n).UnixMilli() }
+
+	alice := mustAddContact(t, s, "Alice") // follows the global 30 days
+	bob := mustAddContact(t, s, "Bob")
+	carol := mustAddContact(t, s, "Carol")
+	for _, c := range []*domain.Contact{alice, bob, carol} {
+		mustReceive(t, s, c.PublicID, c.DisplayName+"-40d", daysAgo(40))
+		mustReceive(t, s, c.PublicID, c.DisplayName+"-10d", daysAgo(10))
+		mustReceive(t, s, c.PublicID, c.DisplayName+"-1d", daysAgo(1))
+	}
+	if err := s.UpdateSettings(newCtx(), domain.Settings{Theme: "system", SidebarWidth: 320, RetentionDays: 30}); err != nil {
+		t.Fatalf("UpdateSettings() error = %v", err)
+	}
+	if err := s.SetChatRetention(newCtx(), bob.PublicID, 7); err != nil {
+		t.Fatalf("SetChatRetention(Bob) error = %v", err)
+	}
+	if err := s.SetChatRetention(newCtx(), carol.PublicID, domain.RetentionForever); err != nil {
+		t.Fatalf("SetChatRetention(Carol) error = %v", err)
+	}
+	if err := s.SetChatRetention(newCtx(), "nonexistent", 7); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("SetChatRetention(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+
+	// Expiry works without the data key.
+	s.Lock()
+	expired, err := s.ExpireMessages(newCtx(), now)
+	if err != nil {
+		t.Fatalf("ExpireMessages() error = %v", err)
+	}
+	if err := s.Unlock(newCtx(), nil); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+
+	got := make(map[string]domain.ExpiredMessages)
+	for _, e := range expired {
+		got[e.ChatID] = e
+	}
+	want := map[string]domain.ExpiredMessages{
+		alice.PublicID: {ChatID: alice.PublicID, Before: daysAgo(30), Count: 1},
+		bob.PublicID:   {ChatID: bob.PublicID, Before: daysAgo(7), Count: 2},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expired = %+v; want %+v", got, want)
+	}
+
+	tests := []struct {
+		contact *domain.Contact
+		want    string
+	}{
+		{alice, "[Alice-10d Alice-1d]"},
+		{bob, "[Bob-1d]"},
+		{carol, "[Carol-40d Carol-10d Carol-1d]"},
+	}
+	for _, tt := range tests {
+		msgs, err := s.GetMessages(newCtx(), tt.contact.PublicID, 0, "")
+		if err != nil {
+			t.Fatalf("GetMessages(%s) error = %v", tt.contact.DisplayName, err)
+		}
+		if got := fmt.Sprint(messageIDs(msgs)); got != tt.want {
+			t.Errorf("%s history = %s; want %s", tt.contact.DisplayName, got, tt.want)
+		}
+	}
+	var orphans int
+	s.db.QueryRow(`SELECT COUNT(*) FROM message_terms WHERE seq NOT IN (SELECT seq FROM messages)`).Scan(&orphans)
+	if orphans != 0 {
+		t.Errorf("%d search terms of expired messages kept", orphans)
+	}
+	c, err := s.GetContact(newCtx(), bob.PublicID)
+	if err != nil || c.RetentionDays != 7 {
+		t.Errorf("GetContact(Bob) = %+v, %v; want RetentionDays 7", c, err)
+	}
+
+	if again, err := s.ExpireMessages(newCtx(), now); err != nil || len(again) != 0 {
+		t.Errorf("second ExpireMessages() = %+v, %v; want nothing", again, err)
+	}
+}
+
+// --- Storage ---
+
+func TestStorageStatsAndCompact(t *testing.T) {
+	s := newStore(t)
+	alice := mustAddContact(t, s, "Alice")
+	bob := mustAddContact(t, s, "Bob")
+	mustReceive(t, s, bob.PublicID, "b1", 1)
+	history := make([]domain.Message, 500)
+	for i := range history {
+		history[i] = domain.Message{
+			ID:        fmt.Sprintf("a%d", i),
+			SenderID:  alice.PublicID,
+			Content:   strings.Repeat(fmt.Sprintf("word%d ", i), 200),
+			Timestamp: int64(i),
+			Status:    domain.StatusRead,
+		}
+	}
+	if _, err := s.ImportMessages(newCtx(), alice.PublicID, history, false); err != nil {
+		t.Fatalf("ImportMessages() error = %v", err)
+	}
+
+	stats, err := s.StorageStats(newCtx())
+	if err != nil {
+		t.Fatalf("StorageStats() error = %v", err)
+	}
+	if stats.MessageCount != 501 || len(stats.Chats) != 2 {
+		t.Fatalf("stats = %+v; want 501 messages in 2 chats", stats)
+	}
+	if c := stats.Chats[0]; c.ContactID != alice.PublicID || c.MessageCount != 500 || c.MessageBytes < 500*1000 {
+		t.Errorf("largest chat = %+v; want Alice's 500 messages", c)
+	}
+	if stats.DatabaseBytes < stats.MessageBytes {
+		t.Errorf("DatabaseBytes = %d; want at least the %d message bytes", stats.DatabaseBytes, stats.MessageBytes)
+	}
+
+	if err := s.ClearHistory(newCtx(), alice.PublicID); err != nil {
+		t.Fatalf("ClearHistory() error = %v", err)
+	}
+	if stats, _ = s.StorageStats(newCtx()); stats.FreeBytes == 0 {
+		t.Fatal("FreeBytes = 0 after clearing a large chat")
+	}
+
+	// Other callers get through while compaction runs.
+	done := make(chan error)
+	go func() {
+		for i := range 20 {
+			if _, err := s.SendMessage(newCtx(), bob.PublicID, fmt.Sprint("during compaction ", i)); err != nil {
+				done <- err
+				return
+			}
+			if _, err := s.GetMessages(newCtx(), bob.PublicID, 0, ""); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	res, err := s.Compact(newCtx())
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("call during compaction error = %v", err)
+	}
+	if res.BytesReclaimed <= 0 || res.BytesAfter != res.BytesBefore-res.BytesReclaimed {
+		t.Errorf("Compact() = %+v; want space reclaimed", res)
+	}
+	if stats, _ = s.StorageStats(newCtx()); stats.FreeBytes != 0 || stats.MessageCount != 21 {
+		t.Errorf("stats after Compact() = %+v; want no free pages and Bob's 21 messages", stats)
+	}
+}
+
+// --- Settings ---
+
+func TestSettings_PersistAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	self := newSelf(t)
+	s := mustOpen(t, path, self)
+
+	got, err := s.GetSettings(newCtx())
+	if err != nil {
+		t.Fatalf("GetSettings() error = %v", err)
+	}
+	if *got != domain.DefaultSettings() {
+		t.Errorf("GetSettings() on new store = %+v; want defaults", *got)
+	}
+
+	want := domain.Settings{Theme: "dark", SoundOn: true, SidebarWidth: 280, RetentionDays: 30, OutboxTTLHours: 24}
+	if err := s.UpdateSettings(newCtx(), want); err != nil {
+		t.Fatalf("UpdateSettings() error = %v", err)
+	}
+	s.Close()
+
+	got, err = mustOpen(t, path, self).GetSettings(newCtx())
+	if err != nil {
+		t.Fatalf("GetSettings() after reopen error = %v", err)
+	}
+	if *got != want {
+		t.Errorf("GetSettings() after reopen = %+v; want %+v", *got, want)
+	}
+}
@@ -0,0 +1,48 @@
+-- Database at schema version 1 (0001_init.sql).
+
+CREATE TABLE contacts (
+    public_id    TEXT PRIMARY KEY,
+    public_key   BLOB NOT NULL,
+    display_name TEXT NOT NULL,
+    avatar_path  TEXT NOT NULL DEFAULT '',
+    is_blocked   INTEGER NOT NULL DEFAULT 0,
+    is_verified  INTEGER NOT NULL DEFAULT 0,
+    last_seen    INTEGER NOT NULL DEFAULT 0,
+    added_at     INTEGER NOT NULL
+);
+
+CREATE TABLE messages (
+    id        TEXT PRIMARY KEY,
+    chat_id   TEXT NOT NULL REFERENCES contacts(public_id) ON DELETE CASCADE,
+    sender_id TEXT NOT NULL,
+    content   TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    status    TEXT NOT NULL DEFAULT 'sending',
+    CHECK (status IN ('sending','sent','delivered','read','failed'))
+);
+
+CREATE INDEX idx_messages_chat_time ON messages(chat_id, timestamp DESC);
+CREATE INDEX idx_messages_chat_status ON messages(chat_id, status);
+
+CREATE TABLE settings (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+INSERT INTO contacts (public_id, public_key, display_name, is_verified, last_seen, added_at)
+VALUES ('0123456789abcdef', X'', 'Alice', 1, 1700000000000, 1700000000000);
+
+INSERT INTO messages (id, chat_id, sender_id, content, timestamp, status) VALUES
+    ('m1', '0123456789abcdef', '0123456789abcdef', 'Привет, fixture!', 1700000001000, 'delivered'),
+    ('m2', '0123456789abcdef', 'fedcba9876543210', 'Hello back', 1700000002000, 'sent');
+
+INSERT INTO settings (key, value) VALUES ('theme', 'dark');
+
+CREATE TABLE schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at INTEGER NOT NULL
+);
+
+INSERT INTO schema_migrations (version, name, applied_at)
+VALUES (1, '0001_init.sql', 1700000000000);
@@ -0,0 +1,99 @@
+-- Database at schema version 5 (0005_contact_address.sql), unlocked once by an
+-- identity without a passphrase: the data key is stored unwrapped.
+
+CREATE TABLE contacts (
+    public_id      TEXT PRIMARY KEY,
+    public_key     BLOB NOT NULL,
+    display_name   TEXT NOT NULL,
+    avatar_path    TEXT NOT NULL DEFAULT '',
+    is_blocked     INTEGER NOT NULL DEFAULT 0,
+    is_verified    INTEGER NOT NULL DEFAULT 0,
+    last_seen      INTEGER NOT NULL DEFAULT 0,
+    added_at       INTEGER NOT NULL,
+    retention_days INTEGER NOT NULL DEFAULT 0,
+    address        TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE messages (
+    seq       INTEGER PRIMARY KEY,
+    id        TEXT NOT NULL UNIQUE,
+    chat_id   TEXT NOT NULL REFERENCES contacts(public_id) ON DELETE CASCADE,
+    sender_id TEXT NOT NULL,
+    content   TEXT NOT NULL,
+    timestamp INTEGER NOT NULL,
+    status    TEXT NOT NULL DEFAULT 'sending',
+    CHECK (status IN ('sending','sent','delivered','read','failed'))
+);
+
+CREATE INDEX idx_messages_chat_time ON messages(chat_id, timestamp DESC);
+
+CREATE INDEX idx_messages_chat_status ON messages(chat_id, status);
+
+CREATE TABLE settings (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at INTEGER NOT NULL
+);
+
+CREATE TABLE keyring (
+    id       INTEGER PRIMARY KEY CHECK (id = 1),
+    data_key BLOB NOT NULL,
+    sealed   INTEGER NOT NULL
+);
+
+CREATE TABLE message_terms (
+    term BLOB NOT NULL,
+    seq  INTEGER NOT NULL REFERENCES messages(seq) ON DELETE CASCADE,
+    PRIMARY KEY (term, seq)
+) WITHOUT ROWID;
+
+CREATE INDEX idx_message_terms_seq ON message_terms(seq);
+
+INSERT INTO contacts (public_id, public_key, display_name, avatar_path, is_blocked, is_verified, last_seen, added_at, retention_days, address) VALUES
+    ('0123456789abcdef', X'9A673C9C3E9320B23E92327BA4372B0766C25B72CBD5E95EA4C7523ED9C4503ABD26FCDD71764238', 'Alice', '', 0, 1, 1700000000000, 1700000000000, 0, '');
+
+INSERT INTO messages (seq, id, chat_id, sender_id, content, timestamp, status) VALUES
+    (1, 'm1', '0123456789abcdef', '0123456789abcdef', X'E44F5C2778ADB2C19CF7C07EA8E58298645D7FB1F5BF5D44F8CA0DCB86125C646614E1B9BEAC0A6734A90BBC3FD8501183D6CAF05A585918AA74DE399D8B', 1700000001000, 'delivered'),
+    (2, 'm2', '0123456789abcdef', 'fedcba9876543210', X'331D17A03D0A31AABFDD957E165B52B93723080C4D8E69F775A2A2FB3DDC74199D815F6F5435B452432C23AB726C3585CA77', 1700000002000, 'sent');
+
+INSERT INTO settings (key, value) VALUES
+    ('theme', 'dark');
+
+INSERT INTO schema_migrations (version, name, applied_at) VALUES
+    (1, '0001_init.sql', 1700000000000),
+    (2, '0002_message_search.sql', 1700000000000),
+    (3, '0003_encryption.sql', 1700000000000),
+    (4, '0004_retention.sql', 1700000000000),
+    (5, '0005_contact_address.sql', 1700000000000);
+
+INSERT INTO keyring (id, data_key, sealed) VALUES
+    (1, X'B627702DF437E3006A4DD5FA7211F74464078EE98432A1034F0208C15DA2B21A', 0);
+
+INSERT INTO message_terms (term, seq) VALUES
+    (X'209B4E37F399A26F573589535DC9C4C4', 1),
+    (X'288547789EA599BCE45060EB0C65372C', 1),
+    (X'3D567A6CC66E9C07ADEAB360A7E364FC', 1),
+    (X'6C74A037651C322B8DFC6ADCE92EF922', 1),
+    (X'6EFEF753DA37489471E827CB0D124FFB', 1),
+    (X'8431F209F5419B0346E84DA0D6C87E54', 1),
+    (X'8F0A6F10AF5EC0C34B76839373D64156', 1),
+    (X'996595F724A81E436887A6E56DDD291F', 1),
+    (X'B17F0AA0AEAE9A5074EF5C53D6EF39D0', 1),
+    (X'B43B43E35C2AA93889410ACD4F15E3C2', 1),
+    (X'C03DFE46F86BE30B987EBD564B384769', 1),
+    (X'D02D671505DDD556FD2CF32B04F1B3BF', 1),
+    (X'D224979049997668FEAB978BD0E63BF8', 1),
+    (X'0BAE2D3AF07E87526C9E71E568C94479', 2),
+    (X'1D3EA6F4E07B4BA1EA2DE77473B4E768', 2),
+    (X'2A3EF220E4DCC1BC18243C63AB39F2FA', 2),
+    (X'6769BEFDA0108FC4B1E2CE1C78B5B2D9', 2),
+    (X'7F00EBB93E4AEDA818133A079A69C384', 2),
+    (X'95F519F3ECF7FDC7D0D206C11EED72CB', 2),
+    (X'9B93047E7631CA062F977D6D069EAE30', 2),
+    (X'B706E80FD52B320C77ED6171DA2D4159', 2),
+    (X'B70D1EC45B19D56EB63124575A514EB8', 2);
@@ -1,19 +1,36 @@
 package stub
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"time"
 
 	"quillet/internal/domain"
+	"quillet/internal/identity"
 )
 
-func defaultProfile() *domain.User {
-	return &domain.User{
-		PublicID:    "me-public-id-0000",
-		PublicKey:   "me-public-key-0000",
+// demoSeed is the fixed Ed25519 seed of the demo identity,
+// so its PublicID stays the same across runs.
+var demoSeed = sha256.Sum256([]byte("quillet stub demo identity"))
+
+func defaultIdentity() *identity.Manager {
+	keys := identity.NewKeyPair(ed25519.NewKeyFromSeed(demoSeed[:]))
+	return identity.NewManagerFor(keys, domain.User{
 		DisplayName: "Me",
 		AvatarPath:  "",
 		CreatedAt:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli(),
-	}
+	})
+}
+
+// demoContactKeys returns a stable Ed25519 key pair for a demo contact.
+func demoContactKeys(name string) *identity.KeyPair {
+	seed := sha256.Sum256([]byte("quillet stub demo contact " + name))
+	return identity.NewKeyPair(ed25519.NewKeyFromSeed(seed[:]))
+}
+
+// demoContactKey returns the public key (hex) of a demo contact.
+func demoContactKey(name string) string {
+	return demoContactKeys(name).PublicKeyHex()
 }
 
 func defaultContacts() map[string]*domain.Contact {
@@ -21,7 +38,7 @@ func defaultContacts() map[string]*domain.Contact {
 	return map[string]*domain.Contact{
 		"alice-id": {
 			PublicID:    "alice-id",
-			PublicKey:   "alice-pub-key",
+			PublicKey:   demoContactKey("alice"),
 			DisplayName: "Alice",
 			AvatarPath:  "",
 			IsOnline:    true,
@@ -31,7 +48,7 @@ func defaultContacts() map[string]*domain.Contact {
 		},
 		"bob-id": {
 			PublicID:    "bob-id",
-			PublicKey:   "bob-pub-key",
+			PublicKey:   demoContactKey("bob"),
 			DisplayName: "Bob",
 			AvatarPath:  "",
 			IsOnline:    false,
@@ -41,7 +58,7 @@ func defaultContacts() map[string]*domain.Contact {
 		},
 		"charlie-id": {
 			PublicID:    "charlie-id",
-			PublicKey:   "charlie-pub-key",
+			PublicKey:   demoContactKey("charlie"),
 			DisplayName: "Charlie",
 			AvatarPath:  "",
 			IsOnline:    true,
@@ -51,7 +68,7 @@ func defaultContacts() map[string]*domain.Contact {
 		},
 		"diana-id": {
 			PublicID:    "diana-id",
-			PublicKey:   "diana-pub-key",
+			PublicKey:   demoContactKey("diana"),
 			DisplayName: "Diana",
 			AvatarPath:  "",
 			IsOnline:    false,
@@ -159,16 +176,9 @@ func defaultUnreadCounts() map[string]int {
 	}
 }
 
-const defaultSidebarWidth = 320
-
 func defaultSettings() *domain.Settings {
-	return &domain.Settings{
-		Theme:              "system",
-		NotificationsOn:    true,
-		SoundOn:            true,
-		ShowMessagePreview: true,
-		SidebarWidth:       defaultSidebarWidth,
-	}
+	settings := domain.DefaultSettings()
+	return &settings
 }
 
 // autoReplies are canned responses the stub sends back after the user sends a message.
@@ -0,0 +1,48 @@
+package stub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"quillet/internal/domain"
+)
+
+// ImportMessages merges msgs into the in-memory history of contactID,
+// skipping IDs it already holds.
+func (s *StubMessenger) ImportMessages(ctx context.Context, contactID string, msgs []domain.Message, dryRun bool) (int, error) {
+	if !simulateDelay(ctx, delayMediumMin, delayMediumMax) {
+		return 0, ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return 0, fmt.Errorf("import messages: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.contacts[contactID]; !exists {
+		return 0, fmt.Errorf("import messages: %w", domain.ErrContactNotFound)
+	}
+	seen := make(map[string]bool)
+	for _, m := range s.messages[contactID] {
+		seen[m.ID] = true
+	}
+	history := s.messages[contactID]
+	added := 0
+	for _, m := range msgs {
+		if seen[m.ID] {
+			continue
+		}
+		seen[m.ID] = true
+		added++
+		if !dryRun {
+			m.ChatID = contactID
+			history = append(history, m)
+		}
+	}
+	if !dryRun {
+		sort.SliceStable(history, func(i, j int) bool { return history[i].Timestamp < history[j].Timestamp })
+		s.messages[contactID] = history
+	}
+	return added, nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/google/uuid"
 
 	"quillet/internal/domain"
+	"quillet/internal/identity"
 	"quillet/internal/messenger"
 )
 
@@ -65,7 +66,7 @@ const (
 type StubMessenger struct {
 	mu           sync.RWMutex
 	wg           sync.WaitGroup
-	profile      *domain.User
+	self         *identity.Manager
 	contacts     map[string]*domain.Contact
 	messages     map[string][]domain.Message // contactID → messages
 	settings     *domain.Settings
@@ -76,42 +77,191 @@ type StubMessenger struct {
 	onMessageStatusChanged messenger.MessageStatusHandler
 	onTypingChanged        messenger.TypingHandler
 	onConnectionChanged    messenger.ConnectionHandler
+	onContactKeyChanged    messenger.ContactKeyHandler
+	onMessagesExpired      messenger.ExpiryHandler
 	connState              string
+
+	// sweep wakes the janitor after a retention setting changed.
+	sweep chan struct{}
 }
 
-// NewStubMessenger creates a StubMessenger pre-populated with test data.
+// NewStubMessenger creates a StubMessenger pre-populated with test data
+// and a demo identity.
 func NewStubMessenger() *StubMessenger {
-	profile := defaultProfile()
+	return NewStubMessengerFor(defaultIdentity())
+}
+
+// NewStubMessengerFor creates a StubMessenger pre-populated with test data
+// that uses self as the local identity. If self has no identity yet,
+// the user's own test messages are attributed once CreateIdentity succeeds.
+func NewStubMessengerFor(self *identity.Manager) *StubMessenger {
 	return &StubMessenger{
-		profile:      profile,
+		self:         self,
 		contacts:     defaultContacts(),
-		messages:     defaultMessages(profile.PublicID),
+		messages:     defaultMessages(self.PublicID()),
 		settings:     defaultSettings(),
 		unreadCounts: defaultUnreadCounts(),
+		sweep:        make(chan struct{}, 1),
 	}
 }
 
 // --- Identity ---
 
+func (s *StubMessenger) HasIdentity(_ context.Context) (bool, error) {
+	return s.self.HasIdentity(), nil
+}
+
+func (s *StubMessenger) CreateIdentity(ctx context.Context, displayName, passphrase, avatarPath string) (*domain.User, error) {
+	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
+		return nil, ctx.Err()
+	}
+
+	u, err := s.self.Create(displayName, passphrase, avatarPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.rebindOwnMessages(u.PublicID)
+	s.mu.Unlock()
+
+	return u, nil
+}
+
+func (s *StubMessenger) ImportIdentity(ctx context.Context, privateKey, passphrase string, overwrite bool) (*domain.User, error) {
+	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
+		return nil, ctx.Err()
+	}
+
+	u, err := s.self.Import(privateKey, passphrase, overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.rebindOwnMessages(u.PublicID)
+	s.mu.Unlock()
+
+	return u, nil
+}
+
+func (s *StubMessenger) ExportPrivateKey(_ context.Context, passphrase string) (string, error) {
+	return s.self.ExportPrivateKey(passphrase)
+}
+
+func (s *StubMessenger) ImportRecoveryPhrase(ctx context.Context, phrase, passphrase string, overwrite bool) (*domain.User, error) {
+	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
+		return nil, ctx.Err()
+	}
+
+	u, err := s.self.ImportMnemonic(phrase, passphrase, overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.rebindOwnMessages(u.PublicID)
+	s.mu.Unlock()
+
+	return u, nil
+}
+
+func (s *StubMessenger) ExportRecoveryPhrase(_ context.Context, passphrase string) (string, error) {
+	return s.self.ExportMnemonic(passphrase)
+}
+
+func (s *StubMessenger) RotateIdentityKey(ctx context.Context) (*domain.User, error) {
+	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
+		return nil, ctx.Err()
+	}
+
+	_, u, err := s.self.Rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.rebindOwnMessages(u.PublicID)
+	contacts := len(s.contacts)
+	s.mu.Unlock()
+
+	// The stub has no transport; real backends deliver the certificate to every contact.
+	slog.Info("stub key rotation", "publicID", u.PublicID, "contacts", contacts)
+	return u, nil
+}
+
+func (s *StubMessenger) CreateInvite(ctx context.Context) (*domain.Invite, error) {
+	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
+		return nil, ctx.Err()
+	}
+	return s.self.CreateInvite()
+}
+
+// ReceiveKeyRotation applies a rotation certificate received from a contact.
+// The contact is found by its old key and moves to the new one with its
+// chat history, only if both signatures of cert are valid.
+func (s *StubMessenger) ReceiveKeyRotation(cert identity.RotationCertificate) error {
+	if err := cert.Verify(); err != nil {
+		return fmt.Errorf("receive key rotation: %w", err)
+	}
+	if err := s.replaceContactKey(cert.OldPublicKey, cert.NewPublicKey); err != nil {
+		return fmt.Errorf("receive key rotation: %w", err)
+	}
+	return nil
+}
+
+// rebindOwnMessages attributes every outgoing message to selfID.
+// In a 1-on-1 chat a message is outgoing iff its sender is not the chat's contact.
+// Must be called with s.mu held.
+func (s *StubMessenger) rebindOwnMessages(selfID string) {
+	for _, msgs := range s.messages {
+		for i := range msgs {
+			if msgs[i].SenderID != msgs[i].ChatID {
+				msgs[i].SenderID = selfID
+			}
+		}
+	}
+}
+
 func (s *StubMessenger) GetProfile(ctx context.Context) (*domain.User, error) {
 	if !simulateDelay(ctx, delayShortMin, delayShortMax) {
 		return nil, ctx.Err()
 	}
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	u := *s.profile
-	return &u, nil
+	return s.self.Profile()
 }
 
-func (s *StubMessenger) UpdateProfile(ctx context.Context, displayName string) error {
+func (s *StubMessenger) UpdateProfile(ctx context.Context, displayName, avatarPath string) error {
 	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
 		return ctx.Err()
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.self.SetProfile(displayName, avatarPath)
+}
+
+func (s *StubMessenger) IsLocked(_ context.Context) (bool, error) {
+	return s.self.Locked(), nil
+}
+
+func (s *StubMessenger) UnlockIdentity(_ context.Context, passphrase string) error {
+	return s.self.Unlock(passphrase)
+}
+
+func (s *StubMessenger) LockIdentity(_ context.Context) error {
+	return s.self.Lock()
+}
+
+func (s *StubMessenger) HasPassphrase(_ context.Context) (bool, error) {
+	return s.self.HasPassphrase(), nil
+}
+
+func (s *StubMessenger) ChangePassphrase(_ context.Context, oldPassphrase, newPassphrase string) error {
+	return s.self.ChangePassphrase(oldPassphrase, newPassphrase)
+}
 
-	s.profile.DisplayName = displayName
+// checkUnlocked fails with domain.ErrLocked while the identity is locked.
+func (s *StubMessenger) checkUnlocked() error {
+	if s.self.Locked() {
+		return domain.ErrLocked
+	}
 	return nil
 }
 
@@ -121,6 +271,9 @@ func (s *StubMessenger) GetContacts(ctx context.Context) ([]domain.Contact, erro
 	if !simulateDelay(ctx, delayMediumMin, delayMediumMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("get contacts: %w", err)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -134,20 +287,27 @@ func (s *StubMessenger) GetContacts(ctx context.Context) ([]domain.Contact, erro
 	return contacts, nil
 }
 
-func (s *StubMessenger) AddContact(ctx context.Context, publicID, displayName string) (*domain.Contact, error) {
+func (s *StubMessenger) AddContact(ctx context.Context, peer domain.PeerID, displayName string) (*domain.Contact, error) {
 	if !simulateDelay(ctx, delayAddContactMin, delayAddContactMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("add contact: %w", err)
+	}
+	if peer.PublicID == s.self.PublicID() {
+		return nil, fmt.Errorf("add contact: %w", domain.ErrSelfContact)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	publicID := peer.PublicID
 	if _, exists := s.contacts[publicID]; exists {
 		return nil, fmt.Errorf("add contact: %w", domain.ErrContactExists)
 	}
 
 	c := &domain.Contact{
 		PublicID:     publicID,
-		PublicKey:    publicID + "-pub-key",
+		PublicKey:    peer.PublicKey,
 		DisplayName:  displayName,
 		IsOnline:     false,
 		LastSeen:     time.Now().UnixMilli(),
@@ -163,6 +323,9 @@ func (s *StubMessenger) RemoveContact(ctx context.Context, contactID string) err
 	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("remove contact: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -179,6 +342,9 @@ func (s *StubMessenger) BlockContact(ctx context.Context, contactID string) erro
 	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("block contact: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -194,6 +360,9 @@ func (s *StubMessenger) UnblockContact(ctx context.Context, contactID string) er
 	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("unblock contact: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -205,12 +374,163 @@ func (s *StubMessenger) UnblockContact(ctx context.Context, contactID string) er
 	return nil
 }
 
+func (s *StubMessenger) GetSafetyNumber(ctx context.Context, contactID string) (string, error) {
+	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
+		return "", ctx.Err()
+	}
+	me, err := s.self.Profile()
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+
+	s.mu.RLock()
+	c, exists := s.contacts[contactID]
+	var theirKeyHex string
+	if exists {
+		theirKeyHex = c.PublicKey
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("get safety number: %w", domain.ErrContactNotFound)
+	}
+	myKey, err := identity.ParsePublicKey(me.PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	theirKey, err := identity.ParsePublicKey(theirKeyHex)
+	if err != nil {
+		return "", fmt.Errorf("get safety number: %w", err)
+	}
+	return identity.SafetyNumber(myKey, theirKey), nil
+}
+
+func (s *StubMessenger) MarkContactVerified(ctx context.Context, contactID string) error {
+	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
+		return ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("mark contact verified: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[contactID]
+	if !exists {
+		return fmt.Errorf("mark contact verified: %w", domain.ErrContactNotFound)
+	}
+	if c.PublicKey == "" {
+		return fmt.Errorf("mark contact verified: %w", domain.ErrContactKeyUnknown)
+	}
+	c.Verified = true
+	return nil
+}
+
+func (s *StubMessenger) UnverifyContact(ctx context.Context, contactID string) error {
+	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
+		return ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("unverify contact: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[contactID]
+	if !exists {
+		return fmt.Errorf("unverify contact: %w", domain.ErrContactNotFound)
+	}
+	c.Verified = false
+	return nil
+}
+
+func (s *StubMessenger) SetContactAvatar(ctx context.Context, contactID, avatarPath string) error {
+	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
+		return ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("set contact avatar: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[contactID]
+	if !exists {
+		return fmt.Errorf("set contact avatar: %w", domain.ErrContactNotFound)
+	}
+	c.AvatarPath = avatarPath
+	return nil
+}
+
+// replaceContactKey moves the contact that has oldKey to newKey and to
+// the Public ID of newKey, together with its chat. The change clears the
+// contact's verification and fires the key-changed callback.
+func (s *StubMessenger) replaceContactKey(oldKey, newKey string) error {
+	key, err := identity.ParsePublicKey(newKey)
+	if err != nil {
+		return fmt.Errorf("replace contact key: %w", err)
+	}
+	newID := domain.PublicIDFromKey(key)
+
+	s.mu.Lock()
+	var (
+		contactID string
+		c         *domain.Contact
+	)
+	for id, candidate := range s.contacts {
+		if candidate.PublicKey == oldKey {
+			contactID, c = id, candidate
+			break
+		}
+	}
+	if c == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("replace contact key: %w", domain.ErrContactNotFound)
+	}
+	if _, taken := s.contacts[newID]; taken && newID != contactID {
+		s.mu.Unlock()
+		return fmt.Errorf("replace contact key: %w", domain.ErrContactExists)
+	}
+	wasVerified := c.Verified
+	c.PublicKey = newKey
+	c.Verified = false
+	if newID != contactID {
+		c.PublicID = newID
+		delete(s.contacts, contactID)
+		s.contacts[newID] = c
+		if msgs, ok := s.messages[contactID]; ok {
+			for i := range msgs {
+				msgs[i].ChatID = newID
+				if msgs[i].SenderID == contactID {
+					msgs[i].SenderID = newID
+				}
+			}
+			delete(s.messages, contactID)
+			s.messages[newID] = msgs
+		}
+		if n, ok := s.unreadCounts[contactID]; ok {
+			delete(s.unreadCounts, contactID)
+			s.unreadCounts[newID] = n
+		}
+	}
+	cb := s.onContactKeyChanged
+	s.mu.Unlock()
+
+	if cb != nil {
+		cb(newID, contactID, newKey, wasVerified)
+	}
+	return nil
+}
+
 // --- Conversations ---
 
 func (s *StubMessenger) GetChatSummaries(ctx context.Context) ([]domain.ChatSummary, error) {
 	if !simulateDelay(ctx, delayProfileMin, delayProfileMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("get chat summaries: %w", err)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -250,6 +570,9 @@ func (s *StubMessenger) SendMessage(ctx context.Context, contactID, content stri
 	if !simulateDelay(ctx, delayMediumMin, delayMediumMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
 
 	msg, err := s.recordOutgoingMessage(contactID, content)
 	if err != nil {
@@ -277,7 +600,7 @@ func (s *StubMessenger) recordOutgoingMessage(contactID, content string) (*domai
 	msg := domain.Message{
 		ID:        uuid.New().String(),
 		ChatID:    contactID,
-		SenderID:  s.profile.PublicID,
+		SenderID:  s.self.PublicID(),
 		Content:   content,
 		Timestamp: time.Now().UnixMilli(),
 		Status:    domain.StatusSending,
@@ -378,6 +701,9 @@ func (s *StubMessenger) GetMessages(ctx context.Context, contactID string, limit
 	if !simulateDelay(ctx, delayMediumMin, delayMediumMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("get messages: %w", err)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -419,6 +745,9 @@ func (s *StubMessenger) ClearHistory(ctx context.Context, contactID string) erro
 	if !simulateDelay(ctx, delayShortMin, delayShortMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("clear history: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -433,6 +762,9 @@ func (s *StubMessenger) MarkAsRead(ctx context.Context, contactID string) error
 	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("mark as read: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -449,6 +781,9 @@ func (s *StubMessenger) GetSettings(ctx context.Context) (*domain.Settings, erro
 	if !simulateDelay(ctx, delayFastMin, delayFastMax) {
 		return nil, ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("get settings: %w", err)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -460,10 +795,14 @@ func (s *StubMessenger) UpdateSettings(ctx context.Context, settings domain.Sett
 	if !simulateDelay(ctx, delayShortMin, delayShortMax) {
 		return ctx.Err()
 	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("update settings: %w", err)
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.settings = &settings
+	s.wakeJanitor()
 	return nil
 }
 
@@ -499,6 +838,18 @@ func (s *StubMessenger) OnConnectionStateChanged(fn messenger.ConnectionHandler)
 	s.onConnectionChanged = fn
 }
 
+func (s *StubMessenger) OnContactKeyChanged(fn messenger.ContactKeyHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onContactKeyChanged = fn
+}
+
+func (s *StubMessenger) OnMessagesExpired(fn messenger.ExpiryHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMessagesExpired = fn
+}
+
 // --- Simulation ---
 
 // StartStatusSimulation periodically toggles random contacts online/offline.
@@ -517,6 +868,10 @@ func (s *StubMessenger) StartStatusSimulation(ctx context.Context) {
 			case <-timer.C:
 			}
 
+			if s.self.Locked() {
+				continue
+			}
+
 			s.mu.Lock()
 			ids := make([]string, 0, len(s.contacts))
 			for id, c := range s.contacts {
@@ -2,11 +2,15 @@ package stub
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
+	"slices"
 	"sync"
 	"testing"
+	"time"
 
 	"quillet/internal/domain"
+	"quillet/internal/identity"
 )
 
 func newCtx() context.Context {
@@ -24,7 +28,7 @@ func mustProfile(t *testing.T, s *StubMessenger) *domain.User {
 
 func mustAddContact(t *testing.T, s *StubMessenger, publicID, name string) *domain.Contact {
 	t.Helper()
-	c, err := s.AddContact(newCtx(), publicID, name)
+	c, err := s.AddContact(newCtx(), domain.PeerID{PublicID: publicID}, name)
 	if err != nil {
 		t.Fatalf("AddContact(%q, %q) error = %v", publicID, name, err)
 	}
@@ -68,39 +72,173 @@ func TestGetProfile_ReturnsCopy(t *testing.T) {
 func TestUpdateProfile(t *testing.T) {
 	s := NewStubMessenger()
 	newName := "Updated Name"
+	newAvatar := "/avatars/0123456789abcdef0123456789abcdef.png"
 
-	if err := s.UpdateProfile(newCtx(), newName); err != nil {
-		t.Fatalf("UpdateProfile(%q) error = %v", newName, err)
+	if err := s.UpdateProfile(newCtx(), newName, newAvatar); err != nil {
+		t.Fatalf("UpdateProfile(%q, %q) error = %v", newName, newAvatar, err)
 	}
 
 	p := mustProfile(t, s)
 	if p.DisplayName != newName {
 		t.Errorf("DisplayName = %q; want %q", p.DisplayName, newName)
 	}
+	if p.AvatarPath != newAvatar {
+		t.Errorf("AvatarPath = %q; want %q", p.AvatarPath, newAvatar)
+	}
+}
+
+// --- HasIdentity / CreateIdentity ---
+
+func TestCreateIdentity(t *testing.T) {
+	s := NewStubMessengerFor(identity.NewManager())
+
+	has, err := s.HasIdentity(newCtx())
+	if err != nil || has {
+		t.Fatalf("HasIdentity() = %v, %v; want false, nil", has, err)
+	}
+	if _, err := s.GetProfile(newCtx()); !errors.Is(err, domain.ErrNoIdentity) {
+		t.Fatalf("GetProfile() error = %v; want %v", err, domain.ErrNoIdentity)
+	}
+
+	u, err := s.CreateIdentity(newCtx(), "Alice", "", "")
+	if err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	if len(u.PublicID) != identity.PublicIDLength {
+		t.Errorf("len(PublicID) = %d; want %d", len(u.PublicID), identity.PublicIDLength)
+	}
+	if u.DisplayName != "Alice" {
+		t.Errorf("DisplayName = %q; want %q", u.DisplayName, "Alice")
+	}
+
+	p := mustProfile(t, s)
+	if p.PublicID != u.PublicID {
+		t.Errorf("GetProfile().PublicID = %q; want %q", p.PublicID, u.PublicID)
+	}
+
+	// собственные сообщения тестовых данных принадлежат новой личности
+	msgs, err := s.GetMessages(newCtx(), "alice-id", 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	for _, m := range msgs {
+		if m.SenderID != m.ChatID && m.SenderID != u.PublicID {
+			t.Errorf("message %s SenderID = %q; want %q", m.ID, m.SenderID, u.PublicID)
+		}
+	}
+}
+
+func TestCreateIdentity_AlreadyExists(t *testing.T) {
+	s := NewStubMessenger()
+
+	_, err := s.CreateIdentity(newCtx(), "Again", "", "")
+	if !errors.Is(err, domain.ErrIdentityExists) {
+		t.Fatalf("CreateIdentity() error = %v; want %v", err, domain.ErrIdentityExists)
+	}
+}
+
+func TestImportIdentity(t *testing.T) {
+	s := NewStubMessenger()
+	keys, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	key := hex.EncodeToString(keys.Private)
+
+	if _, err := s.ImportIdentity(newCtx(), key, "", false); !errors.Is(err, domain.ErrIdentityExists) {
+		t.Fatalf("ImportIdentity(overwrite=false) error = %v; want %v", err, domain.ErrIdentityExists)
+	}
+	u, err := s.ImportIdentity(newCtx(), key, "", true)
+	if err != nil {
+		t.Fatalf("ImportIdentity(overwrite=true) error = %v", err)
+	}
+	if u.PublicID != keys.PublicID() {
+		t.Errorf("PublicID = %q; want %q", u.PublicID, keys.PublicID())
+	}
+
+	exported, err := s.ExportPrivateKey(newCtx(), "")
+	if err != nil {
+		t.Fatalf("ExportPrivateKey() error = %v", err)
+	}
+	if exported != key {
+		t.Errorf("ExportPrivateKey() = %q; want %q", exported, key)
+	}
+}
+
+// --- Lock / Unlock ---
+
+func TestLockedIdentity(t *testing.T) {
+	s := NewStubMessengerFor(identity.NewManager())
+	if _, err := s.CreateIdentity(newCtx(), "Alice", "secret", ""); err != nil {
+		t.Fatalf("CreateIdentity() error = %v", err)
+	}
+	if err := s.LockIdentity(newCtx()); err != nil {
+		t.Fatalf("LockIdentity() error = %v", err)
+	}
+
+	if _, err := s.GetProfile(newCtx()); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("GetProfile() error = %v; want %v", err, domain.ErrLocked)
+	}
+	if _, err := s.GetContacts(newCtx()); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("GetContacts() error = %v; want %v", err, domain.ErrLocked)
+	}
+	if _, err := s.SendMessage(newCtx(), "alice-id", "hi"); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("SendMessage() error = %v; want %v", err, domain.ErrLocked)
+	}
+	if _, err := s.GetSettings(newCtx()); !errors.Is(err, domain.ErrLocked) {
+		t.Errorf("GetSettings() error = %v; want %v", err, domain.ErrLocked)
+	}
+
+	if err := s.UnlockIdentity(newCtx(), "wrong"); !errors.Is(err, domain.ErrWrongPassphrase) {
+		t.Fatalf("UnlockIdentity(wrong) error = %v; want %v", err, domain.ErrWrongPassphrase)
+	}
+	if err := s.UnlockIdentity(newCtx(), "secret"); err != nil {
+		t.Fatalf("UnlockIdentity() error = %v", err)
+	}
+	if _, err := s.GetContacts(newCtx()); err != nil {
+		t.Errorf("GetContacts() after unlock error = %v", err)
+	}
 }
 
 // --- AddContact ---
 
 func TestAddContact(t *testing.T) {
+	me := mustProfile(t, NewStubMessenger())
+	newKey := demoContactKey("eve")
+	newID := identity.PublicIDFromKey(demoContactKeys("eve").Public)
+
 	tests := []struct {
-		name      string
-		publicID  string
-		display   string
-		wantErr   error
-		setupDup  bool
+		name     string
+		peer     domain.PeerID
+		display  string
+		wantKey  string
+		wantErr  error
+		setupDup bool
 	}{
 		{
-			name:     "success",
-			publicID: "new-contact",
-			display:  "New Contact",
+			name:    "by public ID",
+			peer:    domain.PeerID{PublicID: "0123456789abcdef"},
+			display: "New Contact",
+		},
+		{
+			name:    "by public key",
+			peer:    domain.PeerID{PublicID: newID, PublicKey: newKey},
+			display: "Eve",
+			wantKey: newKey,
 		},
 		{
 			name:     "duplicate",
-			publicID: "dup-contact",
+			peer:     domain.PeerID{PublicID: "fedcba9876543210"},
 			display:  "Dup",
 			wantErr:  domain.ErrContactExists,
 			setupDup: true,
 		},
+		{
+			name:    "self",
+			peer:    domain.PeerID{PublicID: me.PublicID, PublicKey: me.PublicKey},
+			display: "Me Again",
+			wantErr: domain.ErrSelfContact,
+		},
 	}
 
 	for _, tt := range tests {
@@ -109,10 +247,10 @@ func TestAddContact(t *testing.T) {
 			ctx := newCtx()
 
 			if tt.setupDup {
-				mustAddContact(t, s, tt.publicID, tt.display)
+				mustAddContact(t, s, tt.peer.PublicID, tt.display)
 			}
 
-			c, err := s.AddContact(ctx, tt.publicID, tt.display)
+			c, err := s.AddContact(ctx, tt.peer, tt.display)
 
 			if tt.wantErr != nil {
 				if !errors.Is(err, tt.wantErr) {
@@ -124,8 +262,11 @@ func TestAddContact(t *testing.T) {
 			if err != nil {
 				t.Fatalf("AddContact() error = %v", err)
 			}
-			if c.PublicID != tt.publicID {
-				t.Errorf("PublicID = %q; want %q", c.PublicID, tt.publicID)
+			if c.PublicID != tt.peer.PublicID {
+				t.Errorf("PublicID = %q; want %q", c.PublicID, tt.peer.PublicID)
+			}
+			if c.PublicKey != tt.wantKey {
+				t.Errorf("PublicKey = %q; want %q", c.PublicKey, tt.wantKey)
 			}
 			if c.DisplayName != tt.display {
 				t.Errorf("DisplayName = %q; want %q", c.DisplayName, tt.display)
@@ -136,7 +277,7 @@ func TestAddContact(t *testing.T) {
 
 func TestAddContact_ExistingDefault(t *testing.T) {
 	s := NewStubMessenger()
-	_, err := s.AddContact(newCtx(), "alice-id", "Alice Again")
+	_, err := s.AddContact(newCtx(), domain.PeerID{PublicID: "alice-id"}, "Alice Again")
 	if !errors.Is(err, domain.ErrContactExists) {
 		t.Fatalf("AddContact(existing) error = %v; want %v", err, domain.ErrContactExists)
 	}
@@ -279,6 +420,159 @@ func TestUnblockContact(t *testing.T) {
 	}
 }
 
+// --- Safety numbers / verification ---
+
+func TestGetSafetyNumber(t *testing.T) {
+	s := NewStubMessenger()
+
+	n1, err := s.GetSafetyNumber(newCtx(), "alice-id")
+	if err != nil {
+		t.Fatalf("GetSafetyNumber() error = %v", err)
+	}
+	n2, err := s.GetSafetyNumber(newCtx(), "alice-id")
+	if err != nil {
+		t.Fatalf("GetSafetyNumber() error = %v", err)
+	}
+	if n1 != n2 {
+		t.Errorf("GetSafetyNumber() not stable: %q then %q", n1, n2)
+	}
+
+	if _, err := s.GetSafetyNumber(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("GetSafetyNumber(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestKeyChangeClearsVerification(t *testing.T) {
+	s := NewStubMessenger()
+
+	var (
+		gotID, gotPrevious string
+		gotVerified        bool
+	)
+	s.OnContactKeyChanged(func(contactID, previousID, _ string, wasVerified bool) {
+		gotID, gotPrevious, gotVerified = contactID, previousID, wasVerified
+	})
+
+	if err := s.MarkContactVerified(newCtx(), "alice-id"); err != nil {
+		t.Fatalf("MarkContactVerified() error = %v", err)
+	}
+	if c := findContact(t, s, "alice-id"); !c.Verified {
+		t.Fatal("Verified = false after MarkContactVerified")
+	}
+
+	before, _ := s.GetSafetyNumber(newCtx(), "alice-id")
+	if err := s.replaceContactKey(demoContactKey("alice"), demoContactKey("alice-rotated")); err != nil {
+		t.Fatalf("replaceContactKey() error = %v", err)
+	}
+
+	rotatedID := demoContactKeys("alice-rotated").PublicID()
+	if c := findContact(t, s, rotatedID); c.Verified {
+		t.Error("Verified = true after key change; want false")
+	}
+	if gotID != rotatedID || gotPrevious != "alice-id" || !gotVerified {
+		t.Errorf("OnContactKeyChanged got (%q, %q, %v); want (%q, %q, true)",
+			gotID, gotPrevious, gotVerified, rotatedID, "alice-id")
+	}
+	after, _ := s.GetSafetyNumber(newCtx(), rotatedID)
+	if before == after {
+		t.Error("safety number did not change with the contact's key")
+	}
+}
+
+func TestRotateIdentityKey(t *testing.T) {
+	s := NewStubMessenger()
+	before := mustProfile(t, s)
+
+	after, err := s.RotateIdentityKey(newCtx())
+	if err != nil {
+		t.Fatalf("RotateIdentityKey() error = %v", err)
+	}
+	if after.PublicKey == before.PublicKey || after.PublicID == before.PublicID {
+		t.Errorf("RotateIdentityKey() kept the key: %s/%s", after.PublicID, after.PublicKey)
+	}
+	if p := mustProfile(t, s); p.PublicKey != after.PublicKey {
+		t.Errorf("GetProfile().PublicKey = %q; want %q", p.PublicKey, after.PublicKey)
+	}
+
+	msg := mustSendMessage(t, s, "alice-id", "after rotation")
+	if msg.SenderID != after.PublicID {
+		t.Errorf("SenderID = %q; want %q", msg.SenderID, after.PublicID)
+	}
+}
+
+func TestReceiveKeyRotation(t *testing.T) {
+	alice := demoContactKeys("alice")
+	next, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	intruder, err := identity.Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	s := NewStubMessenger()
+	history, err := s.GetMessages(newCtx(), "alice-id", 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+
+	forged := identity.SignRotation(intruder, next, 1)
+	forged.OldPublicKey = alice.PublicKeyHex()
+	if err := s.ReceiveKeyRotation(*forged); !errors.Is(err, domain.ErrInvalidRotation) {
+		t.Fatalf("ReceiveKeyRotation(forged) error = %v; want %v", err, domain.ErrInvalidRotation)
+	}
+
+	cert := identity.SignRotation(alice, next, 1)
+	if err := s.ReceiveKeyRotation(*cert); err != nil {
+		t.Fatalf("ReceiveKeyRotation() error = %v", err)
+	}
+
+	c := findContact(t, s, next.PublicID())
+	if c.PublicKey != next.PublicKeyHex() {
+		t.Errorf("PublicKey = %q; want %q", c.PublicKey, next.PublicKeyHex())
+	}
+	kept, err := s.GetMessages(newCtx(), next.PublicID(), 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(kept) != len(history) {
+		t.Errorf("history has %d messages after rotation; want %d", len(kept), len(history))
+	}
+}
+
+func TestUnverifyContact(t *testing.T) {
+	s := NewStubMessenger()
+
+	if err := s.MarkContactVerified(newCtx(), "bob-id"); err != nil {
+		t.Fatalf("MarkContactVerified() error = %v", err)
+	}
+	if err := s.UnverifyContact(newCtx(), "bob-id"); err != nil {
+		t.Fatalf("UnverifyContact() error = %v", err)
+	}
+	if c := findContact(t, s, "bob-id"); c.Verified {
+		t.Error("Verified = true after UnverifyContact")
+	}
+	if err := s.UnverifyContact(newCtx(), "nonexistent"); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("UnverifyContact(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func findContact(t *testing.T, s *StubMessenger, id string) domain.Contact {
+	t.Helper()
+	contacts, err := s.GetContacts(newCtx())
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	for _, c := range contacts {
+		if c.PublicID == id {
+			return c
+		}
+	}
+	t.Fatalf("contact %q not found", id)
+	return domain.Contact{}
+}
+
 // --- GetMessages ---
 
 func TestGetMessages(t *testing.T) {
@@ -572,6 +866,135 @@ func TestClearHistory(t *testing.T) {
 	}
 }
 
+func TestImportMessages(t *testing.T) {
+	s := NewStubMessenger()
+	before, err := s.GetMessages(newCtx(), "alice-id", 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	imported := []domain.Message{
+		{ID: "imported-1", SenderID: "alice-id", Content: "old times", Timestamp: 1, Status: domain.StatusRead},
+		{ID: before[0].ID, SenderID: "alice-id", Content: "duplicate", Timestamp: 2, Status: domain.StatusRead},
+	}
+
+	if n, err := s.ImportMessages(newCtx(), "alice-id", imported, true); err != nil || n != 1 {
+		t.Fatalf("ImportMessages(dry run) = %d, %v; want 1", n, err)
+	}
+	if n, err := s.ImportMessages(newCtx(), "alice-id", imported, false); err != nil || n != 1 {
+		t.Fatalf("ImportMessages() = %d, %v; want 1", n, err)
+	}
+	after, err := s.GetMessages(newCtx(), "alice-id", 0, "")
+	if err != nil {
+		t.Fatalf("GetMessages() error = %v", err)
+	}
+	if len(after) != len(before)+1 || after[0].ID != "imported-1" {
+		t.Errorf("history = %d messages starting %q; want the import first", len(after), after[0].ID)
+	}
+	if _, err := s.ImportMessages(newCtx(), "nonexistent", imported, false); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("ImportMessages(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+func TestSetChatRetention(t *testing.T) {
+	s := NewStubMessenger()
+	old := time.Now().AddDate(0, 0, -60).UnixMilli()
+	imported := []domain.Message{{ID: "ancient", SenderID: "alice-id", Content: "old", Timestamp: old, Status: domain.StatusRead}}
+	if _, err := s.ImportMessages(newCtx(), "alice-id", imported, false); err != nil {
+		t.Fatalf("ImportMessages() error = %v", err)
+	}
+	before, _ := s.GetMessages(newCtx(), "alice-id", 0, "")
+
+	var events []domain.ExpiredMessages
+	s.OnMessagesExpired(func(e domain.ExpiredMessages) { events = append(events, e) })
+	s.expire(time.Now())
+	if len(events) != 0 {
+		t.Fatalf("expired %+v without a retention setting", events)
+	}
+
+	if err := s.SetChatRetention(newCtx(), "alice-id", 30); err != nil {
+		t.Fatalf("SetChatRetention() error = %v", err)
+	}
+	s.expire(time.Now())
+	if len(events) != 1 || events[0].ChatID != "alice-id" || events[0].Count != 1 {
+		t.Errorf("events = %+v; want the ancient message of alice-id", events)
+	}
+	after, _ := s.GetMessages(newCtx(), "alice-id", 0, "")
+	if len(after) != len(before)-1 || after[0].ID == "ancient" {
+		t.Errorf("history = %d messages starting %q; want the ancient one gone", len(after), after[0].ID)
+	}
+	if err := s.SetChatRetention(newCtx(), "nonexistent", 30); !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("SetChatRetention(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
+
+// --- SearchMessages ---
+
+func hitIDs(res *domain.SearchResult) []string {
+	ids := make([]string, len(res.Hits))
+	for i, h := range res.Hits {
+		ids[i] = h.Message.ID
+	}
+	return ids
+}
+
+func TestSearchMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		contactID string
+		want      []string
+		wantErr   error
+	}{
+		{name: "prefix, any case", query: "HE", want: []string{"msg-a1", "msg-b1"}},
+		{name: "all words", query: "hey alice", want: []string{"msg-a1"}},
+		{name: "one chat", query: "hey", contactID: "bob-id", want: []string{"msg-b1"}},
+		{name: "from contact", query: "from:alice how", want: []string{"msg-a2"}},
+		{name: "from me", query: "from:me hey", want: []string{"msg-a1"}},
+		{name: "no match", query: "zebra", want: []string{}},
+		{name: "empty query", query: " ", wantErr: domain.ErrEmptySearchQuery},
+		{name: "nonexistent contact", query: "hey", contactID: "nonexistent", wantErr: domain.ErrContactNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStubMessenger()
+			res, err := s.SearchMessages(newCtx(), tt.query, tt.contactID, 0, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SearchMessages(%q) error = %v; want %v", tt.query, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := hitIDs(res); !slices.Equal(got, tt.want) {
+				t.Errorf("SearchMessages(%q) = %v; want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages_SnippetAndPaging(t *testing.T) {
+	s := NewStubMessenger()
+	mustSendMessage(t, s, "alice-id", "<b>hey</b> again")
+
+	first, err := s.SearchMessages(newCtx(), "hey", "", 1, "")
+	if err != nil {
+		t.Fatalf("SearchMessages() error = %v", err)
+	}
+	if len(first.Hits) != 1 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v; want 1 hit and a cursor", first)
+	}
+	if want := "&lt;b&gt;<mark>hey</mark>&lt;/b&gt; again"; first.Hits[0].Snippet != want {
+		t.Errorf("Snippet = %q; want %q", first.Hits[0].Snippet, want)
+	}
+
+	rest, err := s.SearchMessages(newCtx(), "hey", "", 0, first.NextCursor)
+	if err != nil {
+		t.Fatalf("SearchMessages(cursor) error = %v", err)
+	}
+	if got, want := hitIDs(rest), []string{"msg-a1", "msg-b1"}; !slices.Equal(got, want) || rest.NextCursor != "" {
+		t.Errorf("second page = %v, cursor %q; want %v and no cursor", got, rest.NextCursor, want)
+	}
+}
+
 // --- GetChatSummaries ---
 
 func TestGetChatSummaries(t *testing.T) {
@@ -797,7 +1220,7 @@ func TestConcurrentAccess(t *testing.T) {
 					t.Errorf("MarkAsRead() error = %v", err)
 				}
 
-				if err := s.UpdateProfile(ctx, "concurrent-name"); err != nil {
+				if err := s.UpdateProfile(ctx, "concurrent-name", ""); err != nil {
 					t.Errorf("UpdateProfile() error = %v", err)
 				}
 
@@ -855,7 +1278,7 @@ func TestContextCancellation(t *testing.T) {
 	if _, err := s.GetProfile(ctx); err == nil {
 		t.Error("GetProfile() with cancelled ctx; want error")
 	}
-	if err := s.UpdateProfile(ctx, "name"); err == nil {
+	if err := s.UpdateProfile(ctx, "name", ""); err == nil {
 		t.Error("UpdateProfile() with cancelled ctx; want error")
 	}
 	if _, err := s.GetContacts(ctx); err == nil {
@@ -940,3 +1363,20 @@ func TestBlockUnblockRoundtrip(t *testing.T) {
 		}
 	}
 }
+
+func TestSetContactAvatar(t *testing.T) {
+	s := NewStubMessenger()
+	avatar := "/avatars/0123456789abcdef0123456789abcdef.png"
+
+	if err := s.SetContactAvatar(newCtx(), "alice-id", avatar); err != nil {
+		t.Fatalf("SetContactAvatar() error = %v", err)
+	}
+	if c := findContact(t, s, "alice-id"); c.AvatarPath != avatar {
+		t.Errorf("AvatarPath = %q; want %q", c.AvatarPath, avatar)
+	}
+
+	err := s.SetContactAvatar(newCtx(), "nonexistent", avatar)
+	if !errors.Is(err, domain.ErrContactNotFound) {
+		t.Errorf("SetContactAvatar(nonexistent) error = %v; want %v", err, domain.ErrContactNotFound)
+	}
+}
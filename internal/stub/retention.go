@@ -0,0 +1,98 @@
+package stub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"quillet/internal/domain"
+)
+
+// janitorInterval is how often the janitor looks for expired messages.
+const janitorInterval = time.Minute
+
+func (s *StubMessenger) SetChatRetention(ctx context.Context, contactID string, days int) error {
+	if !simulateDelay(ctx, delayShortMin, delayShortMax) {
+		return ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return fmt.Errorf("set chat retention: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.contacts[contactID]
+	if !exists {
+		return fmt.Errorf("set chat retention: %w", domain.ErrContactNotFound)
+	}
+	c.RetentionDays = days
+	s.wakeJanitor()
+	return nil
+}
+
+// StartJanitor deletes expired messages from the in-memory history until
+// ctx is cancelled; see messenger.Janitor.
+func (s *StubMessenger) StartJanitor(ctx context.Context) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			s.expire(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.sweep:
+			}
+		}
+	}()
+}
+
+// expire deletes the messages that are past their chat's retention as of
+// now and reports them to the OnMessagesExpired callback.
+func (s *StubMessenger) expire(now time.Time) {
+	s.mu.Lock()
+	var expired []domain.ExpiredMessages
+	for id, c := range s.contacts {
+		days := c.RetentionDays
+		if days == 0 {
+			days = s.settings.RetentionDays
+		}
+		if days <= 0 {
+			continue
+		}
+		before := now.AddDate(0, 0, -days).UnixMilli()
+		history := s.messages[id]
+		var kept []domain.Message
+		for _, m := range history {
+			if m.Timestamp >= before {
+				kept = append(kept, m)
+			}
+		}
+		if n := len(history) - len(kept); n > 0 {
+			s.messages[id] = kept
+			expired = append(expired, domain.ExpiredMessages{ChatID: id, Before: before, Count: n})
+		}
+	}
+	cb := s.onMessagesExpired
+	s.mu.Unlock()
+
+	for _, e := range expired {
+		slog.Debug("stub messages expired", "chat", e.ChatID, "count", e.Count)
+		if cb != nil {
+			cb(e)
+		}
+	}
+}
+
+// wakeJanitor makes a running janitor apply changed retention settings
+// now rather than at its next tick.
+func (s *StubMessenger) wakeJanitor() {
+	select {
+	case s.sweep <- struct{}{}:
+	default:
+	}
+}
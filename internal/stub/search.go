@@ -0,0 +1,101 @@
+package stub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"quillet/internal/domain"
+	"quillet/internal/search"
+)
+
+// SearchMessages scans the in-memory history with the same matching rules
+// as the store.
+func (s *StubMessenger) SearchMessages(ctx context.Context, query, contactID string, limit int, cursor string) (*domain.SearchResult, error) {
+	if !simulateDelay(ctx, delayMediumMin, delayMediumMax) {
+		return nil, ctx.Err()
+	}
+	if err := s.checkUnlocked(); err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	q, err := domain.ParseSearchQuery(query, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("search messages: %w", err)
+	}
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if contactID != "" {
+		if _, exists := s.contacts[contactID]; !exists {
+			return nil, fmt.Errorf("search messages: %w", domain.ErrContactNotFound)
+		}
+	}
+	senders := s.searchSenders(q.From)
+	matcher := search.NewMatcher(q.Terms)
+
+	var hits []domain.SearchHit
+	for chatID, msgs := range s.messages {
+		if contactID != "" && chatID != contactID {
+			continue
+		}
+		for _, m := range msgs {
+			if q.From != "" && !senders[m.SenderID] ||
+				q.After != 0 && m.Timestamp < q.After ||
+				q.Before != 0 && m.Timestamp >= q.Before {
+				continue
+			}
+			if snippet, ok := matcher.Match(m.Content); ok {
+				hits = append(hits, domain.SearchHit{Message: m, Snippet: snippet})
+			}
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Message.Timestamp != hits[j].Message.Timestamp {
+			return hits[i].Message.Timestamp > hits[j].Message.Timestamp
+		}
+		return hits[i].Message.ID > hits[j].Message.ID
+	})
+
+	if cursor != "" {
+		idx := -1
+		for i, h := range hits {
+			if h.Message.ID == cursor {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("search messages: %w", domain.ErrMessageNotFound)
+		}
+		hits = hits[idx+1:]
+	}
+
+	result := &domain.SearchResult{Hits: []domain.SearchHit{}}
+	if len(hits) > limit {
+		hits = hits[:limit]
+		result.NextCursor = hits[limit-1].Message.ID
+	}
+	result.Hits = append(result.Hits, hits...)
+	return result, nil
+}
+
+// searchSenders resolves a from: value to sender IDs. Caller must hold s.mu.
+func (s *StubMessenger) searchSenders(from string) map[string]bool {
+	senders := make(map[string]bool)
+	if strings.EqualFold(from, domain.SearchFromMe) {
+		senders[s.self.PublicID()] = true
+		return senders
+	}
+	for id, c := range s.contacts {
+		if strings.EqualFold(id, from) || strings.EqualFold(c.DisplayName, from) {
+			senders[id] = true
+		}
+	}
+	return senders
+}
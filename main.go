@@ -2,7 +2,9 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/wailsapp/wails/v2"
@@ -33,7 +35,10 @@ func run() error {
 		Level: slog.LevelInfo,
 	})))
 
-	app := NewApp()
+	app, err := NewApp()
+	if err != nil {
+		return fmt.Errorf("init app: %w", err)
+	}
 
 	return wails.Run(&options.App{
 		Title:     "Quillet",
@@ -42,7 +47,8 @@ func run() error {
 		MinWidth:  windowMinWidth,
 		MinHeight: windowMinHeight,
 		AssetServer: &assetserver.Options{
-			Assets: assets,
+			Assets:  assets,
+			Handler: http.HandlerFunc(app.serveAvatar),
 		},
 		BackgroundColour: &options.RGBA{R: 18, G: 18, B: 18, A: 1},
 		OnStartup:        app.Startup,